@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	gosyslog "log/syslog"
+	"net/http"
+	"os"
+	"path"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/syslog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// multiLogger passes each record on to all of its loggers.
+type multiLogger []log.Logger
+
+// Log implements log.Logger.
+func (m multiLogger) Log(keyvals ...interface{}) error {
+	var err error
+	for _, l := range m {
+		if e := l.Log(keyvals...); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// newAuditLogger creates a logger for audit records, which are
+// written in logfmt to the given file, if any, and to the local
+// syslog daemon if toSyslog is true. On systemd-based installs,
+// journald receives the records through the syslog socket.
+func newAuditLogger(file string, toSyslog bool) (log.Logger, error) {
+	var m multiLogger
+	if file != "" {
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open audit log: %v", err)
+		}
+		m = append(m, log.WithPrefix(log.NewLogfmtLogger(log.NewSyncWriter(f)), "ts", log.DefaultTimestampUTC))
+	}
+	if toSyslog {
+		// Syslog adds its own timestamp.
+		w, err := gosyslog.New(gosyslog.LOG_INFO|gosyslog.LOG_DAEMON, "servor")
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to syslog: %v", err)
+		}
+		m = append(m, syslog.NewSyslogLogger(w, log.NewLogfmtLogger))
+	}
+	return log.With(m, "audit", true), nil
+}
+
+// audit records the requests handled by next that move servos or
+// change their settings, i.e. all but GET requests, along with
+// the client and the outcome.
+func audit(next http.Handler, logger log.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		sr := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		next.ServeHTTP(sr, r)
+		logger.Log("remote", r.RemoteAddr, "identity", name(r.Context()), "protocol", "http", "method", r.Method, "path", r.URL.Path, "code", sr.code)
+	})
+}
+
+// readOnlyRPCs are the gRPC methods that do not move servos
+// and so are not audited.
+var readOnlyRPCs = map[string]bool{
+	"ListServos": true,
+	"GetState":   true,
+}
+
+// auditUnary records the unary gRPC calls that move servos.
+func auditUnary(logger log.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		res, err := handler(ctx, req)
+		if !readOnlyRPCs[path.Base(info.FullMethod)] {
+			logger.Log("remote", remote(ctx), "identity", name(ctx), "protocol", "grpc", "method", info.FullMethod, "code", status.Code(err).String())
+		}
+		return res, err
+	}
+}
+
+// auditStream records streaming gRPC calls, e.g. jogging,
+// once they end.
+func auditStream(logger log.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		err := handler(srv, ss)
+		logger.Log("remote", remote(ss.Context()), "identity", name(ss.Context()), "protocol", "grpc", "method", info.FullMethod, "code", status.Code(err).String())
+		return err
+	}
+}
+
+// remote returns the address of the gRPC client.
+func remote(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok {
+		return p.Addr.String()
+	}
+	return ""
+}
+
+// name returns the name of the identity that made the request
+// with the given context, or an empty string without authentication.
+func name(ctx context.Context) string {
+	if id := identityFrom(ctx); id != nil {
+		return id.name
+	}
+	return ""
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// tokenConfig grants a client, e.g. an integration, access to the API.
+type tokenConfig struct {
+	// Name identifies the client in statistics and the audit log.
+	Name  string `json:"name"`
+	Token string `json:"token"`
+	// MovesPerHour and MovesPerDay limit the number of moves made
+	// with the token in each clock hour and UTC day; 0 means no limit.
+	MovesPerHour int `json:"movesPerHour,omitempty"`
+	MovesPerDay  int `json:"movesPerDay,omitempty"`
+	// MaxRate limits how fast the token may change the position of
+	// any servo in units per second; 0 means no limit.
+	MaxRate float64 `json:"maxRate,omitempty"`
+	// Scopes limit what the token may do; a token
+	// without scopes is granted all of them.
+	Scopes []string `json:"scopes,omitempty"`
+	// Profile is the UI profile that the token starts with,
+	// e.g. simple for wall tablets; defaults to --ui-profile.
+	Profile string `json:"profile,omitempty"`
+	// Source is the source of the commands made with the token, e.g.
+	// ui for wall tablets; defaults to api-token:<name>. Clients with
+	// a token cannot declare another source, so that they cannot
+	// sidestep the policies that apply to them.
+	Source string `json:"source,omitempty"`
+}
+
+// The scopes that can be granted to tokens.
+const (
+	// scopeRead allows reading the state and settings of servos.
+	scopeRead = "read"
+	// scopeMove allows moving servos, e.g. to a preset or by jogging.
+	scopeMove = "move"
+	// scopeSequences allows starting motion sequences, i.e.
+	// oscillation, demo mode, patrols, tours, sequences, and replays.
+	scopeSequences = "sequences"
+	// scopeConfig allows replacing the settings of servos,
+	// calibrating them, restoring snapshots, and recording
+	// and editing sequences.
+	scopeConfig = "config"
+	// scopeAdmin allows reading the usage statistics of tokens
+	// and injecting faults into servos that use the mock driver.
+	scopeAdmin = "admin"
+)
+
+var scopes = []string{scopeRead, scopeMove, scopeSequences, scopeConfig, scopeAdmin}
+
+// The profiles of the UI. Profiles only select the controls that the
+// UI shows; what a caller may do is limited by the scopes of its token.
+const (
+	// profileSimple shows large buttons for the presets of each servo.
+	profileSimple = "simple"
+	// profileAdvanced shows all controls that the scopes permit, e.g.
+	// sliders, calibration, and the tools to record and edit sequences.
+	profileAdvanced = "advanced"
+)
+
+var profiles = []string{profileSimple, profileAdvanced}
+
+// validProfile returns whether p is the name of a UI profile.
+func validProfile(p string) bool {
+	for _, v := range profiles {
+		if p == v {
+			return true
+		}
+	}
+	return false
+}
+
+// requiredScope returns the scope needed for the given request.
+func requiredScope(r *http.Request) string {
+	p := r.URL.Path
+	if strings.HasSuffix(p, "/chaos") {
+		// Reading the faults reveals that a servo is not real.
+		return scopeAdmin
+	}
+	switch r.Method {
+	case http.MethodPut, http.MethodDelete:
+		if strings.Contains(p, "/moves/") {
+			return scopeMove
+		}
+		// Saving and removing sequences edits the settings.
+		return scopeConfig
+	case http.MethodPost:
+		switch {
+		case strings.Contains(p, "/presets/"):
+			return scopeMove
+		case strings.Contains(p, "/tours/"), strings.Contains(p, "/sequences/"), strings.HasSuffix(p, "/oscillate"), strings.HasSuffix(p, "/demo"), strings.HasSuffix(p, "/patrol"), p == "/api/replay":
+			return scopeSequences
+		case strings.HasSuffix(p, "/settings/import"), p == "/api/restore", strings.HasSuffix(p, "/calibration"), strings.HasSuffix(p, "/maintenance"), strings.HasSuffix(p, "/calibration/move"), strings.Contains(p, "/recording/"):
+			return scopeConfig
+		}
+		return scopeMove
+	}
+	switch p {
+	case "/api/me":
+		// Any token may look up its own scopes.
+		return ""
+	case "/api/tokens":
+		return scopeAdmin
+	case "/api/ws":
+		// The WebSocket endpoint is opened with GET but moves servos.
+		return scopeMove
+	}
+	return scopeRead
+}
+
+// validate checks that the token is complete.
+func (t *tokenConfig) validate() error {
+	if t.Name == "" {
+		return errors.New("name must be set")
+	}
+	if t.Token == "" {
+		return errors.New("token must be set")
+	}
+	if t.MovesPerHour < 0 || t.MovesPerDay < 0 {
+		return errors.New("quotas must not be negative")
+	}
+	if t.MaxRate < 0 {
+		return fmt.Errorf("maxRate must not be negative; got %f", t.MaxRate)
+	}
+	for _, s := range t.Scopes {
+		valid := false
+		for _, v := range scopes {
+			valid = valid || s == v
+		}
+		if !valid {
+			return fmt.Errorf("scope must be one of %s; got %q", strings.Join(scopes, ", "), s)
+		}
+	}
+	if t.Profile != "" && !validProfile(t.Profile) {
+		return fmt.Errorf("profile must be one of %s; got %q", strings.Join(profiles, ", "), t.Profile)
+	}
+	return nil
+}
+
+// quota limits the number of moves in each period.
+type quota struct {
+	name   string
+	limit  int
+	period time.Duration
+	start  time.Time
+	used   int
+}
+
+// quotaError is returned for moves that exceed a quota.
+type quotaError struct {
+	q     *quota
+	retry time.Duration
+}
+
+func (e *quotaError) Error() string {
+	return fmt.Sprintf("quota of %d moves per %s exceeded", e.q.limit, e.q.name)
+}
+
+// writeQuotaError responds to a move that exceeded a quota.
+func writeQuotaError(w http.ResponseWriter, err *quotaError) {
+	// Round up so that clients do not retry too early.
+	w.Header().Set("Retry-After", strconv.Itoa(int(err.retry.Seconds())+1))
+	http.Error(w, err.Error(), http.StatusTooManyRequests)
+}
+
+// check returns an error if no moves are left in the current period.
+func (q *quota) check(now time.Time) *quotaError {
+	if start := now.Truncate(q.period); start != q.start {
+		q.start = start
+		q.used = 0
+	}
+	if q.used >= q.limit {
+		return &quotaError{q: q, retry: q.start.Add(q.period).Sub(now)}
+	}
+	return nil
+}
+
+// identity is a client that authenticated with a token.
+type identity struct {
+	name  string
+	token []byte
+
+	scopes map[string]bool
+	// profile is the UI profile of the token, if set.
+	profile string
+	// source is the source of the commands made with the token.
+	source string
+	// rate is the maximum rate of change of the position
+	// of any servo in units per second, if set.
+	rate float64
+
+	mu     sync.Mutex
+	moves  uint64
+	last   time.Time
+	quotas []*quota
+	// travel is what is left of the rate limit for each servo.
+	travel map[string]*travel
+}
+
+// identityStats are the usage statistics of an identity.
+type identityStats struct {
+	Name         string     `json:"name"`
+	Moves        uint64     `json:"moves"`
+	LastActivity *time.Time `json:"lastActivity,omitempty"`
+}
+
+// record counts a request of the identity.
+// Moves are commands that a servo accepted, however they were sent;
+// if a move would exceed a quota, it is not counted and an error is
+// returned. A nil identity, i.e. an unauthenticated client, has no
+// quotas.
+func (i *identity) record(move bool) *quotaError {
+	if i == nil {
+		return nil
+	}
+	now := time.Now()
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.last = now
+	identityLastActivity.WithLabelValues(i.name).Set(float64(now.UnixNano()) / 1e9)
+	if !move {
+		return nil
+	}
+	for _, q := range i.quotas {
+		if err := q.check(now); err != nil {
+			quotaExceededTotal.WithLabelValues(i.name, q.name).Inc()
+			return err
+		}
+	}
+	for _, q := range i.quotas {
+		q.used++
+	}
+	i.moves++
+	identityMovesTotal.WithLabelValues(i.name).Inc()
+	return nil
+}
+
+func (i *identity) stats() identityStats {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	s := identityStats{Name: i.name, Moves: i.moves}
+	if !i.last.IsZero() {
+		last := i.last
+		s.LastActivity = &last
+	}
+	return s
+}
+
+type identityKey struct{}
+
+// identityFrom returns the identity that made the request with
+// the given context, if authentication is enabled.
+func identityFrom(ctx context.Context) *identity {
+	id, _ := ctx.Value(identityKey{}).(*identity)
+	return id
+}
+
+// authenticator requires clients to present one of the configured
+// tokens and tracks the usage of each.
+type authenticator struct {
+	identities []*identity
+	logger     log.Logger
+}
+
+func newAuthenticator(tokens []tokenConfig, logger log.Logger) *authenticator {
+	a := &authenticator{logger: logger}
+	for _, t := range tokens {
+		id := &identity{name: t.Name, token: []byte(t.Token), scopes: make(map[string]bool), profile: t.Profile, source: t.Source, rate: t.MaxRate, travel: make(map[string]*travel)}
+		if id.source == "" {
+			id.source = tokenSourcePrefix + t.Name
+		}
+		granted := t.Scopes
+		if len(granted) == 0 {
+			granted = scopes
+		}
+		for _, s := range granted {
+			id.scopes[s] = true
+		}
+		if t.MovesPerHour > 0 {
+			id.quotas = append(id.quotas, &quota{name: "hour", limit: t.MovesPerHour, period: time.Hour})
+		}
+		if t.MovesPerDay > 0 {
+			id.quotas = append(id.quotas, &quota{name: "day", limit: t.MovesPerDay, period: 24 * time.Hour})
+		}
+		a.identities = append(a.identities, id)
+		// Export the statistics as 0 before the first request.
+		identityMovesTotal.WithLabelValues(t.Name)
+		if t.MaxRate > 0 {
+			rateLimitedTotal.WithLabelValues(t.Name)
+		}
+	}
+	return a
+}
+
+// authenticate returns the identity with the given token.
+// All tokens are compared so that the time taken does not
+// reveal which token matched.
+func (a *authenticator) authenticate(token string) *identity {
+	var match *identity
+	for _, i := range a.identities {
+		if subtle.ConstantTimeCompare(i.token, []byte(token)) == 1 {
+			match = i
+		}
+	}
+	return match
+}
+
+// credentials extracts the token from an Authorization header.
+// Besides bearer tokens, the token is accepted as the password of
+// HTTP basic authentication, so that browsers can prompt for it.
+func credentials(r *http.Request) string {
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+	return bearer(r.Header.Get("Authorization"))
+}
+
+func bearer(header string) string {
+	const prefix = "Bearer "
+	if len(header) > len(prefix) && strings.EqualFold(header[:len(prefix)], prefix) {
+		return header[len(prefix):]
+	}
+	return ""
+}
+
+// handler requires requests to next to be authenticated and passes
+// the identity on in the request context.
+func (a *authenticator) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := a.authenticate(credentials(r))
+		if id == nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="servor"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		if scope := requiredScope(r); scope != "" && !id.scopes[scope] {
+			http.Error(w, fmt.Sprintf("token %q lacks the %s scope", id.name, scope), http.StatusForbidden)
+			return
+		}
+		// Moves are counted against the quotas when they are
+		// accepted by the servo, whatever the transport.
+		id.record(false)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, id)))
+	})
+}
+
+// authenticateRPC authenticates a gRPC call by the bearer token in
+// its metadata and returns a context that carries the identity.
+func (a *authenticator) authenticateRPC(ctx context.Context, method string) (context.Context, error) {
+	var token string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("authorization"); len(v) != 0 {
+			token = bearer(v[0])
+		}
+	}
+	id := a.authenticate(token)
+	if id == nil {
+		return nil, status.Error(codes.Unauthenticated, "a valid bearer token is required")
+	}
+	move := !readOnlyRPCs[path.Base(method)]
+	scope := scopeRead
+	if move {
+		scope = scopeMove
+	}
+	if !id.scopes[scope] {
+		return nil, status.Errorf(codes.PermissionDenied, "token %q lacks the %s scope", id.name, scope)
+	}
+	id.record(false)
+	return context.WithValue(ctx, identityKey{}, id), nil
+}
+
+// unary authenticates unary gRPC calls.
+func (a *authenticator) unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := a.authenticateRPC(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// stream authenticates streaming gRPC calls.
+func (a *authenticator) stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := a.authenticateRPC(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &identityStream{ServerStream: ss, ctx: ctx})
+}
+
+// identityStream overrides the context of a stream
+// with one that carries the identity.
+type identityStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *identityStream) Context() context.Context {
+	return s.ctx
+}
+
+// ServeHTTP serves the usage statistics of all identities.
+func (a *authenticator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	stats := make([]identityStats, 0, len(a.identities))
+	for _, i := range a.identities {
+		stats = append(stats, i.stats())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		level.Error(a.logger).Log("err", err)
+	}
+}
+
+// session describes the caller.
+type session struct {
+	// Name is the name of the token, if authentication is enabled.
+	Name   string   `json:"name,omitempty"`
+	Scopes []string `json:"scopes"`
+	// Profile is the UI profile that the caller starts with.
+	Profile string `json:"profile"`
+	// Source is the source of the commands made with the token,
+	// which is the only source that the caller may declare,
+	// if authentication is enabled.
+	Source string `json:"source,omitempty"`
+}
+
+// sessionHandler serves the name, scopes, and UI profile of the caller,
+// so that the UI can hide the controls that the caller may not use
+// or that its profile leaves out.
+func sessionHandler(profile string, logger log.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		s := session{Scopes: scopes, Profile: profile}
+		if id := identityFrom(r.Context()); id != nil {
+			s.Name, s.Source = id.name, id.source
+			if id.profile != "" {
+				s.Profile = id.profile
+			}
+			s.Scopes = make([]string, 0, len(scopes))
+			for _, scope := range scopes {
+				if id.scopes[scope] {
+					s.Scopes = append(s.Scopes, scope)
+				}
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(s); err != nil {
+			level.Error(logger).Log("err", err)
+		}
+	})
+}
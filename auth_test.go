@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestQuotaCheck(t *testing.T) {
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name   string
+		period time.Duration
+		used   int
+		now    time.Time
+		err    bool
+		retry  time.Duration
+	}{
+		{name: "moves left", period: time.Hour, used: 1, now: start.Add(10 * time.Minute)},
+		{name: "exhausted", period: time.Hour, used: 2, now: start.Add(10 * time.Minute), err: true, retry: 50 * time.Minute},
+		{name: "end of the hour", period: time.Hour, used: 2, now: start.Add(time.Hour - time.Second), err: true, retry: time.Second},
+		{name: "next hour", period: time.Hour, used: 2, now: start.Add(time.Hour)},
+		{name: "same day", period: 24 * time.Hour, used: 2, now: start.Add(13*time.Hour + 59*time.Minute), err: true, retry: time.Minute},
+		{name: "next day", period: 24 * time.Hour, used: 2, now: start.Add(14 * time.Hour)},
+	} {
+		q := &quota{name: tc.name, limit: 2, period: tc.period, start: start.Truncate(tc.period), used: tc.used}
+		err := q.check(tc.now)
+		if (err != nil) != tc.err {
+			t.Errorf("%s: expected an error: %t; got %v", tc.name, tc.err, err)
+			continue
+		}
+		if err != nil && err.retry != tc.retry {
+			t.Errorf("%s: expected to retry after %s; got %s", tc.name, tc.retry, err.retry)
+		}
+		if err == nil && tc.now.Truncate(tc.period) != start.Truncate(tc.period) && q.used != 0 {
+			t.Errorf("%s: expected a new period to reset the moves; got %d", tc.name, q.used)
+		}
+	}
+}
+
+func TestIdentityRecord(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		token    tokenConfig
+		moves    []bool
+		rejected int
+		counted  uint64
+	}{
+		{name: "unlimited", token: tokenConfig{Name: "unlimited", Token: "a"}, moves: []bool{true, true, true}, counted: 3},
+		{name: "hourly", token: tokenConfig{Name: "hourly", Token: "b", MovesPerHour: 2}, moves: []bool{true, true, true, true}, rejected: 2, counted: 2},
+		{name: "daily", token: tokenConfig{Name: "daily", Token: "c", MovesPerHour: 5, MovesPerDay: 1}, moves: []bool{true, true}, rejected: 1, counted: 1},
+		{name: "reads", token: tokenConfig{Name: "reads", Token: "d", MovesPerHour: 1}, moves: []bool{false, false, true, false}, counted: 1},
+	} {
+		id := newAuthenticator([]tokenConfig{tc.token}, log.NewNopLogger()).identities[0]
+		var rejected int
+		for _, move := range tc.moves {
+			if err := id.record(move); err != nil {
+				rejected++
+			}
+		}
+		if rejected != tc.rejected {
+			t.Errorf("%s: expected %d rejected moves; got %d", tc.name, tc.rejected, rejected)
+		}
+		s := id.stats()
+		if s.Moves != tc.counted {
+			t.Errorf("%s: expected %d counted moves; got %d", tc.name, tc.counted, s.Moves)
+		}
+		if s.LastActivity == nil {
+			t.Errorf("%s: expected the last activity to be recorded", tc.name)
+		}
+	}
+	var id *identity
+	if err := id.record(true); err != nil {
+		t.Errorf("unauthenticated: expected no quota; got %v", err)
+	}
+}
+
+func TestRequiredScope(t *testing.T) {
+	for _, tc := range []struct {
+		method   string
+		path     string
+		expected string
+	}{
+		{method: http.MethodGet, path: "/api/position", expected: scopeRead},
+		{method: http.MethodGet, path: "/api/servos/pan/position", expected: scopeRead},
+		{method: http.MethodGet, path: "/api/me", expected: ""},
+		{method: http.MethodGet, path: "/api/tokens", expected: scopeAdmin},
+		{method: http.MethodGet, path: "/api/ws", expected: scopeMove},
+		{method: http.MethodGet, path: "/api/chaos", expected: scopeAdmin},
+		{method: http.MethodPost, path: "/api/servos/pan/chaos", expected: scopeAdmin},
+		{method: http.MethodPost, path: "/api/left", expected: scopeMove},
+		{method: http.MethodPost, path: "/api/servos/pan/position", expected: scopeMove},
+		{method: http.MethodPost, path: "/api/stop", expected: scopeMove},
+		{method: http.MethodPost, path: "/api/presets/door", expected: scopeMove},
+		{method: http.MethodPut, path: "/api/moves/current", expected: scopeMove},
+		{method: http.MethodDelete, path: "/api/moves/current", expected: scopeMove},
+		{method: http.MethodPost, path: "/api/oscillate", expected: scopeSequences},
+		{method: http.MethodPost, path: "/api/demo", expected: scopeSequences},
+		{method: http.MethodPost, path: "/api/patrol", expected: scopeSequences},
+		{method: http.MethodPost, path: "/api/tours/sweep", expected: scopeSequences},
+		{method: http.MethodPost, path: "/api/servos/pan/sequences/wave", expected: scopeSequences},
+		{method: http.MethodPost, path: "/api/replay", expected: scopeSequences},
+		{method: http.MethodPost, path: "/api/settings/import", expected: scopeConfig},
+		{method: http.MethodPost, path: "/api/restore", expected: scopeConfig},
+		{method: http.MethodPost, path: "/api/calibration", expected: scopeConfig},
+		{method: http.MethodPost, path: "/api/calibration/move", expected: scopeConfig},
+		{method: http.MethodPost, path: "/api/maintenance", expected: scopeConfig},
+		{method: http.MethodPost, path: "/api/recording/start", expected: scopeConfig},
+		{method: http.MethodPut, path: "/api/sequences/wave", expected: scopeConfig},
+		{method: http.MethodDelete, path: "/api/sequences/wave", expected: scopeConfig},
+	} {
+		r := httptest.NewRequest(tc.method, tc.path, nil)
+		if got := requiredScope(r); got != tc.expected {
+			t.Errorf("%s %s: expected scope %q; got %q", tc.method, tc.path, tc.expected, got)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// boneOutput is a PWM output of the BeagleBone.
+type boneOutput struct {
+	// header is the name of the header pin, e.g. P9_14.
+	header string
+	// module is the address of the PWM module, which
+	// identifies its chip independent of the kernel version.
+	module  string
+	channel int
+}
+
+// bonePins maps the PWM-capable pins of the BeagleBone's headers,
+// numbered as header * 100 + pin, e.g. 914 for P9_14, to their outputs.
+// Pins that share an output, e.g. P9_22 and P9_31, cannot be used together.
+var bonePins = map[int]boneOutput{
+	// EHRPWM0A and EHRPWM0B.
+	922: {"P9_22", "48300200", 0},
+	931: {"P9_31", "48300200", 0},
+	921: {"P9_21", "48300200", 1},
+	929: {"P9_29", "48300200", 1},
+	// EHRPWM1A and EHRPWM1B.
+	914: {"P9_14", "48302200", 0},
+	916: {"P9_16", "48302200", 1},
+	// EHRPWM2A and EHRPWM2B.
+	819: {"P8_19", "48304200", 0},
+	813: {"P8_13", "48304200", 1},
+	// ECAP0.
+	942: {"P9_42", "48300100", 0},
+}
+
+// boneOCP holds the pinmux helpers of the cape-universal overlay.
+var boneOCP = "/sys/devices/platform/ocp"
+
+// beaglebone drives servos with the PWM subsystem of the BeagleBone.
+// Its PWM modules are separate chips whose numbers depend on the kernel,
+// so the driver finds the chip of each pin by the module's address and
+// muxes the pin to PWM, like config-pin, if cape-universal is loaded.
+type beaglebone struct {
+	root      string
+	frequency float64
+	chips     map[string]*pwm
+	muxed     map[int]bool
+}
+
+func newBeagleBone(root string, frequency float64) *beaglebone {
+	return &beaglebone{root: root, frequency: frequency, chips: make(map[string]*pwm), muxed: make(map[int]bool)}
+}
+
+// Set implements driver.
+func (b *beaglebone) Set(pin int, value float64) error {
+	out, ok := bonePins[pin]
+	if !ok {
+		return fmt.Errorf("pin %d is not a PWM pin of the BeagleBone; use one of %s", pin, bonePinList())
+	}
+	if !b.muxed[pin] {
+		if err := b.mux(out); err != nil {
+			return err
+		}
+		b.muxed[pin] = true
+	}
+	chip, err := b.chip(out.module)
+	if err != nil {
+		return err
+	}
+	return chip.Set(out.channel, value)
+}
+
+// mux selects the PWM mode of the header pin. Without cape-universal,
+// there is nothing to do, since the overlay that enables the PWM module
+// must mux the pin itself.
+func (b *beaglebone) mux(out boneOutput) error {
+	state := filepath.Join(boneOCP, fmt.Sprintf("ocp:%s_pinmux", out.header), "state")
+	if _, err := os.Stat(state); os.IsNotExist(err) {
+		return nil
+	}
+	if err := ioutil.WriteFile(state, []byte("pwm"), 0644); err != nil {
+		return fmt.Errorf("failed to mux %s to PWM; try config-pin %s pwm: %v", out.header, out.header, err)
+	}
+	return nil
+}
+
+// chip returns the PWM chip of the module with the given address.
+func (b *beaglebone) chip(module string) (*pwm, error) {
+	if p, ok := b.chips[module]; ok {
+		return p, nil
+	}
+	dirs, err := filepath.Glob(filepath.Join(b.root, "pwmchip*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		path, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			continue
+		}
+		if strings.Contains(path, "/"+module+".") {
+			p := newPWM(dir, b.frequency, nil)
+			b.chips[module] = p
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("no PWM chip found for module %s; is its overlay loaded?", module)
+}
+
+// Close implements driver.
+// Channels are left enabled so that the servo keeps its position.
+func (b *beaglebone) Close() error {
+	b.chips = make(map[string]*pwm)
+	return nil
+}
+
+// bonePinList lists the PWM-capable pins of the BeagleBone.
+func bonePinList() string {
+	var pins []int
+	for pin := range bonePins {
+		pins = append(pins, pin)
+	}
+	sort.Ints(pins)
+	var list []string
+	for _, pin := range pins {
+		list = append(list, fmt.Sprintf("%d (%s)", pin, bonePins[pin].header))
+	}
+	return strings.Join(list, ", ")
+}
@@ -0,0 +1,639 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// attCID is the L2CAP channel of the attribute protocol on LE links.
+	attCID = 4
+	// attDefaultMTU is the MTU of a link until the client asks for more.
+	attDefaultMTU = 23
+	// bleMTU is the largest MTU that servor agrees to.
+	bleMTU = 185
+	// blePollTimeout bounds how long the server blocks without
+	// checking whether it should stop.
+	blePollTimeout = 500
+)
+
+// Opcodes of the attribute protocol that servor handles.
+const (
+	attError                   = 0x01
+	attMTURequest              = 0x02
+	attMTUResponse             = 0x03
+	attFindInformationRequest  = 0x04
+	attFindInformationResponse = 0x05
+	attFindByTypeValueRequest  = 0x06
+	attFindByTypeValueResponse = 0x07
+	attReadByTypeRequest       = 0x08
+	attReadByTypeResponse      = 0x09
+	attReadRequest             = 0x0a
+	attReadResponse            = 0x0b
+	attReadBlobRequest         = 0x0c
+	attReadBlobResponse        = 0x0d
+	attReadByGroupTypeRequest  = 0x10
+	attReadByGroupTypeResponse = 0x11
+	attWriteRequest            = 0x12
+	attWriteResponse           = 0x13
+	attNotification            = 0x1b
+	attWriteCommand            = 0x52
+)
+
+// Error codes of the attribute protocol.
+const (
+	attInvalidHandle          = 0x01
+	attReadNotPermitted       = 0x02
+	attWriteNotPermitted      = 0x03
+	attInvalidPDU             = 0x04
+	attRequestNotSupported    = 0x06
+	attInvalidOffset          = 0x07
+	attNotFound               = 0x0a
+	attUnlikely               = 0x0e
+	attUnsupportedGroupType   = 0x10
+	attInvalidAttributeLength = 0x0d
+)
+
+// Properties of GATT characteristics.
+const (
+	gattRead                 = 0x02
+	gattWriteWithoutResponse = 0x04
+	gattWrite                = 0x08
+	gattNotify               = 0x10
+)
+
+// Types of GATT attributes.
+var (
+	gattPrimaryService = uuid16(0x2800)
+	gattCharacteristic = uuid16(0x2803)
+	gattClientConfig   = uuid16(0x2902)
+)
+
+// The UUIDs of servor's GATT service and its characteristics.
+var (
+	bleServiceUUID  = uuid128("8f1d0001-5e4b-4c6a-9d1f-2a3b4c5d6e7f")
+	blePositionUUID = uuid128("8f1d0002-5e4b-4c6a-9d1f-2a3b4c5d6e7f")
+	blePresetsUUID  = uuid128("8f1d0003-5e4b-4c6a-9d1f-2a3b4c5d6e7f")
+)
+
+// uuid16 returns a 16-bit UUID as sent on the air, i.e. little-endian.
+func uuid16(u uint16) []byte {
+	return []byte{byte(u), byte(u >> 8)}
+}
+
+// uuid128 returns a 128-bit UUID as sent on the air, i.e. little-endian.
+func uuid128(u string) []byte {
+	b, err := hex.DecodeString(strings.Replace(u, "-", "", -1))
+	if err != nil || len(b) != 16 {
+		panic(fmt.Sprintf("invalid UUID %q", u))
+	}
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
+
+var errUnknownPreset = errors.New("unknown preset")
+
+// bleServer serves a GATT service that controls a servo over
+// Bluetooth LE, e.g. from a phone app or a BLE remote when Wi-Fi
+// is down or before servor has network credentials. It uses the
+// kernel's HCI and L2CAP sockets directly, so BlueZ's bluetoothd,
+// which would claim the attribute protocol, must not be running.
+// It serves one client at a time, since advertising stops while
+// a client is connected.
+type bleServer struct {
+	adapter int
+	name    string
+	servo   *servor
+	logger  log.Logger
+}
+
+// newBLEServer creates a server that advertises the given name on
+// the given adapter, e.g. hci0, and controls the given servo.
+func newBLEServer(adapter, name string, s *servor, logger log.Logger) (*bleServer, error) {
+	n, err := strconv.Atoi(strings.TrimPrefix(adapter, "hci"))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid Bluetooth adapter %q; expected e.g. hci0", adapter)
+	}
+	return &bleServer{adapter: n, name: name, servo: s, logger: logger}, nil
+}
+
+// run advertises the service and serves clients until the context is done.
+func (b *bleServer) run(ctx context.Context) error {
+	hci, err := unix.Socket(unix.AF_BLUETOOTH, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.BTPROTO_HCI)
+	if err != nil {
+		return fmt.Errorf("failed to open HCI socket: %v", err)
+	}
+	defer unix.Close(hci)
+	if err := unix.Bind(hci, &unix.SockaddrHCI{Dev: uint16(b.adapter), Channel: unix.HCI_CHANNEL_RAW}); err != nil {
+		return fmt.Errorf("failed to bind HCI socket: %v", err)
+	}
+	// Only receive the events that complete commands.
+	filter := make([]byte, 14)
+	binary.LittleEndian.PutUint32(filter[0:], 1<<hciEventPacket)
+	binary.LittleEndian.PutUint32(filter[4:], 1<<hciCommandComplete|1<<hciCommandStatus)
+	if err := unix.SetsockoptString(hci, unix.SOL_HCI, hciFilter, string(filter)); err != nil {
+		return fmt.Errorf("failed to filter HCI socket: %v", err)
+	}
+	l2, err := unix.Socket(unix.AF_BLUETOOTH, unix.SOCK_SEQPACKET|unix.SOCK_CLOEXEC, unix.BTPROTO_L2CAP)
+	if err != nil {
+		return fmt.Errorf("failed to open L2CAP socket: %v", err)
+	}
+	defer unix.Close(l2)
+	if err := unix.Bind(l2, &unix.SockaddrL2{CID: attCID, AddrType: unix.BDADDR_LE_PUBLIC}); err != nil {
+		return fmt.Errorf("failed to bind L2CAP socket; is bluetoothd running?: %v", err)
+	}
+	if err := unix.Listen(l2, 1); err != nil {
+		return fmt.Errorf("failed to listen on L2CAP socket: %v", err)
+	}
+	defer hciCommand(hci, hciSetAdvertiseEnable, 0)
+	for {
+		if err := b.advertise(hci); err != nil {
+			return err
+		}
+		ok, err := readable(ctx, l2)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		fd, _, err := unix.Accept(l2)
+		if err != nil {
+			level.Warn(b.logger).Log("msg", "failed to accept Bluetooth connection", "err", err)
+			continue
+		}
+		level.Info(b.logger).Log("msg", "Bluetooth client connected")
+		c := &bleConn{fd: fd, mtu: attDefaultMTU, server: b}
+		c.serve(ctx)
+		unix.Close(fd)
+		level.Info(b.logger).Log("msg", "Bluetooth client disconnected")
+	}
+}
+
+// advertise advertises the service as connectable. The controller
+// stops advertising when a client connects.
+func (b *bleServer) advertise(hci int) error {
+	// Disabling advertising that is already disabled
+	// fails on some controllers, so errors are ignored.
+	hciCommand(hci, hciSetAdvertiseEnable, 0)
+	// Advertise every 100ms on all channels.
+	params := []byte{0xa0, 0x00, 0xa0, 0x00, 0x00, 0x00, 0x00, 0, 0, 0, 0, 0, 0, 0x07, 0x00}
+	if err := hciCommand(hci, hciSetAdvertisingParameters, params...); err != nil {
+		return err
+	}
+	// The advertisement holds the flags and the service UUID,
+	// and the scan response holds the name.
+	data := append([]byte{2, 0x01, 0x06, 17, 0x07}, bleServiceUUID...)
+	if err := hciCommand(hci, hciSetAdvertisingData, adStructure(data)...); err != nil {
+		return err
+	}
+	name := b.name
+	if len(name) > 29 {
+		name = name[:29]
+	}
+	if err := hciCommand(hci, hciSetScanResponseData, adStructure(append([]byte{byte(len(name) + 1), 0x09}, name...))...); err != nil {
+		return err
+	}
+	return hciCommand(hci, hciSetAdvertiseEnable, 1)
+}
+
+// adStructure pads advertising data to the 31 bytes that the
+// controller expects, preceded by the length of the data.
+func adStructure(data []byte) []byte {
+	p := make([]byte, 32)
+	p[0] = byte(len(data))
+	copy(p[1:], data)
+	return p
+}
+
+// HCI packets, events, and LE commands used to advertise.
+const (
+	hciCommandPacket            = 0x01
+	hciEventPacket              = 0x04
+	hciCommandComplete          = 0x0e
+	hciCommandStatus            = 0x0f
+	hciSetAdvertisingParameters = 0x0006
+	hciSetAdvertisingData       = 0x0008
+	hciSetScanResponseData      = 0x0009
+	hciSetAdvertiseEnable       = 0x000a
+	// hciFilter is the socket option that filters HCI packets.
+	hciFilter = 2
+)
+
+// hciCommand sends an LE command to the controller and waits for it to complete.
+func hciCommand(fd int, ocf uint16, params ...byte) error {
+	op := uint16(0x08)<<10 | ocf
+	cmd := append([]byte{hciCommandPacket, byte(op), byte(op >> 8), byte(len(params))}, params...)
+	if _, err := unix.Write(fd, cmd); err != nil {
+		return fmt.Errorf("failed to send HCI command %#04x: %v", op, err)
+	}
+	buf := make([]byte, 260)
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+		fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+		if n, err := unix.Poll(fds, blePollTimeout); err != nil || n == 0 {
+			continue
+		}
+		n, err := unix.Read(fd, buf)
+		if err != nil {
+			return fmt.Errorf("failed to read HCI event: %v", err)
+		}
+		e := buf[:n]
+		var status byte
+		switch {
+		case len(e) >= 7 && e[1] == hciCommandComplete && binary.LittleEndian.Uint16(e[4:]) == op:
+			status = e[6]
+		case len(e) >= 7 && e[1] == hciCommandStatus && binary.LittleEndian.Uint16(e[5:]) == op:
+			status = e[3]
+		default:
+			continue
+		}
+		if status != 0 {
+			return fmt.Errorf("HCI command %#04x failed with status %#02x", op, status)
+		}
+		return nil
+	}
+	return fmt.Errorf("HCI command %#04x timed out", op)
+}
+
+// readable waits until the socket can be read or the context is done,
+// in which case it returns false.
+func readable(ctx context.Context, fd int) (bool, error) {
+	for ctx.Err() == nil {
+		fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+		n, err := unix.Poll(fds, blePollTimeout)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if n > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// bleAttribute is an attribute of the GATT database.
+// Its handle is its index in the database plus 1.
+type bleAttribute struct {
+	typ   []byte
+	read  func() []byte
+	write func([]byte) error
+}
+
+// bleConn is a connection to a client.
+type bleConn struct {
+	fd     int
+	server *bleServer
+	attrs  []bleAttribute
+
+	mu     sync.Mutex
+	mtu    int
+	notify bool
+}
+
+// The handles of the characteristic values and of
+// the client configuration of the position.
+const (
+	blePositionHandle     = 3
+	bleClientConfigHandle = 4
+	blePresetsHandle      = 6
+)
+
+// attributes returns the GATT database of servor's service: the
+// position, which can be read, written to move the servo, and
+// subscribed to, and the presets, which can be read as a list of
+// names separated by newlines and written to move to one of them.
+// Values are UTF-8 text so that generic BLE apps can use them.
+func (c *bleConn) attributes() []bleAttribute {
+	s := c.server.servo
+	constant := func(v []byte) func() []byte {
+		return func() []byte { return v }
+	}
+	characteristic := func(props byte, handle uint16, uuid []byte) []byte {
+		return append([]byte{props, byte(handle), byte(handle >> 8)}, uuid...)
+	}
+	return []bleAttribute{
+		{typ: gattPrimaryService, read: constant(bleServiceUUID)},
+		{typ: gattCharacteristic, read: constant(characteristic(gattRead|gattWrite|gattWriteWithoutResponse|gattNotify, blePositionHandle, blePositionUUID))},
+		{typ: blePositionUUID, read: c.position, write: func(v []byte) error {
+			target, err := strconv.ParseFloat(strings.TrimSpace(string(v)), 64)
+			if err != nil {
+				return err
+			}
+			return c.server.move(func() (float64, error) { return target, nil })
+		}},
+		{typ: gattClientConfig, read: func() []byte {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if c.notify {
+				return []byte{1, 0}
+			}
+			return []byte{0, 0}
+		}, write: func(v []byte) error {
+			if len(v) != 2 {
+				return errors.New("invalid client configuration")
+			}
+			c.mu.Lock()
+			c.notify = v[0]&1 == 1
+			c.mu.Unlock()
+			return nil
+		}},
+		{typ: gattCharacteristic, read: constant(characteristic(gattRead|gattWrite, blePresetsHandle, blePresetsUUID))},
+		{typ: blePresetsUUID, read: func() []byte {
+			s.mu.Lock()
+			names := make([]string, 0, len(s.presets))
+			for name := range s.presets {
+				names = append(names, name)
+			}
+			s.mu.Unlock()
+			sort.Strings(names)
+			return []byte(strings.Join(names, "\n"))
+		}, write: func(v []byte) error {
+			name := strings.TrimSpace(string(v))
+			return c.server.move(func() (float64, error) {
+				p, ok := s.presets[name]
+				if !ok {
+					return 0, errUnknownPreset
+				}
+				return p, nil
+			})
+		}},
+	}
+}
+
+// position returns the position of the servo as text.
+func (c *bleConn) position() []byte {
+	s := c.server.servo
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return []byte(strconv.FormatFloat(s.position, 'g', 6, 64))
+}
+
+// move drives the servo to the target returned by the given function,
+// which is called with the lock held.
+func (b *bleServer) move(target func() (float64, error)) error {
+	s := b.servo
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Commands over Bluetooth come from operators nearby.
+	if err := s.command(priorityManual, sourceBLE); err != nil {
+		return err
+	}
+	t, err := target()
+	if err != nil {
+		return err
+	}
+	s.stop(reasonSuperseded)
+	return s.move(t)
+}
+
+// serve handles requests until the client disconnects or the context
+// is done, and notifies the client of changes to the position.
+func (c *bleConn) serve(ctx context.Context) {
+	c.attrs = c.attributes()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		t := time.NewTicker(telemetryInterval)
+		defer t.Stop()
+		var last []byte
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+			}
+			c.mu.Lock()
+			notify := c.notify
+			c.mu.Unlock()
+			if !notify {
+				last = nil
+				continue
+			}
+			if p := c.position(); !bytes.Equal(p, last) {
+				c.send(append([]byte{attNotification, blePositionHandle, 0}, p...))
+				last = p
+			}
+		}
+	}()
+	buf := make([]byte, bleMTU)
+	for {
+		ok, err := readable(ctx, c.fd)
+		if err != nil || !ok {
+			return
+		}
+		n, err := unix.Read(c.fd, buf)
+		if err != nil || n == 0 {
+			return
+		}
+		if rsp := c.handle(buf[:n]); rsp != nil {
+			c.send(rsp)
+		}
+	}
+}
+
+// send sends a PDU, truncated to the MTU of the link.
+func (c *bleConn) send(pdu []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(pdu) > c.mtu {
+		pdu = pdu[:c.mtu]
+	}
+	if _, err := unix.Write(c.fd, pdu); err != nil {
+		level.Warn(c.server.logger).Log("msg", "failed to send to Bluetooth client", "err", err)
+	}
+}
+
+// handle handles a request and returns the response, if any.
+func (c *bleConn) handle(req []byte) []byte {
+	c.mu.Lock()
+	mtu := c.mtu
+	c.mu.Unlock()
+	op := req[0]
+	fail := func(handle uint16, code byte) []byte {
+		return []byte{attError, op, byte(handle), byte(handle >> 8), code}
+	}
+	// start and end parse the handle range of requests that have one.
+	var start, end uint16
+	switch op {
+	case attFindInformationRequest, attFindByTypeValueRequest, attReadByTypeRequest, attReadByGroupTypeRequest:
+		if len(req) < 5 {
+			return fail(0, attInvalidPDU)
+		}
+		start, end = binary.LittleEndian.Uint16(req[1:]), binary.LittleEndian.Uint16(req[3:])
+		if start == 0 || start > end {
+			return fail(start, attInvalidHandle)
+		}
+		if int(end) > len(c.attrs) {
+			end = uint16(len(c.attrs))
+		}
+	}
+	switch op {
+	case attMTURequest:
+		if len(req) < 3 {
+			return fail(0, attInvalidPDU)
+		}
+		m := int(binary.LittleEndian.Uint16(req[1:]))
+		if m > bleMTU {
+			m = bleMTU
+		}
+		if m < attDefaultMTU {
+			m = attDefaultMTU
+		}
+		// The response is sent with the old MTU.
+		defer func() {
+			c.mu.Lock()
+			c.mtu = m
+			c.mu.Unlock()
+		}()
+		return []byte{attMTUResponse, byte(bleMTU), byte(bleMTU >> 8)}
+	case attFindInformationRequest:
+		rsp := []byte{attFindInformationResponse, 0}
+		for h := start; h <= end; h++ {
+			typ := c.attrs[h-1].typ
+			format := byte(1)
+			if len(typ) == 16 {
+				format = 2
+			}
+			if rsp[1] != 0 && rsp[1] != format || len(rsp)+2+len(typ) > mtu {
+				break
+			}
+			rsp[1] = format
+			rsp = append(rsp, byte(h), byte(h>>8))
+			rsp = append(rsp, typ...)
+		}
+		if rsp[1] == 0 {
+			return fail(start, attNotFound)
+		}
+		return rsp
+	case attFindByTypeValueRequest:
+		if len(req) < 7 {
+			return fail(0, attInvalidPDU)
+		}
+		typ, value := req[5:7], req[7:]
+		rsp := []byte{attFindByTypeValueResponse}
+		for h := start; h <= end && len(rsp)+4 <= mtu; h++ {
+			a := c.attrs[h-1]
+			if bytes.Equal(a.typ, typ) && bytes.Equal(a.read(), value) {
+				g := c.groupEnd(h)
+				rsp = append(rsp, byte(h), byte(h>>8), byte(g), byte(g>>8))
+			}
+		}
+		if len(rsp) == 1 {
+			return fail(start, attNotFound)
+		}
+		return rsp
+	case attReadByTypeRequest, attReadByGroupTypeRequest:
+		typ := req[5:]
+		group := op == attReadByGroupTypeRequest
+		if group && !bytes.Equal(typ, gattPrimaryService) {
+			return fail(start, attUnsupportedGroupType)
+		}
+		rsp := []byte{attReadByTypeResponse, 0}
+		if group {
+			rsp[0] = attReadByGroupTypeResponse
+		}
+		for h := start; h <= end; h++ {
+			a := c.attrs[h-1]
+			if !bytes.Equal(a.typ, typ) {
+				continue
+			}
+			entry := []byte{byte(h), byte(h >> 8)}
+			if group {
+				g := c.groupEnd(h)
+				entry = append(entry, byte(g), byte(g>>8))
+			}
+			entry = append(entry, a.read()...)
+			// All entries of a response have the same length.
+			if rsp[1] != 0 && int(rsp[1]) != len(entry) || len(rsp)+len(entry) > mtu {
+				break
+			}
+			rsp[1] = byte(len(entry))
+			rsp = append(rsp, entry...)
+		}
+		if rsp[1] == 0 {
+			return fail(start, attNotFound)
+		}
+		return rsp
+	case attReadRequest, attReadBlobRequest:
+		if len(req) < 3 || op == attReadBlobRequest && len(req) < 5 {
+			return fail(0, attInvalidPDU)
+		}
+		h := binary.LittleEndian.Uint16(req[1:])
+		if h == 0 || int(h) > len(c.attrs) {
+			return fail(h, attInvalidHandle)
+		}
+		a := c.attrs[h-1]
+		if a.read == nil {
+			return fail(h, attReadNotPermitted)
+		}
+		value := a.read()
+		if op == attReadRequest {
+			return append([]byte{attReadResponse}, value...)
+		}
+		offset := int(binary.LittleEndian.Uint16(req[3:]))
+		if offset > len(value) {
+			return fail(h, attInvalidOffset)
+		}
+		return append([]byte{attReadBlobResponse}, value[offset:]...)
+	case attWriteRequest, attWriteCommand:
+		if len(req) < 3 {
+			return fail(0, attInvalidPDU)
+		}
+		h := binary.LittleEndian.Uint16(req[1:])
+		// Commands are never answered, not even with errors.
+		respond := func(rsp []byte) []byte {
+			if op == attWriteCommand {
+				return nil
+			}
+			return rsp
+		}
+		if h == 0 || int(h) > len(c.attrs) {
+			return respond(fail(h, attInvalidHandle))
+		}
+		a := c.attrs[h-1]
+		if a.write == nil {
+			return respond(fail(h, attWriteNotPermitted))
+		}
+		if err := a.write(req[3:]); err != nil {
+			level.Warn(c.server.logger).Log("msg", "failed to apply Bluetooth write", "err", err)
+			if h == bleClientConfigHandle {
+				return respond(fail(h, attInvalidAttributeLength))
+			}
+			return respond(fail(h, attUnlikely))
+		}
+		return respond([]byte{attWriteResponse})
+	}
+	// Commands, which have bit 6 set, are never answered.
+	if op&0x40 != 0 {
+		return nil
+	}
+	return fail(0, attRequestNotSupported)
+}
+
+// groupEnd returns the last handle of the service that starts at the given handle.
+func (c *bleConn) groupEnd(h uint16) uint16 {
+	for i := int(h); i < len(c.attrs); i++ {
+		if bytes.Equal(c.attrs[i].typ, gattPrimaryService) {
+			return uint16(i)
+		}
+	}
+	return uint16(len(c.attrs))
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"periph.io/x/host/v3/beagle"
+	"periph.io/x/host/v3/distro"
+	"periph.io/x/host/v3/rpi"
+)
+
+// Boards that servor knows the pin semantics of.
+const (
+	boardAuto        = "auto"
+	boardRaspberryPi = "raspberry-pi"
+	boardBeagleBone  = "beaglebone"
+	boardGeneric     = "generic"
+)
+
+var boards = []string{boardAuto, boardRaspberryPi, boardBeagleBone, boardGeneric}
+
+// knownBoard returns whether b names a board that servor supports.
+func knownBoard(b string) bool {
+	for _, k := range boards {
+		if b == k {
+			return true
+		}
+	}
+	return false
+}
+
+// boardDriver returns the default driver for the board:
+// pi-blaster on a Raspberry Pi, the PWM subsystem on a BeagleBone,
+// and the kernel's PWM interface, which every board with hardware
+// PWM offers, elsewhere.
+func boardDriver(board string) string {
+	switch board {
+	case boardRaspberryPi:
+		return "pi-blaster"
+	case boardBeagleBone:
+		return "beaglebone"
+	}
+	return "pwm"
+}
+
+// boardPWMChannels maps the pins of the board to the channels of
+// its PWM chip. For a generic board, nil is returned: as servor
+// cannot know how the board's pins are muxed, the pin is the
+// channel of the PWM chip given as the device.
+func boardPWMChannels(board string) map[int]int {
+	if board == boardRaspberryPi {
+		return bcmPWMChannels
+	}
+	return nil
+}
+
+var (
+	detectOnce sync.Once
+	detected   string
+)
+
+// detectBoard detects the board that servor runs on with periph.io.
+func detectBoard() string {
+	detectOnce.Do(func() {
+		switch {
+		case rpi.Present():
+			detected = boardRaspberryPi
+		case beagle.Present():
+			detected = boardBeagleBone
+		default:
+			detected = boardGeneric
+		}
+	})
+	return detected
+}
+
+// boardModel returns the model of the board as given by the device
+// tree, e.g. Orange Pi PC, or the empty string if it is unknown.
+func boardModel() string {
+	if model := distro.DTModel(); model != "<unknown>" {
+		return model
+	}
+	return ""
+}
+
+// capabilities are the PWM and GPIO interfaces that the board offers.
+type capabilities struct {
+	Board string
+	Model string
+	// PWM maps the PWM chips to their number of channels.
+	PWM map[string]int
+	// GPIO is whether the kernel's GPIO interface is available.
+	GPIO bool
+}
+
+// detectCapabilities probes the kernel's PWM and GPIO interfaces,
+// which the pwm and soft-pwm drivers use. periph.io does not support
+// the kernel's PWM interface, so both are probed in sysfs directly.
+func detectCapabilities(board string) capabilities {
+	c := capabilities{Board: board, Model: boardModel(), PWM: make(map[string]int)}
+	chips, _ := filepath.Glob("/sys/class/pwm/pwmchip*")
+	for _, chip := range chips {
+		if n, err := pwmChannels(chip); err == nil {
+			c.PWM[chip] = n
+		}
+	}
+	if _, err := os.Stat("/sys/class/gpio/export"); err == nil {
+		c.GPIO = true
+	}
+	return c
+}
+
+// String formats the PWM chips for logging.
+func (c capabilities) String() string {
+	var chips []string
+	for chip, n := range c.PWM {
+		chips = append(chips, fmt.Sprintf("%s:%d", filepath.Base(chip), n))
+	}
+	sort.Strings(chips)
+	return strings.Join(chips, ",")
+}
+
+// pwmChannels returns the number of channels of the PWM chip.
+func pwmChannels(chip string) (int, error) {
+	npwm, err := ioutil.ReadFile(filepath.Join(chip, "npwm"))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(npwm)))
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"math"
+)
+
+// calibration is the range of motion found while calibrating a servo.
+type calibration struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+	// Home defaults to the center of the range.
+	Home *float64 `json:"home"`
+}
+
+// calibrationMove is a move made while calibrating a servo.
+// The position may lie outside of the configured range, so that
+// the physical limits of the mechanism can be found.
+type calibrationMove struct {
+	Position float64 `json:"position"`
+}
+
+func (m calibrationMove) validate() error {
+	if m.Position < 0 || m.Position > 1 {
+		return errors.New("position must be between 0 and 1")
+	}
+	return nil
+}
+
+// calibrate moves the servo to the given position, ignoring the
+// configured range, which calibration is meant to find. Otherwise,
+// it is held back like any other move while motion is inhibited or
+// over budget, and it needs the approval of the pre-move hooks.
+// The caller must hold the lock.
+func (s *servor) calibrate(position float64) error {
+	s.stop(reasonSuperseded)
+	if err := s.admit(); err != nil {
+		return err
+	}
+	position, err := s.approveMove(position)
+	if err != nil {
+		return err
+	}
+	// Hooks may move the target, but not beyond the mechanism.
+	s.position = math.Max(0, math.Min(1, position))
+	return s.write()
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	flag "github.com/spf13/pflag"
+	"github.com/squat/servor/pkg/version"
+)
+
+// cliCommand is a command of the servor CLI.
+type cliCommand struct {
+	name  string
+	args  string
+	short string
+	// client marks commands that control a running servor,
+	// which take the client flags rather than the server flags.
+	client bool
+}
+
+// cliCommands are the commands of the servor CLI. Commands that
+// are not clients take the flags of the server, e.g. to validate
+// the same configuration that servor would serve.
+var cliCommands = []cliCommand{
+	{name: "serve", short: "Drive the servos and serve the UI and APIs; this is the default command."},
+	{name: "validate", short: "Check the configuration and the availability of the drivers without serving."},
+	{name: "replay", args: "<command log>", short: "Play back a command log against the driver given by the flags."},
+	{name: "soak", short: "Cycle the servos through a pattern for --soak-duration and report cycles, errors, and timing drift."},
+	{name: "tui", short: "Control a running servor from the terminal.", client: true},
+	{name: "get", args: "[servo]", short: "Print the state of a servo of a running servor, or of all servos.", client: true},
+	{name: "set", args: "[servo] <position>", short: "Move a servo of a running servor to a position.", client: true},
+	{name: "version", short: "Print the version of servor."},
+	{name: "completion", args: "bash|zsh|fish", short: "Print a script that completes servor commands and flags in the given shell."},
+	{name: "help", short: "Print this help."},
+}
+
+// splitCommand splits the arguments into the name of the command and
+// its arguments. Without a command, servor serves, so that invocations
+// with flags alone keep working.
+func splitCommand(args []string) (string, []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return "serve", args
+	}
+	return args[0], args[1:]
+}
+
+// printUsage prints the commands and their flags.
+func printUsage() {
+	w := os.Stderr
+	fmt.Fprintf(w, "Usage:\n  %s [command] [flags]\n\nCommands:\n", os.Args[0])
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, c := range cliCommands {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", c.name, c.args, c.short)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\nFlags of serve, validate, replay, and soak:\n%s", flag.CommandLine.FlagUsages())
+	fs, _, _ := clientFlags("client")
+	fmt.Fprintf(w, "\nFlags of tui, get, and set:\n%s", fs.FlagUsages())
+}
+
+// clientFlags returns the flags of client commands.
+func clientFlags(name string) (*flag.FlagSet, *string, *string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = printUsage
+	server := fs.String("server", "http://localhost:8080", "The URL of the servor to control.")
+	token := fs.String("token", "", "The API token with which to authenticate, if the server requires one.")
+	return fs, server, token
+}
+
+// runCommand runs the commands other than serve, validate, replay, and soak.
+func runCommand(name string, args []string) error {
+	var cmd *cliCommand
+	for i := range cliCommands {
+		if cliCommands[i].name == name {
+			cmd = &cliCommands[i]
+		}
+	}
+	if cmd == nil {
+		return fmt.Errorf("unknown command %q; run %s help for the commands", name, os.Args[0])
+	}
+	if !cmd.client {
+		switch name {
+		case "help":
+			printUsage()
+			return nil
+		case "version":
+			fmt.Println(version.Version)
+			return nil
+		case "completion":
+			if len(args) != 1 {
+				return errors.New("usage: completion bash|zsh|fish")
+			}
+			return completion(os.Stdout, args[0])
+		}
+	}
+	fs, server, token := clientFlags(name)
+	fs.Parse(args)
+	if name == "tui" {
+		if fs.NArg() != 0 {
+			return errors.New("usage: tui [flags]")
+		}
+		return runTUI(*server, *token)
+	}
+	c, err := newAPIClient(*server, *token)
+	if err != nil {
+		return err
+	}
+	var states []clientState
+	switch {
+	case name == "get" && fs.NArg() == 0:
+		var list struct {
+			Servos []clientState `json:"servos"`
+		}
+		if err := c.do(http.MethodGet, "/v1/servos", nil, &list); err != nil {
+			return err
+		}
+		states = list.Servos
+	case name == "get" && fs.NArg() == 1:
+		var st clientState
+		if err := c.do(http.MethodGet, "/v1/servos/"+url.PathEscape(fs.Arg(0)), nil, &st); err != nil {
+			return err
+		}
+		states = append(states, st)
+	case name == "set" && (fs.NArg() == 1 || fs.NArg() == 2):
+		target, err := strconv.ParseFloat(fs.Arg(fs.NArg()-1), 64)
+		if err != nil {
+			return fmt.Errorf("failed to parse position: %v", err)
+		}
+		servo := fs.Arg(0)
+		if fs.NArg() == 1 {
+			// The REST API names every servo, so look up the default one.
+			if servo, err = c.defaultServo(); err != nil {
+				return err
+			}
+		}
+		var st clientState
+		if err := c.do(http.MethodPost, "/v1/servos/"+url.PathEscape(servo)+":move", map[string]float64{"target": target}, &st); err != nil {
+			return err
+		}
+		states = append(states, st)
+	default:
+		return fmt.Errorf("usage: %s %s [flags]", cmd.name, cmd.args)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVO\tPOSITION\tMIN\tMAX\tMEASURED")
+	for _, st := range states {
+		measured := "-"
+		if st.Measured != nil {
+			measured = strconv.FormatFloat(st.Measured.Position, 'g', 6, 64)
+		}
+		fmt.Fprintf(tw, "%s\t%.6g\t%.6g\t%.6g\t%s\n", st.Servo, st.Position, st.Min, st.Max, measured)
+	}
+	return tw.Flush()
+}
+
+// clientState is the state of a servo as returned by the REST API.
+type clientState struct {
+	Servo    string  `json:"servo"`
+	Position float64 `json:"position"`
+	Min      float64 `json:"min"`
+	Max      float64 `json:"max"`
+	Measured *struct {
+		Position float64 `json:"position"`
+	} `json:"measured"`
+}
+
+// apiClient sends requests to a running servor.
+type apiClient struct {
+	server *url.URL
+	token  string
+	client *http.Client
+}
+
+func newAPIClient(server, token string) (*apiClient, error) {
+	u, err := url.Parse(server)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("server must be a URL like http://servor.local:8080; got %q", server)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	return &apiClient{server: u, token: token, client: &http.Client{Timeout: 5 * time.Second}}, nil
+}
+
+// do sends a request to the given path with the given body, if any,
+// encoded as JSON, and decodes the JSON response into v, if given.
+func (c *apiClient) do(method, path string, body, v interface{}) error {
+	u := *c.server
+	u.Path += path
+	var r io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(buf)
+	}
+	req, err := http.NewRequest(method, u.String(), r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(buf))
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(buf, v)
+}
+
+// defaultServo returns the name of the default servo.
+func (c *apiClient) defaultServo() (string, error) {
+	var summaries []servoSummary
+	if err := c.do(http.MethodGet, "/api/servos", nil, &summaries); err != nil {
+		return "", err
+	}
+	if len(summaries) == 0 {
+		return "", errors.New("the server has no servos")
+	}
+	return summaries[0].Name, nil
+}
+
+// completion writes a completion script for the given shell.
+func completion(w io.Writer, shell string) error {
+	var names, serverFlags, clientNames []string
+	for _, c := range cliCommands {
+		names = append(names, c.name)
+		if c.client {
+			clientNames = append(clientNames, c.name)
+		}
+	}
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		serverFlags = append(serverFlags, "--"+f.Name)
+	})
+	fs, _, _ := clientFlags("client")
+	var client []string
+	fs.VisitAll(func(f *flag.Flag) {
+		client = append(client, "--"+f.Name)
+	})
+	switch shell {
+	case "bash", "zsh":
+		if shell == "zsh" {
+			// zsh runs the bash completion with bashcompinit.
+			fmt.Fprintln(w, "autoload -U +X bashcompinit && bashcompinit")
+		}
+		_, err := fmt.Fprintf(w, `_servor() {
+	local cur=${COMP_WORDS[COMP_CWORD]}
+	if [ "$COMP_CWORD" -eq 1 ] && [[ $cur != -* ]]; then
+		COMPREPLY=($(compgen -W %q -- "$cur"))
+		return
+	fi
+	case ${COMP_WORDS[1]} in
+	%s)
+		COMPREPLY=($(compgen -W %q -- "$cur")) ;;
+	completion)
+		COMPREPLY=($(compgen -W "bash zsh fish" -- "$cur")) ;;
+	version|help) ;;
+	*)
+		COMPREPLY=($(compgen -W %q -- "$cur")) ;;
+	esac
+}
+complete -o default -F _servor servor
+`, strings.Join(names, " "), strings.Join(clientNames, "|"), strings.Join(client, " "), strings.Join(serverFlags, " "))
+		return err
+	case "fish":
+		quote := func(s string) string {
+			return "'" + strings.Replace(strings.Replace(s, `\`, `\\`, -1), "'", `\'`, -1) + "'"
+		}
+		fmt.Fprintln(w, "complete -c servor -f")
+		for _, c := range cliCommands {
+			fmt.Fprintf(w, "complete -c servor -n __fish_use_subcommand -a %s -d %s\n", c.name, quote(c.short))
+		}
+		fmt.Fprintln(w, "complete -c servor -n '__fish_seen_subcommand_from completion' -a 'bash zsh fish'")
+		server := fmt.Sprintf("'not __fish_seen_subcommand_from %s version completion help'", strings.Join(clientNames, " "))
+		flag.CommandLine.VisitAll(func(f *flag.Flag) {
+			fmt.Fprintf(w, "complete -c servor -n %s -l %s -d %s\n", server, f.Name, quote(firstSentence(f.Usage)))
+		})
+		fs.VisitAll(func(f *flag.Flag) {
+			fmt.Fprintf(w, "complete -c servor -n '__fish_seen_subcommand_from %s' -l %s -d %s\n", strings.Join(clientNames, " "), f.Name, quote(firstSentence(f.Usage)))
+		})
+		return nil
+	}
+	return fmt.Errorf("unknown shell %q; the shells are bash, zsh, and fish", shell)
+}
+
+// firstSentence returns the first sentence of the usage of a flag,
+// skipping abbreviations like e.g., which are not followed by a capital.
+func firstSentence(s string) string {
+	for i := 0; i+2 < len(s); i++ {
+		if s[i] == '.' && s[i+1] == ' ' && s[i+2] >= 'A' && s[i+2] <= 'Z' {
+			return s[:i]
+		}
+	}
+	return strings.TrimSuffix(s, ".")
+}
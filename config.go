@@ -0,0 +1,657 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// defaultServoName is the name of the servo configured
+// without a list of servos.
+const defaultServoName = "default"
+
+// reservedServoNames are the endpoints that would be shadowed
+// by a servo of the same name.
+var reservedServoNames = map[string]bool{
+	"calibration": true,
+	"center":      true,
+	"chaos":       true,
+	"controls":    true,
+	"demo":        true,
+	"events":      true,
+	"home":        true,
+	"jobs":        true,
+	"jog":         true,
+	"left":        true,
+	"maintenance": true,
+	"me":          true,
+	"moves":       true,
+	"odometer":    true,
+	"oscillate":   true,
+	"pairs":       true,
+	"patrol":      true,
+	"position":    true,
+	"presets":     true,
+	"recording":   true,
+	"replay":      true,
+	"restore":     true,
+	"right":       true,
+	"sequences":   true,
+	"servos":      true,
+	"settings":    true,
+	"snapshot":    true,
+	"status":      true,
+	"stop":        true,
+	"system":      true,
+	"tokens":      true,
+	"tours":       true,
+	"webrtc":      true,
+	"ws":          true,
+}
+
+// servoConfig holds the settings of a servo.
+// It can be loaded from a file, exported, and imported.
+type servoConfig struct {
+	// Name identifies the servo in the API.
+	Name string `json:"name,omitempty"`
+	// Driver configures the hardware that drives the servo.
+	Driver driverConfig `json:"driver"`
+	// Fallbacks are used in order when the driver fails.
+	Fallbacks []driverConfig `json:"fallbacks,omitempty"`
+	Pin       int            `json:"pin"`
+	Min       float64        `json:"min"`
+	Max       float64        `json:"max"`
+	Steps     uint32         `json:"steps"`
+	// Degrees is the angle that the servo turns between min and max;
+	// 0 means that the angle is unknown.
+	Degrees float64 `json:"degrees,omitempty"`
+	// Speed is the maximum speed in units per second at which direct
+	// moves, e.g. to a position or a preset, reach their target;
+	// 0 moves immediately.
+	Speed float64 `json:"speed,omitempty"`
+	// Easing is the easing curve of direct moves at a speed.
+	Easing string `json:"easing,omitempty"`
+	// Home defaults to the center of the range.
+	Home    *float64           `json:"home,omitempty"`
+	Presets map[string]float64 `json:"presets,omitempty"`
+	Tours   map[string]tour    `json:"tours,omitempty"`
+	// Initial is the position that the servo is assumed
+	// to be at on startup; it defaults to home.
+	Initial *float64 `json:"initial,omitempty"`
+	// Sequences are timelines of keyframes, e.g. as
+	// created with the sequence editor in the UI.
+	Sequences map[string]sequence `json:"sequences,omitempty"`
+	Patrol    patrol              `json:"patrol"`
+	// Stall detects stalls with a current sensor and backs the servo off.
+	Stall *stallConfig `json:"stall,omitempty"`
+	// Watchdog drives the servo to a failsafe position
+	// when it receives no command for a while.
+	Watchdog *watchdogConfig `json:"watchdog,omitempty"`
+	// Engage detaches the servo when idle and ramps it
+	// when it is re-engaged.
+	Engage *engageConfig `json:"engage,omitempty"`
+	// Budget limits how much the servo may move within a window.
+	Budget *budgetConfig `json:"budget,omitempty"`
+	// Maintenance configures when the servo is due for maintenance.
+	Maintenance *maintenanceConfig `json:"maintenance,omitempty"`
+	// Power describes the power that the servo draws,
+	// so that its energy usage can be estimated.
+	Power *powerConfig `json:"power,omitempty"`
+}
+
+// config holds the settings of servor.
+// The embedded settings describe a single servo and are
+// only used when no list of servos is given.
+type config struct {
+	servoConfig
+	Servos []servoConfig `json:"servos,omitempty"`
+	// Pairs combine two of the servos into a single control in the UI.
+	Pairs []pairConfig `json:"pairs,omitempty"`
+	// Inputs shape analog inputs, e.g. gamepads, by the name of their source.
+	Inputs map[string]inputConfig `json:"inputs,omitempty"`
+	// Controls map keys and gamepad buttons to actions in the UI;
+	// if none are given, the arrow keys jog and the Home key homes.
+	Controls []controlConfig `json:"controls,omitempty"`
+	// Hooks run local commands on events.
+	Hooks []hookConfig `json:"hooks,omitempty"`
+	// Webhooks post events to URLs.
+	Webhooks []webhookConfig `json:"webhooks,omitempty"`
+	// CallbackHosts are the hosts, e.g. orchestrator.local, that any
+	// client may have jobs post callbacks to, including loopback and
+	// link-local ones; other hosts require the admin scope.
+	CallbackHosts []string `json:"callbackHosts,omitempty"`
+	// Tokens enable authentication; if none are given,
+	// the API is open to anyone who can reach it.
+	Tokens []tokenConfig `json:"tokens,omitempty"`
+	// Sources are additional sources that API clients can declare,
+	// e.g. for use in policies.
+	Sources []string `json:"sources,omitempty"`
+	// Policies are precedence rules between the sources of commands.
+	Policies []policyConfig `json:"policies,omitempty"`
+	// Hosts are virtual hosts that serve groups of the servos.
+	Hosts []hostConfig `json:"hosts,omitempty"`
+	// Startup is the sequence of steps that run on startup
+	// before servor opens its listeners.
+	Startup []startupStep `json:"startup,omitempty"`
+	// LogLevel is the least severe level of the messages that are
+	// logged; one of debug, info, warn, or error.
+	LogLevel string `json:"logLevel,omitempty"`
+	// DryRun replaces the drivers of all servos with mock drivers that
+	// log their writes. It is only set with --dry-run.
+	DryRun bool `json:"-"`
+}
+
+// logLevels are the levels that can be given as LogLevel.
+var logLevels = []string{"debug", "info", "warn", "error"}
+
+// pairConfig combines two servos that move a mechanism along two
+// axes, e.g. a pan/tilt head, into a single control in the UI.
+type pairConfig struct {
+	Name string `json:"name"`
+	// Pan and Tilt are the names of the servos that move the
+	// mechanism horizontally and vertically, respectively.
+	Pan  string `json:"pan"`
+	Tilt string `json:"tilt"`
+}
+
+// loadConfig reads the configuration file at the given path into c.
+// Fields that are not set in the file are left unchanged.
+// The file may be written in YAML or JSON.
+//
+// The file may define named profiles under profiles, e.g. dev and
+// prod, so that the same file serves on a laptop and on the Pi. The
+// settings of the given profile, if any, are merged into those of the
+// file: objects are merged field by field, lists of named objects,
+// e.g. servos and tokens, are merged by name, and other values replace
+// those of the file.
+func loadConfig(path, profile string, c *config) error {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	buf, err = yaml.YAMLToJSON(buf)
+	if err != nil {
+		return fmt.Errorf("failed to parse config file: %v", err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(buf, &doc); err != nil {
+		return fmt.Errorf("failed to parse config file: %v", err)
+	}
+	profiles, ok := doc["profiles"].(map[string]interface{})
+	if _, set := doc["profiles"]; set && !ok {
+		return errors.New("failed to parse config file: profiles must map names to settings")
+	}
+	delete(doc, "profiles")
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	// Every profile is checked, so that typos are found before
+	// the profile is deployed.
+	for _, name := range names {
+		p, ok := profiles[name].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("failed to parse config file: profile %q must be an object", name)
+		}
+		var scratch config
+		if err := decodeConfig(merge(copyValue(doc), copyValue(p)), &scratch); err != nil {
+			return fmt.Errorf("failed to parse profile %q of config file: %v", name, err)
+		}
+	}
+	if profile != "" {
+		p, ok := profiles[profile].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("unknown profile %q; the config file defines %s", profile, strings.Join(names, ", "))
+		}
+		doc = merge(doc, p).(map[string]interface{})
+	}
+	if err := decodeConfig(doc, c); err != nil {
+		return fmt.Errorf("failed to parse config file: %v", err)
+	}
+	return nil
+}
+
+// decodeConfig decodes the parsed configuration file into c,
+// rejecting unknown fields.
+func decodeConfig(doc interface{}, c *config) error {
+	buf, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	d := json.NewDecoder(bytes.NewReader(buf))
+	d.DisallowUnknownFields()
+	return d.Decode(c)
+}
+
+// merge merges the overlay into the parsed value v and returns the result.
+func merge(v, overlay interface{}) interface{} {
+	switch o := overlay.(type) {
+	case map[string]interface{}:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return o
+		}
+		for k, ov := range o {
+			m[k] = merge(m[k], ov)
+		}
+		return m
+	case []interface{}:
+		l, ok := v.([]interface{})
+		if !ok || !named(l) || !named(o) {
+			return o
+		}
+		for _, ov := range o {
+			i := 0
+			for i < len(l) && l[i].(map[string]interface{})["name"] != ov.(map[string]interface{})["name"] {
+				i++
+			}
+			if i == len(l) {
+				l = append(l, ov)
+				continue
+			}
+			l[i] = merge(l[i], ov)
+		}
+		return l
+	}
+	return overlay
+}
+
+// named returns whether every element of the list is an object with a name.
+func named(l []interface{}) bool {
+	for _, v := range l {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		if _, ok := m["name"].(string); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// copyValue returns a deep copy of the parsed value.
+func copyValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[k] = copyValue(e)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, e := range v {
+			l[i] = copyValue(e)
+		}
+		return l
+	}
+	return v
+}
+
+// servos returns the settings of all configured servos.
+func (c *config) servos() []servoConfig {
+	if len(c.Servos) != 0 {
+		return c.Servos
+	}
+	return []servoConfig{c.servoConfig}
+}
+
+// parseServoFlag parses a servo given with --servo as
+// name:key=value,..., whose settings default to those of base,
+// i.e. of the flags that configure a single servo. The servo shares
+// the driver of base but none of its presets and motions.
+func parseServoFlag(v string, base servoConfig) (servoConfig, error) {
+	parts := strings.SplitN(v, ":", 2)
+	c := servoConfig{
+		Name:      parts[0],
+		Driver:    base.Driver,
+		Fallbacks: append([]driverConfig(nil), base.Fallbacks...),
+		Pin:       base.Pin,
+		Min:       base.Min,
+		Max:       base.Max,
+		Steps:     base.Steps,
+		Degrees:   base.Degrees,
+		Speed:     base.Speed,
+		Easing:    base.Easing,
+	}
+	if c.Name == "" {
+		return c, errors.New("name must be set")
+	}
+	if len(parts) == 1 || parts[1] == "" {
+		return c, nil
+	}
+	for _, kv := range strings.Split(parts[1], ",") {
+		p := strings.SplitN(kv, "=", 2)
+		if len(p) != 2 {
+			return c, fmt.Errorf("setting must be given as key=value; got %q", kv)
+		}
+		var err error
+		switch p[0] {
+		case "pin":
+			c.Pin, err = strconv.Atoi(p[1])
+		case "min":
+			c.Min, err = strconv.ParseFloat(p[1], 64)
+		case "max":
+			c.Max, err = strconv.ParseFloat(p[1], 64)
+		case "steps":
+			var steps uint64
+			steps, err = strconv.ParseUint(p[1], 10, 32)
+			c.Steps = uint32(steps)
+		case "degrees":
+			c.Degrees, err = strconv.ParseFloat(p[1], 64)
+		case "speed":
+			c.Speed, err = strconv.ParseFloat(p[1], 64)
+		case "easing":
+			c.Easing = p[1]
+		case "home":
+			var home float64
+			home, err = strconv.ParseFloat(p[1], 64)
+			c.Home = &home
+		default:
+			return c, fmt.Errorf("key must be one of pin, min, max, steps, degrees, speed, easing, and home; got %q", p[0])
+		}
+		if err != nil {
+			return c, fmt.Errorf("invalid %s: %v", p[0], err)
+		}
+	}
+	return c, nil
+}
+
+// dryRun replaces the driver of the servo and its fallbacks with the
+// mock driver. The device of the mock names the replaced driver, so
+// that servos that shared a device still share a mock.
+func (c *servoConfig) dryRun() {
+	d := c.Driver.withDefaults()
+	c.Driver = driverConfig{Type: "mock", Device: d.Type + ":" + d.Device, Frequency: d.Frequency, Board: d.Board}
+	c.Fallbacks = nil
+}
+
+// drivers returns the configurations of the driver and its
+// fallbacks, in order of preference, with defaults applied.
+func (c *servoConfig) drivers() []driverConfig {
+	drivers := []driverConfig{c.Driver.withDefaults()}
+	for _, f := range c.Fallbacks {
+		drivers = append(drivers, f.withDefaults())
+	}
+	return drivers
+}
+
+// defaults fills in settings that were left unset, e.g.
+// in an entry of the list of servos, with their defaults.
+func (c *servoConfig) defaults() {
+	if c.Name == "" {
+		c.Name = defaultServoName
+	}
+	if c.Max == 0 && c.Min == 0 {
+		c.Max = 1
+	}
+	if c.Steps == 0 {
+		c.Steps = 20
+	}
+	if c.Home == nil {
+		home := c.Min + (c.Max-c.Min)/2
+		c.Home = &home
+	}
+	if c.Presets == nil {
+		c.Presets = make(map[string]float64)
+	}
+	if c.Patrol.Dwell == 0 {
+		c.Patrol.Dwell = defaultPatrolDwell.Seconds()
+	}
+}
+
+// validate checks that the configuration is complete and consistent.
+func (c *config) validate() error {
+	if c.LogLevel != "" {
+		valid := false
+		for _, l := range logLevels {
+			valid = valid || c.LogLevel == l
+		}
+		if !valid {
+			return fmt.Errorf("logLevel must be one of %s; got %q", strings.Join(logLevels, ", "), c.LogLevel)
+		}
+	}
+	names := make(map[string]bool)
+	for _, s := range c.servos() {
+		if err := s.validate(); err != nil {
+			if len(c.Servos) == 0 {
+				return err
+			}
+			return fmt.Errorf("invalid servo %q: %v", s.Name, err)
+		}
+		if names[s.Name] {
+			return fmt.Errorf("servo names must be unique; got %q more than once", s.Name)
+		}
+		names[s.Name] = true
+	}
+	// Servos that share a device must use different pins.
+	pins := make(map[string]string)
+	for _, s := range c.servos() {
+		key := fmt.Sprintf("%+v/%d", s.drivers(), s.Pin)
+		if other, ok := pins[key]; ok {
+			return fmt.Errorf("servos %q and %q use the same pin %d of the same device", other, s.Name, s.Pin)
+		}
+		pins[key] = s.Name
+	}
+	pairs := make(map[string]bool)
+	for _, p := range c.Pairs {
+		if p.Name == "" {
+			return errors.New("pair names must be set")
+		}
+		if pairs[p.Name] {
+			return fmt.Errorf("pair names must be unique; got %q more than once", p.Name)
+		}
+		pairs[p.Name] = true
+		if !names[p.Pan] || !names[p.Tilt] {
+			return fmt.Errorf("pair %q must combine configured servos; got %q and %q", p.Name, p.Pan, p.Tilt)
+		}
+		if p.Pan == p.Tilt {
+			return fmt.Errorf("pair %q must combine two different servos", p.Name)
+		}
+	}
+	for name, in := range c.Inputs {
+		if err := in.validate(); err != nil {
+			return fmt.Errorf("invalid input %q: %v", name, err)
+		}
+	}
+	bound := make(map[string]bool)
+	for i, ctl := range c.Controls {
+		if err := ctl.validate(names); err != nil {
+			return fmt.Errorf("invalid control %d: %v", i, err)
+		}
+		binding := "key " + ctl.Key
+		if ctl.Button != nil {
+			binding = fmt.Sprintf("button %d", *ctl.Button)
+		}
+		if bound[binding] {
+			return fmt.Errorf("controls must be unique; got %s more than once", binding)
+		}
+		bound[binding] = true
+	}
+	for i, h := range c.Hooks {
+		if err := h.validate(names); err != nil {
+			return fmt.Errorf("invalid hook %d: %v", i, err)
+		}
+	}
+	for i, h := range c.Webhooks {
+		if err := h.validate(names); err != nil {
+			return fmt.Errorf("invalid webhook %d: %v", i, err)
+		}
+	}
+	for i, h := range c.CallbackHosts {
+		if h == "" || (strings.ContainsAny(h, "/:@") && net.ParseIP(h) == nil) {
+			return fmt.Errorf("invalid callback host %d: must be a host name or an IP address without a port; got %q", i, h)
+		}
+	}
+	tokens := make(map[string]bool)
+	for i, t := range c.Tokens {
+		if err := t.validate(); err != nil {
+			return fmt.Errorf("invalid token %d: %v", i, err)
+		}
+		if tokens[t.Name] {
+			return fmt.Errorf("token names must be unique; got %q more than once", t.Name)
+		}
+		tokens[t.Name] = true
+	}
+	if err := validateSources(c.Sources); err != nil {
+		return err
+	}
+	for i, t := range c.Tokens {
+		if t.Source == "" {
+			continue
+		}
+		if _, err := apiSource(t.Source, nil, newPolicies(c)); err != nil {
+			return fmt.Errorf("invalid token %d: %v", i, err)
+		}
+	}
+	sources := policySources(c)
+	for i, p := range c.Policies {
+		if err := p.validate(names, sources); err != nil {
+			return fmt.Errorf("invalid policy %d: %v", i, err)
+		}
+	}
+	hosts := make(map[string]bool)
+	for i, h := range c.Hosts {
+		if err := h.validate(names); err != nil {
+			return fmt.Errorf("invalid host %d: %v", i, err)
+		}
+		if hosts[h.Name] {
+			return fmt.Errorf("host names must be unique; got %q more than once", h.Name)
+		}
+		hosts[h.Name] = true
+	}
+	for i, s := range c.Startup {
+		if err := s.validate(); err != nil {
+			return fmt.Errorf("invalid startup step %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// validate checks that the settings of a servo are complete and consistent.
+func (c *servoConfig) validate() error {
+	if c.Name == "" {
+		return errors.New("name must be set")
+	}
+	if strings.Contains(c.Name, "/") {
+		return fmt.Errorf("name must not contain a slash; got %q", c.Name)
+	}
+	if reservedServoNames[c.Name] {
+		return fmt.Errorf("name %q is reserved", c.Name)
+	}
+	for _, d := range c.drivers() {
+		if !knownDriver(d.Type) {
+			return fmt.Errorf("driver must be one of %s; got %q", strings.Join(driverTypes, ", "), d.Type)
+		}
+		if !knownBoard(d.Board) {
+			return fmt.Errorf("board must be one of %s; got %q", strings.Join(boards, ", "), d.Board)
+		}
+	}
+	// NaN passes every comparison below,
+	// so it is rejected before them.
+	for _, f := range []struct {
+		name  string
+		value float64
+	}{{"min", c.Min}, {"max", c.Max}, {"degrees", c.Degrees}, {"speed", c.Speed}} {
+		if !finite(f.value) {
+			return fmt.Errorf("%s must be a finite number; got %f", f.name, f.value)
+		}
+	}
+	if c.Min >= c.Max {
+		return fmt.Errorf("min must be less than max; got %f and %f, respectively", c.Min, c.Max)
+	}
+	if c.Steps == 0 {
+		return errors.New("steps must be greater than 0")
+	}
+	if c.Degrees < 0 {
+		return fmt.Errorf("degrees must not be negative; got %f", c.Degrees)
+	}
+	if c.Speed < 0 {
+		return fmt.Errorf("speed must not be negative; got %f", c.Speed)
+	}
+	if _, ok := easings[c.Easing]; !ok {
+		return fmt.Errorf("unknown easing %q", c.Easing)
+	}
+	if c.Home == nil {
+		return errors.New("home must be set")
+	}
+	if !finite(*c.Home) {
+		return fmt.Errorf("home must be a finite number; got %f", *c.Home)
+	}
+	if *c.Home < c.Min || *c.Home > c.Max {
+		return fmt.Errorf("home must be between min and max; got %f", *c.Home)
+	}
+	if c.Initial != nil && (*c.Initial < c.Min || *c.Initial > c.Max) {
+		return fmt.Errorf("initial must be between min and max; got %f", *c.Initial)
+	}
+	for name, p := range c.Presets {
+		if p < c.Min || p > c.Max {
+			return fmt.Errorf("preset %q must be between min and max; got %f", name, p)
+		}
+	}
+	for name, t := range c.Tours {
+		if err := t.validate(c.Presets); err != nil {
+			return fmt.Errorf("invalid tour %q: %v", name, err)
+		}
+	}
+	for name, sq := range c.Sequences {
+		if name == "" || strings.Contains(name, "/") {
+			return fmt.Errorf("sequence name must be non-empty and must not contain a slash; got %q", name)
+		}
+		if err := sq.validate(c.Min, c.Max); err != nil {
+			return fmt.Errorf("invalid sequence %q: %v", name, err)
+		}
+	}
+	if len(c.Patrol.Presets) != 0 {
+		if err := c.Patrol.validate(c.Presets); err != nil {
+			return fmt.Errorf("invalid patrol: %v", err)
+		}
+	}
+	if c.Stall != nil {
+		if err := c.Stall.validate(); err != nil {
+			return fmt.Errorf("invalid stall detection: %v", err)
+		}
+	}
+	if c.Watchdog != nil {
+		if err := c.Watchdog.validate(c.Min, c.Max, c.Presets); err != nil {
+			return fmt.Errorf("invalid watchdog: %v", err)
+		}
+	}
+	if c.Engage != nil {
+		if err := c.Engage.validate(c.Min, c.Max, c.Driver); err != nil {
+			return fmt.Errorf("invalid engage: %v", err)
+		}
+	}
+	if c.Budget != nil {
+		if err := c.Budget.validate(); err != nil {
+			return fmt.Errorf("invalid budget: %v", err)
+		}
+	}
+	if c.Maintenance != nil {
+		if err := c.Maintenance.validate(); err != nil {
+			return fmt.Errorf("invalid maintenance: %v", err)
+		}
+	}
+	if c.Power != nil {
+		if err := c.Power.validate(); err != nil {
+			return fmt.Errorf("invalid power: %v", err)
+		}
+	}
+	return nil
+}
+
+// finite returns whether the value is neither NaN nor infinite.
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
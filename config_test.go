@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		base     string
+		overlay  string
+		expected string
+	}{
+		{
+			name:     "fields",
+			base:     `{"logLevel": "info", "sources": ["ui"]}`,
+			overlay:  `{"logLevel": "debug"}`,
+			expected: `{"logLevel": "debug", "sources": ["ui"]}`,
+		},
+		{
+			name:     "nested objects",
+			base:     `{"inputs": {"joystick": {"device": "/dev/input/js0", "deadzone": 0.1}}}`,
+			overlay:  `{"inputs": {"joystick": {"deadzone": 0.2}}}`,
+			expected: `{"inputs": {"joystick": {"device": "/dev/input/js0", "deadzone": 0.2}}}`,
+		},
+		{
+			name:     "named lists",
+			base:     `{"servos": [{"name": "pan", "pin": 18, "min": 0.1}, {"name": "tilt", "pin": 19}]}`,
+			overlay:  `{"servos": [{"name": "tilt", "driver": {"type": "mock"}}, {"name": "zoom", "pin": 12}]}`,
+			expected: `{"servos": [{"name": "pan", "pin": 18, "min": 0.1}, {"name": "tilt", "pin": 19, "driver": {"type": "mock"}}, {"name": "zoom", "pin": 12}]}`,
+		},
+		{
+			name:     "unnamed lists",
+			base:     `{"sources": ["ui", "mqtt"]}`,
+			overlay:  `{"sources": ["api"]}`,
+			expected: `{"sources": ["api"]}`,
+		},
+		{
+			name:     "partly named lists",
+			base:     `{"servos": [{"name": "pan"}, {"pin": 19}]}`,
+			overlay:  `{"servos": [{"name": "tilt"}]}`,
+			expected: `{"servos": [{"name": "tilt"}]}`,
+		},
+		{
+			name:     "object replaces value",
+			base:     `{"driver": "mock"}`,
+			overlay:  `{"driver": {"type": "pwm"}}`,
+			expected: `{"driver": {"type": "pwm"}}`,
+		},
+		{
+			name:     "value replaces object",
+			base:     `{"driver": {"type": "pwm"}}`,
+			overlay:  `{"driver": null}`,
+			expected: `{"driver": null}`,
+		},
+	} {
+		var base, overlay, expected interface{}
+		for _, v := range []struct {
+			s string
+			v *interface{}
+		}{{tc.base, &base}, {tc.overlay, &overlay}, {tc.expected, &expected}} {
+			if err := json.Unmarshal([]byte(v.s), v.v); err != nil {
+				t.Fatalf("%s: failed to parse %s: %v", tc.name, v.s, err)
+			}
+		}
+		if got := merge(base, overlay); !reflect.DeepEqual(got, expected) {
+			buf, _ := json.Marshal(got)
+			t.Errorf("%s: expected %s; got %s", tc.name, tc.expected, buf)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "servor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	const base = `
+logLevel: info
+servos:
+- name: pan
+  pin: 18
+  min: 0.1
+  max: 0.9
+- name: tilt
+  pin: 19
+`
+	for _, tc := range []struct {
+		name     string
+		file     string
+		profile  string
+		err      string
+		logLevel string
+		servos   []servoConfig
+	}{
+		{
+			name:     "no profiles",
+			file:     base,
+			logLevel: "info",
+			servos:   []servoConfig{{Name: "pan", Pin: 18, Min: 0.1, Max: 0.9}, {Name: "tilt", Pin: 19}},
+		},
+		{
+			name:     "profile not selected",
+			file:     base + "profiles:\n  dev:\n    logLevel: debug\n",
+			logLevel: "info",
+			servos:   []servoConfig{{Name: "pan", Pin: 18, Min: 0.1, Max: 0.9}, {Name: "tilt", Pin: 19}},
+		},
+		{
+			name:     "profile merges servos by name",
+			file:     base + "profiles:\n  dev:\n    logLevel: debug\n    servos:\n    - name: pan\n      max: 0.5\n    - name: zoom\n      pin: 12\n",
+			profile:  "dev",
+			logLevel: "debug",
+			servos:   []servoConfig{{Name: "pan", Pin: 18, Min: 0.1, Max: 0.5}, {Name: "tilt", Pin: 19}, {Name: "zoom", Pin: 12}},
+		},
+		{
+			name:    "unknown profile",
+			file:    base + "profiles:\n  dev: {}\n  prod: {}\n",
+			profile: "staging",
+			err:     `unknown profile "staging"; the config file defines dev, prod`,
+		},
+		{
+			name: "profile is not an object",
+			file: base + "profiles:\n  dev: [logLevel]\n",
+			err:  `profile "dev" must be an object`,
+		},
+		{
+			name: "profiles are not a map",
+			file: base + "profiles:\n- dev\n",
+			err:  "profiles must map names to settings",
+		},
+		{
+			name: "unselected profile is checked",
+			file: base + "profiles:\n  dev:\n    logLevl: debug\n",
+			err:  `failed to parse profile "dev"`,
+		},
+		{
+			name: "unknown field",
+			file: base + "logLevl: debug\n",
+			err:  "unknown field",
+		},
+	} {
+		path := filepath.Join(dir, "servor.yaml")
+		if err := ioutil.WriteFile(path, []byte(tc.file), 0644); err != nil {
+			t.Fatal(err)
+		}
+		var c config
+		err := loadConfig(path, tc.profile, &c)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: expected an error containing %q; got %v", tc.name, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: expected no error; got %v", tc.name, err)
+			continue
+		}
+		if c.LogLevel != tc.logLevel {
+			t.Errorf("%s: expected log level %q; got %q", tc.name, tc.logLevel, c.LogLevel)
+		}
+		if !reflect.DeepEqual(c.Servos, tc.servos) {
+			t.Errorf("%s: expected servos %+v; got %+v", tc.name, tc.servos, c.Servos)
+		}
+	}
+}
+
+func TestServoConfigValidate(t *testing.T) {
+	nan, inf := math.NaN(), math.Inf(1)
+	for _, tc := range []struct {
+		name   string
+		modify func(c *servoConfig)
+		err    bool
+	}{
+		{name: "valid", modify: func(*servoConfig) {}},
+		{name: "NaN min", modify: func(c *servoConfig) { c.Min = nan }, err: true},
+		{name: "infinite min", modify: func(c *servoConfig) { c.Min = -inf }, err: true},
+		{name: "NaN max", modify: func(c *servoConfig) { c.Max = nan }, err: true},
+		{name: "infinite max", modify: func(c *servoConfig) { c.Max = inf }, err: true},
+		{name: "NaN degrees", modify: func(c *servoConfig) { c.Degrees = nan }, err: true},
+		{name: "NaN speed", modify: func(c *servoConfig) { c.Speed = nan }, err: true},
+		{name: "infinite speed", modify: func(c *servoConfig) { c.Speed = inf }, err: true},
+		{name: "NaN home", modify: func(c *servoConfig) { c.Home = &nan }, err: true},
+		{name: "min above max", modify: func(c *servoConfig) { c.Min = 0.95 }, err: true},
+	} {
+		home := 0.5
+		c := servoConfig{Name: "pan", Driver: driverConfig{Type: "mock"}, Min: 0.1, Max: 0.9, Steps: 20, Speed: 0.5, Home: &home}
+		tc.modify(&c)
+		if err := c.validate(); (err != nil) != tc.err {
+			t.Errorf("%s: expected an error: %t; got %v", tc.name, tc.err, err)
+		}
+	}
+}
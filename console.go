@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// consoleIdleTimeout closes sessions that were left open.
+const consoleIdleTimeout = 10 * time.Minute
+
+const consoleHelp = `Commands:
+  servos            list the servos
+  use <servo>       control the given servo
+  pos               show the position of the servo
+  set <position>    move to the given position
+  left [count]      step left, once by default
+  right [count]     step right, once by default
+  home              move to the home position
+  center            move to the center of the range
+  presets           list the presets
+  preset <name>     move to the given preset
+  stop              stop any running motion
+  quit              close the console`
+
+// console serves an interactive line-based console over TCP, e.g.
+// for telnet or netcat, so that servos can be driven and debugged
+// from any terminal without crafting HTTP requests. The same commands
+// can be piped into servor on stdin, so that scripts can drive servos.
+type console struct {
+	servos *servos
+	logger log.Logger
+}
+
+// serve accepts sessions until the listener is closed.
+func (c *console) serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go c.handle(conn)
+	}
+}
+
+// handle runs the commands of a client until it quits or disconnects.
+func (c *console) handle(conn net.Conn) {
+	defer conn.Close()
+	level.Info(c.logger).Log("msg", "console session started", "client", conn.RemoteAddr())
+	defer level.Info(c.logger).Log("msg", "console session ended", "client", conn.RemoteAddr())
+	fmt.Fprintf(conn, "servor console; controlling servo %q; type help for commands.\r\n", c.servos.list[0].name)
+	c.session(conn, conn, true)
+}
+
+// session runs the commands read from r and writes their results to w
+// until the input ends or the user quits. Interactive sessions show a
+// prompt and end lines with CRLF for telnet; other sessions, e.g. on
+// stdin, also log errors, since nobody may read the results.
+func (c *console) session(r io.Reader, w io.Writer, interactive bool) {
+	s := c.servos.list[0]
+	scanner := bufio.NewScanner(r)
+	for {
+		if interactive {
+			fmt.Fprintf(w, "%s> ", s.name)
+		}
+		if conn, ok := r.(net.Conn); ok {
+			conn.SetReadDeadline(time.Now().Add(consoleIdleTimeout))
+		}
+		if !scanner.Scan() {
+			return
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		var out string
+		var err error
+		switch cmd, args := strings.ToLower(fields[0]), fields[1:]; cmd {
+		case "quit", "exit":
+			// Piped sessions end with their input.
+			if interactive {
+				return
+			}
+		case "help", "?":
+			out = consoleHelp
+		case "servos":
+			out = c.list(s)
+		case "use":
+			if len(args) != 1 {
+				err = errors.New("usage: use <servo>")
+				break
+			}
+			next, ok := c.servos.get(args[0])
+			if !ok {
+				err = fmt.Errorf("unknown servo %q", args[0])
+				break
+			}
+			s = next
+		default:
+			out, err = c.command(s, cmd, args)
+		}
+		if err != nil {
+			out = "error: " + err.Error()
+			if !interactive {
+				level.Warn(c.logger).Log("msg", "failed to run command", "command", scanner.Text(), "err", err)
+			}
+		}
+		if out != "" {
+			out += "\n"
+		}
+		if interactive {
+			out = strings.Replace(out, "\n", "\r\n", -1)
+		}
+		if _, err := io.WriteString(w, out); err != nil {
+			return
+		}
+	}
+}
+
+// list describes every servo, marking the one under control.
+func (c *console) list(current *servor) string {
+	lines := make([]string, 0, len(c.servos.list))
+	for _, s := range c.servos.list {
+		mark := " "
+		if s == current {
+			mark = "*"
+		}
+		s.mu.Lock()
+		lines = append(lines, fmt.Sprintf("%s %s\t%s pin %d\t%s", mark, s.name, s.driver.Type, s.pin, consolePosition(s)))
+		s.mu.Unlock()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// command runs a command that applies to the servo under control.
+func (c *console) command(s *servor, cmd string, args []string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var target float64
+	switch cmd {
+	case "pos", "position", "status":
+		return consolePosition(s), nil
+	case "presets":
+		names := make([]string, 0, len(s.presets))
+		for name := range s.presets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for i, name := range names {
+			names[i] = fmt.Sprintf("%s\t%g", name, s.presets[name])
+		}
+		return strings.Join(names, "\n"), nil
+	case "stop":
+		s.stop(reasonStopped)
+		return consolePosition(s), nil
+	case "set":
+		if len(args) != 1 {
+			return "", errors.New("usage: set <position>")
+		}
+		p, err := strconv.ParseFloat(args[0], 64)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse position: %v", err)
+		}
+		target = p
+	case "left", "right":
+		count := 1
+		if len(args) > 0 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 1 {
+				return "", fmt.Errorf("count must be a whole number of at least 1; got %q", args[0])
+			}
+			count = n
+		}
+		distance := float64(count) * s.step
+		if cmd == "right" {
+			distance = -distance
+		}
+		target = s.stepFrom() + distance
+	case "home":
+		target = s.home
+	case "center":
+		target = s.min + (s.max-s.min)/2
+	case "preset":
+		if len(args) != 1 {
+			return "", errors.New("usage: preset <name>")
+		}
+		p, ok := s.presets[args[0]]
+		if !ok {
+			return "", fmt.Errorf("unknown preset %q", args[0])
+		}
+		target = p
+	default:
+		return "", fmt.Errorf("unknown command %q; type help for commands", cmd)
+	}
+	// Commands from the console are operator input.
+	if err := s.command(priorityManual, sourceConsole); err != nil {
+		return "", err
+	}
+	s.stop(reasonSuperseded)
+	if err := s.move(target); err != nil {
+		return "", err
+	}
+	return consolePosition(s), nil
+}
+
+// consolePosition describes the position of the servo.
+// The caller must hold the lock.
+func consolePosition(s *servor) string {
+	out := fmt.Sprintf("position %.6g (min %.6g, max %.6g)", s.position, s.min, s.max)
+	if s.job != nil {
+		out += fmt.Sprintf(", moving to %.6g", s.state().Target)
+	}
+	if s.failed {
+		out += ", device failed"
+	}
+	return out
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Actions that a control can trigger.
+const (
+	actionStep     = "step"
+	actionJog      = "jog"
+	actionHome     = "home"
+	actionCenter   = "center"
+	actionStop     = "stop"
+	actionPreset   = "preset"
+	actionTour     = "tour"
+	actionSequence = "sequence"
+)
+
+var actions = []string{actionStep, actionJog, actionHome, actionCenter, actionStop, actionPreset, actionTour, actionSequence}
+
+// Axes of a pair that a control can address.
+const (
+	axisHorizontal = "horizontal"
+	axisVertical   = "vertical"
+)
+
+// controlConfig maps a key or a gamepad button to an action,
+// so that installations can tailor the controls of the UI.
+type controlConfig struct {
+	// Key is the name of a keyboard key, e.g. ArrowLeft or h,
+	// as reported by KeyboardEvent.key.
+	Key string `json:"key,omitempty"`
+	// Button is the index of a button of the standard gamepad mapping.
+	Button *int `json:"button,omitempty"`
+	// Action is one of step, jog, home, center, stop, preset, tour, and sequence.
+	Action string `json:"action"`
+	// Direction is 1 to step or jog towards the maximum
+	// and -1 to step or jog towards the minimum.
+	Direction int `json:"direction,omitempty"`
+	// Name is the preset, tour, or sequence to play.
+	Name string `json:"name,omitempty"`
+	// Servo is the servo that the control addresses; if unset,
+	// the control addresses the servo or pair that is shown.
+	Servo string `json:"servo,omitempty"`
+	// Axis selects the servo of a pair that steps and jogs address:
+	// horizontal, the default, for the pan servo or vertical for the
+	// tilt servo. Vertical controls do nothing for a single servo.
+	Axis string `json:"axis,omitempty"`
+}
+
+// defaultControls jog with the arrow keys and home with the Home key.
+var defaultControls = []controlConfig{
+	{Key: "ArrowLeft", Action: actionJog, Direction: 1},
+	{Key: "ArrowRight", Action: actionJog, Direction: -1},
+	{Key: "ArrowUp", Action: actionJog, Direction: 1, Axis: axisVertical},
+	{Key: "ArrowDown", Action: actionJog, Direction: -1, Axis: axisVertical},
+	{Key: "Home", Action: actionHome},
+}
+
+// validate checks that the control is complete
+// and only addresses configured servos.
+func (c *controlConfig) validate(servos map[string]bool) error {
+	if (c.Key == "") == (c.Button == nil) {
+		return errors.New("exactly one of key and button must be set")
+	}
+	if c.Button != nil && *c.Button < 0 {
+		return fmt.Errorf("button must not be negative; got %d", *c.Button)
+	}
+	valid := false
+	for _, a := range actions {
+		valid = valid || c.Action == a
+	}
+	if !valid {
+		return fmt.Errorf("action must be one of %s; got %q", strings.Join(actions, ", "), c.Action)
+	}
+	switch c.Action {
+	case actionStep, actionJog:
+		if c.Direction != 1 && c.Direction != -1 {
+			return fmt.Errorf("direction must be 1 or -1; got %d", c.Direction)
+		}
+	case actionPreset, actionTour, actionSequence:
+		if c.Name == "" {
+			return fmt.Errorf("name must be set for action %q", c.Action)
+		}
+	}
+	if c.Axis != "" && c.Axis != axisHorizontal && c.Axis != axisVertical {
+		return fmt.Errorf("axis must be %s or %s; got %q", axisHorizontal, axisVertical, c.Axis)
+	}
+	if c.Servo != "" && !servos[c.Servo] {
+		return fmt.Errorf("servo %q is not configured", c.Servo)
+	}
+	return nil
+}
@@ -0,0 +1,708 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// The name, object path, and interface under which servor serves.
+const (
+	dbusName      = "org.squat.Servor1"
+	dbusPath      = "/org/squat/Servor1"
+	dbusInterface = "org.squat.Servor1"
+)
+
+// D-Bus message types.
+const (
+	dbusMethodCall   = 1
+	dbusMethodReturn = 2
+	dbusError        = 3
+	dbusSignal       = 4
+)
+
+// D-Bus header fields.
+const (
+	dbusFieldPath        = 1
+	dbusFieldInterface   = 2
+	dbusFieldMember      = 3
+	dbusFieldErrorName   = 4
+	dbusFieldReplySerial = 5
+	dbusFieldDestination = 6
+	dbusFieldSender      = 7
+	dbusFieldSignature   = 8
+)
+
+const (
+	// dbusNoReplyExpected is the flag of method calls whose
+	// callers do not wait for a reply.
+	dbusNoReplyExpected = 0x1
+	// dbusDoNotQueue asks the bus to fail RequestName
+	// rather than queue servor if the name is taken.
+	dbusDoNotQueue = 0x4
+	// dbusPrimaryOwner is the reply to RequestName once servor owns the name.
+	dbusPrimaryOwner = 1
+	dbusReconnect    = 5 * time.Second
+	dbusTimeout      = 10 * time.Second
+)
+
+const dbusSystemBus = "unix:path=/var/run/dbus/system_bus_socket"
+
+// dbusIntrospection describes the interface to tools like busctl and d-feet.
+const dbusIntrospection = `<!DOCTYPE node PUBLIC "-//freedesktop//DTD D-BUS Object Introspection 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/introspect.dtd">
+<node>
+  <interface name="org.squat.Servor1">
+    <method name="ListServos"><arg name="servos" type="as" direction="out"/></method>
+    <method name="GetPosition"><arg name="servo" type="s" direction="in"/><arg name="position" type="d" direction="out"/></method>
+    <method name="Move"><arg name="servo" type="s" direction="in"/><arg name="target" type="d" direction="in"/><arg name="position" type="d" direction="out"/></method>
+    <method name="Step"><arg name="servo" type="s" direction="in"/><arg name="count" type="i" direction="in"/><arg name="position" type="d" direction="out"/></method>
+    <method name="Home"><arg name="servo" type="s" direction="in"/><arg name="position" type="d" direction="out"/></method>
+    <method name="Center"><arg name="servo" type="s" direction="in"/><arg name="position" type="d" direction="out"/></method>
+    <method name="Stop"><arg name="servo" type="s" direction="in"/><arg name="position" type="d" direction="out"/></method>
+    <method name="ListPresets"><arg name="servo" type="s" direction="in"/><arg name="presets" type="as" direction="out"/></method>
+    <method name="MoveToPreset"><arg name="servo" type="s" direction="in"/><arg name="preset" type="s" direction="in"/><arg name="position" type="d" direction="out"/></method>
+    <signal name="PositionChanged"><arg name="servo" type="s"/><arg name="position" type="d"/><arg name="target" type="d"/><arg name="current" type="d"/></signal>
+  </interface>
+  <interface name="org.freedesktop.DBus.Introspectable">
+    <method name="Introspect"><arg name="xml" type="s" direction="out"/></method>
+  </interface>
+</node>
+`
+
+// dbusMessage is a D-Bus message. Only the header fields
+// and body types that servor uses are supported.
+type dbusMessage struct {
+	kind        byte
+	flags       byte
+	serial      uint32
+	path        string
+	iface       string
+	member      string
+	errorName   string
+	replySerial uint32
+	destination string
+	sender      string
+	signature   string
+	// body holds the arguments, which are strings,
+	// arrays of strings, doubles, unsigned, or signed integers.
+	body []interface{}
+}
+
+// dbusErr is an error that is returned to the caller under its D-Bus name.
+type dbusErr struct {
+	name string
+	msg  string
+}
+
+func (e *dbusErr) Error() string {
+	return e.msg
+}
+
+// dbusService serves servor on D-Bus, so that desktop tools,
+// systemd units, and other daemons can drive servos with standard
+// IPC: methods move servos, and the PositionChanged signal reports
+// changes of their positions.
+type dbusService struct {
+	address string
+	servos  *servos
+	logger  log.Logger
+}
+
+// newDBusService returns a service on the given bus, i.e. system,
+// session, or the address of a bus, e.g. unix:path=/run/dbus/bus.
+func newDBusService(bus string, ss *servos, logger log.Logger) (*dbusService, error) {
+	address := bus
+	switch bus {
+	case "system":
+		address = dbusSystemBus
+		if a := os.Getenv("DBUS_SYSTEM_BUS_ADDRESS"); a != "" {
+			address = a
+		}
+	case "session":
+		address = os.Getenv("DBUS_SESSION_BUS_ADDRESS")
+		if address == "" {
+			return nil, errors.New("DBUS_SESSION_BUS_ADDRESS is not set, so the session bus cannot be found")
+		}
+	}
+	if _, _, err := dbusSocket(address); err != nil {
+		return nil, err
+	}
+	return &dbusService{address: address, servos: ss, logger: logger}, nil
+}
+
+// dbusSocket returns the network and address of the first
+// Unix socket among the given D-Bus addresses.
+func dbusSocket(addresses string) (string, string, error) {
+	for _, a := range strings.Split(addresses, ";") {
+		if !strings.HasPrefix(a, "unix:") {
+			continue
+		}
+		for _, kv := range strings.Split(strings.TrimPrefix(a, "unix:"), ",") {
+			switch {
+			case strings.HasPrefix(kv, "path="):
+				return "unix", unescapeDBus(strings.TrimPrefix(kv, "path=")), nil
+			case strings.HasPrefix(kv, "abstract="):
+				return "unix", "@" + unescapeDBus(strings.TrimPrefix(kv, "abstract=")), nil
+			}
+		}
+	}
+	return "", "", fmt.Errorf("D-Bus address must be system, session, or a Unix socket address like unix:path=/run/dbus/system_bus_socket; got %q", addresses)
+}
+
+// unescapeDBus decodes the percent-encoding of D-Bus addresses.
+func unescapeDBus(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// run serves on the bus until the context is done,
+// reconnecting if the connection fails.
+func (d *dbusService) run(ctx context.Context) error {
+	for {
+		err := d.session(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		level.Warn(d.logger).Log("msg", "D-Bus connection failed; reconnecting", "err", err)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(dbusReconnect):
+		}
+	}
+}
+
+// dbusConn is a connection to a bus.
+type dbusConn struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	serial uint32
+	// mu serializes writes to the connection.
+	mu sync.Mutex
+}
+
+// dialDBus connects and authenticates to the bus and owns the name.
+func dialDBus(address string) (*dbusConn, error) {
+	network, addr, err := dbusSocket(address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout(network, addr, dbusTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to D-Bus: %v", err)
+	}
+	c := &dbusConn{conn: conn, r: bufio.NewReader(conn)}
+	conn.SetDeadline(time.Now().Add(dbusTimeout))
+	// Authenticate as the user that runs servor.
+	uid := hex.EncodeToString([]byte(strconv.Itoa(os.Getuid())))
+	if _, err := fmt.Fprintf(conn, "\x00AUTH EXTERNAL %s\r\n", uid); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to authenticate to D-Bus: %v", err)
+	}
+	if !strings.HasPrefix(line, "OK ") {
+		conn.Close()
+		return nil, fmt.Errorf("D-Bus refused authentication: %s", strings.TrimSpace(line))
+	}
+	if _, err := io.WriteString(conn, "BEGIN\r\n"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if _, err := c.call("Hello"); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to say hello to D-Bus: %v", err)
+	}
+	reply, err := c.call("RequestName", dbusName, uint32(dbusDoNotQueue))
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to request D-Bus name: %v", err)
+	}
+	if len(reply.body) != 1 || reply.body[0] != uint32(dbusPrimaryOwner) {
+		conn.Close()
+		return nil, fmt.Errorf("the D-Bus name %s is owned by another process", dbusName)
+	}
+	conn.SetDeadline(time.Time{})
+	return c, nil
+}
+
+// call calls a method of the bus and waits for its reply,
+// skipping other messages, e.g. the NameAcquired signal.
+func (c *dbusConn) call(member string, args ...interface{}) (*dbusMessage, error) {
+	m := &dbusMessage{kind: dbusMethodCall, path: "/org/freedesktop/DBus", iface: "org.freedesktop.DBus", member: member, destination: "org.freedesktop.DBus", body: args}
+	if err := c.send(m); err != nil {
+		return nil, err
+	}
+	for {
+		reply, err := c.receive()
+		if err != nil {
+			return nil, err
+		}
+		if reply.replySerial != m.serial {
+			continue
+		}
+		if reply.kind == dbusError {
+			return nil, fmt.Errorf("%s: %v", reply.errorName, reply.body)
+		}
+		return reply, nil
+	}
+}
+
+func (c *dbusConn) send(m *dbusMessage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.serial++
+	m.serial = c.serial
+	_, err := c.conn.Write(m.marshal())
+	return err
+}
+
+func (c *dbusConn) receive() (*dbusMessage, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(c.r, header); err != nil {
+		return nil, err
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if header[0] == 'B' {
+		order = binary.BigEndian
+	}
+	bodyLength, fieldsLength := order.Uint32(header[4:]), order.Uint32(header[12:])
+	if bodyLength > 1<<20 || fieldsLength > 1<<16 {
+		return nil, errors.New("D-Bus message is too large")
+	}
+	n := 16 + int(fieldsLength)
+	n += (8 - n%8) % 8
+	buf := make([]byte, n+int(bodyLength))
+	copy(buf, header)
+	if _, err := io.ReadFull(c.r, buf[16:]); err != nil {
+		return nil, err
+	}
+	return unmarshalDBus(buf, order)
+}
+
+func (c *dbusConn) Close() error {
+	return c.conn.Close()
+}
+
+// session serves on one connection until it fails or the context is done.
+func (d *dbusService) session(ctx context.Context) error {
+	c, err := dialDBus(d.address)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	level.Debug(d.logger).Log("msg", "connected to D-Bus", "address", d.address)
+	calls := make(chan *dbusMessage)
+	errs := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			m, err := c.receive()
+			if err != nil {
+				errs <- err
+				return
+			}
+			if m.kind != dbusMethodCall {
+				continue
+			}
+			select {
+			case calls <- m:
+			case <-done:
+				return
+			}
+		}
+	}()
+	t := time.NewTicker(telemetryInterval)
+	defer t.Stop()
+	last := make(map[*servor]servoState)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errs:
+			return err
+		case m := <-calls:
+			reply := d.handle(m)
+			if m.flags&dbusNoReplyExpected != 0 {
+				continue
+			}
+			reply.replySerial, reply.destination = m.serial, m.sender
+			if err := c.send(reply); err != nil {
+				return err
+			}
+		case <-t.C:
+			for _, s := range d.servos.list {
+				s.mu.Lock()
+				st := s.state()
+				s.mu.Unlock()
+				if l, ok := last[s]; ok && l.Position == st.Position && l.Target == st.Target && l.Current == st.Current {
+					continue
+				}
+				last[s] = st
+				signal := &dbusMessage{kind: dbusSignal, path: dbusPath, iface: dbusInterface, member: "PositionChanged", signature: "sddd", body: []interface{}{s.name, st.Position, st.Target, st.Current}}
+				if err := c.send(signal); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// handle handles a method call and returns the reply.
+func (d *dbusService) handle(m *dbusMessage) *dbusMessage {
+	out, err := d.call(m)
+	if err != nil {
+		e, ok := err.(*dbusErr)
+		if !ok {
+			e = &dbusErr{name: dbusInterface + ".Error.Failed", msg: err.Error()}
+		}
+		return &dbusMessage{kind: dbusError, errorName: e.name, signature: "s", body: []interface{}{e.msg}}
+	}
+	reply := &dbusMessage{kind: dbusMethodReturn, body: []interface{}{out}}
+	switch out.(type) {
+	case string:
+		reply.signature = "s"
+	case []string:
+		reply.signature = "as"
+	case float64:
+		reply.signature = "d"
+	}
+	return reply
+}
+
+// call runs a method and returns its result.
+func (d *dbusService) call(m *dbusMessage) (interface{}, error) {
+	if m.path != dbusPath {
+		return nil, &dbusErr{name: "org.freedesktop.DBus.Error.UnknownObject", msg: fmt.Sprintf("no object at %s", m.path)}
+	}
+	if m.iface == "org.freedesktop.DBus.Introspectable" || (m.iface == "" && m.member == "Introspect") {
+		if m.member != "Introspect" {
+			return nil, &dbusErr{name: "org.freedesktop.DBus.Error.UnknownMethod", msg: fmt.Sprintf("unknown method %s", m.member)}
+		}
+		return dbusIntrospection, nil
+	}
+	if m.iface != "" && m.iface != dbusInterface {
+		return nil, &dbusErr{name: "org.freedesktop.DBus.Error.UnknownInterface", msg: fmt.Sprintf("unknown interface %s", m.iface)}
+	}
+	signatures := map[string]string{
+		"ListServos":   "",
+		"GetPosition":  "s",
+		"Move":         "sd",
+		"Step":         "si",
+		"Home":         "s",
+		"Center":       "s",
+		"Stop":         "s",
+		"ListPresets":  "s",
+		"MoveToPreset": "ss",
+	}
+	signature, ok := signatures[m.member]
+	if !ok {
+		return nil, &dbusErr{name: "org.freedesktop.DBus.Error.UnknownMethod", msg: fmt.Sprintf("unknown method %s", m.member)}
+	}
+	if m.signature != signature {
+		return nil, &dbusErr{name: "org.freedesktop.DBus.Error.InvalidArgs", msg: fmt.Sprintf("%s takes arguments of type %q; got %q", m.member, signature, m.signature)}
+	}
+	if m.member == "ListServos" {
+		names := make([]string, 0, len(d.servos.list))
+		for _, s := range d.servos.list {
+			names = append(names, s.name)
+		}
+		return names, nil
+	}
+	s, ok := d.servos.get(m.body[0].(string))
+	if !ok {
+		return nil, &dbusErr{name: dbusInterface + ".Error.NotFound", msg: fmt.Sprintf("unknown servo %q", m.body[0])}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var target float64
+	switch m.member {
+	case "GetPosition":
+		return s.position, nil
+	case "ListPresets":
+		names := make([]string, 0, len(s.presets))
+		for name := range s.presets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names, nil
+	case "Stop":
+		s.stop(reasonStopped)
+		return s.position, nil
+	case "Move":
+		target = m.body[1].(float64)
+	case "Step":
+		target = s.stepFrom() + float64(m.body[1].(int32))*s.step
+	case "Home":
+		target = s.home
+	case "Center":
+		target = s.min + (s.max-s.min)/2
+	case "MoveToPreset":
+		p, ok := s.presets[m.body[1].(string)]
+		if !ok {
+			return nil, &dbusErr{name: dbusInterface + ".Error.NotFound", msg: fmt.Sprintf("unknown preset %q", m.body[1])}
+		}
+		target = p
+	}
+	// Commands over D-Bus are treated as operator input.
+	if err := s.command(priorityManual, sourceDBus); err != nil {
+		return nil, &dbusErr{name: dbusInterface + ".Error.Conflict", msg: err.Error()}
+	}
+	s.stop(reasonSuperseded)
+	if err := s.move(target); err != nil {
+		if _, ok := err.(*strictError); ok {
+			return nil, &dbusErr{name: "org.freedesktop.DBus.Error.InvalidArgs", msg: err.Error()}
+		}
+		return nil, err
+	}
+	return s.position, nil
+}
+
+// marshal encodes the message in little-endian byte order.
+func (m *dbusMessage) marshal() []byte {
+	var body dbusEncoder
+	for _, v := range m.body {
+		body.value(v)
+	}
+	e := dbusEncoder{b: []byte{'l', m.kind, m.flags, 1, 0, 0, 0, 0, 0, 0, 0, 0}}
+	binary.LittleEndian.PutUint32(e.b[4:], uint32(len(body.b)))
+	binary.LittleEndian.PutUint32(e.b[8:], m.serial)
+	// The header fields are an array of structs of a code and a variant.
+	e.uint32(0)
+	start := len(e.b)
+	field := func(code byte, signature string, v interface{}) {
+		e.align(8)
+		e.b = append(e.b, code)
+		e.signature(signature)
+		e.value(v)
+	}
+	for _, f := range []struct {
+		code      byte
+		signature string
+		value     string
+	}{
+		{dbusFieldPath, "o", m.path},
+		{dbusFieldInterface, "s", m.iface},
+		{dbusFieldMember, "s", m.member},
+		{dbusFieldErrorName, "s", m.errorName},
+		{dbusFieldDestination, "s", m.destination},
+	} {
+		if f.value != "" {
+			field(f.code, f.signature, f.value)
+		}
+	}
+	if m.replySerial != 0 {
+		field(dbusFieldReplySerial, "u", m.replySerial)
+	}
+	signature := m.signature
+	if signature == "" {
+		for _, v := range m.body {
+			switch v.(type) {
+			case string:
+				signature += "s"
+			case uint32:
+				signature += "u"
+			}
+		}
+	}
+	if signature != "" {
+		e.align(8)
+		e.b = append(e.b, dbusFieldSignature)
+		e.signature("g")
+		e.signature(signature)
+	}
+	binary.LittleEndian.PutUint32(e.b[start-4:], uint32(len(e.b)-start))
+	e.align(8)
+	return append(e.b, body.b...)
+}
+
+// dbusEncoder encodes values in little-endian byte order.
+type dbusEncoder struct {
+	b []byte
+}
+
+func (e *dbusEncoder) align(n int) {
+	for len(e.b)%n != 0 {
+		e.b = append(e.b, 0)
+	}
+}
+
+func (e *dbusEncoder) uint32(v uint32) {
+	e.align(4)
+	e.b = append(e.b, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(e.b[len(e.b)-4:], v)
+}
+
+func (e *dbusEncoder) signature(s string) {
+	e.b = append(append(e.b, byte(len(s))), s...)
+	e.b = append(e.b, 0)
+}
+
+func (e *dbusEncoder) value(v interface{}) {
+	switch v := v.(type) {
+	case string:
+		e.uint32(uint32(len(v)))
+		e.b = append(append(e.b, v...), 0)
+	case []string:
+		e.uint32(0)
+		start := len(e.b)
+		for _, s := range v {
+			e.value(s)
+		}
+		binary.LittleEndian.PutUint32(e.b[start-4:], uint32(len(e.b)-start))
+	case uint32:
+		e.uint32(v)
+	case int32:
+		e.uint32(uint32(v))
+	case float64:
+		e.align(8)
+		e.b = append(e.b, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint64(e.b[len(e.b)-8:], math.Float64bits(v))
+	}
+}
+
+// dbusDecoder decodes values in the given byte order.
+type dbusDecoder struct {
+	b     []byte
+	off   int
+	order binary.ByteOrder
+	err   error
+}
+
+func (d *dbusDecoder) align(n int) {
+	d.off += (n - d.off%n) % n
+}
+
+func (d *dbusDecoder) next(n int) []byte {
+	if d.err != nil || d.off+n > len(d.b) {
+		d.err = errors.New("malformed D-Bus message")
+		return make([]byte, n)
+	}
+	d.off += n
+	return d.b[d.off-n : d.off]
+}
+
+func (d *dbusDecoder) uint32() uint32 {
+	d.align(4)
+	return d.order.Uint32(d.next(4))
+}
+
+func (d *dbusDecoder) signature() string {
+	n := int(d.next(1)[0])
+	s := string(d.next(n))
+	d.next(1)
+	return s
+}
+
+// value decodes a value of the given single complete type.
+func (d *dbusDecoder) value(t string) interface{} {
+	switch t {
+	case "s", "o":
+		n := int(d.uint32())
+		s := string(d.next(n))
+		d.next(1)
+		return s
+	case "g":
+		return d.signature()
+	case "u":
+		return d.uint32()
+	case "i":
+		return int32(d.uint32())
+	case "d":
+		d.align(8)
+		return math.Float64frombits(d.order.Uint64(d.next(8)))
+	case "as":
+		n := int(d.uint32())
+		end := d.off + n
+		var list []string
+		for d.err == nil && d.off < end {
+			list = append(list, d.value("s").(string))
+		}
+		return list
+	}
+	d.err = fmt.Errorf("unsupported D-Bus type %q", t)
+	return nil
+}
+
+// unmarshalDBus decodes a complete message.
+func unmarshalDBus(b []byte, order binary.ByteOrder) (*dbusMessage, error) {
+	d := &dbusDecoder{b: b, order: order}
+	h := d.next(12)
+	m := &dbusMessage{kind: h[1], flags: h[2], serial: order.Uint32(h[8:])}
+	end := int(d.uint32()) + d.off
+	for d.err == nil && d.off < end {
+		d.align(8)
+		code := d.next(1)[0]
+		v := d.value(d.signature())
+		switch code {
+		case dbusFieldPath:
+			m.path, _ = v.(string)
+		case dbusFieldInterface:
+			m.iface, _ = v.(string)
+		case dbusFieldMember:
+			m.member, _ = v.(string)
+		case dbusFieldErrorName:
+			m.errorName, _ = v.(string)
+		case dbusFieldReplySerial:
+			m.replySerial, _ = v.(uint32)
+		case dbusFieldDestination:
+			m.destination, _ = v.(string)
+		case dbusFieldSender:
+			m.sender, _ = v.(string)
+		case dbusFieldSignature:
+			m.signature, _ = v.(string)
+		}
+	}
+	d.align(8)
+	// The body is aligned relative to its start, which is 8-aligned.
+	d.b, d.off = d.b[d.off:], 0
+	for _, t := range splitSignature(m.signature) {
+		m.body = append(m.body, d.value(t))
+	}
+	return m, d.err
+}
+
+// splitSignature splits a signature into single complete types,
+// treating arrays of basic types as one type.
+func splitSignature(s string) []string {
+	var types []string
+	for i := 0; i < len(s); i++ {
+		if s[i] == 'a' && i+1 < len(s) {
+			types = append(types, s[i:i+2])
+			i++
+			continue
+		}
+		types = append(types, s[i:i+1])
+	}
+	return types
+}
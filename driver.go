@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// driver writes PWM values to the hardware that drives a servo.
+// Values are duty cycles between 0 and 1 at the configured frequency,
+// so that --min and --max mean the same pulse widths for every driver.
+// Drivers should (re-)open their device lazily, so that a driver
+// can be recovered by closing it and setting the position again.
+type driver interface {
+	// Set drives the given pin or channel to the given value.
+	Set(pin int, value float64) error
+	Close() error
+}
+
+// staler is implemented by drivers that can tell whether their
+// device was replaced, e.g. because a daemon was restarted.
+type staler interface {
+	stale() (bool, error)
+}
+
+// feedbacker is implemented by drivers that can read back
+// the actual state of a servo.
+type feedbacker interface {
+	feedback(pin int) (*feedback, error)
+}
+
+// feedback is the state of a servo as measured by the servo.
+// Fields that a driver cannot measure are nil.
+type feedback struct {
+	// Position is in the same units as the values given to the driver.
+	Position float64 `json:"position"`
+	// Load is the fraction of the maximum torque being applied;
+	// its sign indicates the direction.
+	Load *float64 `json:"load,omitempty"`
+	// Voltage is the supply voltage of the servo in volts.
+	Voltage *float64 `json:"voltage,omitempty"`
+	// Temperature is the internal temperature of the servo in degrees Celsius.
+	Temperature *float64 `json:"temperature,omitempty"`
+}
+
+// driverConfig configures a driver.
+type driverConfig struct {
+	// Type is the kind of driver, e.g. pi-blaster.
+	Type string `json:"type,omitempty"`
+	// Device is the path to the device, e.g. a FIFO or serial port,
+	// or the address of a daemon.
+	// It defaults to a path suitable for the type.
+	Device string `json:"device,omitempty"`
+	// Baud is the baud rate of serial devices.
+	Baud int `json:"baud,omitempty"`
+	// Protocol selects a variant of the protocol spoken by the device.
+	Protocol string `json:"protocol,omitempty"`
+	// Template is the printf-style format of commands for the serial driver.
+	Template string `json:"template,omitempty"`
+	// TemplateUnit is the unit of the target given to the template;
+	// one of us or value.
+	TemplateUnit string `json:"templateUnit,omitempty"`
+	// Frequency is the PWM frequency in Hz, which relates values to pulse widths.
+	// It defaults to 100Hz, the frequency used by pi-blaster.
+	Frequency float64 `json:"frequency,omitempty"`
+	// Board is the kind of board that the driver runs on, which selects
+	// the default driver and maps pins to PWM channels; one of auto,
+	// raspberry-pi, beaglebone, or generic. It defaults to auto, which detects the board.
+	Board string `json:"board,omitempty"`
+	// MockSpeed, MockAcceleration, and MockOvershoot simulate the
+	// physics of servos for the mock driver: their maximum speed in
+	// units per second, their maximum acceleration in units per second
+	// squared, and the fraction of each move by which they overshoot.
+	// Servos of the mock driver move instantly if none are set.
+	MockSpeed        float64 `json:"mockSpeed,omitempty"`
+	MockAcceleration float64 `json:"mockAcceleration,omitempty"`
+	MockOvershoot    float64 `json:"mockOvershoot,omitempty"`
+}
+
+const defaultFrequency = 100
+
+// driverTypes are the kinds of drivers that servor supports.
+var driverTypes = []string{"pi-blaster", "pigpiod", "pwm", "periph", "soft-pwm", "beaglebone", "maestro", "firmata", "dynamixel", "lx-16a", "serial", "remote", "mock"}
+
+// knownDriver returns whether t is a kind of driver that servor supports.
+func knownDriver(t string) bool {
+	for _, d := range driverTypes {
+		if t == d {
+			return true
+		}
+	}
+	return false
+}
+
+// withDefaults returns the configuration with unset fields
+// replaced by the defaults for the type of driver, so that
+// configurations of the same device compare equal.
+// pulses reports whether the driver takes duty cycles of a PWM signal
+// at Frequency, which correspond to pulse widths, rather than fractions
+// of the range of a smart servo.
+func (c driverConfig) pulses() bool {
+	switch c.Type {
+	case "dynamixel", "lx-16a":
+		return false
+	}
+	return true
+}
+
+func (c driverConfig) withDefaults() driverConfig {
+	if c.Board == "" || c.Board == boardAuto {
+		c.Board = detectBoard()
+	}
+	if c.Type == "" {
+		c.Type = boardDriver(c.Board)
+	}
+	if c.Frequency == 0 {
+		c.Frequency = defaultFrequency
+	}
+	switch c.Type {
+	case "pi-blaster":
+		if c.Device == "" {
+			c.Device = piBlasterPath
+		}
+	case "pigpiod":
+		if c.Device == "" {
+			c.Device = "localhost:8888"
+		}
+	case "maestro":
+		if c.Device == "" {
+			c.Device = "/dev/ttyACM0"
+		}
+		if c.Baud == 0 {
+			c.Baud = 9600
+		}
+	case "firmata":
+		if c.Device == "" {
+			c.Device = "/dev/ttyACM0"
+		}
+		if c.Baud == 0 {
+			c.Baud = 57600
+		}
+	case "dynamixel":
+		if c.Device == "" {
+			c.Device = "/dev/ttyUSB0"
+		}
+		if c.Baud == 0 {
+			c.Baud = 57600
+		}
+	case "lx-16a":
+		if c.Device == "" {
+			c.Device = "/dev/ttyUSB0"
+		}
+		if c.Baud == 0 {
+			c.Baud = 115200
+		}
+	case "pwm":
+		if c.Device == "" {
+			c.Device = "/sys/class/pwm/pwmchip0"
+		}
+	case "soft-pwm":
+		if c.Device == "" {
+			c.Device = "/sys/class/gpio"
+		}
+	case "beaglebone":
+		if c.Device == "" {
+			c.Device = "/sys/class/pwm"
+		}
+	case "serial":
+		if c.Device == "" {
+			c.Device = "/dev/ttyUSB0"
+		}
+		if c.Baud == 0 {
+			c.Baud = 9600
+		}
+	}
+	return c
+}
+
+// newDriver creates a driver from the given configuration.
+func newDriver(c driverConfig) (driver, error) {
+	c = c.withDefaults()
+	if c.Frequency < 0 {
+		return nil, fmt.Errorf("frequency must be greater than 0")
+	}
+	switch c.Type {
+	case "pi-blaster":
+		return newPiBlaster(c.Device), nil
+	case "pigpiod":
+		return newPigpiod(c.Device, c.Frequency), nil
+	case "maestro":
+		return newMaestro(c.Device, c.Baud, c.Protocol, c.Frequency)
+	case "firmata":
+		return newFirmata(c.Device, c.Baud, c.Frequency), nil
+	case "dynamixel":
+		return newDynamixel(c.Device, c.Baud, c.Protocol)
+	case "lx-16a":
+		return newLX16A(c.Device, c.Baud), nil
+	case "pwm":
+		return newPWM(c.Device, c.Frequency, boardPWMChannels(c.Board)), nil
+	case "periph":
+		return newPeriph(c.Frequency)
+	case "soft-pwm":
+		return newSoftPWM(c.Device, c.Frequency), nil
+	case "beaglebone":
+		return newBeagleBone(c.Device, c.Frequency), nil
+	case "remote":
+		return newRemoteNode(c.Device, c.Frequency)
+	case "serial":
+		return newSerialTemplate(c.Device, c.Baud, c.Template, c.TemplateUnit, c.Frequency)
+	case "mock":
+		return newMock(mockPhysics{Speed: c.MockSpeed, Acceleration: c.MockAcceleration, Overshoot: c.MockOvershoot})
+	default:
+		return nil, fmt.Errorf("unknown driver %q", c.Type)
+	}
+}
+
+// pulseWidth converts a value to a pulse width at the given frequency.
+func pulseWidth(value, frequency float64) time.Duration {
+	return time.Duration(value / frequency * float64(time.Second))
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// defaultBudgetWindow is the period in seconds
+// over which the usage of a servo is counted.
+const defaultBudgetWindow = 60
+
+// errOverBudget is returned for moves of servos that used up their duty budget.
+var errOverBudget = errors.New("servo exceeded its duty budget; try again later")
+
+// budgetConfig limits how hard a servo may be worked within a sliding
+// window, protecting cheap servos from automations that never let them
+// rest. Once either limit is reached, further motion is refused until
+// enough usage falls out of the window.
+type budgetConfig struct {
+	// Window is the period in seconds over which usage is counted;
+	// defaults to 60.
+	Window float64 `json:"window,omitempty"`
+	// Travel is the total distance that the servo may move within
+	// the window; 0 means no limit.
+	Travel float64 `json:"travel,omitempty"`
+	// Moving is the number of seconds that the servo may spend moving
+	// within the window; 0 means no limit.
+	Moving float64 `json:"moving,omitempty"`
+}
+
+func (c *budgetConfig) validate() error {
+	if c.Window < 0 {
+		return fmt.Errorf("window must not be negative; got %f", c.Window)
+	}
+	if c.Travel < 0 {
+		return fmt.Errorf("travel must not be negative; got %f", c.Travel)
+	}
+	if c.Moving < 0 {
+		return fmt.Errorf("moving must not be negative; got %f", c.Moving)
+	}
+	if c.Travel == 0 && c.Moving == 0 {
+		return errors.New("at least one of travel and moving must be set")
+	}
+	return nil
+}
+
+func (c *budgetConfig) window() time.Duration {
+	if c.Window == 0 {
+		return defaultBudgetWindow * time.Second
+	}
+	return time.Duration(c.Window * float64(time.Second))
+}
+
+// usage is how much a servo was worked within one second.
+type usage struct {
+	second time.Time
+	travel float64
+	moving time.Duration
+}
+
+// duty tracks the usage of a servo within the window of its budget.
+// Usage is kept per second so that long windows stay cheap.
+type duty struct {
+	usage []usage
+	// changed is when the position last changed.
+	changed time.Time
+}
+
+// use records a change of the position of the servo by the given distance.
+// The time spent moving is the time since the previous change, up to one
+// frame, since servos are driven in frames during continuous motions and
+// direct moves are assumed to finish within one.
+// The caller must hold the lock.
+func (s *servor) use(distance float64) {
+	if s.budget == nil {
+		return
+	}
+	now := time.Now()
+	moving := now.Sub(s.duty.changed)
+	if moving > frameInterval {
+		moving = frameInterval
+	}
+	s.duty.changed = now
+	second := now.Truncate(time.Second)
+	if n := len(s.duty.usage); n > 0 && s.duty.usage[n-1].second.Equal(second) {
+		s.duty.usage[n-1].travel += math.Abs(distance)
+		s.duty.usage[n-1].moving += moving
+		return
+	}
+	s.duty.usage = append(s.duty.usage, usage{second: second, travel: math.Abs(distance), moving: moving})
+}
+
+// overBudget returns errOverBudget if the servo used up its duty budget
+// within the window and nil otherwise.
+// The caller must hold the lock.
+func (s *servor) overBudget() error {
+	if s.budget == nil {
+		return nil
+	}
+	start := time.Now().Add(-s.budget.window())
+	i := 0
+	for i < len(s.duty.usage) && s.duty.usage[i].second.Before(start) {
+		i++
+	}
+	s.duty.usage = s.duty.usage[i:]
+	var travel float64
+	var moving time.Duration
+	for _, u := range s.duty.usage {
+		travel += u.travel
+		moving += u.moving
+	}
+	if (s.budget.Travel != 0 && travel >= s.budget.Travel) || (s.budget.Moving != 0 && moving.Seconds() >= s.budget.Moving) {
+		budgetExceededTotal.WithLabelValues(s.name).Inc()
+		level.Warn(s.logger).Log("msg", "servo exceeded its duty budget; refusing motion", "travel", travel, "moving", moving)
+		return errOverBudget
+	}
+	return nil
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Dynamixel instructions and control table addresses.
+const (
+	dynamixelRead  = 0x02
+	dynamixelWrite = 0x03
+	dynamixelReply = 0x55
+)
+
+// dynamixelTable describes the parts of the control table
+// that are used for a version of the Dynamixel protocol.
+type dynamixelTable struct {
+	torqueEnable    uint16
+	goalPosition    uint16
+	presentPosition uint16
+	presentLoad     uint16
+	positionSize    int
+	maxPosition     float64
+}
+
+var dynamixelTables = map[string]dynamixelTable{
+	// AX and MX servos in protocol 1.0 mode.
+	"1.0": {torqueEnable: 24, goalPosition: 30, presentPosition: 36, presentLoad: 40, positionSize: 2, maxPosition: 1023},
+	// X series servos.
+	"2.0": {torqueEnable: 64, goalPosition: 116, presentPosition: 132, presentLoad: 126, positionSize: 4, maxPosition: 4095},
+}
+
+// dynamixel drives Robotis Dynamixel smart servos on a half-duplex
+// serial bus. Servos are addressed by their ID and values are
+// fractions of the full position range of the servo.
+type dynamixel struct {
+	device   string
+	baud     int
+	protocol string
+	table    dynamixelTable
+
+	f *os.File
+	r *bufio.Reader
+	// torque records which servos had torque enabled since the bus was opened.
+	torque map[int]bool
+}
+
+func newDynamixel(device string, baud int, protocol string) (*dynamixel, error) {
+	if protocol == "" {
+		protocol = "2.0"
+	}
+	table, ok := dynamixelTables[protocol]
+	if !ok {
+		return nil, fmt.Errorf("unknown Dynamixel protocol %q; must be 1.0 or 2.0", protocol)
+	}
+	return &dynamixel{device: device, baud: baud, protocol: protocol, table: table}, nil
+}
+
+func (d *dynamixel) open() error {
+	if d.f != nil {
+		return nil
+	}
+	f, err := openSerial(d.device, d.baud)
+	if err != nil {
+		return err
+	}
+	d.f = f
+	d.r = bufio.NewReader(f)
+	d.torque = make(map[int]bool)
+	return nil
+}
+
+// Set implements driver.
+func (d *dynamixel) Set(id int, value float64) error {
+	if id < 0 || id > 252 {
+		return fmt.Errorf("invalid Dynamixel ID %d", id)
+	}
+	if err := d.open(); err != nil {
+		return err
+	}
+	if !d.torque[id] {
+		if err := d.write(id, d.table.torqueEnable, []byte{1}); err != nil {
+			d.Close()
+			return err
+		}
+		d.torque[id] = true
+	}
+	goal := make([]byte, 4)
+	binary.LittleEndian.PutUint32(goal, uint32(value*d.table.maxPosition+0.5))
+	if err := d.write(id, d.table.goalPosition, goal[:d.table.positionSize]); err != nil {
+		d.Close()
+		return err
+	}
+	return nil
+}
+
+// feedback implements feedbacker.
+func (d *dynamixel) feedback(id int) (*feedback, error) {
+	if err := d.open(); err != nil {
+		return nil, err
+	}
+	buf, err := d.read(id, d.table.presentPosition, d.table.positionSize)
+	if err != nil {
+		return nil, err
+	}
+	pos := make([]byte, 4)
+	copy(pos, buf)
+	fb := &feedback{Position: float64(binary.LittleEndian.Uint32(pos)) / d.table.maxPosition}
+	if buf, err = d.read(id, d.table.presentLoad, 2); err != nil {
+		return nil, err
+	}
+	raw := binary.LittleEndian.Uint16(buf)
+	var load float64
+	if d.protocol == "1.0" {
+		// Bits 0-9 hold the magnitude and bit 10 the direction.
+		load = float64(raw&0x3ff) / 1023
+		if raw&0x400 != 0 {
+			load = -load
+		}
+	} else {
+		// The load is a signed value in units of 0.1%.
+		load = float64(int16(raw)) / 1000
+	}
+	fb.Load = &load
+	return fb, nil
+}
+
+// Close implements driver.
+func (d *dynamixel) Close() error {
+	if d.f == nil {
+		return nil
+	}
+	err := d.f.Close()
+	d.f = nil
+	return err
+}
+
+// write sends a write instruction without waiting for a status packet,
+// since servos may be configured to only reply to reads.
+func (d *dynamixel) write(id int, addr uint16, data []byte) error {
+	var params []byte
+	if d.protocol == "1.0" {
+		params = append([]byte{byte(addr)}, data...)
+	} else {
+		params = append([]byte{byte(addr), byte(addr >> 8)}, data...)
+	}
+	_, err := d.f.Write(d.packet(byte(id), dynamixelWrite, params))
+	return err
+}
+
+// read reads n bytes from the control table of the given servo.
+func (d *dynamixel) read(id int, addr uint16, n int) ([]byte, error) {
+	// Discard status packets of earlier writes.
+	if err := flushInput(d.f); err != nil {
+		return nil, err
+	}
+	d.r.Reset(d.f)
+	var params []byte
+	if d.protocol == "1.0" {
+		params = []byte{byte(addr), byte(n)}
+	} else {
+		params = []byte{byte(addr), byte(addr >> 8), byte(n), byte(n >> 8)}
+	}
+	if _, err := d.f.Write(d.packet(byte(id), dynamixelRead, params)); err != nil {
+		d.Close()
+		return nil, err
+	}
+	data, err := d.status(byte(id))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != n {
+		return nil, fmt.Errorf("expected %d bytes from Dynamixel %d; got %d", n, id, len(data))
+	}
+	return data, nil
+}
+
+// packet builds an instruction packet.
+func (d *dynamixel) packet(id, instruction byte, params []byte) []byte {
+	if d.protocol == "1.0" {
+		p := append([]byte{0xff, 0xff, id, byte(len(params) + 2), instruction}, params...)
+		var sum byte
+		for _, b := range p[2:] {
+			sum += b
+		}
+		return append(p, ^sum)
+	}
+	body := dynamixelStuff(append([]byte{instruction}, params...))
+	n := len(body) + 2
+	p := append([]byte{0xff, 0xff, 0xfd, 0x00, id, byte(n), byte(n >> 8)}, body...)
+	crc := dynamixelCRC(p)
+	return append(p, byte(crc), byte(crc>>8))
+}
+
+// status reads a status packet from the given servo and returns its parameters.
+func (d *dynamixel) status(id byte) ([]byte, error) {
+	header := []byte{0xff, 0xff}
+	if d.protocol == "2.0" {
+		header = []byte{0xff, 0xff, 0xfd, 0x00}
+	}
+	if err := d.sync(header); err != nil {
+		return nil, err
+	}
+	if d.protocol == "1.0" {
+		buf := make([]byte, 2)
+		if err := d.readFull(buf); err != nil {
+			return nil, err
+		}
+		rest := make([]byte, buf[1])
+		if len(rest) < 2 {
+			return nil, errors.New("invalid Dynamixel status packet")
+		}
+		if err := d.readFull(rest); err != nil {
+			return nil, err
+		}
+		sum := buf[0] + buf[1]
+		for _, b := range rest[:len(rest)-1] {
+			sum += b
+		}
+		if ^sum != rest[len(rest)-1] {
+			return nil, errors.New("invalid Dynamixel status packet checksum")
+		}
+		if buf[0] != id {
+			return nil, fmt.Errorf("expected status from Dynamixel %d; got %d", id, buf[0])
+		}
+		if rest[0] != 0 {
+			return nil, fmt.Errorf("Dynamixel %d reported error %#x", id, rest[0])
+		}
+		return rest[1 : len(rest)-1], nil
+	}
+	buf := make([]byte, 3)
+	if err := d.readFull(buf); err != nil {
+		return nil, err
+	}
+	n := int(binary.LittleEndian.Uint16(buf[1:]))
+	if n < 4 {
+		return nil, errors.New("invalid Dynamixel status packet")
+	}
+	rest := make([]byte, n)
+	if err := d.readFull(rest); err != nil {
+		return nil, err
+	}
+	p := append(append(append([]byte{}, header...), buf...), rest[:n-2]...)
+	if dynamixelCRC(p) != binary.LittleEndian.Uint16(rest[n-2:]) {
+		return nil, errors.New("invalid Dynamixel status packet CRC")
+	}
+	if buf[0] != id {
+		return nil, fmt.Errorf("expected status from Dynamixel %d; got %d", id, buf[0])
+	}
+	if rest[0] != dynamixelReply {
+		return nil, fmt.Errorf("expected status packet; got instruction %#x", rest[0])
+	}
+	if rest[1]&0x7f != 0 {
+		return nil, fmt.Errorf("Dynamixel %d reported error %#x", id, rest[1]&0x7f)
+	}
+	return dynamixelUnstuff(rest[2 : n-2]), nil
+}
+
+// sync discards bytes until the given header was read.
+func (d *dynamixel) sync(header []byte) error {
+	var matched int
+	for matched < len(header) {
+		b, err := d.r.ReadByte()
+		if err != nil {
+			return d.timeout(err)
+		}
+		switch {
+		case b == header[matched]:
+			matched++
+		case b == header[0]:
+			// Headers start with repeated bytes, so restart the match
+			// without discarding the current byte.
+			if matched == 0 || header[matched-1] != b {
+				matched = 1
+			}
+		default:
+			matched = 0
+		}
+	}
+	return nil
+}
+
+func (d *dynamixel) readFull(buf []byte) error {
+	if _, err := io.ReadFull(d.r, buf); err != nil {
+		return d.timeout(err)
+	}
+	return nil
+}
+
+func (d *dynamixel) timeout(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errors.New("timed out waiting for Dynamixel status packet")
+	}
+	d.Close()
+	return err
+}
+
+// dynamixelStuff inserts a 0xfd byte after every occurrence of the
+// header pattern 0xff 0xff 0xfd in the payload of a protocol 2.0 packet.
+func dynamixelStuff(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for _, c := range b {
+		out = append(out, c)
+		if n := len(out); n >= 3 && out[n-3] == 0xff && out[n-2] == 0xff && out[n-1] == 0xfd {
+			out = append(out, 0xfd)
+		}
+	}
+	return out
+}
+
+// dynamixelUnstuff reverses dynamixelStuff.
+func dynamixelUnstuff(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		out = append(out, b[i])
+		if n := len(out); n >= 3 && out[n-3] == 0xff && out[n-2] == 0xff && out[n-1] == 0xfd && i+1 < len(b) && b[i+1] == 0xfd {
+			i++
+		}
+	}
+	return out
+}
+
+// dynamixelCRC computes the CRC-16 used by protocol 2.0.
+func dynamixelCRC(b []byte) uint16 {
+	var crc uint16
+	for _, c := range b {
+		crc ^= uint16(c) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
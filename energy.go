@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// meterInterval is how often the usage of the servos is metered.
+const meterInterval = time.Second
+
+// defaultPowerVoltage is the supply voltage of most hobby servos.
+const defaultPowerVoltage = 5
+
+// powerConfig describes how much power a servo draws, so that its
+// energy usage can be estimated, e.g. to budget the battery or solar
+// panel of a deployment.
+type powerConfig struct {
+	// Voltage is the supply voltage of the servo in volts; it defaults
+	// to the measured supply voltage, if any, or else to 5.
+	Voltage float64 `json:"voltage,omitempty"`
+	// Idle is the current in milliamps that the servo draws
+	// while it holds its position.
+	Idle float64 `json:"idle"`
+	// Moving is the current in milliamps that the servo draws while it moves.
+	Moving float64 `json:"moving"`
+}
+
+func (c *powerConfig) validate() error {
+	if c.Voltage < 0 {
+		return fmt.Errorf("voltage must not be negative; got %f", c.Voltage)
+	}
+	if c.Idle < 0 || c.Moving < 0 {
+		return fmt.Errorf("currents must not be negative; got %f and %f", c.Idle, c.Moving)
+	}
+	return nil
+}
+
+// meter tracks the usage of a servo between two readings of the meter.
+type meter struct {
+	// read is when the meter was last read.
+	read time.Time
+	// changed is when the position last changed.
+	changed time.Time
+	// moving is the time spent moving since the last reading.
+	moving time.Duration
+	// current is the last current in milliamps measured
+	// by the current sensor of the servo, if any.
+	current *float64
+}
+
+// moved records a change of the position of the servo. Like the duty
+// budget, it assumes that the servo moved for the time since the
+// previous change, up to one frame.
+// The caller must hold the lock.
+func (s *servor) moved() {
+	now := time.Now()
+	moving := now.Sub(s.meter.changed)
+	if moving > frameInterval {
+		moving = frameInterval
+	}
+	s.meter.changed = now
+	s.meter.moving += moving
+}
+
+// readMeter exports the usage of the servo since the last reading.
+// A servo holds its position under load once it was first driven.
+// Energy is estimated from the measured current, if the servo has a
+// current sensor, or else from the currents of its power configuration.
+// The caller must hold the lock.
+func (s *servor) readMeter() {
+	now := time.Now()
+	elapsed := now.Sub(s.meter.read)
+	s.meter.read = now
+	moving := s.meter.moving
+	s.meter.moving = 0
+	if s.written == nil || elapsed > 2*meterInterval {
+		// The first reading and readings after a stall of the meter
+		// only start the period.
+		return
+	}
+	if moving > elapsed {
+		moving = elapsed
+	}
+	holding := elapsed - moving
+	movingSecondsTotal.WithLabelValues(s.name).Add(moving.Seconds())
+	holdingSecondsTotal.WithLabelValues(s.name).Add(holding.Seconds())
+	if s.power == nil && s.meter.current == nil {
+		return
+	}
+	voltage := float64(defaultPowerVoltage)
+	switch {
+	case s.power != nil && s.power.Voltage != 0:
+		voltage = s.power.Voltage
+	case s.system != nil:
+		s.system.mu.Lock()
+		if v := s.system.state.SupplyVoltage; v != nil {
+			voltage = *v
+		}
+		s.system.mu.Unlock()
+	}
+	var charge float64
+	if s.meter.current != nil {
+		charge = *s.meter.current * elapsed.Seconds()
+	} else {
+		charge = s.power.Idle*holding.Seconds() + s.power.Moving*moving.Seconds()
+	}
+	energyJoulesTotal.WithLabelValues(s.name).Add(voltage * charge / 1000)
+}
+
+// meter reads the meters of all servos at meterInterval until the context is done.
+func (ss *servos) meter(ctx context.Context) error {
+	t := time.NewTicker(meterInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+		for _, s := range ss.list {
+			s.mu.Lock()
+			s.readMeter()
+			s.mu.Unlock()
+		}
+	}
+}
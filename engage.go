@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// detachInterval is how often idle servos are checked for detaching.
+const detachInterval = time.Second
+
+// engageConfig configures how a servo is detached when idle and how it
+// is re-engaged once its pulses resume. A servo without pulses can be
+// back-driven, so snapping it back to its position could jump violently;
+// instead, it is ramped there from where it is assumed to be.
+type engageConfig struct {
+	// Idle is the number of seconds without a write after which
+	// the pulses of the servo are stopped; 0 never detaches it.
+	Idle float64 `json:"idle,omitempty"`
+	// Speed is the speed in units per second of the ramp.
+	Speed float64 `json:"speed"`
+	// From is the position that the servo is assumed to be at when it is
+	// re-engaged; it defaults to the position measured by the servo, if
+	// its driver supports feedback, or else to the last written position.
+	From *float64 `json:"from,omitempty"`
+}
+
+// validate checks the configuration of a servo with the given range and driver.
+func (c *engageConfig) validate(min, max float64, d driverConfig) error {
+	if c.Idle < 0 {
+		return fmt.Errorf("idle must not be negative; got %f", c.Idle)
+	}
+	if c.Speed <= 0 {
+		return fmt.Errorf("speed must be greater than 0; got %f", c.Speed)
+	}
+	if c.From != nil && (*c.From < min || *c.From > max) {
+		return fmt.Errorf("from must be between min and max; got %f", *c.From)
+	}
+	// Only PWM drivers stop the pulses of a pin that is set to 0.
+	if c.Idle > 0 && !d.withDefaults().pulses() {
+		return errors.New("idle requires a PWM driver")
+	}
+	return nil
+}
+
+// reengage ramps a servo whose pulses stopped from where it is assumed
+// to be towards its position, which the caller then writes. Servos
+// whose assumed position is unknown are not ramped.
+// The caller must hold the lock.
+func (s *servor) reengage() error {
+	var from float64
+	switch {
+	case s.engage.From != nil:
+		from = *s.engage.From
+	case s.feedback != nil:
+		from = s.feedback.Position
+	case s.written != nil:
+		from = *s.written
+	default:
+		return nil
+	}
+	d := s.position - from
+	frames := int(math.Abs(d) / (s.engage.Speed * frameInterval.Seconds()))
+	level.Info(s.logger).Log("msg", "re-engaging servo", "from", from, "to", s.position, "duration", time.Duration(frames)*frameInterval)
+	for i := 1; i < frames; i++ {
+		v := from + d*float64(i)/float64(frames)
+		s.device.mu.Lock()
+		err := s.device.Set(s.pin, v)
+		s.device.commands.record(s.device.config.Device, s.pin, v)
+		s.device.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		time.Sleep(frameInterval)
+	}
+	return nil
+}
+
+// detach stops the pulses of the servo if it was not written for
+// its idle time and is not running a job.
+// The servo must have an engage configuration.
+func (s *servor) detach() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.engage.Idle == 0 || s.detached || !s.sent || s.failed || s.job != nil || time.Since(s.lastWrite).Seconds() < s.engage.Idle {
+		return
+	}
+	s.device.mu.Lock()
+	err := s.device.Set(s.pin, 0)
+	s.device.commands.record(s.device.config.Device, s.pin, 0)
+	s.device.mu.Unlock()
+	if err != nil {
+		level.Warn(s.logger).Log("msg", "failed to detach idle servo", "err", err)
+		return
+	}
+	s.detached = true
+	level.Info(s.logger).Log("msg", "detached idle servo", "idle", s.engage.Idle)
+}
+
+// watchIdle detaches the servos that were idle for longer than
+// their idle time until the context is done.
+func (ss *servos) watchIdle(ctx context.Context) error {
+	t := time.NewTicker(detachInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+		for _, s := range ss.list {
+			s.mu.Lock()
+			configured := s.engage != nil
+			s.mu.Unlock()
+			if configured {
+				s.detach()
+			}
+		}
+	}
+}
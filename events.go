@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// eventQueueSize is how many events a subscription buffers
+// for a consumer that falls behind, e.g. a slow client.
+const eventQueueSize = 64
+
+// The sinks that events are delivered to, as exported in metrics.
+const (
+	sinkSSE       = "sse"
+	sinkWebSocket = "websocket"
+	sinkMQTT      = "mqtt"
+	sinkWebhook   = "webhook"
+	sinkAudit     = "audit"
+	sinkGRPC      = "grpc"
+)
+
+// eventSink receives the events published on the bus.
+// Since events are published while servos are locked,
+// sinks must not block.
+type eventSink interface {
+	send(e event)
+}
+
+// bus publishes the events of all servos to the subscribed sinks, e.g.
+// hooks, event streams, and webhooks, so that an integration subscribes
+// to the events it needs rather than being wired into every place
+// that causes them.
+type bus struct {
+	mu    sync.RWMutex
+	sinks map[eventSink]bool
+}
+
+func newBus() *bus {
+	return &bus{sinks: make(map[eventSink]bool)}
+}
+
+// subscribe delivers the events to the sink until the
+// returned function is called.
+func (b *bus) subscribe(k eventSink) func() {
+	b.mu.Lock()
+	b.sinks[k] = true
+	b.mu.Unlock()
+	return func() {
+		b.mu.Lock()
+		delete(b.sinks, k)
+		b.mu.Unlock()
+	}
+}
+
+// publish delivers the event to all sinks.
+// It is safe to call on a nil bus.
+func (b *bus) publish(e event) {
+	if b == nil {
+		return
+	}
+	if e.time.IsZero() {
+		e.time = time.Now()
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for k := range b.sinks {
+		k.send(e)
+	}
+}
+
+// eventFilter selects events by name and servo;
+// empty fields select all events.
+type eventFilter struct {
+	Events []string
+	Servo  string
+}
+
+func (f *eventFilter) validate(servos map[string]bool) error {
+	for _, e := range f.Events {
+		if !knownEvent(e) {
+			return fmt.Errorf("event must be one of %s; got %q", strings.Join(events, ", "), e)
+		}
+		// Pre-move hooks approve moves rather than being notified
+		// of them, and shutdown hooks run once the sinks are gone.
+		if e == eventPreMove || e == eventShutdown {
+			return fmt.Errorf("%s events are only delivered to hooks", e)
+		}
+	}
+	if f.Servo != "" && !servos[f.Servo] {
+		return fmt.Errorf("unknown servo %q", f.Servo)
+	}
+	return nil
+}
+
+// matches returns whether the filter selects the event.
+func (f *eventFilter) matches(e event) bool {
+	if f.Servo != "" && f.Servo != e.servo {
+		return false
+	}
+	if len(f.Events) == 0 {
+		return true
+	}
+	for _, name := range f.Events {
+		if name == e.name {
+			return true
+		}
+	}
+	return false
+}
+
+// parseEventFilter returns the filter given in the query of the
+// request as a comma-separated list of events and a servo, if any.
+func parseEventFilter(r *http.Request, servos map[string]*servor) (eventFilter, error) {
+	q := r.URL.Query()
+	f := eventFilter{Servo: q.Get("servo")}
+	if v := q.Get("events"); v != "" {
+		f.Events = strings.Split(v, ",")
+	}
+	names := make(map[string]bool, len(servos))
+	for name := range servos {
+		names[name] = true
+	}
+	return f, f.validate(names)
+}
+
+func knownEvent(name string) bool {
+	for _, e := range events {
+		if name == e {
+			return true
+		}
+	}
+	return false
+}
+
+// subscription is a sink that buffers the events selected by its
+// filter for a consumer that reads them from its channel, e.g.
+// an event stream. Events that do not fit in the buffer are dropped,
+// so that a slow consumer does not hold up the servos.
+type subscription struct {
+	// sink names the kind of consumer in metrics.
+	sink   string
+	filter eventFilter
+	events chan event
+}
+
+// listen subscribes a new subscription with the given filter to the
+// bus until the returned function is called.
+func (b *bus) listen(sink string, f eventFilter) (*subscription, func()) {
+	sub := &subscription{sink: sink, filter: f, events: make(chan event, eventQueueSize)}
+	// Export the drops as 0 before the first one.
+	eventsDroppedTotal.WithLabelValues(sink)
+	return sub, b.subscribe(sub)
+}
+
+// closer returns a function that unsubscribes the subscription and
+// then closes its channel, which ends the consumers that range over it.
+// Since the bus sends to its sinks while holding its lock, nothing is
+// sent on the channel once it is unsubscribed.
+func closer(sub *subscription, unsubscribe func()) func() {
+	return func() {
+		unsubscribe()
+		close(sub.events)
+	}
+}
+
+func (sub *subscription) send(e event) {
+	if !sub.filter.matches(e) {
+		return
+	}
+	select {
+	case sub.events <- e:
+	default:
+		eventsDroppedTotal.WithLabelValues(sub.sink).Inc()
+	}
+}
+
+// eventMessage is an event as sent by the sinks that
+// deliver events as JSON, e.g. the event stream.
+type eventMessage struct {
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+	Servo string    `json:"servo,omitempty"`
+	// Position, Target, and Current describe the servo;
+	// see servoState.
+	Position *float64 `json:"position,omitempty"`
+	Target   *float64 `json:"target,omitempty"`
+	Current  *float64 `json:"current,omitempty"`
+	Source   string   `json:"source,omitempty"`
+	Error    string   `json:"error,omitempty"`
+	// Job is the job that started or ended, if any.
+	Job *job `json:"job,omitempty"`
+}
+
+// message returns the event as a message.
+func (e event) message() eventMessage {
+	m := eventMessage{Event: e.name, Time: e.time, Servo: e.servo, Source: e.source, Job: e.job}
+	if e.servo != "" {
+		m.Position, m.Target, m.Current = &e.position, &e.target, &e.current
+	}
+	if e.err != nil {
+		m.Error = e.err.Error()
+	}
+	return m
+}
+
+// streamEvents streams the events of the servos, optionally filtered
+// by the query of the request, as server-sent events until the
+// client disconnects.
+func (ss *servos) streamEvents(w http.ResponseWriter, r *http.Request) {
+	f, err := parseEventFilter(r, ss.byName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	sub, unsubscribe := ss.events.listen(sinkSSE, f)
+	defer unsubscribe()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-sub.events:
+			// Virtual hosts only stream the events of their servos.
+			if _, ok := ss.byName[e.servo]; !ok {
+				continue
+			}
+			buf, err := json.Marshal(e.message())
+			if err != nil {
+				level.Error(ss.logger).Log("err", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.name, buf); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// webhookConfig posts events to a URL as JSON, e.g. to notify
+// a chat or a home automation system when a job ends.
+type webhookConfig struct {
+	URL string `json:"url"`
+	// Events are the events to post, which must be given, so that
+	// the frequent position-changed events are only posted on purpose.
+	Events []string `json:"events"`
+	// Servo limits the webhook to the events of the named servo.
+	Servo string `json:"servo,omitempty"`
+}
+
+func (c *webhookConfig) validate(servos map[string]bool) error {
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an absolute HTTP or HTTPS URL; got %q", c.URL)
+	}
+	if len(c.Events) == 0 {
+		return errors.New("events must be set")
+	}
+	f := c.filter()
+	return f.validate(servos)
+}
+
+func (c *webhookConfig) filter() eventFilter {
+	return eventFilter{Events: c.Events, Servo: c.Servo}
+}
+
+// subscribeWebhooks posts the events selected by each webhook one
+// after the other until the returned function is called.
+// Failed deliveries are not retried.
+func (b *bus) subscribeWebhooks(configs []webhookConfig, logger log.Logger) func() {
+	var closers []func()
+	for _, c := range configs {
+		c := c
+		sub, unsubscribe := b.listen(sinkWebhook, c.filter())
+		closers = append(closers, closer(sub, unsubscribe))
+		go func() {
+			for e := range sub.events {
+				if err := postEvent(c.URL, e); err != nil {
+					webhookFailuresTotal.WithLabelValues(e.name).Inc()
+					level.Warn(logger).Log("msg", "failed to deliver webhook", "event", e.name, "servo", e.servo, "url", c.URL, "err", err)
+				}
+			}
+		}()
+		// Export the failures as 0 before the first one.
+		for _, name := range c.Events {
+			webhookFailuresTotal.WithLabelValues(name)
+		}
+	}
+	return func() {
+		for _, c := range closers {
+			c()
+		}
+	}
+}
+
+// postEvent posts the event to the URL as JSON.
+func postEvent(u string, e event) error {
+	buf, err := json.Marshal(e.message())
+	if err != nil {
+		return err
+	}
+	resp, err := webhookClient.Post(u, "application/json", bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// subscribeAudit records the selected events in the audit log,
+// alongside the requests that caused them, until the returned
+// function is called.
+func (b *bus) subscribeAudit(names []string, logger log.Logger) func() {
+	sub, unsubscribe := b.listen(sinkAudit, eventFilter{Events: names})
+	go func() {
+		for e := range sub.events {
+			keyvals := []interface{}{"event", e.name}
+			if e.servo != "" {
+				keyvals = append(keyvals, "servo", e.servo, "position", e.position)
+			}
+			if e.source != "" {
+				keyvals = append(keyvals, "source", e.source)
+			}
+			if e.job != nil {
+				keyvals = append(keyvals, "job", e.job.ID, "motion", e.job.Motion, "state", e.job.State)
+			}
+			if e.err != nil {
+				keyvals = append(keyvals, "err", e.err)
+			}
+			logger.Log(keyvals...)
+		}
+	}()
+	return closer(sub, unsubscribe)
+}
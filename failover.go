@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// failover drives servos with the first of several drivers that
+// works, e.g. pigpiod with pi-blaster as a fallback.
+// When a write to the active driver fails, the next healthy driver
+// takes over. Drivers that are preferred over the active one are
+// retried on every check of the device, so that servor fails back
+// once they recover.
+type failover struct {
+	drivers []driver
+	configs []driverConfig
+	active  int
+	// values holds the last value set for each pin,
+	// which is replayed when switching drivers.
+	values map[int]float64
+	logger log.Logger
+}
+
+// newFailover creates a failover from the given driver configurations,
+// in order of preference.
+func newFailover(configs []driverConfig, logger log.Logger) (*failover, error) {
+	f := &failover{
+		configs: configs,
+		values:  make(map[int]float64),
+		logger:  logger,
+	}
+	for _, c := range configs {
+		d, err := newDriver(c)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		f.drivers = append(f.drivers, d)
+	}
+	f.activate(0)
+	return f, nil
+}
+
+// Set implements driver.
+func (f *failover) Set(pin int, value float64) error {
+	f.values[pin] = value
+	err := f.drivers[f.active].Set(pin, value)
+	if err == nil {
+		return nil
+	}
+	for i := range f.drivers {
+		if i == f.active {
+			continue
+		}
+		if f.replay(i) == nil {
+			level.Warn(f.logger).Log("msg", "driver failed; failing over", "from", f.configs[f.active].Type, "to", f.configs[i].Type, "err", err)
+			f.activate(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("all drivers failed: %v", err)
+}
+
+// replay sets the last value of every pin with the given driver.
+func (f *failover) replay(i int) error {
+	for pin, value := range f.values {
+		if err := f.drivers[i].Set(pin, value); err != nil {
+			f.drivers[i].Close()
+			return err
+		}
+	}
+	return nil
+}
+
+// activate makes the given driver the active one and releases the
+// previously active driver. Setting a pin to 0 stops the pulses of
+// PWM drivers, so that two drivers never drive the same pin.
+func (f *failover) activate(i int) {
+	if i != f.active {
+		for pin := range f.values {
+			f.drivers[f.active].Set(pin, 0)
+		}
+		f.drivers[f.active].Close()
+	}
+	f.active = i
+	for j, c := range f.configs {
+		var active float64
+		if j == i {
+			active = 1
+		}
+		driverActive.WithLabelValues(c.Type, c.Device).Set(active)
+	}
+}
+
+// stale implements staler. It fails back to a preferred driver
+// if one is healthy again, or else reports whether the active
+// driver's device was replaced.
+func (f *failover) stale() (bool, error) {
+	for i := 0; i < f.active; i++ {
+		if f.replay(i) == nil {
+			level.Info(f.logger).Log("msg", "driver recovered; failing back", "from", f.configs[f.active].Type, "to", f.configs[i].Type)
+			f.activate(i)
+			return false, nil
+		}
+	}
+	if s, ok := f.drivers[f.active].(staler); ok {
+		return s.stale()
+	}
+	return false, nil
+}
+
+// Close implements driver.
+func (f *failover) Close() error {
+	var err error
+	for _, d := range f.drivers {
+		if e := d.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
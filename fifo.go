@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// openFIFO creates the named pipe at the given path, unless it exists,
+// and opens it for reading console commands, so that scripts and
+// daemons on the same host can drive servos by writing lines to it,
+// like they write to pi-blaster. The pipe is also opened for writing,
+// so that reads do not end when a writer closes it.
+func openFIFO(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory for FIFO: %v", err)
+	}
+	fi, err := os.Stat(path)
+	switch {
+	case os.IsNotExist(err):
+		if err := unix.Mkfifo(path, 0660); err != nil {
+			return nil, fmt.Errorf("failed to create FIFO: %v", err)
+		}
+	case err != nil:
+		return nil, fmt.Errorf("failed to check FIFO: %v", err)
+	case fi.Mode()&os.ModeNamedPipe == 0:
+		return nil, fmt.Errorf("%s exists and is not a FIFO", path)
+	}
+	return os.OpenFile(path, os.O_RDWR, 0)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Firmata messages and sysex commands used to drive servos.
+const (
+	firmataAnalog         = 0xe0
+	firmataReportVersion  = 0xf9
+	firmataStartSysex     = 0xf0
+	firmataEndSysex       = 0xf7
+	firmataExtendedAnalog = 0x6f
+	firmataServoConfig    = 0x70
+
+	// StandardFirmata treats values below the minimum pulse width
+	// of the Arduino Servo library as angles rather than pulse widths.
+	firmataMinPulse = 544
+	firmataMaxPulse = 2500
+
+	// firmataReady bounds the wait for the board to boot,
+	// since opening the port resets most Arduinos.
+	firmataReady = 5 * time.Second
+)
+
+var errFirmataVersion = errors.New("no Firmata version report; is StandardFirmata running on the board?")
+
+// firmata drives servos attached to a microcontroller, e.g. an Arduino,
+// that runs StandardFirmata, which generates the pulses, so servor
+// can run on any Linux host with a USB port.
+type firmata struct {
+	device    string
+	baud      int
+	frequency float64
+	f         *os.File
+	// configured records the pins that were put into servo mode.
+	configured map[int]bool
+}
+
+func newFirmata(device string, baud int, frequency float64) *firmata {
+	return &firmata{device: device, baud: baud, frequency: frequency}
+}
+
+// Set implements driver.
+func (f *firmata) Set(pin int, value float64) error {
+	if pin < 0 || pin > 127 {
+		return fmt.Errorf("invalid Firmata pin %d", pin)
+	}
+	if f.f == nil {
+		if err := f.open(); err != nil {
+			return err
+		}
+	}
+	if !f.configured[pin] {
+		cmd := []byte{firmataStartSysex, firmataServoConfig, byte(pin)}
+		cmd = append(cmd, firmata14(firmataMinPulse)...)
+		cmd = append(cmd, firmata14(firmataMaxPulse)...)
+		if err := f.write(append(cmd, firmataEndSysex)); err != nil {
+			return err
+		}
+		f.configured[pin] = true
+	}
+	us := int(pulseWidth(value, f.frequency) / time.Microsecond)
+	if us < firmataMinPulse {
+		us = firmataMinPulse
+	}
+	if us > firmataMaxPulse {
+		us = firmataMaxPulse
+	}
+	// Analog messages only address the first 16 pins.
+	if pin < 16 {
+		return f.write(append([]byte{firmataAnalog | byte(pin)}, firmata14(us)...))
+	}
+	cmd := append([]byte{firmataStartSysex, firmataExtendedAnalog, byte(pin)}, firmata14(us)...)
+	return f.write(append(cmd, firmataEndSysex))
+}
+
+// open opens the serial port and waits until the board reports the
+// version of its firmware, asking for it periodically in case the
+// board was not reset by opening the port.
+func (f *firmata) open() error {
+	port, err := openSerial(f.device, f.baud)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 64)
+	var asked time.Time
+	for deadline := time.Now().Add(firmataReady); time.Now().Before(deadline); {
+		if time.Since(asked) > 500*time.Millisecond {
+			if _, err := port.Write([]byte{firmataReportVersion}); err != nil {
+				port.Close()
+				return err
+			}
+			asked = time.Now()
+		}
+		// Reads time out after 100ms.
+		n, err := port.Read(buf)
+		if err != nil {
+			port.Close()
+			return err
+		}
+		for _, b := range buf[:n] {
+			if b == firmataReportVersion {
+				f.f = port
+				f.configured = make(map[int]bool)
+				return nil
+			}
+		}
+	}
+	port.Close()
+	return errFirmataVersion
+}
+
+func (f *firmata) write(cmd []byte) error {
+	if _, err := f.f.Write(cmd); err != nil {
+		f.Close()
+		return err
+	}
+	return nil
+}
+
+// Close implements driver.
+func (f *firmata) Close() error {
+	if f.f == nil {
+		return nil
+	}
+	err := f.f.Close()
+	f.f = nil
+	return err
+}
+
+// firmata14 encodes a 14-bit value as two 7-bit bytes, LSB first.
+func firmata14(v int) []byte {
+	return []byte{byte(v & 0x7f), byte(v >> 7 & 0x7f)}
+}
@@ -1,14 +1,26 @@
 module github.com/squat/servor
 
 require (
-	github.com/go-kit/kit v0.9.0
-	github.com/go-logfmt/logfmt v0.4.0 // indirect
-	github.com/golang/protobuf v1.3.2 // indirect
+	github.com/desertbit/timer v0.0.0-20180107155436-c41aec40b27f // indirect
+	github.com/go-kit/kit v0.10.0
+	github.com/golang/protobuf v1.4.3
+	github.com/gorilla/websocket v1.4.1
+	github.com/grpc-ecosystem/grpc-gateway v1.12.2
+	github.com/improbable-eng/grpc-web v0.12.0
 	github.com/oklog/run v1.0.0
-	github.com/prometheus/client_golang v0.9.4
-	github.com/prometheus/procfs v0.0.3 // indirect
+	github.com/pion/webrtc/v3 v3.0.0
+	github.com/prometheus/client_golang v1.9.0
+	github.com/rs/cors v1.7.0 // indirect
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+	github.com/soheilhy/cmux v0.1.4
 	github.com/spf13/pflag v1.0.3
-	golang.org/x/lint v0.0.0-20190409202823-959b441ac422
+	golang.org/x/lint v0.0.0-20190930215403-16217165b5de
+	golang.org/x/sys v0.0.0-20201214210602-f9fddec55a1e
+	google.golang.org/genproto v0.0.0-20190927181202-20e1ac93f88c
+	google.golang.org/grpc v1.26.0
+	periph.io/x/conn/v3 v3.6.8
+	periph.io/x/host/v3 v3.7.0
+	sigs.k8s.io/yaml v1.1.0
 )
 
 go 1.13
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/improbable-eng/grpc-web/go/grpcweb"
+	"github.com/squat/servor/pkg/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// grpcServer implements the gRPC API.
+// Its unary RPCs are also served as a REST API by grpc-gateway.
+type grpcServer struct {
+	servos *servos
+	// limiter caps the samples of jog streams that are applied at
+	// once along with the other actuation requests, if set.
+	limiter *limiter
+}
+
+// grpcHandler serves gRPC-Web requests from browsers and gRPC requests
+// that arrive through the HTTP server, e.g. over TLS, with the gRPC
+// server, and all other requests with next.
+func grpcHandler(gs *grpc.Server, next http.Handler) http.Handler {
+	web := grpcweb.WrapServer(gs)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case web.IsGrpcWebRequest(r):
+			web.ServeHTTP(w, r)
+		case r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc"):
+			gs.ServeHTTP(w, r)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// chainUnary combines unary interceptors into one that
+// runs them in the given order.
+func chainUnary(interceptors ...grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], handler
+			handler = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, next)
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+// chainStream combines stream interceptors into one that
+// runs them in the given order.
+func chainStream(interceptors ...grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], handler
+			handler = func(srv interface{}, ss grpc.ServerStream) error {
+				return interceptor(srv, ss, info, next)
+			}
+		}
+		return handler(srv, ss)
+	}
+}
+
+// state returns the state of the given servo.
+// The caller must hold the lock.
+func state(s *servor) *api.State {
+	st := s.state()
+	out := &api.State{Servo: st.Servo, Position: st.Position, Target: st.Target, Current: st.Current, Min: st.Min, Max: st.Max}
+	if st.Measured != nil {
+		out.Measured = &api.Measurement{Position: *st.Measured}
+	}
+	return out
+}
+
+// lookup returns the servo with the given name as a gRPC error if it does not exist.
+func (g *grpcServer) lookup(name string) (*servor, error) {
+	s, ok := g.servos.get(name)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "unknown servo %q", name)
+	}
+	return s, nil
+}
+
+// move stops any running motion of the named servo
+// and drives it to the target returned by fn.
+func (g *grpcServer) move(ctx context.Context, name string, fn func(s *servor) float64) (*api.State, error) {
+	s, err := g.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	source, err := grpcSource(ctx, g.servos.policies)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.client = identityFrom(ctx)
+	defer func() {
+		s.client = nil
+	}()
+	// Commands over gRPC are treated as operator input.
+	if err := s.command(priorityManual, source); err != nil {
+		return nil, status.Error(moveStatus(err), err.Error())
+	}
+	// The target is taken before the running motion stops,
+	// so that steps during a move are taken from its target.
+	target := fn(s)
+	// Manual moves take precedence over any running motion.
+	s.stop(reasonSuperseded)
+	if err := s.move(target); err != nil {
+		if code := moveStatus(err); code != codes.Unknown {
+			return nil, status.Error(code, err.Error())
+		}
+		level.Error(s.logger).Log("err", err)
+		return nil, status.Errorf(codes.Unavailable, "failed to move servo: %v", err)
+	}
+	return state(s), nil
+}
+
+// ListServos implements api.ServorServer.
+func (g *grpcServer) ListServos(_ context.Context, _ *api.ListServosRequest) (*api.ListServosResponse, error) {
+	res := &api.ListServosResponse{}
+	for _, s := range g.servos.list {
+		s.mu.Lock()
+		res.Servos = append(res.Servos, state(s))
+		s.mu.Unlock()
+	}
+	return res, nil
+}
+
+// GetState implements api.ServorServer.
+func (g *grpcServer) GetState(_ context.Context, req *api.ServoRequest) (*api.State, error) {
+	s, err := g.lookup(req.Servo)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return state(s), nil
+}
+
+// Move implements api.ServorServer.
+func (g *grpcServer) Move(ctx context.Context, req *api.MoveRequest) (*api.State, error) {
+	return g.move(ctx, req.Servo, func(s *servor) float64 {
+		return req.Target
+	})
+}
+
+// Step implements api.ServorServer.
+func (g *grpcServer) Step(ctx context.Context, req *api.StepRequest) (*api.State, error) {
+	count := req.Count
+	if count == 0 {
+		count = 1
+	}
+	return g.move(ctx, req.Servo, func(s *servor) float64 {
+		return s.stepFrom() + float64(count)*s.step
+	})
+}
+
+// Home implements api.ServorServer.
+func (g *grpcServer) Home(ctx context.Context, req *api.ServoRequest) (*api.State, error) {
+	return g.move(ctx, req.Servo, func(s *servor) float64 {
+		return s.home
+	})
+}
+
+// Center implements api.ServorServer.
+func (g *grpcServer) Center(ctx context.Context, req *api.ServoRequest) (*api.State, error) {
+	return g.move(ctx, req.Servo, func(s *servor) float64 {
+		return s.min + (s.max-s.min)/2
+	})
+}
+
+// Stop implements api.ServorServer.
+func (g *grpcServer) Stop(_ context.Context, req *api.ServoRequest) (*api.State, error) {
+	s, err := g.lookup(req.Servo)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stop(reasonStopped)
+	return state(s), nil
+}
+
+// Jog implements api.ServorServer.
+func (g *grpcServer) Jog(stream api.Servor_JogServer) error {
+	source, err := grpcSource(stream.Context(), g.servos.policies)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	// The state of the jogged servos is pushed whenever they move,
+	// e.g. while they move at a velocity between samples, rather
+	// than only in reply to samples. Since streams must not be sent
+	// on concurrently, mu guards the stream and the jogged servos.
+	var mu sync.Mutex
+	jogged := make(map[string]*servor)
+	send := func(st *api.State) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return stream.Send(st)
+	}
+	sub, unsubscribe := g.servos.events.listen(sinkGRPC, eventFilter{Events: []string{eventPositionChanged}})
+	defer unsubscribe()
+	done, pushed := make(chan struct{}), make(chan struct{})
+	defer func() {
+		close(done)
+		<-pushed
+	}()
+	go func() {
+		defer close(pushed)
+		for {
+			select {
+			case <-done:
+				return
+			case e := <-sub.events:
+				mu.Lock()
+				s := jogged[e.servo]
+				mu.Unlock()
+				if s == nil {
+					continue
+				}
+				s.mu.Lock()
+				st := state(s)
+				s.mu.Unlock()
+				if err := send(st); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		s, err := g.lookup(req.Servo)
+		if err != nil {
+			return err
+		}
+		var target, velocity *float64
+		switch c := req.Command.(type) {
+		case *api.JogRequest_Target:
+			target = &c.Target
+		case *api.JogRequest_Velocity:
+			velocity = &c.Velocity
+		default:
+			return status.Error(codes.InvalidArgument, errInvalidSample.Error())
+		}
+		mu.Lock()
+		jogged[s.name] = s
+		mu.Unlock()
+		// Each sample is an actuation request of its own.
+		release, err := g.limiter.acquire(stream.Context().Done())
+		if err != nil {
+			return limitStatus(err)
+		}
+		s.mu.Lock()
+		s.client = identityFrom(stream.Context())
+		if err = s.command(priorityManual, source); err == nil {
+			err = s.sample(target, velocity)
+		}
+		s.client = nil
+		st := state(s)
+		s.mu.Unlock()
+		release()
+		if code := moveStatus(err); code != codes.Unknown {
+			return status.Error(code, err.Error())
+		}
+		if err != nil {
+			level.Error(s.logger).Log("msg", "failed to jog", "err", err)
+			return status.Errorf(codes.Unavailable, "failed to move servo: %v", err)
+		}
+		if err := send(st); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// The events that are published on the bus and that hooks can run on.
+const (
+	// eventPositionChanged occurs when a servo is driven to a new position.
+	eventPositionChanged = "position-changed"
+	// eventLimitHit occurs when a move is cut short by the range of a servo.
+	eventLimitHit = "limit-hit"
+	// eventDeviceError occurs when a write to the device of a servo fails.
+	eventDeviceError = "device-error"
+	// eventShutdown occurs when servor shuts down.
+	eventShutdown = "shutdown"
+	// eventPreMove occurs before a direct move, i.e. a move to a target
+	// rather than a continuous motion. Its hooks run one after the other
+	// and can change the target or reject the move.
+	eventPreMove = "pre-move"
+	// eventPostMove occurs after a direct move completed.
+	eventPostMove = "post-move"
+	// eventStall occurs when a servo stalled and was backed off.
+	eventStall = "stall"
+	// eventMaintenanceDue occurs when a servo exceeds a maintenance threshold.
+	eventMaintenanceDue = "maintenance-due"
+	// eventWatchdog occurs when the watchdog of a servo tripped
+	// and moved it to its failsafe position.
+	eventWatchdog = "watchdog"
+	// eventJobStarted occurs when a job, e.g. a tour, starts.
+	eventJobStarted = "job-started"
+	// eventJobEnded occurs when a job completes or is cancelled.
+	eventJobEnded = "job-ended"
+	// eventConfigChanged occurs when the settings of a servo change,
+	// e.g. when they are imported or the servo is calibrated.
+	eventConfigChanged = "config-changed"
+)
+
+var events = []string{eventPositionChanged, eventLimitHit, eventDeviceError, eventShutdown, eventPreMove, eventPostMove, eventStall, eventMaintenanceDue, eventWatchdog, eventJobStarted, eventJobEnded, eventConfigChanged}
+
+// hookTimeout is how long a hook may run before it is killed.
+const hookTimeout = 10 * time.Second
+
+// hookConfig runs a local command whenever an event occurs,
+// e.g. to play a sound or toggle a relay.
+type hookConfig struct {
+	Event string `json:"event"`
+	// Command is the program to run and its arguments;
+	// it is not run by a shell.
+	Command []string `json:"command"`
+	// Servo limits the hook to the events of the named servo.
+	Servo string `json:"servo,omitempty"`
+}
+
+func (h *hookConfig) validate(servos map[string]bool) error {
+	if !knownEvent(h.Event) {
+		return fmt.Errorf("event must be one of %s; got %q", strings.Join(events, ", "), h.Event)
+	}
+	if len(h.Command) == 0 || h.Command[0] == "" {
+		return errors.New("command must be set")
+	}
+	if h.Servo != "" {
+		if h.Event == eventShutdown {
+			return fmt.Errorf("%s hooks cannot be limited to a servo", eventShutdown)
+		}
+		if !servos[h.Servo] {
+			return fmt.Errorf("unknown servo %q", h.Servo)
+		}
+	}
+	return nil
+}
+
+// event describes an occurrence that is published on the bus.
+type event struct {
+	name     string
+	time     time.Time
+	servo    string
+	position float64
+	// target is where the servo is heading or, for a pre-move
+	// event, the target of the move.
+	target float64
+	// current is where the servo is; see servoState.
+	current float64
+	// source is the source of the command that caused the event, if any.
+	source string
+	err    error
+	// job is a copy of the job that started or ended, if any.
+	job *job
+}
+
+// env returns the environment variables that describe the event.
+func (e event) env() []string {
+	env := []string{"SERVOR_EVENT=" + e.name}
+	if e.servo != "" {
+		env = append(env,
+			"SERVOR_SERVO="+e.servo,
+			"SERVOR_POSITION="+strconv.FormatFloat(e.position, 'g', -1, 64),
+			"SERVOR_TARGET="+strconv.FormatFloat(e.target, 'g', -1, 64),
+			"SERVOR_CURRENT="+strconv.FormatFloat(e.current, 'g', -1, 64),
+		)
+	}
+	if e.source != "" {
+		env = append(env, "SERVOR_SOURCE="+e.source)
+	}
+	if e.err != nil {
+		env = append(env, "SERVOR_ERROR="+e.err.Error())
+	}
+	if e.job != nil {
+		env = append(env,
+			"SERVOR_JOB="+strconv.FormatUint(e.job.ID, 10),
+			"SERVOR_MOTION="+e.job.Motion,
+			"SERVOR_JOB_STATE="+e.job.State,
+		)
+	}
+	return env
+}
+
+// hook is a configured hook and the event it is due to run on next.
+type hook struct {
+	config  hookConfig
+	pending chan event
+}
+
+// hooks runs the configured hooks.
+// Each hook runs for one event at a time; if events occur faster than
+// the hook runs, e.g. while a servo is jogged, only the latest event
+// waits, so that a slow command does not fall ever further behind.
+type hooks struct {
+	list   []*hook
+	logger log.Logger
+}
+
+func newHooks(configs []hookConfig, logger log.Logger) *hooks {
+	hs := &hooks{logger: logger}
+	for _, c := range configs {
+		h := &hook{config: c, pending: make(chan event, 1)}
+		hs.list = append(hs.list, h)
+		// Shutdown and pre-move hooks are run by the caller.
+		if c.Event != eventShutdown && c.Event != eventPreMove {
+			go func() {
+				for e := range h.pending {
+					hs.run(h, e)
+				}
+			}()
+		}
+		// Export the failures as 0 before the first one.
+		hookFailuresTotal.WithLabelValues(c.Event)
+	}
+	return hs
+}
+
+// event returns the event of the given name for the servo in its current state.
+// The caller must hold the lock.
+func (s *servor) event(name string) event {
+	st := s.state()
+	return event{name: name, servo: s.name, position: st.Position, target: st.Target, current: st.Current, source: s.source}
+}
+
+// send queues the hooks of the event without waiting for them,
+// which makes the hooks a sink of the bus.
+// It is safe to call on nil hooks.
+func (hs *hooks) send(e event) {
+	if hs == nil {
+		return
+	}
+	for _, h := range hs.list {
+		if h.config.Event != e.name || (h.config.Servo != "" && h.config.Servo != e.servo) {
+			continue
+		}
+		for sent := false; !sent; {
+			select {
+			case h.pending <- e:
+				sent = true
+			default:
+				// Replace the stale event that is still waiting.
+				select {
+				case <-h.pending:
+				default:
+				}
+			}
+		}
+	}
+}
+
+// shutdown runs the hooks of the shutdown event and waits for them.
+// It is safe to call on nil hooks.
+func (hs *hooks) shutdown() {
+	if hs == nil {
+		return
+	}
+	for _, h := range hs.list {
+		if h.config.Event == eventShutdown {
+			hs.run(h, event{name: eventShutdown})
+		}
+	}
+}
+
+// vetoError is returned for moves that a pre-move hook rejected.
+type vetoError struct {
+	reason string
+}
+
+func (e *vetoError) Error() string {
+	return "move rejected by pre-move hook: " + e.reason
+}
+
+// approves reports whether the moves of the named servo
+// need the approval of pre-move hooks.
+// It is safe to call on nil hooks.
+func (hs *hooks) approves(servo string) bool {
+	if hs == nil {
+		return false
+	}
+	for _, h := range hs.list {
+		if h.config.Event == eventPreMove && (h.config.Servo == "" || h.config.Servo == servo) {
+			return true
+		}
+	}
+	return false
+}
+
+// errSuperseded is returned for moves that another command
+// took over while their pre-move hooks ran.
+var errSuperseded = errors.New("the move was superseded while awaiting the pre-move hooks")
+
+// approve asks the pre-move hooks to approve the move of the servo to
+// the target and returns the target to move to. Since hooks may take
+// a while, the lock is released while they run, and the move fails
+// if another command or motion took over the servo in the meantime.
+// The caller must hold the lock.
+func (s *servor) approve(target float64) (float64, error) {
+	e := s.event(eventPreMove)
+	e.target = target
+	generation, client, source, traceID := s.generation, s.client, s.source, s.traceID
+	s.mu.Unlock()
+	target, err := s.hooks.approve(e)
+	s.mu.Lock()
+	// Other commands may have been handled in the meantime.
+	s.client, s.source, s.traceID = client, source, traceID
+	if err != nil {
+		return 0, err
+	}
+	if s.generation != generation {
+		return 0, errSuperseded
+	}
+	return target, nil
+}
+
+// checkContinuous rejects continuous motions, e.g. jogs at a velocity
+// or tours, of servos whose moves need the approval of pre-move hooks,
+// since the hooks only approve direct moves and would be bypassed.
+// The caller must hold the lock.
+func (s *servor) checkContinuous() error {
+	if s.hooks.approves(s.name) {
+		return &vetoError{reason: "continuous motions bypass the pre-move hooks of the servo"}
+	}
+	return nil
+}
+
+// approve runs the pre-move hooks for the pre-move event, which
+// describes a move of a servo to its target, and returns the target
+// to move to. A hook rejects the move by exiting with a non-zero
+// status, giving the reason on standard error, and changes the target
+// by printing a new one.
+// Since moves wait for the hooks, hooks that cannot be run or time
+// out reject the move rather than letting it bypass a policy.
+// It is safe to call on nil hooks.
+func (hs *hooks) approve(e event) (float64, error) {
+	if hs == nil {
+		return e.target, nil
+	}
+	for _, h := range hs.list {
+		if h.config.Event != eventPreMove || (h.config.Servo != "" && h.config.Servo != e.servo) {
+			continue
+		}
+		out, reason, err := hs.exec(h, e)
+		if _, ok := err.(*exec.ExitError); ok {
+			if reason = strings.TrimSpace(reason); reason == "" {
+				reason = err.Error()
+			}
+			level.Info(hs.logger).Log("msg", "move rejected by pre-move hook", "servo", e.servo, "target", e.target, "reason", reason)
+			return 0, &vetoError{reason: reason}
+		}
+		if err != nil {
+			hs.failed(h, eventPreMove, e.servo, reason, err)
+			return 0, &vetoError{reason: err.Error()}
+		}
+		if out = strings.TrimSpace(out); out != "" {
+			t, err := strconv.ParseFloat(out, 64)
+			// A target that is not a finite number vetoes the move.
+			if err != nil || math.IsNaN(t) || math.IsInf(t, 0) {
+				err = fmt.Errorf("invalid target %q", out)
+				hs.failed(h, eventPreMove, e.servo, reason, err)
+				return 0, &vetoError{reason: err.Error()}
+			}
+			e.target = t
+		}
+	}
+	return e.target, nil
+}
+
+// run runs the command of the hook for the event and logs failures.
+func (hs *hooks) run(h *hook, e event) {
+	if _, stderr, err := hs.exec(h, e); err != nil {
+		hs.failed(h, e.name, e.servo, stderr, err)
+	}
+}
+
+func (hs *hooks) failed(h *hook, event, servo, stderr string, err error) {
+	hookFailuresTotal.WithLabelValues(event).Inc()
+	level.Warn(hs.logger).Log("msg", "hook failed", "event", event, "servo", servo, "command", h.config.Command[0], "err", err, "output", stderr)
+}
+
+// exec runs the command of the hook for the event
+// and returns its standard output and standard error.
+func (hs *hooks) exec(h *hook, e event) (string, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, h.config.Command[0], h.config.Command[1:]...)
+	cmd.Env = append(os.Environ(), e.env()...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		// Killed commands exit with an error, too, but they did not
+		// decide to, so report the timeout instead.
+		err = fmt.Errorf("timed out after %s", hookTimeout)
+	}
+	return stdout.String(), stderr.String(), err
+}
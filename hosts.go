@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// hostConfig is a virtual host that serves a group of the servos
+// with a UI of its own, so that one instance behind one address can
+// serve, e.g., blinds.home and camera.home.
+type hostConfig struct {
+	// Name is the host name that clients request, e.g. blinds.home.
+	Name string `json:"name"`
+	// Servos are the names of the servos of the host, the first of
+	// which is the default servo of the host; defaults to all servos.
+	Servos []string `json:"servos,omitempty"`
+	// Profile is the UI profile of the host for callers whose token
+	// does not set one; defaults to --ui-profile.
+	Profile string `json:"profile,omitempty"`
+	// TLSCert and TLSKey are the paths to the certificate and key
+	// that are served for the host; default to --tls-cert and --tls-key.
+	TLSCert string `json:"tlsCert,omitempty"`
+	TLSKey  string `json:"tlsKey,omitempty"`
+}
+
+// validate checks the host given the names of the servos.
+func (h *hostConfig) validate(servos map[string]bool) error {
+	if h.Name == "" {
+		return errors.New("name must be set")
+	}
+	if h.Name != strings.ToLower(h.Name) || strings.ContainsAny(h.Name, ":/") {
+		return fmt.Errorf("name must be a lowercase host name without a port; got %q", h.Name)
+	}
+	seen := make(map[string]bool)
+	for _, s := range h.Servos {
+		if !servos[s] {
+			return fmt.Errorf("unknown servo %q", s)
+		}
+		if seen[s] {
+			return fmt.Errorf("servos must be unique; got %q more than once", s)
+		}
+		seen[s] = true
+	}
+	if h.Profile != "" && !validProfile(h.Profile) {
+		return fmt.Errorf("profile must be one of %s; got %q", strings.Join(profiles, ", "), h.Profile)
+	}
+	if (h.TLSCert == "") != (h.TLSKey == "") {
+		return errors.New("tlsCert and tlsKey must be set together")
+	}
+	return nil
+}
+
+// group returns a view of the named servos, in the given order,
+// that shares the servos, their devices, and their hooks with ss.
+// Pairs are kept if the group includes both of their servos.
+func (ss *servos) group(names []string) *servos {
+	if len(names) == 0 {
+		return ss
+	}
+	g := *ss
+	g.list = make([]*servor, 0, len(names))
+	g.byName = make(map[string]*servor, len(names))
+	for _, name := range names {
+		g.list = append(g.list, ss.byName[name])
+		g.byName[name] = ss.byName[name]
+	}
+	g.pairs = nil
+	for _, p := range ss.pairs {
+		if g.byName[p.Pan] != nil && g.byName[p.Tilt] != nil {
+			g.pairs = append(g.pairs, p)
+		}
+	}
+	return &g
+}
+
+// hostRouter serves each request with the handler of the virtual host
+// that it names in its Host header, or else with the default handler.
+type hostRouter struct {
+	hosts map[string]http.Handler
+	def   http.Handler
+}
+
+func (hr *hostRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if h, ok := hr.hosts[strings.ToLower(strings.TrimSuffix(host, "."))]; ok {
+		h.ServeHTTP(w, r)
+		return
+	}
+	hr.def.ServeHTTP(w, r)
+}
+
+// sniCertificates serves the certificate of the virtual host that
+// TLS clients name with SNI, or else the default certificate.
+type sniCertificates struct {
+	hosts map[string]*certReloader
+	def   *certReloader
+}
+
+// getCertificate implements tls.Config.GetCertificate.
+func (sc *sniCertificates) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if c, ok := sc.hosts[strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))]; ok {
+		return c.getCertificate(hello)
+	}
+	return sc.def.getCertificate(hello)
+}
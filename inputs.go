@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// inputConfig shapes the deflection of an analog input, e.g. a
+// gamepad stick, before it jogs a servo, since raw linear input
+// makes fine aiming nearly impossible.
+type inputConfig struct {
+	// Deadzone is the deflection between 0 and 1 below which the input
+	// is ignored, so that sticks that do not center perfectly do not drift.
+	Deadzone float64 `json:"deadzone,omitempty"`
+	// Expo blends a cubic curve into the response, from 0 for linear
+	// to 1 for fully cubic, for finer control near the center.
+	Expo float64 `json:"expo,omitempty"`
+	// Scale is the velocity at full deflection as a fraction of the
+	// range of the servo per second; defaults to 1.
+	Scale float64 `json:"scale,omitempty"`
+}
+
+func (c *inputConfig) validate() error {
+	if c.Deadzone < 0 || c.Deadzone >= 1 {
+		return fmt.Errorf("deadzone must be at least 0 and less than 1; got %f", c.Deadzone)
+	}
+	if c.Expo < 0 || c.Expo > 1 {
+		return fmt.Errorf("expo must be between 0 and 1; got %f", c.Expo)
+	}
+	if c.Scale < 0 {
+		return fmt.Errorf("scale must not be negative; got %f", c.Scale)
+	}
+	return nil
+}
+
+// shape maps a deflection between -1 and 1
+// to a fraction of the range per second.
+func (c inputConfig) shape(x float64) float64 {
+	a := math.Min(1, math.Abs(x))
+	if a <= c.Deadzone {
+		return 0
+	}
+	// The response starts from 0 at the edge of the deadzone
+	// rather than jumping to the deadzone.
+	a = (a - c.Deadzone) / (1 - c.Deadzone)
+	a = (1-c.Expo)*a + c.Expo*a*a*a
+	scale := c.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	return math.Copysign(a*scale, x)
+}
+
+// jogRequest is the body of the jog endpoint. Like a sample of the
+// gRPC Jog stream, it sets exactly one of target and velocity, or,
+// for analog inputs, the deflection of the input in axis.
+type jogRequest struct {
+	Target   *float64 `json:"target"`
+	Velocity *float64 `json:"velocity"`
+	// Axis is the deflection of an analog input between -1 and 1,
+	// which is shaped by the configuration of its source.
+	Axis *float64 `json:"axis"`
+	// Source names the input, e.g. gamepad.
+	Source string `json:"source"`
+}
+
+// errInvalidSample is returned for jog samples that do not
+// set exactly one of target, velocity, and axis.
+var errInvalidSample = errors.New("exactly one of target, velocity, and axis must be set")
+
+// jogRequest applies a jog request, converting the deflection of an
+// analog input, if given, into a velocity.
+// The caller must hold the lock.
+func (s *servor) jogRequest(j jogRequest) error {
+	if j.Axis == nil {
+		return s.sample(j.Target, j.Velocity)
+	}
+	if j.Target != nil || j.Velocity != nil {
+		return errInvalidSample
+	}
+	if err := s.checkAxis(*j.Axis); err != nil {
+		return err
+	}
+	// Sources without a configuration respond linearly.
+	v := s.inputs[j.Source].shape(*j.Axis) * (s.max - s.min)
+	return s.sample(nil, &v)
+}
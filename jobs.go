@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// The states of a job.
+const (
+	jobRunning   = "running"
+	jobCompleted = "completed"
+	jobCancelled = "cancelled"
+)
+
+// The reasons for which a job is cancelled.
+const (
+	// reasonSuperseded means that a new command took over the servo.
+	reasonSuperseded = "superseded"
+	// reasonStopped means that the motion was stopped explicitly.
+	reasonStopped = "stopped"
+	// reasonRequested means that a client cancelled the job itself.
+	reasonRequested = "requested"
+	// reasonReconfigured means that the settings of the servo changed.
+	reasonReconfigured = "reconfigured"
+	// reasonStalled means that the servo stalled and was backed off.
+	reasonStalled = "stalled"
+	// reasonParked means that the servo was parked at a low supply voltage.
+	reasonParked = "parked"
+	// reasonOverBudget means that the servo used up its duty budget.
+	reasonOverBudget = "over-budget"
+	// reasonShutdown means that servor shut down.
+	reasonShutdown = "shutdown"
+	// reasonPaused means that a snapshot paused the job.
+	reasonPaused = "paused"
+)
+
+// maxJobHistory is how many jobs are kept per servo,
+// including the running one.
+const maxJobHistory = 20
+
+// lastJobID is the ID of the most recently started job of any servo.
+var lastJobID uint64
+
+// callbackTimeout bounds the delivery of a callback.
+const callbackTimeout = 10 * time.Second
+
+var (
+	// webhookClient posts the events of webhooks, whose URLs are
+	// configured rather than given by clients, like callbacks.
+	webhookClient = &http.Client{Timeout: callbackTimeout}
+	// callbacks tracks the callbacks being delivered,
+	// so that shutdown can wait for them.
+	callbacks sync.WaitGroup
+	// motions tracks the motions of jobs, so that shutdown
+	// can wait for them to stop driving the servos.
+	motions sync.WaitGroup
+)
+
+// job is a motion that runs in the background, e.g. a tour.
+// Its fields are guarded by the lock of its servo.
+type job struct {
+	ID     uint64 `json:"id"`
+	Servo  string `json:"servo"`
+	Motion string `json:"motion"`
+	// Priority and Source are the priority and the source
+	// of the command that started the job.
+	Priority string `json:"priority"`
+	Source   string `json:"source,omitempty"`
+	State    string `json:"state"`
+	// Reason is why the job was cancelled.
+	Reason string `json:"reason,omitempty"`
+	// SupersededBy is the source of the command that took over
+	// the servo, if the job was superseded.
+	SupersededBy string     `json:"supersededBy,omitempty"`
+	Started      time.Time  `json:"started"`
+	Ended        *time.Time `json:"ended,omitempty"`
+
+	cancel   context.CancelFunc
+	priority priority
+	// callback is a URL to which the job is posted when it ends,
+	// and callbacks posts it.
+	callback  string
+	callbacks *callbackPolicy
+	// spec describes the motion of the job, if it can be resumed.
+	spec *motionSpec
+	// done is closed once the motion of the job returned.
+	done   chan struct{}
+	logger log.Logger
+}
+
+// end records that the job ended in the given state unless it
+// already ended, and returns whether it ended now.
+func (j *job) end(state, reason string) bool {
+	if j.State != jobRunning {
+		return false
+	}
+	now := time.Now()
+	j.State, j.Reason, j.Ended = state, reason, &now
+	if j.callback != "" {
+		callbacks.Add(1)
+		go func(j job) {
+			defer callbacks.Done()
+			j.notify()
+		}(*j)
+	}
+	return true
+}
+
+// errCallbackForbidden is returned for callbacks to hosts that are not
+// allowed for everyone given by clients without the admin scope.
+var errCallbackForbidden = errors.New("callbacks to this host require a token with the admin scope")
+
+// errCallbackAddress is returned for callbacks to loopback and
+// link-local addresses of hosts that are not allowed explicitly.
+var errCallbackAddress = errors.New("callbacks to loopback and link-local addresses must be allowed explicitly")
+
+// callbackPolicy limits the hosts that jobs post their callbacks to,
+// since clients could otherwise have servor post to any host that it
+// can reach, e.g. to servor itself or to the metadata service of a cloud.
+type callbackPolicy struct {
+	// hosts are the hosts that any client may give callbacks to,
+	// including loopback and link-local ones.
+	hosts map[string]bool
+	// client posts the callbacks, refusing to connect to loopback and
+	// link-local addresses of hosts that are not allowed explicitly.
+	client *http.Client
+}
+
+func newCallbackPolicy(hosts []string) *callbackPolicy {
+	cp := &callbackPolicy{hosts: make(map[string]bool)}
+	for _, h := range hosts {
+		cp.hosts[strings.ToLower(h)] = true
+	}
+	cp.client = &http.Client{
+		Timeout:   callbackTimeout,
+		Transport: &http.Transport{DialContext: cp.dial},
+		// Redirects could lead anywhere.
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return cp
+}
+
+// allowed returns whether a callback to the given host may be given
+// by the given client: any client may give callbacks to the configured
+// hosts, and clients with the admin scope to any other host.
+func (cp *callbackPolicy) allowed(host string, id *identity) error {
+	if cp.hosts[strings.ToLower(host)] {
+		return nil
+	}
+	if id == nil || !id.scopes[scopeAdmin] {
+		return errCallbackForbidden
+	}
+	if ip := net.ParseIP(host); (ip != nil && privateCallbackIP(ip)) || strings.EqualFold(host, "localhost") {
+		return errCallbackAddress
+	}
+	return nil
+}
+
+// dial connects to the address of a callback. Since the name of a host
+// may resolve to other addresses than when the callback was given,
+// the addresses are checked when the callback is posted.
+func (cp *callbackPolicy) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	if cp.hosts[strings.ToLower(host)] {
+		return d.DialContext(ctx, network, address)
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if privateCallbackIP(ip.IP) {
+			return nil, errCallbackAddress
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no addresses found for %q", host)
+	}
+	return d.DialContext(ctx, network, net.JoinHostPort(ips[0].IP.String(), port))
+}
+
+// privateCallbackIP reports whether callbacks to the IP address
+// must be allowed explicitly.
+func privateCallbackIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified()
+}
+
+// parseCallback returns the callback URL given in the query of
+// the request, if any, if the client may give it.
+func parseCallback(r *http.Request, cp *callbackPolicy) (string, error) {
+	callback := r.URL.Query().Get("callback")
+	if callback == "" {
+		return "", nil
+	}
+	u, err := url.Parse(callback)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("callback must be an absolute HTTP or HTTPS URL; got %q", callback)
+	}
+	if err := cp.allowed(u.Hostname(), identityFrom(r.Context())); err != nil {
+		return "", err
+	}
+	return callback, nil
+}
+
+// callbackError responds with the error of parseCallback.
+func callbackError(w http.ResponseWriter, err error) {
+	if err == errCallbackForbidden || err == errCallbackAddress {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+// notify posts the ended job to its callback URL.
+// Failed deliveries are not retried.
+func (j job) notify() {
+	buf, err := json.Marshal(j)
+	if err != nil {
+		level.Error(j.logger).Log("err", err)
+		return
+	}
+	resp, err := j.callbacks.client.Post(j.callback, "application/json", bytes.NewReader(buf))
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			err = fmt.Errorf("callback responded with %s", resp.Status)
+		}
+	}
+	if err != nil {
+		callbackFailuresTotal.Inc()
+		level.Warn(j.logger).Log("msg", "failed to deliver job callback", "job", j.ID, "err", err)
+	}
+}
+
+// start cancels any running job and runs fn in the background as
+// a job of the given motion until it returns or the job is cancelled.
+// The job has the priority of the command in control of the servo.
+// The caller must hold the lock.
+func (s *servor) start(motion string, fn func(context.Context)) *job {
+	s.stop(reasonSuperseded)
+	s.target = nil
+	ctx, cancel := context.WithCancel(context.Background())
+	p := s.control()
+	j := &job{
+		ID:        atomic.AddUint64(&lastJobID, 1),
+		Servo:     s.name,
+		Motion:    motion,
+		Priority:  p.String(),
+		Source:    s.source,
+		State:     jobRunning,
+		Started:   time.Now(),
+		cancel:    cancel,
+		priority:  p,
+		callbacks: s.callbacks,
+		done:      make(chan struct{}),
+		logger:    s.logger,
+	}
+	s.job = j
+	s.jobs = append(s.jobs, j)
+	if len(s.jobs) > maxJobHistory {
+		s.jobs = s.jobs[len(s.jobs)-maxJobHistory:]
+	}
+	s.events.publish(s.jobEvent(eventJobStarted, j))
+	active := motionJobsActive.WithLabelValues(s.name)
+	active.Inc()
+	motions.Add(1)
+	go func() {
+		defer motions.Done()
+		defer close(j.done)
+		defer active.Dec()
+		fn(ctx)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		ended := j.end(jobCompleted, "")
+		if s.job == j {
+			s.job = nil
+		}
+		if ended {
+			s.events.publish(s.jobEvent(eventJobEnded, j))
+		}
+	}()
+	return j
+}
+
+// stop cancels the running job, if any, for the given reason.
+// The motion stops before its next frame, leaving the
+// servo at its current position.
+// The caller must hold the lock.
+func (s *servor) stop(reason string) {
+	s.generation++
+	if j := s.job; j != nil {
+		if reason == reasonSuperseded {
+			j.SupersededBy = s.source
+		}
+		j.cancel()
+		j.end(jobCancelled, reason)
+		s.job = nil
+		s.events.publish(s.jobEvent(eventJobEnded, j))
+	}
+}
+
+// jobEvent returns the event of the given name for the job of the servo.
+// The caller must hold the lock.
+func (s *servor) jobEvent(name string, j *job) event {
+	e := s.event(name)
+	c := *j
+	e.job = &c
+	return e
+}
+
+// startJob starts the motion as a job like startMotion, with the
+// given callback URL, if any, and responds with the job, unless the
+// servo has pre-move hooks, which the motion would bypass.
+// The caller must hold the lock.
+func (s *servor) startJob(w http.ResponseWriter, callback string, m motionSpec) {
+	if err := s.checkContinuous(); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	j := s.startMotion(m)
+	j.callback = callback
+	s.writeJob(w, j)
+}
+
+// writeJob responds with the given job.
+// The caller must hold the lock.
+func (s *servor) writeJob(w http.ResponseWriter, j *job) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(j); err != nil {
+		level.Error(s.logger).Log("err", err)
+	}
+}
+
+// stop cancels the running jobs of all servos for the given reason.
+func (ss *servos) stop(reason string) {
+	for _, s := range ss.list {
+		s.mu.Lock()
+		s.stop(reason)
+		s.mu.Unlock()
+	}
+}
+
+// cancelJob cancels the job with the given ID, which may be a job
+// of any servo, and responds with it.
+func (ss *servos) cancelJob(w http.ResponseWriter, id uint64) {
+	for _, s := range ss.list {
+		s.mu.Lock()
+		for _, j := range s.jobs {
+			if j.ID != id {
+				continue
+			}
+			defer s.mu.Unlock()
+			if j != s.job {
+				http.Error(w, fmt.Sprintf("job %d is not running; it is %s", id, j.State), http.StatusConflict)
+				return
+			}
+			s.stop(reasonRequested)
+			s.writeJob(w, j)
+			return
+		}
+		s.mu.Unlock()
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
+
+// history returns copies of the recent jobs of the servo, oldest first.
+// The caller must hold the lock.
+func (s *servor) history() []job {
+	jobs := make([]job, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		jobs = append(jobs, *j)
+	}
+	return jobs
+}
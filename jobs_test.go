@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCallbackAllowed(t *testing.T) {
+	admin := &identity{name: "admin", scopes: map[string]bool{scopeRead: true, scopeMove: true, scopeAdmin: true}}
+	mover := &identity{name: "mover", scopes: map[string]bool{scopeRead: true, scopeMove: true}}
+	cp := newCallbackPolicy([]string{"orchestrator.local", "127.0.0.1"})
+	for _, tc := range []struct {
+		name string
+		host string
+		id   *identity
+		err  error
+	}{
+		{name: "allowed host", host: "orchestrator.local", id: mover},
+		{name: "allowed host in other case", host: "Orchestrator.Local", id: mover},
+		{name: "allowed host without authentication", host: "orchestrator.local"},
+		{name: "allowed loopback", host: "127.0.0.1", id: mover},
+		{name: "other host", host: "example.com", id: mover, err: errCallbackForbidden},
+		{name: "other host without authentication", host: "example.com", err: errCallbackForbidden},
+		{name: "other host by admin", host: "example.com", id: admin},
+		{name: "loopback by admin", host: "127.0.0.2", id: admin, err: errCallbackAddress},
+		{name: "IPv6 loopback by admin", host: "::1", id: admin, err: errCallbackAddress},
+		{name: "localhost by admin", host: "LocalHost", id: admin, err: errCallbackAddress},
+		{name: "link-local by admin", host: "169.254.169.254", id: admin, err: errCallbackAddress},
+		{name: "IPv6 link-local by admin", host: "fe80::1", id: admin, err: errCallbackAddress},
+		{name: "unspecified by admin", host: "0.0.0.0", id: admin, err: errCallbackAddress},
+		{name: "private by admin", host: "192.168.1.10", id: admin},
+	} {
+		if err := cp.allowed(tc.host, tc.id); err != tc.err {
+			t.Errorf("%s: expected %v; got %v", tc.name, tc.err, err)
+		}
+	}
+}
+
+func TestParseCallback(t *testing.T) {
+	admin := &identity{name: "admin", scopes: map[string]bool{scopeAdmin: true}}
+	cp := newCallbackPolicy([]string{"orchestrator.local"})
+	for _, tc := range []struct {
+		name     string
+		callback string
+		id       *identity
+		err      bool
+		status   int
+	}{
+		{name: "none"},
+		{name: "allowed", callback: "http://orchestrator.local:8080/done"},
+		{name: "HTTPS", callback: "https://orchestrator.local/done"},
+		{name: "relative", callback: "/done", err: true, status: http.StatusBadRequest},
+		{name: "other scheme", callback: "ftp://orchestrator.local/done", err: true, status: http.StatusBadRequest},
+		{name: "forbidden", callback: "http://example.com/done", err: true, status: http.StatusForbidden},
+		{name: "admin", callback: "http://example.com/done", id: admin},
+		{name: "metadata service", callback: "http://169.254.169.254/latest", id: admin, err: true, status: http.StatusForbidden},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/api/oscillate?callback="+url.QueryEscape(tc.callback), nil)
+		if tc.id != nil {
+			r = r.WithContext(context.WithValue(r.Context(), identityKey{}, tc.id))
+		}
+		got, err := parseCallback(r, cp)
+		if (err != nil) != tc.err {
+			t.Errorf("%s: expected an error: %t; got %v", tc.name, tc.err, err)
+			continue
+		}
+		if err != nil {
+			w := httptest.NewRecorder()
+			callbackError(w, err)
+			if w.Code != tc.status {
+				t.Errorf("%s: expected status %d; got %d", tc.name, tc.status, w.Code)
+			}
+			continue
+		}
+		if got != tc.callback {
+			t.Errorf("%s: expected callback %q; got %q", tc.name, tc.callback, got)
+		}
+	}
+}
+
+func TestCallbackDial(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	_, port, err := net.SplitHostPort(strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name  string
+		hosts []string
+		url   string
+		err   bool
+	}{
+		{name: "allowed loopback", hosts: []string{"127.0.0.1"}, url: srv.URL},
+		{name: "loopback", url: srv.URL, err: true},
+		{name: "loopback allowed by another name", hosts: []string{"localhost"}, url: srv.URL, err: true},
+		{name: "unspecified", url: "http://0.0.0.0:" + port, err: true},
+		{name: "link-local", url: "http://169.254.169.254:" + port, err: true},
+	} {
+		cp := newCallbackPolicy(tc.hosts)
+		resp, err := cp.client.Post(tc.url, "application/json", nil)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if (err != nil) != tc.err {
+			t.Errorf("%s: expected an error: %t; got %v", tc.name, tc.err, err)
+			continue
+		}
+		if err != nil && !strings.Contains(err.Error(), errCallbackAddress.Error()) {
+			t.Errorf("%s: expected the address to be refused; got %v", tc.name, err)
+		}
+	}
+}
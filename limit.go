@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errTooManyRequests is returned for actuation requests that find
+// both the slots and the queue of the limiter full.
+var errTooManyRequests = errors.New("too many concurrent requests")
+
+// errQueueAbandoned is returned for actuation requests whose client
+// went away while they waited for a free slot.
+var errQueueAbandoned = errors.New("the request was abandoned while queued")
+
+// limiter caps the number of actuation requests that are handled
+// concurrently. Requests beyond the cap wait in a small queue for a
+// free slot; once the queue is full, they are rejected so that a
+// burst of clients does not pile up on the device mutex.
+type limiter struct {
+	inFlight chan struct{}
+	queue    chan struct{}
+}
+
+// newLimiter creates a limiter that handles up to max requests at
+// once and queues up to queue more.
+func newLimiter(max, queue int) *limiter {
+	return &limiter{
+		inFlight: make(chan struct{}, max),
+		queue:    make(chan struct{}, queue),
+	}
+}
+
+// acquire takes a slot for an actuation request, waiting in the queue
+// until one is free or done is closed, and returns a func that frees
+// the slot. A nil limiter, i.e. a disabled limit, never waits.
+func (l *limiter) acquire(done <-chan struct{}) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	select {
+	case l.inFlight <- struct{}{}:
+	default:
+		select {
+		case l.queue <- struct{}{}:
+		default:
+			return nil, errTooManyRequests
+		}
+		requestsQueued.Inc()
+		select {
+		case l.inFlight <- struct{}{}:
+		case <-done:
+			<-l.queue
+			requestsQueued.Dec()
+			return nil, errQueueAbandoned
+		}
+		<-l.queue
+		requestsQueued.Dec()
+	}
+	requestsInFlight.Inc()
+	return func() {
+		requestsInFlight.Dec()
+		<-l.inFlight
+	}, nil
+}
+
+// handler limits the POST requests passed to next, which are the
+// ones that move servos. Other requests are passed on directly;
+// WebSocket clients, which connect with a GET, take a slot for
+// every batch of frames that they apply.
+func (l *limiter) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+		release, err := l.acquire(r.Context().Done())
+		if err == errQueueAbandoned {
+			return
+		}
+		if err != nil {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		defer release()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// unary limits the unary gRPC calls that move or stop servos, like
+// the POST requests of the HTTP API. Jog streams take a slot for
+// every sample that they apply.
+func (l *limiter) unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if readOnlyRPCs[path.Base(info.FullMethod)] {
+		return handler(ctx, req)
+	}
+	release, err := l.acquire(ctx.Done())
+	if err != nil {
+		return nil, limitStatus(err)
+	}
+	defer release()
+	return handler(ctx, req)
+}
+
+// limitStatus converts an error of acquire to a gRPC status.
+func limitStatus(err error) error {
+	if err == errQueueAbandoned {
+		return status.Error(codes.Canceled, err.Error())
+	}
+	return status.Error(codes.Unavailable, err.Error())
+}
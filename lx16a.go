@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// LX-16A bus servo commands.
+const (
+	lx16aMoveTimeWrite = 1
+	lx16aTempRead      = 26
+	lx16aVinRead       = 27
+	lx16aPosRead       = 28
+
+	// lx16aMaxPosition corresponds to 240 degrees.
+	lx16aMaxPosition = 1000
+)
+
+// lx16a drives LewanSoul/Hiwonder LX-16A and compatible serial bus
+// servos. Servos are addressed by their ID and values are fractions
+// of the full position range of the servo.
+type lx16a struct {
+	device string
+	baud   int
+
+	f *os.File
+	r *bufio.Reader
+}
+
+func newLX16A(device string, baud int) *lx16a {
+	return &lx16a{device: device, baud: baud}
+}
+
+func (l *lx16a) open() error {
+	if l.f != nil {
+		return nil
+	}
+	f, err := openSerial(l.device, l.baud)
+	if err != nil {
+		return err
+	}
+	l.f = f
+	l.r = bufio.NewReader(f)
+	return nil
+}
+
+// Set implements driver.
+func (l *lx16a) Set(id int, value float64) error {
+	if id < 0 || id > 253 {
+		return fmt.Errorf("invalid LX-16A ID %d", id)
+	}
+	if err := l.open(); err != nil {
+		return err
+	}
+	pos := uint16(value*lx16aMaxPosition + 0.5)
+	// A move time of 0 moves as fast as possible.
+	if err := l.send(byte(id), lx16aMoveTimeWrite, byte(pos), byte(pos>>8), 0, 0); err != nil {
+		l.Close()
+		return err
+	}
+	return nil
+}
+
+// feedback implements feedbacker.
+func (l *lx16a) feedback(id int) (*feedback, error) {
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	buf, err := l.read(byte(id), lx16aPosRead, 2)
+	if err != nil {
+		return nil, err
+	}
+	fb := &feedback{Position: float64(int16(binary.LittleEndian.Uint16(buf))) / lx16aMaxPosition}
+	if buf, err = l.read(byte(id), lx16aVinRead, 2); err != nil {
+		return nil, err
+	}
+	voltage := float64(binary.LittleEndian.Uint16(buf)) / 1000
+	fb.Voltage = &voltage
+	if buf, err = l.read(byte(id), lx16aTempRead, 1); err != nil {
+		return nil, err
+	}
+	temperature := float64(buf[0])
+	fb.Temperature = &temperature
+	return fb, nil
+}
+
+// Close implements driver.
+func (l *lx16a) Close() error {
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+func (l *lx16a) send(id, cmd byte, params ...byte) error {
+	p := append([]byte{0x55, 0x55, id, byte(len(params) + 3), cmd}, params...)
+	_, err := l.f.Write(append(p, lx16aChecksum(p[2:])))
+	return err
+}
+
+// read sends a read command and returns the n bytes of the reply.
+func (l *lx16a) read(id, cmd byte, n int) ([]byte, error) {
+	if err := flushInput(l.f); err != nil {
+		return nil, err
+	}
+	l.r.Reset(l.f)
+	if err := l.send(id, cmd); err != nil {
+		l.Close()
+		return nil, err
+	}
+	// Single-wire bus adapters echo the command itself,
+	// so skip packets until the reply arrives.
+	for {
+		p, err := l.packet()
+		if err != nil {
+			return nil, err
+		}
+		if p[0] != id || p[2] != cmd || len(p) == 3 {
+			continue
+		}
+		if len(p)-3 != n {
+			return nil, fmt.Errorf("expected %d bytes from LX-16A %d; got %d", n, id, len(p)-3)
+		}
+		return p[3:], nil
+	}
+}
+
+// packet reads a packet and returns its ID, length, command, and parameters.
+func (l *lx16a) packet() ([]byte, error) {
+	var matched int
+	for matched < 2 {
+		b, err := l.r.ReadByte()
+		if err != nil {
+			return nil, l.timeout(err)
+		}
+		if b == 0x55 {
+			matched++
+		} else {
+			matched = 0
+		}
+	}
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(l.r, head); err != nil {
+		return nil, l.timeout(err)
+	}
+	if head[1] < 3 {
+		return nil, errors.New("invalid LX-16A packet")
+	}
+	rest := make([]byte, head[1]-1)
+	if _, err := io.ReadFull(l.r, rest); err != nil {
+		return nil, l.timeout(err)
+	}
+	p := append(head, rest[:len(rest)-1]...)
+	if lx16aChecksum(p) != rest[len(rest)-1] {
+		return nil, errors.New("invalid LX-16A packet checksum")
+	}
+	return p, nil
+}
+
+func (l *lx16a) timeout(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errors.New("timed out waiting for LX-16A reply")
+	}
+	l.Close()
+	return err
+}
+
+func lx16aChecksum(b []byte) byte {
+	var sum byte
+	for _, c := range b {
+		sum += c
+	}
+	return ^sum
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	maestroCompact = "compact"
+	maestroMiniSSC = "mini-ssc"
+
+	// The Mini SSC protocol maps 0-254 onto the channel's neutral
+	// plus or minus its range, which default to the following values.
+	maestroNeutral = 1500 * time.Microsecond
+	maestroRange   = 476250 * time.Nanosecond
+)
+
+// maestro drives the channels of a Pololu Maestro servo controller
+// over its virtual serial port.
+type maestro struct {
+	device    string
+	baud      int
+	protocol  string
+	frequency float64
+	f         *os.File
+}
+
+func newMaestro(device string, baud int, protocol string, frequency float64) (*maestro, error) {
+	switch protocol {
+	case "":
+		protocol = maestroCompact
+	case maestroCompact, maestroMiniSSC:
+	default:
+		return nil, fmt.Errorf("unknown Maestro protocol %q", protocol)
+	}
+	return &maestro{device: device, baud: baud, protocol: protocol, frequency: frequency}, nil
+}
+
+// Set implements driver.
+func (m *maestro) Set(channel int, value float64) error {
+	if channel < 0 || channel > 254 {
+		return fmt.Errorf("invalid Maestro channel %d", channel)
+	}
+	if m.f == nil {
+		f, err := openSerial(m.device, m.baud)
+		if err != nil {
+			return err
+		}
+		m.f = f
+	}
+	var cmd []byte
+	pulse := pulseWidth(value, m.frequency)
+	switch m.protocol {
+	case maestroCompact:
+		// The target is given in quarter-microseconds.
+		target := int(pulse / (250 * time.Nanosecond))
+		cmd = []byte{0x84, byte(channel), byte(target & 0x7f), byte(target >> 7 & 0x7f)}
+	case maestroMiniSSC:
+		target := float64(pulse-maestroNeutral+maestroRange) / float64(2*maestroRange) * 254
+		if target < 0 {
+			target = 0
+		}
+		if target > 254 {
+			target = 254
+		}
+		cmd = []byte{0xff, byte(channel), byte(target)}
+	}
+	if _, err := m.f.Write(cmd); err != nil {
+		m.Close()
+		return err
+	}
+	return nil
+}
+
+// Close implements driver.
+func (m *maestro) Close() error {
+	if m.f == nil {
+		return nil
+	}
+	err := m.f.Close()
+	m.f = nil
+	return err
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultContentSecurityPolicy allows only the embedded UI,
+// which uses inline scripts and styles.
+const defaultContentSecurityPolicy = "default-src 'self'; script-src 'self' 'unsafe-inline'; style-src 'self' 'unsafe-inline'; img-src 'self' data:; frame-ancestors 'none'; base-uri 'none'; form-action 'self'"
+
+// securityHeaders sets headers that harden browsers against
+// clickjacking, content injection, and downgrade attacks.
+// An empty csp omits the Content-Security-Policy header.
+// HSTS is only sent when the server uses TLS.
+func securityHeaders(next http.Handler, csp string, hsts bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		if csp != "" {
+			h.Set("Content-Security-Policy", csp)
+		}
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Referrer-Policy", "no-referrer")
+		if hsts {
+			h.Set("Strict-Transport-Security", "max-age=31536000")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// statusRecorder records the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.code = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// Hijack lets handlers take over the connection, e.g. for WebSocket,
+// which records the status as switching protocols.
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the connection cannot be hijacked")
+	}
+	s.code = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+// Flush lets handlers stream responses, e.g. server-sent events.
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// instrument records the duration of requests. If exemplars is true,
+// the trace ID of requests that carry a W3C traceparent header is
+// attached as an exemplar and passed on in the request context, so
+// that device writes caused by the request can be linked to the trace.
+func instrument(next http.Handler, exemplars bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestsActive.Inc()
+		defer requestsActive.Dec()
+		start := time.Now()
+		var id string
+		if exemplars {
+			if id = parseTraceParent(r.Header.Get("traceparent")); id != "" {
+				r = r.WithContext(context.WithValue(r.Context(), traceIDKey{}, id))
+			}
+		}
+		sr := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		next.ServeHTTP(sr, r)
+		observe(requestDuration.WithLabelValues(strconv.Itoa(sr.code), r.Method), time.Since(start).Seconds(), id)
+	})
+}
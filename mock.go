@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// mockMaxLatency bounds injected latency, so that a typo
+// cannot hang the device of a servo for good.
+const mockMaxLatency = 30
+
+// errMockFailure is the error of writes that fail by injection.
+var errMockFailure = errors.New("injected device failure")
+
+// mockFaults are the faults injected into a pin of the mock driver.
+type mockFaults struct {
+	// Fail makes every write fail, like a disconnected device.
+	Fail bool `json:"fail"`
+	// FailureRate is the fraction of writes that fail at random.
+	FailureRate float64 `json:"failureRate"`
+	// Latency delays every write by the given number of seconds,
+	// like a slow or congested bus.
+	Latency float64 `json:"latency"`
+	// Stuck freezes the measured position while writes keep
+	// succeeding, like a jammed horn.
+	Stuck bool `json:"stuck"`
+}
+
+// validate checks that the faults are in range.
+func (f *mockFaults) validate() error {
+	if f.FailureRate < 0 || f.FailureRate > 1 {
+		return fmt.Errorf("failureRate must be between 0 and 1; got %f", f.FailureRate)
+	}
+	if f.Latency < 0 || f.Latency > mockMaxLatency {
+		return fmt.Errorf("latency must be between 0 and %d seconds; got %f", mockMaxLatency, f.Latency)
+	}
+	return nil
+}
+
+// mockStep is the time step at which the motion of mock servos is simulated.
+const mockStep = 5 * time.Millisecond
+
+// mockMaxSteps bounds the steps that are simulated at once, so that
+// a pin that was not read for long is simulated at a coarser step.
+const mockMaxSteps = 2000
+
+// mockPhysics is the physical model of the servos of the mock driver.
+// Its zero value moves servos instantly.
+type mockPhysics struct {
+	// Speed is the maximum speed in units per second; 0 means unlimited.
+	Speed float64
+	// Acceleration is the maximum acceleration in units per second squared;
+	// 0 means unlimited.
+	Acceleration float64
+	// Overshoot is the fraction of each move by which servos overshoot
+	// their target before they settle on it.
+	Overshoot float64
+}
+
+// validate checks that the physics are in range.
+func (p *mockPhysics) validate() error {
+	if p.Speed < 0 {
+		return fmt.Errorf("mock speed must not be negative; got %f", p.Speed)
+	}
+	if p.Acceleration < 0 {
+		return fmt.Errorf("mock acceleration must not be negative; got %f", p.Acceleration)
+	}
+	if p.Overshoot < 0 || p.Overshoot > 1 {
+		return fmt.Errorf("mock overshoot must be between 0 and 1; got %f", p.Overshoot)
+	}
+	return nil
+}
+
+// instant returns whether servos move instantly.
+func (p *mockPhysics) instant() bool {
+	return p.Speed == 0 && p.Acceleration == 0
+}
+
+// mockMotion is the simulated motion of a pin.
+type mockMotion struct {
+	position float64
+	velocity float64
+	// aim is where the horn is headed, which lies past the target
+	// while the horn overshoots.
+	aim     float64
+	target  float64
+	updated time.Time
+}
+
+// advance simulates the motion up to now. A stuck horn does not move.
+func (mm *mockMotion) advance(p mockPhysics, now time.Time, stuck bool) {
+	elapsed := now.Sub(mm.updated)
+	mm.updated = now
+	if stuck {
+		mm.velocity = 0
+		return
+	}
+	step := mockStep
+	if elapsed > mockStep*mockMaxSteps {
+		step = elapsed / mockMaxSteps
+	}
+	for ; elapsed > 0; elapsed -= step {
+		dt := step.Seconds()
+		if elapsed < step {
+			dt = elapsed.Seconds()
+		}
+		d := mm.aim - mm.position
+		// The desired velocity is the fastest from which
+		// the horn can still stop at its aim.
+		v := math.Inf(1)
+		if p.Acceleration > 0 {
+			v = math.Sqrt(2 * p.Acceleration * math.Abs(d))
+		}
+		if p.Speed > 0 {
+			v = math.Min(v, p.Speed)
+		}
+		v = math.Copysign(v, d)
+		if p.Acceleration > 0 {
+			dv := p.Acceleration * dt
+			v = math.Max(mm.velocity-dv, math.Min(mm.velocity+dv, v))
+		}
+		next := mm.position + v*dt
+		if math.IsInf(v, 0) || (next-mm.aim)*d >= 0 {
+			// The horn reaches its aim, and after an
+			// overshoot, heads back to the target.
+			mm.position, mm.velocity = mm.aim, 0
+			if mm.aim == mm.target {
+				return
+			}
+			mm.aim = mm.target
+			continue
+		}
+		mm.position, mm.velocity = next, v
+	}
+}
+
+// mock drives no hardware. It accepts every write and reads back
+// the last value written as feedback, so that servor can run on any machine,
+// e.g. to develop and test clients. Faults can be injected into each
+// pin to test how clients handle failing servos. With physics, the
+// position read back follows the writes like a real horn would,
+// with limited speed and acceleration.
+type mock struct {
+	// measured is the position read back for each pin,
+	// which stays put while the pin is stuck.
+	measured map[int]float64
+	physics  mockPhysics
+	motions  map[int]*mockMotion
+	rand     *rand.Rand
+	// logger logs every write, if set, e.g. in a dry run.
+	logger log.Logger
+
+	// mu guards the faults, which are set outside of the device lock.
+	mu     sync.Mutex
+	faults map[int]mockFaults
+}
+
+func newMock(p mockPhysics) (*mock, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	return &mock{
+		measured: make(map[int]float64),
+		physics:  p,
+		motions:  make(map[int]*mockMotion),
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		faults:   make(map[int]mockFaults),
+	}, nil
+}
+
+// Set implements driver.
+func (m *mock) Set(pin int, value float64) error {
+	if m.logger != nil {
+		level.Info(m.logger).Log("msg", "dry run: not writing to device", "pin", pin, "value", value)
+	}
+	f := m.fault(pin)
+	time.Sleep(time.Duration(f.Latency * float64(time.Second)))
+	if f.Fail || (f.FailureRate > 0 && m.rand.Float64() < f.FailureRate) {
+		return errMockFailure
+	}
+	// Like a real servo, a horn whose pulses stop stays where it is.
+	if value == 0 {
+		return nil
+	}
+	if m.physics.instant() {
+		if !f.Stuck {
+			m.measured[pin] = value
+		}
+		return nil
+	}
+	now := time.Now()
+	mm, ok := m.motions[pin]
+	if !ok {
+		// Horns that were never written rest at 0.
+		mm = &mockMotion{updated: now}
+		m.motions[pin] = mm
+	}
+	mm.advance(m.physics, now, f.Stuck)
+	if value != mm.target {
+		mm.target, mm.aim = value, value
+		if m.physics.Overshoot > 0 {
+			mm.aim = value + (value-mm.position)*m.physics.Overshoot
+		}
+	}
+	m.measured[pin] = mm.position
+	return nil
+}
+
+// feedback implements feedbacker.
+func (m *mock) feedback(pin int) (*feedback, error) {
+	f := m.fault(pin)
+	if f.Fail {
+		return nil, errMockFailure
+	}
+	if mm, ok := m.motions[pin]; ok {
+		mm.advance(m.physics, time.Now(), f.Stuck)
+		m.measured[pin] = mm.position
+	}
+	// Pins that were never written read 0.
+	return &feedback{Position: m.measured[pin]}, nil
+}
+
+// Close implements driver.
+func (m *mock) Close() error {
+	return nil
+}
+
+func (m *mock) fault(pin int) mockFaults {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.faults[pin]
+}
+
+func (m *mock) inject(pin int, f mockFaults) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.faults[pin] = f
+}
+
+// chaos serves the faults injected into the servo: GET returns them,
+// PUT replaces them, and DELETE clears them. Only servos that use
+// the mock driver accept faults.
+func (s *servor) chaos(w http.ResponseWriter, r *http.Request) {
+	m, ok := s.device.driver.(*mock)
+	if !ok {
+		http.Error(w, "faults can only be injected into servos that use the mock driver", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var f mockFaults
+		if err := decodeJSON(r.Body, &f, s.strict); err != nil && err != io.EOF {
+			http.Error(w, fmt.Sprintf("failed to parse request body: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := f.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		m.inject(s.pin, f)
+		level.Warn(s.logger).Log("msg", "injected faults", "fail", f.Fail, "failureRate", f.FailureRate, "latency", f.Latency, "stuck", f.Stuck)
+	case http.MethodDelete:
+		m.inject(s.pin, mockFaults{})
+		level.Info(s.logger).Log("msg", "cleared faults")
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(m.fault(s.pin)); err != nil {
+		level.Error(s.logger).Log("err", err)
+	}
+}
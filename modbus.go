@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// Modbus function codes that servor serves.
+const (
+	modbusReadHolding   = 0x03
+	modbusReadInput     = 0x04
+	modbusWriteSingle   = 0x06
+	modbusWriteMultiple = 0x10
+)
+
+// Modbus exception codes.
+const (
+	modbusIllegalFunction = 0x01
+	modbusIllegalAddress  = 0x02
+	modbusIllegalValue    = 0x03
+	modbusDeviceFailure   = 0x04
+)
+
+// The registers of each servo, relative to the first register
+// of the servo, which is 16 times the index of the servo.
+// Positions are in hundredths of a percent of the range,
+// i.e. 0 for the minimum and 10000 for the maximum.
+const (
+	modbusServoRegisters = 16
+	// modbusTarget is where the servo is heading;
+	// writing it moves the servo.
+	modbusTarget = 0
+	// modbusPosition is the current position of the servo.
+	modbusPosition = 1
+	// modbusStatus holds the status bits of the servo.
+	modbusStatus = 2
+	// modbusCommand runs the command that is written to it.
+	modbusCommand = 3
+)
+
+// Bits of the status register.
+const (
+	modbusMoving    = 1 << 0
+	modbusFailed    = 1 << 1
+	modbusInhibited = 1 << 2
+)
+
+// Values of the command register.
+const (
+	modbusStop   = 1
+	modbusHome   = 2
+	modbusCenter = 3
+)
+
+// modbusScale is the value of a position register at the maximum.
+const modbusScale = 10000
+
+// modbusIdleTimeout closes connections of clients that went away.
+const modbusIdleTimeout = 5 * time.Minute
+
+// modbusException is a Modbus exception returned to the client.
+type modbusException byte
+
+func (e modbusException) Error() string {
+	return [...]string{
+		modbusIllegalFunction: "illegal function",
+		modbusIllegalAddress:  "illegal data address",
+		modbusIllegalValue:    "illegal data value",
+		modbusDeviceFailure:   "server device failure",
+	}[e]
+}
+
+// modbusServer exposes the servos as Modbus TCP holding registers,
+// so that PLCs and industrial HMIs can drive them without HTTP.
+// The unit identifier is ignored.
+type modbusServer struct {
+	servos *servos
+	logger log.Logger
+}
+
+// serve accepts connections until the listener is closed.
+func (m *modbusServer) serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go m.handle(conn)
+	}
+}
+
+// handle answers the requests of a client until it disconnects.
+func (m *modbusServer) handle(conn net.Conn) {
+	defer conn.Close()
+	header := make([]byte, 7)
+	for {
+		conn.SetReadDeadline(time.Now().Add(modbusIdleTimeout))
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		// The length counts the unit identifier and the PDU.
+		n := int(binary.BigEndian.Uint16(header[4:]))
+		if binary.BigEndian.Uint16(header[2:]) != 0 || n < 2 || n > 254 {
+			level.Warn(m.logger).Log("msg", "closing Modbus connection after malformed request", "client", conn.RemoteAddr())
+			return
+		}
+		pdu := make([]byte, n-1)
+		if _, err := io.ReadFull(conn, pdu); err != nil {
+			return
+		}
+		rsp, err := m.request(pdu)
+		if err != nil {
+			e, ok := err.(modbusException)
+			if !ok {
+				level.Warn(m.logger).Log("msg", "failed to apply Modbus request", "err", err)
+				e = modbusDeviceFailure
+			}
+			rsp = []byte{pdu[0] | 0x80, byte(e)}
+		}
+		out := make([]byte, 7, 7+len(rsp))
+		copy(out, header[:4])
+		binary.BigEndian.PutUint16(out[4:], uint16(len(rsp)+1))
+		out[6] = header[6]
+		if _, err := conn.Write(append(out, rsp...)); err != nil {
+			return
+		}
+	}
+}
+
+// request handles a request PDU and returns the response PDU.
+func (m *modbusServer) request(pdu []byte) ([]byte, error) {
+	if len(pdu) < 5 {
+		return nil, modbusException(modbusIllegalValue)
+	}
+	fn := pdu[0]
+	addr := int(binary.BigEndian.Uint16(pdu[1:]))
+	switch fn {
+	case modbusReadHolding, modbusReadInput:
+		count := int(binary.BigEndian.Uint16(pdu[3:]))
+		if count < 1 || count > 125 {
+			return nil, modbusException(modbusIllegalValue)
+		}
+		rsp := []byte{fn, byte(2 * count)}
+		for r := addr; r < addr+count; r++ {
+			v, err := m.read(r)
+			if err != nil {
+				return nil, err
+			}
+			rsp = append(rsp, byte(v>>8), byte(v))
+		}
+		return rsp, nil
+	case modbusWriteSingle:
+		if err := m.write(addr, binary.BigEndian.Uint16(pdu[3:])); err != nil {
+			return nil, err
+		}
+		return pdu[:5], nil
+	case modbusWriteMultiple:
+		count := int(binary.BigEndian.Uint16(pdu[3:]))
+		if count < 1 || count > 123 || len(pdu) != 6+2*count || int(pdu[5]) != 2*count {
+			return nil, modbusException(modbusIllegalValue)
+		}
+		for i := 0; i < count; i++ {
+			if err := m.write(addr+i, binary.BigEndian.Uint16(pdu[6+2*i:])); err != nil {
+				return nil, err
+			}
+		}
+		return pdu[:5], nil
+	}
+	return nil, modbusException(modbusIllegalFunction)
+}
+
+// servo returns the servo that owns the register and
+// the offset of the register from the first of the servo.
+func (m *modbusServer) servo(register int) (*servor, int, error) {
+	i := register / modbusServoRegisters
+	if i >= len(m.servos.list) {
+		return nil, 0, modbusException(modbusIllegalAddress)
+	}
+	return m.servos.list[i], register % modbusServoRegisters, nil
+}
+
+// read returns the value of a register.
+func (m *modbusServer) read(register int) (uint16, error) {
+	s, r, err := m.servo(register)
+	if err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	scale := func(p float64) uint16 {
+		return uint16(math.Round((p - s.min) / (s.max - s.min) * modbusScale))
+	}
+	switch r {
+	case modbusTarget:
+		return scale(s.state().Target), nil
+	case modbusPosition:
+		return scale(s.position), nil
+	case modbusStatus:
+		var v uint16
+		if s.job != nil {
+			v |= modbusMoving
+		}
+		if s.failed {
+			v |= modbusFailed
+		}
+		if s.system.inhibited() {
+			v |= modbusInhibited
+		}
+		return v, nil
+	case modbusCommand:
+		return 0, nil
+	}
+	return 0, modbusException(modbusIllegalAddress)
+}
+
+// write writes a register and applies it to the servo.
+func (m *modbusServer) write(register int, v uint16) error {
+	s, r, err := m.servo(register)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var target float64
+	switch {
+	case r == modbusTarget && v <= modbusScale:
+		target = s.min + float64(v)/modbusScale*(s.max-s.min)
+	case r == modbusCommand && v == modbusHome:
+		target = s.home
+	case r == modbusCommand && v == modbusCenter:
+		target = s.min + (s.max-s.min)/2
+	case r == modbusCommand && v == modbusStop:
+	case r == modbusTarget || r == modbusCommand:
+		return modbusException(modbusIllegalValue)
+	default:
+		// The other registers are read-only.
+		return modbusException(modbusIllegalAddress)
+	}
+	// Commands over Modbus come from PLCs and other automation.
+	if err := s.command(priorityAutomation, sourceModbus); err != nil {
+		return err
+	}
+	if r == modbusCommand && v == modbusStop {
+		s.stop(reasonStopped)
+		return nil
+	}
+	s.stop(reasonSuperseded)
+	return s.move(target)
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// frameInterval is the period between position updates of
+// continuous motions; hobby servos expect a 50Hz signal.
+const frameInterval = 20 * time.Millisecond
+
+// frames calls fn once per frame with the time elapsed since the
+// first frame until fn returns true or the context is done.
+// While the system is paused, no frames are run and the
+// time that passes does not count as elapsed.
+// fn is called with the lock held.
+func (s *servor) frames(ctx context.Context, fn func(time.Duration) bool) {
+	t := time.NewTicker(frameInterval)
+	defer t.Stop()
+	begin := time.Now()
+	var paused time.Duration
+	var pausedAt time.Time
+	for {
+		s.mu.Lock()
+		// The motion may have been stopped while waiting for the lock.
+		if ctx.Err() != nil {
+			s.mu.Unlock()
+			return
+		}
+		// Continuous motions end once the servo used up its duty budget.
+		if s.overBudget() != nil {
+			s.stop(reasonOverBudget)
+			s.mu.Unlock()
+			return
+		}
+		var done bool
+		switch halted := s.system.paused(); {
+		case halted && pausedAt.IsZero():
+			pausedAt = time.Now()
+		case !halted && !pausedAt.IsZero():
+			paused += time.Since(pausedAt)
+			pausedAt = time.Time{}
+			fallthrough
+		case !halted:
+			done = fn(time.Since(begin) - paused)
+		}
+		s.mu.Unlock()
+		if done {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// jogTimeout is how long a servo keeps moving at the velocity
+// of a jog sample without receiving another sample.
+const jogTimeout = 500 * time.Millisecond
+
+// jog moves the servo at the given velocity in units per second
+// until it reaches a limit, the motion is stopped, or jogTimeout passes.
+// A velocity of 0 stops the servo.
+// The caller must hold the lock.
+func (s *servor) jog(velocity float64) {
+	if velocity == 0 {
+		s.stop(reasonStopped)
+		return
+	}
+	from := s.position
+	s.start("jog", func(ctx context.Context) {
+		s.frames(ctx, func(elapsed time.Duration) bool {
+			s.position = from + velocity*elapsed.Seconds()
+			if err := s.set(); err != nil {
+				level.Error(s.logger).Log("msg", "failed to jog", "err", err)
+				return true
+			}
+			return elapsed >= jogTimeout || s.position == s.min || s.position == s.max
+		})
+	})
+}
+
+// sample applies a sample of a jog stream, which either moves the
+// servo to a target immediately or moves it at a velocity.
+// The caller must hold the lock.
+func (s *servor) sample(target, velocity *float64) error {
+	switch {
+	case target != nil && velocity == nil:
+		s.stop(reasonSuperseded)
+		// The samples of a stream are already spaced in time,
+		// so they are not slowed down to the speed of the servo.
+		return s.moveAt(*target, 0, "")
+	case velocity != nil && target == nil:
+		if err := s.checkContinuous(); err != nil {
+			return err
+		}
+		if err := s.checkVelocity(*velocity); err != nil {
+			return err
+		}
+		s.jog(s.client.limitVelocity(*velocity))
+		return nil
+	}
+	return errInvalidSample
+}
+
+// oscillation describes a sinusoidal motion around a center point.
+type oscillation struct {
+	Center *float64 `json:"center"`
+	// Amplitude is the distance from the center to either extreme.
+	Amplitude float64 `json:"amplitude"`
+	// Frequency is the number of full cycles per second.
+	Frequency float64 `json:"frequency"`
+}
+
+func (o oscillation) validate() error {
+	if o.Amplitude <= 0 {
+		return errors.New("amplitude must be greater than 0")
+	}
+	if o.Frequency <= 0 {
+		return errors.New("frequency must be greater than 0")
+	}
+	if max := 1 / (2 * frameInterval.Seconds()); o.Frequency > max {
+		return errors.New("frequency must not exceed half of the update rate")
+	}
+	return nil
+}
+
+// oscillate moves the servo back and forth, starting the given
+// number of seconds into the oscillation, until the context is done.
+func (s *servor) oscillate(ctx context.Context, o oscillation, offset float64) {
+	s.frames(ctx, func(elapsed time.Duration) bool {
+		t := offset + elapsed.Seconds()
+		s.progress(0, t)
+		s.position = *o.Center + o.Amplitude*math.Sin(2*math.Pi*o.Frequency*t)
+		if err := s.set(); err != nil {
+			level.Error(s.logger).Log("msg", "failed to oscillate", "err", err)
+		}
+		return false
+	})
+}
+
+// demo describes a gentle random walk within soft limits.
+type demo struct {
+	Min *float64 `json:"min"`
+	Max *float64 `json:"max"`
+	// Intensity scales the speed of the motion and is between 0 and 1.
+	Intensity float64 `json:"intensity"`
+}
+
+func (d demo) validate() error {
+	if d.Intensity <= 0 || d.Intensity > 1 {
+		return errors.New("intensity must be greater than 0 and at most 1")
+	}
+	if *d.Min >= *d.Max {
+		return errors.New("min must be less than max")
+	}
+	return nil
+}
+
+// demo moves the servo randomly until the context is done.
+func (s *servor) demo(ctx context.Context, d demo) {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	speed := d.Intensity * (*d.Max - *d.Min) / 2
+	var velocity float64
+	var last time.Duration
+	s.frames(ctx, func(elapsed time.Duration) bool {
+		dt := (elapsed - last).Seconds()
+		last = elapsed
+		velocity += (r.Float64() - 0.5) * 4 * speed * dt
+		velocity = math.Max(-speed, math.Min(speed, velocity))
+		s.position += velocity * dt
+		// Bounce off of the soft limits.
+		if s.position > *d.Max {
+			s.position = *d.Max
+			velocity = -math.Abs(velocity)
+		}
+		if s.position < *d.Min {
+			s.position = *d.Min
+			velocity = math.Abs(velocity)
+		}
+		if err := s.set(); err != nil {
+			level.Error(s.logger).Log("msg", "failed to run demo", "err", err)
+		}
+		return false
+	})
+}
+
+// startDemo validates the demo, filling in the soft limits
+// from the range of the servo if unset, and starts it unless
+// the servo has pre-move hooks.
+// The caller must hold the lock.
+func (s *servor) startDemo(d demo) (*job, error) {
+	if err := s.checkContinuous(); err != nil {
+		return nil, err
+	}
+	if d.Min == nil {
+		min := s.min
+		d.Min = &min
+	}
+	if d.Max == nil {
+		max := s.max
+		d.Max = &max
+	}
+	if err := d.validate(); err != nil {
+		return nil, err
+	}
+	return s.startMotion(motionSpec{Motion: "demo", Demo: &d}), nil
+}
+
+// defaultPatrolDwell is how long a patrol stays at each preset
+// unless configured otherwise.
+const defaultPatrolDwell = 5 * time.Second
+
+// patrol describes a loop through an ordered list of presets.
+type patrol struct {
+	Presets []string `json:"presets,omitempty"`
+	// Dwell is the number of seconds to stay at each preset.
+	Dwell float64 `json:"dwell"`
+}
+
+func (p patrol) validate(presets map[string]float64) error {
+	if len(p.Presets) == 0 {
+		return errors.New("patrol must include at least one preset")
+	}
+	for _, name := range p.Presets {
+		if _, ok := presets[name]; !ok {
+			return fmt.Errorf("unknown preset %q", name)
+		}
+	}
+	if p.Dwell <= 0 {
+		return errors.New("dwell must be greater than 0")
+	}
+	return nil
+}
+
+// runPatrol visits the presets of the patrol in order, starting
+// with the preset at the given index, looping until the context is done.
+func (s *servor) runPatrol(ctx context.Context, p patrol, from int) {
+	dwell := time.Duration(p.Dwell * float64(time.Second))
+	for i := from % len(p.Presets); ; i = (i + 1) % len(p.Presets) {
+		// The patrol waits at its current preset while the system is paused.
+		for s.system.paused() {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+		}
+		s.mu.Lock()
+		if ctx.Err() != nil {
+			s.mu.Unlock()
+			return
+		}
+		s.progress(i, 0)
+		s.position = s.presets[p.Presets[i]]
+		if err := s.set(); err != nil {
+			level.Error(s.logger).Log("msg", "failed to patrol", "err", err)
+		}
+		s.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(dwell):
+		}
+	}
+}
+
+// easings maps the names of easing curves to functions that
+// map the fraction of time elapsed to the fraction of distance moved.
+var easings = map[string]func(float64) float64{
+	"":       func(t float64) float64 { return t },
+	"linear": func(t float64) float64 { return t },
+	"ease-in": func(t float64) float64 {
+		return t * t
+	},
+	"ease-out": func(t float64) float64 {
+		return t * (2 - t)
+	},
+	"ease-in-out": func(t float64) float64 {
+		if t < 0.5 {
+			return 2 * t * t
+		}
+		return -1 + (4-2*t)*t
+	},
+}
+
+// easingPeaks maps the names of easing curves to their steepest slope,
+// i.e. how much faster than on average a move along the curve is at
+// its fastest.
+var easingPeaks = map[string]float64{
+	"":            1,
+	"linear":      1,
+	"ease-in":     2,
+	"ease-out":    2,
+	"ease-in-out": 2,
+}
+
+// glideDuration returns the number of seconds that a move over the
+// given distance takes along the given easing curve, so that it
+// never exceeds the given speed in units per second.
+// A speed of 0 moves immediately.
+func glideDuration(distance, speed float64, easing string) float64 {
+	if speed <= 0 {
+		return 0
+	}
+	return math.Abs(distance) / speed * easingPeaks[easing]
+}
+
+// glide moves the servo from its current position to the target
+// at no more than the given speed in units per second along the given
+// easing curve. It returns once the target is reached or the
+// context is done. A speed of 0 moves to the target immediately.
+func (s *servor) glide(ctx context.Context, target, speed float64, easing string) {
+	ease := easings[easing]
+	var start, duration float64
+	var started bool
+	s.frames(ctx, func(elapsed time.Duration) bool {
+		if !started {
+			started = true
+			start = s.position
+			s.target = &target
+			duration = glideDuration(target-start, speed, easing)
+		}
+		f := 1.0
+		if elapsed.Seconds() < duration {
+			f = elapsed.Seconds() / duration
+		}
+		s.position = start + (target-start)*ease(f)
+		if err := s.set(); err != nil {
+			level.Error(s.logger).Log("msg", "failed to move", "err", err)
+		}
+		if f >= 1 {
+			s.target = nil
+		}
+		return f >= 1
+	})
+}
+
+// leg is a move to a preset as part of a tour.
+type leg struct {
+	Preset string `json:"preset"`
+	// Speed is the maximum speed of the move in units per second;
+	// 0 moves to the preset immediately.
+	Speed  float64 `json:"speed,omitempty"`
+	Easing string  `json:"easing,omitempty"`
+	// Dwell is the number of seconds to stay at the preset.
+	Dwell float64 `json:"dwell,omitempty"`
+}
+
+// tour is an ordered sequence of moves to presets.
+type tour []leg
+
+func (t tour) validate(presets map[string]float64) error {
+	if len(t) == 0 {
+		return errors.New("tour must include at least one leg")
+	}
+	for i, l := range t {
+		if _, ok := presets[l.Preset]; !ok {
+			return fmt.Errorf("leg %d: unknown preset %q", i, l.Preset)
+		}
+		if l.Speed < 0 {
+			return fmt.Errorf("leg %d: speed must not be negative", i)
+		}
+		if _, ok := easings[l.Easing]; !ok {
+			return fmt.Errorf("leg %d: unknown easing %q", i, l.Easing)
+		}
+		if l.Dwell < 0 {
+			return fmt.Errorf("leg %d: dwell must not be negative", i)
+		}
+	}
+	return nil
+}
+
+// runTour moves through the legs of the tour once,
+// starting with the leg at the given index.
+func (s *servor) runTour(ctx context.Context, t tour, from int) {
+	for i := from; i < len(t); i++ {
+		l := t[i]
+		s.mu.Lock()
+		if ctx.Err() != nil {
+			s.mu.Unlock()
+			return
+		}
+		s.progress(i, 0)
+		target := s.presets[l.Preset]
+		s.mu.Unlock()
+		s.glide(ctx, target, l.Speed, l.Easing)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(l.Dwell * float64(time.Second))):
+		}
+	}
+}
+
+// keyframe is a position that a sequence reaches at a given time.
+type keyframe struct {
+	// Time is the number of seconds since the start of the sequence.
+	Time     float64 `json:"time"`
+	Position float64 `json:"position"`
+	// Easing is the curve of the move from the previous keyframe.
+	Easing string `json:"easing,omitempty"`
+}
+
+// sequence is a timeline of keyframes, ordered by time.
+type sequence []keyframe
+
+func (sq sequence) validate(min, max float64) error {
+	if len(sq) == 0 {
+		return errors.New("sequence must include at least one keyframe")
+	}
+	for i, k := range sq {
+		if k.Time < 0 {
+			return fmt.Errorf("keyframe %d: time must not be negative", i)
+		}
+		if i > 0 && k.Time <= sq[i-1].Time {
+			return fmt.Errorf("keyframe %d: time must be later than that of the previous keyframe", i)
+		}
+		if k.Position < min || k.Position > max {
+			return fmt.Errorf("keyframe %d: position must be between min and max; got %f", i, k.Position)
+		}
+		if _, ok := easings[k.Easing]; !ok {
+			return fmt.Errorf("keyframe %d: unknown easing %q", i, k.Easing)
+		}
+	}
+	return nil
+}
+
+// runSequence plays the sequence once, starting the given number of
+// seconds into it. The move to the first keyframe starts from the
+// position of the servo at time 0.
+func (s *servor) runSequence(ctx context.Context, sq sequence, offset float64) {
+	var from keyframe
+	var started bool
+	var i int
+	s.frames(ctx, func(elapsed time.Duration) bool {
+		if !started {
+			started = true
+			from = keyframe{Position: s.position}
+		}
+		t := offset + elapsed.Seconds()
+		s.progress(0, t)
+		for i < len(sq) && sq[i].Time <= t {
+			from = sq[i]
+			i++
+		}
+		s.position = from.Position
+		if i < len(sq) {
+			to := sq[i]
+			f := (t - from.Time) / (to.Time - from.Time)
+			s.position += (to.Position - from.Position) * easings[to.Easing](f)
+		}
+		if err := s.set(); err != nil {
+			level.Error(s.logger).Log("msg", "failed to play sequence", "err", err)
+		}
+		return i == len(sq)
+	})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEasings(t *testing.T) {
+	const steps = 10000
+	for name, ease := range easings {
+		if got := ease(0); got != 0 {
+			t.Errorf("%q: expected to start at 0; got %f", name, got)
+		}
+		if got := ease(1); math.Abs(got-1) > 1e-9 {
+			t.Errorf("%q: expected to end at 1; got %f", name, got)
+		}
+		peak, ok := easingPeaks[name]
+		if !ok {
+			t.Errorf("%q: expected a peak slope", name)
+			continue
+		}
+		var steepest float64
+		for i := 0; i < steps; i++ {
+			from, to := ease(float64(i)/steps), ease(float64(i+1)/steps)
+			if to < from {
+				t.Errorf("%q: expected to never move backwards; moved from %f to %f", name, from, to)
+				break
+			}
+			steepest = math.Max(steepest, (to-from)*steps)
+		}
+		if math.Abs(steepest-peak) > 1e-3 {
+			t.Errorf("%q: expected a peak slope of %f; got %f", name, peak, steepest)
+		}
+	}
+}
+
+func TestGlideDuration(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		distance float64
+		speed    float64
+		easing   string
+		expected float64
+	}{
+		{name: "immediate", distance: 0.5, speed: 0, easing: "ease-in-out", expected: 0},
+		{name: "default", distance: 0.5, speed: 0.1, easing: "", expected: 5},
+		{name: "linear", distance: 0.5, speed: 0.1, easing: "linear", expected: 5},
+		{name: "backwards", distance: -0.5, speed: 0.1, easing: "linear", expected: 5},
+		{name: "ease-in", distance: 0.5, speed: 0.1, easing: "ease-in", expected: 10},
+		{name: "ease-out", distance: 0.5, speed: 0.1, easing: "ease-out", expected: 10},
+		{name: "ease-in-out", distance: 0.5, speed: 0.1, easing: "ease-in-out", expected: 10},
+	} {
+		if got := glideDuration(tc.distance, tc.speed, tc.easing); math.Abs(got-tc.expected) > 1e-9 {
+			t.Errorf("%s: expected %f seconds; got %f", tc.name, tc.expected, got)
+		}
+	}
+}
+
+func TestGlidePeakSpeed(t *testing.T) {
+	const distance, speed = 0.8, 0.2
+	for name, ease := range easings {
+		duration := glideDuration(distance, speed, name)
+		frames := int(duration / frameInterval.Seconds())
+		var fastest float64
+		for i := 0; i < frames; i++ {
+			from := distance * ease(float64(i)/float64(frames))
+			to := distance * ease(float64(i+1)/float64(frames))
+			fastest = math.Max(fastest, (to-from)/frameInterval.Seconds())
+		}
+		if fastest > speed*(1+1e-9) {
+			t.Errorf("%q: expected to move at no more than %f units per second; got %f", name, speed, fastest)
+		}
+		if fastest < speed*0.99 {
+			t.Errorf("%q: expected to reach %f units per second; got %f", name, speed, fastest)
+		}
+	}
+}
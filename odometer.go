@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// odometerSaveInterval is how often the odometers are saved,
+// bounding the travel that is lost if servor crashes.
+const odometerSaveInterval = time.Minute
+
+// maintenanceConfig configures when a servo is due for maintenance,
+// e.g. to replace worn gears before they strip.
+type maintenanceConfig struct {
+	// Travel is the distance in units of the position after
+	// which the servo is due; 0 means no limit.
+	Travel float64 `json:"travel,omitempty"`
+	// Cycles is the number of cycles after which the servo is due;
+	// 0 means no limit.
+	Cycles uint64 `json:"cycles,omitempty"`
+}
+
+func (c *maintenanceConfig) validate() error {
+	if c.Travel < 0 {
+		return fmt.Errorf("travel must not be negative; got %f", c.Travel)
+	}
+	if c.Travel == 0 && c.Cycles == 0 {
+		return errors.New("at least one of travel and cycles must be set")
+	}
+	return nil
+}
+
+// odometer is the cumulative usage of a servo.
+type odometer struct {
+	// Travel is the total distance moved in units of the position.
+	Travel float64 `json:"travel"`
+	// Cycles is the number of times the servo reversed its direction,
+	// which is what wears gears most.
+	Cycles uint64 `json:"cycles"`
+	// TravelSinceService and CyclesSinceService count the
+	// usage since the servo was last serviced.
+	TravelSinceService float64 `json:"travelSinceService"`
+	CyclesSinceService uint64  `json:"cyclesSinceService"`
+	// Serviced is when the servo was last serviced, if ever.
+	Serviced *time.Time `json:"serviced,omitempty"`
+}
+
+// odometerStatus is the odometer of a servo and whether it is due for maintenance.
+type odometerStatus struct {
+	odometer
+	Maintenance    *maintenanceConfig `json:"maintenance,omitempty"`
+	MaintenanceDue bool               `json:"maintenanceDue"`
+}
+
+// drive records a move of the servo by the given distance.
+// The caller must hold the lock.
+func (s *servor) drive(distance float64, reversed bool) {
+	distance = math.Abs(distance)
+	s.odometer.Travel += distance
+	s.odometer.TravelSinceService += distance
+	travelTotal.WithLabelValues(s.name).Add(distance)
+	if reversed {
+		s.odometer.Cycles++
+		s.odometer.CyclesSinceService++
+		cyclesTotal.WithLabelValues(s.name).Inc()
+	}
+	if s.maintenanceDue() && !s.maintenanceWarned {
+		s.maintenanceWarned = true
+		maintenanceDue.WithLabelValues(s.name).Set(1)
+		level.Warn(s.logger).Log("msg", "servo is due for maintenance", "travel", s.odometer.TravelSinceService, "cycles", s.odometer.CyclesSinceService)
+		s.events.publish(s.event(eventMaintenanceDue))
+	}
+}
+
+// maintenanceDue returns whether the usage since the last service
+// exceeded a maintenance threshold.
+// The caller must hold the lock.
+func (s *servor) maintenanceDue() bool {
+	m := s.maintenance
+	if m == nil {
+		return false
+	}
+	return (m.Travel != 0 && s.odometer.TravelSinceService >= m.Travel) || (m.Cycles != 0 && s.odometer.CyclesSinceService >= m.Cycles)
+}
+
+// service records that the servo was serviced.
+// The caller must hold the lock.
+func (s *servor) service() {
+	now := time.Now()
+	s.odometer.TravelSinceService = 0
+	s.odometer.CyclesSinceService = 0
+	s.odometer.Serviced = &now
+	s.maintenanceWarned = false
+	maintenanceDue.WithLabelValues(s.name).Set(0)
+	level.Info(s.logger).Log("msg", "servo was serviced", "travel", s.odometer.Travel, "cycles", s.odometer.Cycles)
+}
+
+// restore sets the odometer, e.g. as saved by a previous run.
+// The caller must hold the lock unless the servor is not yet in use.
+func (s *servor) restore(o odometer) {
+	s.odometer = o
+	travelTotal.WithLabelValues(s.name).Add(o.Travel)
+	cyclesTotal.WithLabelValues(s.name).Add(float64(o.Cycles))
+	if s.maintenanceDue() {
+		// Warn again, since the warning may have gone unnoticed.
+		s.maintenanceWarned = true
+		maintenanceDue.WithLabelValues(s.name).Set(1)
+		level.Warn(s.logger).Log("msg", "servo is due for maintenance", "travel", o.TravelSinceService, "cycles", o.CyclesSinceService)
+	}
+}
+
+// odometerStatus returns the odometer of the servo.
+// The caller must hold the lock.
+func (s *servor) odometerStatus() odometerStatus {
+	return odometerStatus{odometer: s.odometer, Maintenance: s.maintenance, MaintenanceDue: s.maintenanceDue()}
+}
+
+// writeOdometer responds with the odometer of the servo.
+// The caller must hold the lock.
+func (s *servor) writeOdometer(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.odometerStatus()); err != nil {
+		level.Error(s.logger).Log("err", err)
+	}
+}
+
+// odometerFile persists the odometers of the servos in a JSON
+// file that maps the names of servos to their odometers.
+type odometerFile struct {
+	path   string
+	servos *servos
+	logger log.Logger
+}
+
+// load restores the odometers from the file, if it exists.
+// Servos that are not in the file start from zero.
+func (f *odometerFile) load() error {
+	buf, err := ioutil.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read odometer file: %v", err)
+	}
+	odometers := make(map[string]odometer)
+	if err := json.Unmarshal(buf, &odometers); err != nil {
+		return fmt.Errorf("failed to parse odometer file: %v", err)
+	}
+	for _, s := range f.servos.list {
+		if o, ok := odometers[s.name]; ok {
+			s.mu.Lock()
+			s.restore(o)
+			s.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+// save writes the odometers to the file. The file is replaced
+// atomically, so that a crash cannot leave it truncated.
+func (f *odometerFile) save() error {
+	odometers := make(map[string]odometer, len(f.servos.list))
+	for _, s := range f.servos.list {
+		s.mu.Lock()
+		odometers[s.name] = s.odometer
+		s.mu.Unlock()
+	}
+	buf, err := json.MarshalIndent(odometers, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(f.path), filepath.Base(f.path)+".")
+	if err != nil {
+		return fmt.Errorf("failed to save odometers: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save odometers: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save odometers: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		return fmt.Errorf("failed to save odometers: %v", err)
+	}
+	return nil
+}
+
+// run saves the odometers periodically and once more when the context is done.
+func (f *odometerFile) run(ctx context.Context) error {
+	t := time.NewTicker(odometerSaveInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return f.save()
+		case <-t.C:
+			if err := f.save(); err != nil {
+				level.Warn(f.logger).Log("msg", "failed to save odometers", "err", err)
+			}
+		}
+	}
+}
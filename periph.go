@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"periph.io/x/conn/v3/driver/driverreg"
+	"periph.io/x/conn/v3/gpio"
+	"periph.io/x/conn/v3/gpio/gpioreg"
+	"periph.io/x/conn/v3/physic"
+	// Register only the drivers of the processors with hardware PWM
+	// rather than all of periph.io's host drivers, which include the
+	// cgo FTDI driver.
+	_ "periph.io/x/host/v3/allwinner"
+	_ "periph.io/x/host/v3/bcm283x"
+	_ "periph.io/x/host/v3/rpi"
+	_ "periph.io/x/host/v3/sysfs"
+)
+
+// periphPWM drives servos with the hardware PWM of the pins that
+// periph.io supports, e.g. the PWM peripheral of a Raspberry Pi or
+// the PWM pins of Allwinner boards, by accessing the peripherals
+// directly, so neither a daemon nor the kernel's PWM interface is
+// needed. Pins are given by their GPIO number.
+type periphPWM struct {
+	frequency physic.Frequency
+	mu        sync.Mutex
+	// pins are the pins that were driven, so that they can be halted.
+	pins map[int]gpio.PinIO
+}
+
+func newPeriph(frequency float64) (*periphPWM, error) {
+	// Initializing periph.io twice returns the state of the first time.
+	if _, err := driverreg.Init(); err != nil {
+		return nil, fmt.Errorf("failed to initialize periph.io: %v", err)
+	}
+	return &periphPWM{frequency: physic.Frequency(frequency * float64(physic.Hertz)), pins: make(map[int]gpio.PinIO)}, nil
+}
+
+// Set implements driver.
+func (p *periphPWM) Set(pin int, value float64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	gp, ok := p.pins[pin]
+	if !ok {
+		var err error
+		if gp, err = periphPin(pin); err != nil {
+			return err
+		}
+		p.pins[pin] = gp
+	}
+	if err := gp.PWM(gpio.Duty(value*float64(gpio.DutyMax)), p.frequency); err != nil {
+		return fmt.Errorf("failed to drive GPIO %d with hardware PWM: %v", pin, err)
+	}
+	return nil
+}
+
+// periphPin returns the pin of periph.io with the given GPIO number.
+func periphPin(pin int) (gpio.PinIO, error) {
+	gp := gpioreg.ByName(strconv.Itoa(pin))
+	if gp == nil {
+		return nil, fmt.Errorf("periph.io does not know GPIO %d on this board", pin)
+	}
+	return gp, nil
+}
+
+// Close implements driver.
+func (p *periphPWM) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var err error
+	for _, gp := range p.pins {
+		if e := gp.Halt(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+const piBlasterPath = "/dev/pi-blaster"
+
+// piBlaster writes PWM values to the pi-blaster FIFO.
+// The FIFO is kept open between writes so that a restart of
+// pi-blaster, which recreates the FIFO, can be detected.
+type piBlaster struct {
+	path string
+	f    *os.File
+}
+
+func newPiBlaster(path string) *piBlaster {
+	return &piBlaster{path: path}
+}
+
+// Set implements driver.
+// If the write fails, the FIFO is closed and will be
+// re-opened on the next call.
+func (p *piBlaster) Set(pin int, value float64) error {
+	if p.f == nil {
+		// Opening the FIFO without blocking fails immediately
+		// rather than hanging when pi-blaster is not running.
+		f, err := os.OpenFile(p.path, os.O_WRONLY|os.O_APPEND|syscall.O_NONBLOCK, 0644)
+		if err != nil {
+			return err
+		}
+		p.f = f
+	}
+	if _, err := fmt.Fprintf(p.f, "%d=%f\n", pin, value); err != nil {
+		p.Close()
+		return err
+	}
+	return nil
+}
+
+// stale reports whether the FIFO held open is no longer the one
+// at the configured path, e.g. because pi-blaster was restarted.
+func (p *piBlaster) stale() (bool, error) {
+	if p.f == nil {
+		return false, nil
+	}
+	held, err := p.f.Stat()
+	if err != nil {
+		return true, err
+	}
+	current, err := os.Stat(p.path)
+	if err != nil {
+		return true, err
+	}
+	return !os.SameFile(held, current), nil
+}
+
+// Close implements driver.
+func (p *piBlaster) Close() error {
+	if p.f == nil {
+		return nil
+	}
+	err := p.f.Close()
+	p.f = nil
+	return err
+}
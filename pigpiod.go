@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	// pigpioServo is the pigpio socket command that sets
+	// the servo pulse width of a GPIO in microseconds.
+	pigpioServo = 8
+	// pigpioTimeout bounds each exchange with the daemon.
+	pigpioTimeout = time.Second
+)
+
+// pigpiod drives servos through the socket interface of the
+// pigpio daemon, which generates the pulses with DMA.
+type pigpiod struct {
+	addr      string
+	frequency float64
+	conn      net.Conn
+}
+
+func newPigpiod(addr string, frequency float64) *pigpiod {
+	return &pigpiod{addr: addr, frequency: frequency}
+}
+
+// Set implements driver.
+// If the exchange fails, the connection is closed and will be
+// re-established on the next call.
+func (p *pigpiod) Set(pin int, value float64) error {
+	us := pulseWidth(value, p.frequency) / time.Microsecond
+	if us < 500 || us > 2500 {
+		return fmt.Errorf("pulse width must be between 500us and 2500us for pigpiod; got %dus", us)
+	}
+	if p.conn == nil {
+		conn, err := net.DialTimeout("tcp", p.addr, pigpioTimeout)
+		if err != nil {
+			return err
+		}
+		p.conn = conn
+	}
+	if err := p.command(pigpioServo, uint32(pin), uint32(us)); err != nil {
+		p.Close()
+		return err
+	}
+	return nil
+}
+
+// command sends a command to the daemon and checks its result.
+// Commands and responses are four little-endian 32-bit words:
+// the command, two parameters, and the result.
+func (p *pigpiod) command(cmd, p1, p2 uint32) error {
+	buf := make([]byte, 16)
+	binary.LittleEndian.PutUint32(buf[0:], cmd)
+	binary.LittleEndian.PutUint32(buf[4:], p1)
+	binary.LittleEndian.PutUint32(buf[8:], p2)
+	if err := p.conn.SetDeadline(time.Now().Add(pigpioTimeout)); err != nil {
+		return err
+	}
+	if _, err := p.conn.Write(buf); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(p.conn, buf); err != nil {
+		return err
+	}
+	if res := int32(binary.LittleEndian.Uint32(buf[12:])); res < 0 {
+		return fmt.Errorf("pigpiod returned error %d", res)
+	}
+	return nil
+}
+
+// Close implements driver.
+func (p *pigpiod) Close() error {
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.conn = nil
+	return err
+}
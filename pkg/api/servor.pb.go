@@ -0,0 +1,896 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: servor.proto
+
+package api
+
+import (
+	context "context"
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	_ "google.golang.org/genproto/googleapis/api/annotations"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+type JogRequest struct {
+	// Servo is the name of the servo; the default servo is used if empty.
+	Servo string `protobuf:"bytes,1,opt,name=servo,proto3" json:"servo,omitempty"`
+	// Types that are valid to be assigned to Command:
+	//	*JogRequest_Target
+	//	*JogRequest_Velocity
+	Command              isJogRequest_Command `protobuf_oneof:"command"`
+	XXX_NoUnkeyedLiteral struct{}             `json:"-"`
+	XXX_unrecognized     []byte               `json:"-"`
+	XXX_sizecache        int32                `json:"-"`
+}
+
+func (m *JogRequest) Reset()         { *m = JogRequest{} }
+func (m *JogRequest) String() string { return proto.CompactTextString(m) }
+func (*JogRequest) ProtoMessage()    {}
+func (*JogRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_400e7b86d242d1f4, []int{0}
+}
+
+func (m *JogRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_JogRequest.Unmarshal(m, b)
+}
+func (m *JogRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_JogRequest.Marshal(b, m, deterministic)
+}
+func (m *JogRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_JogRequest.Merge(m, src)
+}
+func (m *JogRequest) XXX_Size() int {
+	return xxx_messageInfo_JogRequest.Size(m)
+}
+func (m *JogRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_JogRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_JogRequest proto.InternalMessageInfo
+
+func (m *JogRequest) GetServo() string {
+	if m != nil {
+		return m.Servo
+	}
+	return ""
+}
+
+type isJogRequest_Command interface {
+	isJogRequest_Command()
+}
+
+type JogRequest_Target struct {
+	Target float64 `protobuf:"fixed64,2,opt,name=target,proto3,oneof"`
+}
+
+type JogRequest_Velocity struct {
+	Velocity float64 `protobuf:"fixed64,3,opt,name=velocity,proto3,oneof"`
+}
+
+func (*JogRequest_Target) isJogRequest_Command() {}
+
+func (*JogRequest_Velocity) isJogRequest_Command() {}
+
+func (m *JogRequest) GetCommand() isJogRequest_Command {
+	if m != nil {
+		return m.Command
+	}
+	return nil
+}
+
+func (m *JogRequest) GetTarget() float64 {
+	if x, ok := m.GetCommand().(*JogRequest_Target); ok {
+		return x.Target
+	}
+	return 0
+}
+
+func (m *JogRequest) GetVelocity() float64 {
+	if x, ok := m.GetCommand().(*JogRequest_Velocity); ok {
+		return x.Velocity
+	}
+	return 0
+}
+
+// XXX_OneofWrappers is for the internal use of the proto package.
+func (*JogRequest) XXX_OneofWrappers() []interface{} {
+	return []interface{}{
+		(*JogRequest_Target)(nil),
+		(*JogRequest_Velocity)(nil),
+	}
+}
+
+type ServoRequest struct {
+	// Servo is the name of the servo; the default servo is used if empty.
+	Servo                string   `protobuf:"bytes,1,opt,name=servo,proto3" json:"servo,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ServoRequest) Reset()         { *m = ServoRequest{} }
+func (m *ServoRequest) String() string { return proto.CompactTextString(m) }
+func (*ServoRequest) ProtoMessage()    {}
+func (*ServoRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_400e7b86d242d1f4, []int{1}
+}
+
+func (m *ServoRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ServoRequest.Unmarshal(m, b)
+}
+func (m *ServoRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ServoRequest.Marshal(b, m, deterministic)
+}
+func (m *ServoRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ServoRequest.Merge(m, src)
+}
+func (m *ServoRequest) XXX_Size() int {
+	return xxx_messageInfo_ServoRequest.Size(m)
+}
+func (m *ServoRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ServoRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ServoRequest proto.InternalMessageInfo
+
+func (m *ServoRequest) GetServo() string {
+	if m != nil {
+		return m.Servo
+	}
+	return ""
+}
+
+type MoveRequest struct {
+	// Servo is the name of the servo; the default servo is used if empty.
+	Servo string `protobuf:"bytes,1,opt,name=servo,proto3" json:"servo,omitempty"`
+	// Target is the position to move to.
+	Target               float64  `protobuf:"fixed64,2,opt,name=target,proto3" json:"target,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *MoveRequest) Reset()         { *m = MoveRequest{} }
+func (m *MoveRequest) String() string { return proto.CompactTextString(m) }
+func (*MoveRequest) ProtoMessage()    {}
+func (*MoveRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_400e7b86d242d1f4, []int{2}
+}
+
+func (m *MoveRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_MoveRequest.Unmarshal(m, b)
+}
+func (m *MoveRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_MoveRequest.Marshal(b, m, deterministic)
+}
+func (m *MoveRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_MoveRequest.Merge(m, src)
+}
+func (m *MoveRequest) XXX_Size() int {
+	return xxx_messageInfo_MoveRequest.Size(m)
+}
+func (m *MoveRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_MoveRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_MoveRequest proto.InternalMessageInfo
+
+func (m *MoveRequest) GetServo() string {
+	if m != nil {
+		return m.Servo
+	}
+	return ""
+}
+
+func (m *MoveRequest) GetTarget() float64 {
+	if m != nil {
+		return m.Target
+	}
+	return 0
+}
+
+type StepRequest struct {
+	// Servo is the name of the servo; the default servo is used if empty.
+	Servo string `protobuf:"bytes,1,opt,name=servo,proto3" json:"servo,omitempty"`
+	// Count is the number of steps to move; it defaults to 1.
+	Count                int32    `protobuf:"varint,2,opt,name=count,proto3" json:"count,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *StepRequest) Reset()         { *m = StepRequest{} }
+func (m *StepRequest) String() string { return proto.CompactTextString(m) }
+func (*StepRequest) ProtoMessage()    {}
+func (*StepRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_400e7b86d242d1f4, []int{3}
+}
+
+func (m *StepRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_StepRequest.Unmarshal(m, b)
+}
+func (m *StepRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_StepRequest.Marshal(b, m, deterministic)
+}
+func (m *StepRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_StepRequest.Merge(m, src)
+}
+func (m *StepRequest) XXX_Size() int {
+	return xxx_messageInfo_StepRequest.Size(m)
+}
+func (m *StepRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_StepRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_StepRequest proto.InternalMessageInfo
+
+func (m *StepRequest) GetServo() string {
+	if m != nil {
+		return m.Servo
+	}
+	return ""
+}
+
+func (m *StepRequest) GetCount() int32 {
+	if m != nil {
+		return m.Count
+	}
+	return 0
+}
+
+type ListServosRequest struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListServosRequest) Reset()         { *m = ListServosRequest{} }
+func (m *ListServosRequest) String() string { return proto.CompactTextString(m) }
+func (*ListServosRequest) ProtoMessage()    {}
+func (*ListServosRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_400e7b86d242d1f4, []int{4}
+}
+
+func (m *ListServosRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListServosRequest.Unmarshal(m, b)
+}
+func (m *ListServosRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListServosRequest.Marshal(b, m, deterministic)
+}
+func (m *ListServosRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListServosRequest.Merge(m, src)
+}
+func (m *ListServosRequest) XXX_Size() int {
+	return xxx_messageInfo_ListServosRequest.Size(m)
+}
+func (m *ListServosRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListServosRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListServosRequest proto.InternalMessageInfo
+
+type ListServosResponse struct {
+	Servos               []*State `protobuf:"bytes,1,rep,name=servos,proto3" json:"servos,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListServosResponse) Reset()         { *m = ListServosResponse{} }
+func (m *ListServosResponse) String() string { return proto.CompactTextString(m) }
+func (*ListServosResponse) ProtoMessage()    {}
+func (*ListServosResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_400e7b86d242d1f4, []int{5}
+}
+
+func (m *ListServosResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListServosResponse.Unmarshal(m, b)
+}
+func (m *ListServosResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListServosResponse.Marshal(b, m, deterministic)
+}
+func (m *ListServosResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListServosResponse.Merge(m, src)
+}
+func (m *ListServosResponse) XXX_Size() int {
+	return xxx_messageInfo_ListServosResponse.Size(m)
+}
+func (m *ListServosResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListServosResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListServosResponse proto.InternalMessageInfo
+
+func (m *ListServosResponse) GetServos() []*State {
+	if m != nil {
+		return m.Servos
+	}
+	return nil
+}
+
+type State struct {
+	// Servo is the name of the servo.
+	Servo string `protobuf:"bytes,1,opt,name=servo,proto3" json:"servo,omitempty"`
+	// Position is the commanded position of the servo.
+	Position float64 `protobuf:"fixed64,2,opt,name=position,proto3" json:"position,omitempty"`
+	Min      float64 `protobuf:"fixed64,3,opt,name=min,proto3" json:"min,omitempty"`
+	Max      float64 `protobuf:"fixed64,4,opt,name=max,proto3" json:"max,omitempty"`
+	// Measured is the position last read back from the servo;
+	// it is only set for drivers that support feedback.
+	Measured *Measurement `protobuf:"bytes,5,opt,name=measured,proto3" json:"measured,omitempty"`
+	// Target is where the servo is heading, i.e. the end of the
+	// glide in progress, if any, or else its position.
+	Target float64 `protobuf:"fixed64,6,opt,name=target,proto3" json:"target,omitempty"`
+	// Current is where the servo is, i.e. the measured position,
+	// if known, or else the commanded position.
+	Current              float64  `protobuf:"fixed64,7,opt,name=current,proto3" json:"current,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *State) Reset()         { *m = State{} }
+func (m *State) String() string { return proto.CompactTextString(m) }
+func (*State) ProtoMessage()    {}
+func (*State) Descriptor() ([]byte, []int) {
+	return fileDescriptor_400e7b86d242d1f4, []int{6}
+}
+
+func (m *State) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_State.Unmarshal(m, b)
+}
+func (m *State) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_State.Marshal(b, m, deterministic)
+}
+func (m *State) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_State.Merge(m, src)
+}
+func (m *State) XXX_Size() int {
+	return xxx_messageInfo_State.Size(m)
+}
+func (m *State) XXX_DiscardUnknown() {
+	xxx_messageInfo_State.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_State proto.InternalMessageInfo
+
+func (m *State) GetServo() string {
+	if m != nil {
+		return m.Servo
+	}
+	return ""
+}
+
+func (m *State) GetPosition() float64 {
+	if m != nil {
+		return m.Position
+	}
+	return 0
+}
+
+func (m *State) GetMin() float64 {
+	if m != nil {
+		return m.Min
+	}
+	return 0
+}
+
+func (m *State) GetMax() float64 {
+	if m != nil {
+		return m.Max
+	}
+	return 0
+}
+
+func (m *State) GetMeasured() *Measurement {
+	if m != nil {
+		return m.Measured
+	}
+	return nil
+}
+
+func (m *State) GetTarget() float64 {
+	if m != nil {
+		return m.Target
+	}
+	return 0
+}
+
+func (m *State) GetCurrent() float64 {
+	if m != nil {
+		return m.Current
+	}
+	return 0
+}
+
+type Measurement struct {
+	Position             float64  `protobuf:"fixed64,1,opt,name=position,proto3" json:"position,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Measurement) Reset()         { *m = Measurement{} }
+func (m *Measurement) String() string { return proto.CompactTextString(m) }
+func (*Measurement) ProtoMessage()    {}
+func (*Measurement) Descriptor() ([]byte, []int) {
+	return fileDescriptor_400e7b86d242d1f4, []int{7}
+}
+
+func (m *Measurement) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Measurement.Unmarshal(m, b)
+}
+func (m *Measurement) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Measurement.Marshal(b, m, deterministic)
+}
+func (m *Measurement) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Measurement.Merge(m, src)
+}
+func (m *Measurement) XXX_Size() int {
+	return xxx_messageInfo_Measurement.Size(m)
+}
+func (m *Measurement) XXX_DiscardUnknown() {
+	xxx_messageInfo_Measurement.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Measurement proto.InternalMessageInfo
+
+func (m *Measurement) GetPosition() float64 {
+	if m != nil {
+		return m.Position
+	}
+	return 0
+}
+
+func init() {
+	proto.RegisterType((*JogRequest)(nil), "servor.JogRequest")
+	proto.RegisterType((*ServoRequest)(nil), "servor.ServoRequest")
+	proto.RegisterType((*MoveRequest)(nil), "servor.MoveRequest")
+	proto.RegisterType((*StepRequest)(nil), "servor.StepRequest")
+	proto.RegisterType((*ListServosRequest)(nil), "servor.ListServosRequest")
+	proto.RegisterType((*ListServosResponse)(nil), "servor.ListServosResponse")
+	proto.RegisterType((*State)(nil), "servor.State")
+	proto.RegisterType((*Measurement)(nil), "servor.Measurement")
+}
+
+func init() { proto.RegisterFile("servor.proto", fileDescriptor_400e7b86d242d1f4) }
+
+var fileDescriptor_400e7b86d242d1f4 = []byte{
+	// 528 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xa4, 0x94, 0xc1, 0x6e, 0xd3, 0x4c,
+	0x10, 0xc7, 0xbf, 0x6d, 0x62, 0x27, 0x9d, 0xe4, 0x93, 0x60, 0x1a, 0x81, 0x63, 0x22, 0x11, 0x56,
+	0x20, 0x05, 0x84, 0x6a, 0x08, 0x27, 0xda, 0x5b, 0x39, 0x50, 0x95, 0x14, 0x21, 0xe7, 0x80, 0xc4,
+	0xcd, 0xa4, 0xa3, 0x60, 0xa9, 0xde, 0x35, 0xde, 0x4d, 0x54, 0x84, 0xb8, 0xf0, 0x0a, 0xbc, 0x10,
+	0xef, 0xc0, 0x2b, 0x70, 0xe2, 0x29, 0x90, 0x77, 0xed, 0xd8, 0x69, 0x43, 0x54, 0xc4, 0x29, 0x99,
+	0x99, 0xdd, 0xdf, 0xfe, 0xe7, 0x3f, 0x23, 0x43, 0x57, 0x51, 0xb6, 0x94, 0xd9, 0x7e, 0x9a, 0x49,
+	0x2d, 0xd1, 0xb5, 0x91, 0x3f, 0x98, 0x4b, 0x39, 0x3f, 0xa7, 0x20, 0x4a, 0xe3, 0x20, 0x12, 0x42,
+	0xea, 0x48, 0xc7, 0x52, 0x28, 0x7b, 0x8a, 0x13, 0xc0, 0x89, 0x9c, 0x87, 0xf4, 0x71, 0x41, 0x4a,
+	0x63, 0x0f, 0x1c, 0x73, 0xcb, 0x63, 0x43, 0x36, 0xda, 0x0d, 0x6d, 0x80, 0x1e, 0xb8, 0x3a, 0xca,
+	0xe6, 0xa4, 0xbd, 0x9d, 0x21, 0x1b, 0xb1, 0xe3, 0xff, 0xc2, 0x22, 0xc6, 0x01, 0xb4, 0x97, 0x74,
+	0x2e, 0x67, 0xb1, 0xfe, 0xe4, 0x35, 0x8a, 0xda, 0x2a, 0x73, 0xb4, 0x0b, 0xad, 0x99, 0x4c, 0x92,
+	0x48, 0x9c, 0xf1, 0xfb, 0xd0, 0x9d, 0xe6, 0xac, 0xad, 0x0f, 0xf1, 0x43, 0xe8, 0x9c, 0xca, 0x25,
+	0x6d, 0x57, 0x73, 0x6b, 0x5d, 0x4d, 0xa9, 0x85, 0x3f, 0x87, 0xce, 0x54, 0x53, 0xba, 0xfd, 0x72,
+	0x0f, 0x9c, 0x99, 0x5c, 0x08, 0x7b, 0xd7, 0x09, 0x6d, 0xc0, 0xf7, 0xe0, 0xe6, 0x24, 0x56, 0xda,
+	0x28, 0x54, 0x05, 0x80, 0x1f, 0x02, 0xd6, 0x93, 0x2a, 0x95, 0x42, 0x11, 0x3e, 0x00, 0xeb, 0xab,
+	0xf2, 0xd8, 0xb0, 0x31, 0xea, 0x8c, 0xff, 0xdf, 0x2f, 0x4c, 0x9f, 0xea, 0x48, 0x53, 0x58, 0x14,
+	0xf9, 0x77, 0x06, 0x8e, 0xc9, 0xfc, 0x41, 0x87, 0x0f, 0xed, 0x54, 0xaa, 0x38, 0x9f, 0x44, 0xd1,
+	0xc6, 0x2a, 0xc6, 0x1b, 0xd0, 0x48, 0x62, 0x61, 0xfd, 0x0c, 0xf3, 0xbf, 0x26, 0x13, 0x5d, 0x78,
+	0xcd, 0x22, 0x13, 0x5d, 0x60, 0x00, 0xed, 0x84, 0x22, 0xb5, 0xc8, 0xe8, 0xcc, 0x73, 0x86, 0x6c,
+	0xd4, 0x19, 0xef, 0x95, 0x42, 0x4e, 0x6d, 0x3e, 0x21, 0xa1, 0xc3, 0xd5, 0xa1, 0x9a, 0x6b, 0x6e,
+	0xdd, 0x35, 0xf4, 0xa0, 0x35, 0x5b, 0x64, 0x19, 0x09, 0xed, 0xb5, 0x4c, 0xa1, 0x0c, 0xf9, 0x43,
+	0xe8, 0xd4, 0x50, 0x6b, 0x8a, 0xd9, 0xba, 0xe2, 0xf1, 0xaf, 0x26, 0xb8, 0xc6, 0xa7, 0x0c, 0x1f,
+	0x43, 0xe3, 0x44, 0xce, 0x11, 0x4b, 0x35, 0xd5, 0x72, 0xf9, 0xeb, 0x56, 0x8d, 0xd8, 0x13, 0x86,
+	0x6f, 0x01, 0x2a, 0x8f, 0xb1, 0x5f, 0x1e, 0xb8, 0x32, 0x0c, 0xdf, 0xdf, 0x54, 0xb2, 0x23, 0xe1,
+	0xf8, 0xf5, 0xc7, 0xcf, 0x6f, 0x3b, 0x5d, 0x84, 0x60, 0xf9, 0x34, 0xb0, 0xfe, 0xe3, 0x2b, 0x68,
+	0xbf, 0x24, 0x5d, 0x4c, 0x60, 0xf5, 0x6e, 0x6d, 0x03, 0x2f, 0xa9, 0xe1, 0xbe, 0x81, 0xf4, 0x10,
+	0x2b, 0x48, 0xf0, 0xd9, 0xfc, 0x7e, 0xc1, 0xd7, 0xd0, 0xcc, 0xd7, 0x12, 0x2b, 0x8b, 0xab, 0x25,
+	0xbd, 0xcc, 0xe1, 0x86, 0x33, 0xe0, 0xb7, 0xaf, 0x72, 0x0e, 0x12, 0xb9, 0xa4, 0x03, 0xf6, 0x28,
+	0xe7, 0xe5, 0x9b, 0x5a, 0xf1, 0x6a, 0x7b, 0xfb, 0x37, 0x3c, 0xa5, 0x29, 0xcd, 0x79, 0x13, 0x68,
+	0x1e, 0xcb, 0xe4, 0x9a, 0x8d, 0xde, 0x35, 0xc0, 0xfe, 0x46, 0xe0, 0x87, 0x9c, 0xf2, 0x06, 0xdc,
+	0x17, 0x24, 0x34, 0x65, 0xd7, 0xe3, 0xdd, 0x33, 0xbc, 0x3b, 0xbc, 0xbf, 0x81, 0x37, 0xb3, 0x9c,
+	0x49, 0xde, 0xaf, 0x4c, 0xff, 0x5d, 0x9f, 0xd2, 0x32, 0x3d, 0x72, 0xde, 0x35, 0xa2, 0x34, 0x7e,
+	0xef, 0x9a, 0xef, 0xd7, 0xb3, 0xdf, 0x03, 0x00, 0xdd, 0xc7, 0xb9, 0xeb, 0xf5, 0x04, 0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// ServorClient is the client API for Servor service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type ServorClient interface {
+	// Jog takes a stream of samples, each of which either moves a servo
+	// to a target position or sets its velocity, and returns the state
+	// of the servo after each sample on the same stream, as well as
+	// whenever a jogged servo moves.
+	Jog(ctx context.Context, opts ...grpc.CallOption) (Servor_JogClient, error)
+	// ListServos returns the state of all servos.
+	ListServos(ctx context.Context, in *ListServosRequest, opts ...grpc.CallOption) (*ListServosResponse, error)
+	// GetState returns the state of a servo.
+	GetState(ctx context.Context, in *ServoRequest, opts ...grpc.CallOption) (*State, error)
+	// Move moves a servo to a target position.
+	Move(ctx context.Context, in *MoveRequest, opts ...grpc.CallOption) (*State, error)
+	// Step moves a servo by a number of steps;
+	// positive counts move left and negative counts move right.
+	Step(ctx context.Context, in *StepRequest, opts ...grpc.CallOption) (*State, error)
+	// Home moves a servo to its home position.
+	Home(ctx context.Context, in *ServoRequest, opts ...grpc.CallOption) (*State, error)
+	// Center moves a servo to the center of its range.
+	Center(ctx context.Context, in *ServoRequest, opts ...grpc.CallOption) (*State, error)
+	// Stop stops any running motion of a servo.
+	Stop(ctx context.Context, in *ServoRequest, opts ...grpc.CallOption) (*State, error)
+}
+
+type servorClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewServorClient(cc *grpc.ClientConn) ServorClient {
+	return &servorClient{cc}
+}
+
+func (c *servorClient) Jog(ctx context.Context, opts ...grpc.CallOption) (Servor_JogClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Servor_serviceDesc.Streams[0], "/servor.Servor/Jog", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &servorJogClient{stream}
+	return x, nil
+}
+
+type Servor_JogClient interface {
+	Send(*JogRequest) error
+	Recv() (*State, error)
+	grpc.ClientStream
+}
+
+type servorJogClient struct {
+	grpc.ClientStream
+}
+
+func (x *servorJogClient) Send(m *JogRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *servorJogClient) Recv() (*State, error) {
+	m := new(State)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *servorClient) ListServos(ctx context.Context, in *ListServosRequest, opts ...grpc.CallOption) (*ListServosResponse, error) {
+	out := new(ListServosResponse)
+	err := c.cc.Invoke(ctx, "/servor.Servor/ListServos", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *servorClient) GetState(ctx context.Context, in *ServoRequest, opts ...grpc.CallOption) (*State, error) {
+	out := new(State)
+	err := c.cc.Invoke(ctx, "/servor.Servor/GetState", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *servorClient) Move(ctx context.Context, in *MoveRequest, opts ...grpc.CallOption) (*State, error) {
+	out := new(State)
+	err := c.cc.Invoke(ctx, "/servor.Servor/Move", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *servorClient) Step(ctx context.Context, in *StepRequest, opts ...grpc.CallOption) (*State, error) {
+	out := new(State)
+	err := c.cc.Invoke(ctx, "/servor.Servor/Step", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *servorClient) Home(ctx context.Context, in *ServoRequest, opts ...grpc.CallOption) (*State, error) {
+	out := new(State)
+	err := c.cc.Invoke(ctx, "/servor.Servor/Home", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *servorClient) Center(ctx context.Context, in *ServoRequest, opts ...grpc.CallOption) (*State, error) {
+	out := new(State)
+	err := c.cc.Invoke(ctx, "/servor.Servor/Center", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *servorClient) Stop(ctx context.Context, in *ServoRequest, opts ...grpc.CallOption) (*State, error) {
+	out := new(State)
+	err := c.cc.Invoke(ctx, "/servor.Servor/Stop", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ServorServer is the server API for Servor service.
+type ServorServer interface {
+	// Jog takes a stream of samples, each of which either moves a servo
+	// to a target position or sets its velocity, and returns the state
+	// of the servo after each sample on the same stream, as well as
+	// whenever a jogged servo moves.
+	Jog(Servor_JogServer) error
+	// ListServos returns the state of all servos.
+	ListServos(context.Context, *ListServosRequest) (*ListServosResponse, error)
+	// GetState returns the state of a servo.
+	GetState(context.Context, *ServoRequest) (*State, error)
+	// Move moves a servo to a target position.
+	Move(context.Context, *MoveRequest) (*State, error)
+	// Step moves a servo by a number of steps;
+	// positive counts move left and negative counts move right.
+	Step(context.Context, *StepRequest) (*State, error)
+	// Home moves a servo to its home position.
+	Home(context.Context, *ServoRequest) (*State, error)
+	// Center moves a servo to the center of its range.
+	Center(context.Context, *ServoRequest) (*State, error)
+	// Stop stops any running motion of a servo.
+	Stop(context.Context, *ServoRequest) (*State, error)
+}
+
+// UnimplementedServorServer can be embedded to have forward compatible implementations.
+type UnimplementedServorServer struct {
+}
+
+func (*UnimplementedServorServer) Jog(srv Servor_JogServer) error {
+	return status.Errorf(codes.Unimplemented, "method Jog not implemented")
+}
+func (*UnimplementedServorServer) ListServos(ctx context.Context, req *ListServosRequest) (*ListServosResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListServos not implemented")
+}
+func (*UnimplementedServorServer) GetState(ctx context.Context, req *ServoRequest) (*State, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetState not implemented")
+}
+func (*UnimplementedServorServer) Move(ctx context.Context, req *MoveRequest) (*State, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Move not implemented")
+}
+func (*UnimplementedServorServer) Step(ctx context.Context, req *StepRequest) (*State, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Step not implemented")
+}
+func (*UnimplementedServorServer) Home(ctx context.Context, req *ServoRequest) (*State, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Home not implemented")
+}
+func (*UnimplementedServorServer) Center(ctx context.Context, req *ServoRequest) (*State, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Center not implemented")
+}
+func (*UnimplementedServorServer) Stop(ctx context.Context, req *ServoRequest) (*State, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Stop not implemented")
+}
+
+func RegisterServorServer(s *grpc.Server, srv ServorServer) {
+	s.RegisterService(&_Servor_serviceDesc, srv)
+}
+
+func _Servor_Jog_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ServorServer).Jog(&servorJogServer{stream})
+}
+
+type Servor_JogServer interface {
+	Send(*State) error
+	Recv() (*JogRequest, error)
+	grpc.ServerStream
+}
+
+type servorJogServer struct {
+	grpc.ServerStream
+}
+
+func (x *servorJogServer) Send(m *State) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *servorJogServer) Recv() (*JogRequest, error) {
+	m := new(JogRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Servor_ListServos_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListServosRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServorServer).ListServos(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/servor.Servor/ListServos",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServorServer).ListServos(ctx, req.(*ListServosRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Servor_GetState_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ServoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServorServer).GetState(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/servor.Servor/GetState",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServorServer).GetState(ctx, req.(*ServoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Servor_Move_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MoveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServorServer).Move(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/servor.Servor/Move",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServorServer).Move(ctx, req.(*MoveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Servor_Step_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StepRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServorServer).Step(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/servor.Servor/Step",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServorServer).Step(ctx, req.(*StepRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Servor_Home_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ServoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServorServer).Home(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/servor.Servor/Home",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServorServer).Home(ctx, req.(*ServoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Servor_Center_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ServoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServorServer).Center(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/servor.Servor/Center",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServorServer).Center(ctx, req.(*ServoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Servor_Stop_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ServoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServorServer).Stop(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/servor.Servor/Stop",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServorServer).Stop(ctx, req.(*ServoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Servor_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "servor.Servor",
+	HandlerType: (*ServorServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListServos",
+			Handler:    _Servor_ListServos_Handler,
+		},
+		{
+			MethodName: "GetState",
+			Handler:    _Servor_GetState_Handler,
+		},
+		{
+			MethodName: "Move",
+			Handler:    _Servor_Move_Handler,
+		},
+		{
+			MethodName: "Step",
+			Handler:    _Servor_Step_Handler,
+		},
+		{
+			MethodName: "Home",
+			Handler:    _Servor_Home_Handler,
+		},
+		{
+			MethodName: "Center",
+			Handler:    _Servor_Center_Handler,
+		},
+		{
+			MethodName: "Stop",
+			Handler:    _Servor_Stop_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Jog",
+			Handler:       _Servor_Jog_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "servor.proto",
+}
@@ -0,0 +1,5 @@
+// Package version holds the version of servor.
+package version
+
+// Version is the version of servor; it is set at build time.
+var Version = "unversioned"
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// sourceName matches the names of the sources that can be configured.
+var sourceName = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+// policyConfig is a precedence rule between the sources of commands,
+// e.g. so that manual input in the UI suspends a scheduler rather than
+// the two fighting over the servo.
+type policyConfig struct {
+	// Source is the source whose commands suspend the other sources.
+	Source string `json:"source"`
+	// Suspends are the sources whose commands are rejected
+	// for the duration after each command of the source.
+	Suspends []string `json:"suspends"`
+	// Duration is how long in seconds the sources are suspended.
+	Duration float64 `json:"duration"`
+	// Servo limits the policy to the named servo.
+	Servo string `json:"servo,omitempty"`
+}
+
+// validate checks the policy given the names of the servos
+// and the sources that can be named.
+func (c *policyConfig) validate(servos, sources map[string]bool) error {
+	if !sources[c.Source] {
+		return fmt.Errorf("unknown source %q", c.Source)
+	}
+	if len(c.Suspends) == 0 {
+		return errors.New("suspends must name at least one source")
+	}
+	for _, s := range c.Suspends {
+		if !sources[s] {
+			return fmt.Errorf("unknown source %q", s)
+		}
+		if s == c.Source {
+			return fmt.Errorf("source %q cannot suspend itself", s)
+		}
+	}
+	if c.Duration <= 0 {
+		return fmt.Errorf("duration must be greater than 0; got %f", c.Duration)
+	}
+	if c.Servo != "" && !servos[c.Servo] {
+		return fmt.Errorf("unknown servo %q", c.Servo)
+	}
+	return nil
+}
+
+// policySources returns the sources that policies can name: the
+// sources of servor, the configured sources, and the tokens.
+func policySources(c *config) map[string]bool {
+	sources := make(map[string]bool)
+	for _, s := range builtinSources {
+		sources[s] = true
+	}
+	for _, s := range c.Sources {
+		sources[s] = true
+	}
+	for _, t := range c.Tokens {
+		sources[tokenSourcePrefix+t.Name] = true
+	}
+	return sources
+}
+
+// policies enforces the configured policies.
+type policies struct {
+	list []policyConfig
+	// sources are the configured sources, which clients
+	// can declare in addition to declaredSources.
+	sources map[string]bool
+}
+
+func newPolicies(c *config) *policies {
+	ps := &policies{list: c.Policies, sources: make(map[string]bool)}
+	for _, s := range c.Sources {
+		ps.sources[s] = true
+	}
+	return ps
+}
+
+// declarable returns the sources that clients can declare.
+// It is safe to call on nil policies.
+func (ps *policies) declarable() []string {
+	sources := append([]string(nil), declaredSources...)
+	if ps == nil {
+		return sources
+	}
+	custom := make([]string, 0, len(ps.sources))
+	for s := range ps.sources {
+		custom = append(custom, s)
+	}
+	sort.Strings(custom)
+	return append(sources, custom...)
+}
+
+// suspension records that commands of a source are rejected
+// until the given time because of a command of another source.
+type suspension struct {
+	Until time.Time `json:"until"`
+	// By is the source whose command suspended the source.
+	By string `json:"by"`
+}
+
+// suspendedError is returned for commands of a suspended source.
+type suspendedError struct {
+	source string
+	suspension
+}
+
+func (e *suspendedError) Error() string {
+	return fmt.Sprintf("commands from %s are suspended for %s after a command from %s", e.source, time.Until(e.Until).Round(time.Second), e.By)
+}
+
+// suspended returns an error if commands of the source are suspended.
+// The caller must hold the lock.
+func (s *servor) suspended(source string) error {
+	sp, ok := s.suspensions[source]
+	if !ok {
+		return nil
+	}
+	if !time.Now().Before(sp.Until) {
+		delete(s.suspensions, source)
+		return nil
+	}
+	return &suspendedError{source: source, suspension: sp}
+}
+
+// suspend applies the policies of the source after
+// one of its commands took control of the servo.
+// The caller must hold the lock.
+func (s *servor) suspend(source string) {
+	if s.policies == nil {
+		return
+	}
+	for _, p := range s.policies.list {
+		if p.Source != source || (p.Servo != "" && p.Servo != s.name) {
+			continue
+		}
+		until := time.Now().Add(time.Duration(p.Duration * float64(time.Second)))
+		for _, other := range p.Suspends {
+			if sp, ok := s.suspensions[other]; ok && sp.Until.After(until) {
+				continue
+			}
+			if s.suspended(other) == nil {
+				level.Info(s.logger).Log("msg", "suspended source", "source", other, "by", source, "until", until.Format(time.RFC3339))
+			}
+			s.suspensions[other] = suspension{Until: until, By: source}
+		}
+	}
+}
+
+// activeSuspensions returns the suspensions that have not yet expired.
+// The caller must hold the lock.
+func (s *servor) activeSuspensions() map[string]suspension {
+	var active map[string]suspension
+	for source, sp := range s.suspensions {
+		if time.Now().Before(sp.Until) {
+			if active == nil {
+				active = make(map[string]suspension)
+			}
+			active[source] = sp
+		}
+	}
+	return active
+}
+
+// validateSources checks the configured sources.
+func validateSources(sources []string) error {
+	seen := make(map[string]bool)
+	for _, s := range builtinSources {
+		seen[s] = true
+	}
+	for _, s := range sources {
+		if !sourceName.MatchString(s) {
+			return fmt.Errorf("source names must consist of lowercase letters, digits, and dashes; got %q", s)
+		}
+		if seen[s] {
+			return fmt.Errorf("source %q is already defined", s)
+		}
+		seen[s] = true
+	}
+	return nil
+}
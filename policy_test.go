@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestSuspend(t *testing.T) {
+	c := &config{Policies: []policyConfig{
+		{Source: sourceUI, Suspends: []string{sourceScheduler, sourceMQTT}, Duration: 60},
+		{Source: sourceScript, Suspends: []string{sourceScheduler}, Duration: 5},
+		{Source: sourceGPIOButton, Suspends: []string{sourceUI}, Duration: 60, Servo: "tilt"},
+	}}
+	for _, tc := range []struct {
+		name      string
+		commands  []string
+		existing  map[string]suspension
+		suspended []string
+		allowed   []string
+		// by is the source that suspended the scheduler, if it is suspended.
+		by string
+	}{
+		{name: "none", allowed: []string{sourceUI, sourceScheduler, sourceMQTT, sourceScript}},
+		{name: "manual input", commands: []string{sourceUI}, suspended: []string{sourceScheduler, sourceMQTT}, allowed: []string{sourceUI, sourceScript}, by: sourceUI},
+		{name: "no policy", commands: []string{sourceMQTT}, allowed: []string{sourceUI, sourceScheduler, sourceScript}},
+		{name: "other servo", commands: []string{sourceGPIOButton}, allowed: []string{sourceUI}},
+		{name: "shorter suspension", commands: []string{sourceUI, sourceScript}, suspended: []string{sourceScheduler}, by: sourceUI},
+		{name: "longer suspension", commands: []string{sourceScript, sourceUI}, suspended: []string{sourceScheduler}, by: sourceUI},
+		{
+			name:     "expired",
+			existing: map[string]suspension{sourceScheduler: {Until: time.Now().Add(-time.Second), By: sourceUI}},
+			allowed:  []string{sourceScheduler},
+		},
+		{
+			name:      "restored",
+			existing:  map[string]suspension{sourceScheduler: {Until: time.Now().Add(time.Hour), By: sourceScript}},
+			suspended: []string{sourceScheduler},
+			by:        sourceScript,
+		},
+	} {
+		s := &servor{name: "pan", policies: newPolicies(c), suspensions: make(map[string]suspension), logger: log.NewNopLogger()}
+		for source, sp := range tc.existing {
+			s.suspensions[source] = sp
+		}
+		for _, source := range tc.commands {
+			s.suspend(source)
+		}
+		for _, source := range tc.suspended {
+			err := s.suspended(source)
+			if err == nil {
+				t.Errorf("%s: expected %s to be suspended", tc.name, source)
+				continue
+			}
+			if _, ok := err.(*suspendedError); !ok {
+				t.Errorf("%s: expected a suspended error; got %T", tc.name, err)
+			}
+		}
+		for _, source := range tc.allowed {
+			if err := s.suspended(source); err != nil {
+				t.Errorf("%s: expected %s to be allowed; got %v", tc.name, source, err)
+			}
+		}
+		if sp, ok := s.suspensions[sourceScheduler]; ok && tc.by != "" && sp.By != tc.by {
+			t.Errorf("%s: expected the scheduler to be suspended by %s; got %s", tc.name, tc.by, sp.By)
+		}
+		if _, ok := s.suspensions[sourceScheduler]; ok && tc.by == "" {
+			t.Errorf("%s: expected expired suspensions to be forgotten", tc.name)
+		}
+	}
+}
+
+func TestCommandSuspension(t *testing.T) {
+	c := &config{Policies: []policyConfig{
+		{Source: sourceUI, Suspends: []string{sourceScheduler, sourceServor}, Duration: 60},
+	}}
+	s := &servor{
+		name:        "pan",
+		device:      &device{ready: 1},
+		policies:    newPolicies(c),
+		suspensions: make(map[string]suspension),
+		logger:      log.NewNopLogger(),
+	}
+	if err := s.command(priorityManual, sourceUI); err != nil {
+		t.Fatalf("expected the command of the UI to be accepted; got %v", err)
+	}
+	// Whatever their priority, clients cannot escape the policies.
+	for _, p := range []priority{priorityAutomation, priorityManual, prioritySafety} {
+		if err := s.command(p, sourceScheduler); err == nil {
+			t.Errorf("expected the scheduler to be suspended at priority %v", p)
+		}
+	}
+	// The moves of servor itself are never suspended.
+	if err := s.command(prioritySafety, sourceServor); err != nil {
+		t.Errorf("expected the command of servor to be accepted; got %v", err)
+	}
+}
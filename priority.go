@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"google.golang.org/grpc/codes"
+)
+
+// priority ranks commands, so that more important commands preempt
+// less important ones rather than whichever writes last winning.
+type priority int
+
+const (
+	// priorityAutomation is the priority of scheduled or scripted
+	// commands, and the default for commands that start background
+	// motions, e.g. tours.
+	priorityAutomation priority = iota
+	// priorityManual is the priority of operator input and the
+	// default for direct moves and jogging.
+	priorityManual
+	// prioritySafety is the priority of watchdogs and other safety
+	// commands, which preempt everything else.
+	prioritySafety
+)
+
+var priorityNames = []string{"automation", "manual", "safety"}
+
+func (p priority) String() string {
+	return priorityNames[p]
+}
+
+// defaultPriorityHold is how long a direct move keeps
+// commands of lower priority from moving the servo.
+const defaultPriorityHold = 10 * time.Second
+
+// errPreempted is returned for commands of lower priority than the
+// command that is in control of the servo.
+var errPreempted = errors.New("a command of higher priority is in control of the servo")
+
+// claim records that a command of the given priority
+// took control of the servo.
+type claim struct {
+	priority priority
+	until    time.Time
+}
+
+// errSafetyPriority is returned for clients that request safety
+// priority without a token that has the admin scope.
+var errSafetyPriority = errors.New("safety priority requires a token with the admin scope")
+
+// parsePriority returns the priority given in the query of the
+// request, or the given default if there is none. Since safety
+// priority preempts operators and the watchdog, only clients
+// with the admin scope may request it.
+func parsePriority(r *http.Request, def priority) (priority, error) {
+	name := r.URL.Query().Get("priority")
+	if name == "" {
+		return def, nil
+	}
+	for i, n := range priorityNames {
+		if n != name {
+			continue
+		}
+		if p := priority(i); p == prioritySafety {
+			if id := identityFrom(r.Context()); id == nil || !id.scopes[scopeAdmin] {
+				return 0, errSafetyPriority
+			}
+		}
+		return priority(i), nil
+	}
+	return 0, fmt.Errorf("priority must be one of %s; got %q", strings.Join(priorityNames, ", "), name)
+}
+
+// priorityError responds with the error returned by parsePriority.
+func priorityError(w http.ResponseWriter, err error) {
+	code := http.StatusBadRequest
+	if err == errSafetyPriority {
+		code = http.StatusForbidden
+	}
+	http.Error(w, err.Error(), code)
+}
+
+// moveStatus returns the gRPC status code for the error of a command
+// or a move, whatever the interface that it came over, or Unknown for
+// unexpected errors, e.g. failed writes to the device.
+func moveStatus(err error) codes.Code {
+	switch err.(type) {
+	case *strictError:
+		return codes.InvalidArgument
+	case *vetoError:
+		return codes.PermissionDenied
+	case *suspendedError:
+		return codes.FailedPrecondition
+	case *quotaError:
+		return codes.ResourceExhausted
+	}
+	switch err {
+	case errInvalidSample:
+		return codes.InvalidArgument
+	case errPreempted, errSuperseded, errNotReady:
+		return codes.FailedPrecondition
+	case errInhibited:
+		return codes.Unavailable
+	case errOverBudget:
+		return codes.ResourceExhausted
+	}
+	return codes.Unknown
+}
+
+// moveHTTPStatus maps the status codes of moveStatus to HTTP.
+var moveHTTPStatus = map[codes.Code]int{
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.FailedPrecondition: http.StatusConflict,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+	codes.Unknown:            http.StatusInternalServerError,
+}
+
+// writeMoveError responds with the error of a command or a move.
+func writeMoveError(w http.ResponseWriter, err error) {
+	if qe, ok := err.(*quotaError); ok {
+		writeQuotaError(w, qe)
+		return
+	}
+	code := moveStatus(err)
+	if code == codes.Unknown {
+		http.Error(w, fmt.Sprintf("failed to move servo: %v", err), moveHTTPStatus[code])
+		return
+	}
+	http.Error(w, err.Error(), moveHTTPStatus[code])
+}
+
+// control returns the priority of the command in control of the servo,
+// i.e. of the running job or of a recent direct move, whichever is higher.
+// The caller must hold the lock.
+func (s *servor) control() priority {
+	p := priorityAutomation
+	if s.job != nil && s.job.priority > p {
+		p = s.job.priority
+	}
+	if time.Now().Before(s.claim.until) && s.claim.priority > p {
+		p = s.claim.priority
+	}
+	return p
+}
+
+// command hands control of the servo to a command of the given priority
+// from the given source, unless its device is not ready yet, the source
+// is suspended by a policy, a command of higher priority is in control,
+// or the command would exceed a quota of the client, which counts every
+// accepted command as a move, whatever the transport it came over.
+// Whatever their priority, only the commands of servor itself are never
+// suspended, so that clients cannot escape policies by raising it.
+// The caller must hold the lock.
+func (s *servor) command(p priority, source string) error {
+	if !s.device.isReady() {
+		return errNotReady
+	}
+	if source != sourceServor {
+		if err := s.suspended(source); err != nil {
+			commandsSuspendedTotal.WithLabelValues(s.name, source).Inc()
+			return err
+		}
+	}
+	if p < s.control() {
+		commandsPreemptedTotal.WithLabelValues(s.name, source).Inc()
+		level.Debug(s.logger).Log("msg", "command was preempted", "source", source, "priority", p, "controller", s.source)
+		return errPreempted
+	}
+	if err := s.client.record(true); err != nil {
+		return err
+	}
+	now := time.Now()
+	s.claim = claim{priority: p, until: now.Add(s.priorityHold)}
+	s.source = source
+	s.generation++
+	// Every command feeds the watchdog.
+	s.lastCommand, s.tripped = now, false
+	commandsTotal.WithLabelValues(s.name, source).Inc()
+	s.suspend(source)
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// bcmPWMChannels maps the BCM pins that can be muxed to the
+// PWM peripheral to their PWM channel.
+var bcmPWMChannels = map[int]int{
+	12: 0,
+	18: 0,
+	13: 1,
+	19: 1,
+}
+
+// pwm drives a servo with a hardware PWM channel using the kernel's
+// PWM interface, so no daemon is needed. On a Raspberry Pi, the PWM
+// peripheral must be enabled, e.g. with dtoverlay=pwm-2chan.
+type pwm struct {
+	chip      string
+	frequency float64
+	// channels maps pins to channels; if nil, pins are channels.
+	channels map[int]int
+	// exported records the channels that were exported and configured.
+	exported map[int]bool
+}
+
+func newPWM(chip string, frequency float64, channels map[int]int) *pwm {
+	return &pwm{chip: chip, frequency: frequency, channels: channels, exported: make(map[int]bool)}
+}
+
+// Set implements driver.
+func (p *pwm) Set(pin int, value float64) error {
+	channel, err := p.channel(pin)
+	if err != nil {
+		return err
+	}
+	period := time.Duration(float64(time.Second) / p.frequency)
+	if !p.exported[channel] {
+		if err := p.export(channel, period); err != nil {
+			return err
+		}
+		p.exported[channel] = true
+	}
+	duty := time.Duration(value * float64(period))
+	if err := p.write(channel, "duty_cycle", strconv.FormatInt(duty.Nanoseconds(), 10)); err != nil {
+		// Re-configure the channel on the next call in case it was
+		// un-exported or reset in the meantime.
+		delete(p.exported, channel)
+		return err
+	}
+	return nil
+}
+
+// channel returns the PWM channel that drives the pin.
+func (p *pwm) channel(pin int) (int, error) {
+	if p.channels == nil {
+		return pin, nil
+	}
+	channel, ok := p.channels[pin]
+	if !ok {
+		return 0, fmt.Errorf("pin %d does not support hardware PWM", pin)
+	}
+	return channel, nil
+}
+
+// export makes the channel available, sets its period, and enables it.
+func (p *pwm) export(channel int, period time.Duration) error {
+	if _, err := os.Stat(p.dir(channel)); os.IsNotExist(err) {
+		if err := ioutil.WriteFile(filepath.Join(p.chip, "export"), []byte(strconv.Itoa(channel)), 0200); err != nil {
+			return fmt.Errorf("failed to export PWM channel %d: %v", channel, err)
+		}
+		// udev may take a moment to make the new files writable.
+		for i := 0; i < 10; i++ {
+			if f, err := os.OpenFile(filepath.Join(p.dir(channel), "period"), os.O_WRONLY, 0); err == nil {
+				f.Close()
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	// The duty cycle must never exceed the period, so reset it first.
+	if err := p.write(channel, "duty_cycle", "0"); err != nil {
+		return err
+	}
+	if err := p.write(channel, "period", strconv.FormatInt(period.Nanoseconds(), 10)); err != nil {
+		return err
+	}
+	return p.write(channel, "enable", "1")
+}
+
+// dir returns the directory of the exported channel. Some kernels,
+// e.g. those of the BeagleBone, name it pwm-<chip>:<channel>
+// rather than pwm<channel>.
+func (p *pwm) dir(channel int) string {
+	dir := filepath.Join(p.chip, fmt.Sprintf("pwm%d", channel))
+	if _, err := os.Stat(dir); err == nil {
+		return dir
+	}
+	if dirs, _ := filepath.Glob(filepath.Join(p.chip, fmt.Sprintf("pwm-*:%d", channel))); len(dirs) == 1 {
+		return dirs[0]
+	}
+	return dir
+}
+
+func (p *pwm) write(channel int, attr, value string) error {
+	path := filepath.Join(p.dir(channel), attr)
+	if err := ioutil.WriteFile(path, []byte(value), 0644); err != nil {
+		return fmt.Errorf("failed to write PWM %s: %v", attr, err)
+	}
+	return nil
+}
+
+// Close implements driver.
+// Channels are left enabled so that the servo keeps its position.
+func (p *pwm) Close() error {
+	p.exported = make(map[int]bool)
+	return nil
+}
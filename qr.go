@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// uiURLs returns the URLs at which other devices on the network,
+// e.g. phones, can reach the UI of a server listening on the given
+// address. For servers listening on all interfaces, there is one
+// URL per address of each interface that is up, excluding loopback
+// and link-local addresses, which phones cannot use.
+func uiURLs(listen string, secure bool) ([]string, error) {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse listen address: %v", err)
+	}
+	scheme := "http"
+	if secure {
+		scheme = "https"
+	}
+	if ip := net.ParseIP(host); host != "" && (ip == nil || !ip.IsUnspecified()) {
+		return []string{fmt.Sprintf("%s://%s/", scheme, net.JoinHostPort(host, port))}, nil
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list network interfaces: %v", err)
+	}
+	var v4, v6 []string
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the addresses of %s: %v", iface.Name, err)
+		}
+		for _, addr := range addrs {
+			ipn, ok := addr.(*net.IPNet)
+			if !ok || ipn.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			u := fmt.Sprintf("%s://%s/", scheme, net.JoinHostPort(ipn.IP.String(), port))
+			if ipn.IP.To4() != nil {
+				v4 = append(v4, u)
+				continue
+			}
+			v6 = append(v6, u)
+		}
+	}
+	// IPv4 addresses come first, as they are easier to type.
+	return append(v4, v6...), nil
+}
+
+// qrHandler serves a QR code of the given URL as a PNG image.
+func qrHandler(url string, logger log.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || url == "" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		size := 256
+		if s := r.URL.Query().Get("size"); s != "" {
+			var err error
+			if size, err = strconv.Atoi(s); err != nil || size < 64 || size > 2048 {
+				http.Error(w, "size must be an integer between 64 and 2048", http.StatusBadRequest)
+				return
+			}
+		}
+		png, err := qrcode.Encode(url, qrcode.Medium, size)
+		if err != nil {
+			level.Error(logger).Log("err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		if _, err := w.Write(png); err != nil {
+			level.Error(logger).Log("err", err)
+		}
+	})
+}
+
+// qrText renders a QR code of the given URL as text that can be
+// scanned from a terminal, using half blocks for compactness.
+func qrText(url string) (string, error) {
+	q, err := qrcode.New(url, qrcode.Medium)
+	if err != nil {
+		return "", err
+	}
+	return q.ToSmallString(false), nil
+}
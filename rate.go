@@ -0,0 +1,51 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// travel is how far an identity may still move a servo. Like a token
+// bucket, it refills at the rate limit of the identity and holds at
+// most one second of travel, so that the identity can never make the
+// servo jump by more than its rate limit at once.
+type travel struct {
+	allowance float64
+	last      time.Time
+}
+
+// limitTarget clamps the target of a move of the given servo from
+// its current position to the travel that the rate limit of the
+// identity allows, and uses up that travel.
+// A nil identity or one without a rate limit is not limited.
+func (id *identity) limitTarget(servo string, from, to float64) float64 {
+	if id == nil || id.rate == 0 {
+		return to
+	}
+	id.mu.Lock()
+	defer id.mu.Unlock()
+	now := time.Now()
+	t, ok := id.travel[servo]
+	if !ok {
+		t = &travel{allowance: id.rate, last: now}
+		id.travel[servo] = t
+	}
+	t.allowance = math.Min(id.rate, t.allowance+id.rate*now.Sub(t.last).Seconds())
+	t.last = now
+	d := math.Max(-t.allowance, math.Min(t.allowance, to-from))
+	t.allowance -= math.Abs(d)
+	if d != to-from {
+		rateLimitedTotal.WithLabelValues(id.name).Inc()
+	}
+	return from + d
+}
+
+// limitVelocity clamps the velocity of a jog by the identity
+// to its rate limit, if any.
+func (id *identity) limitVelocity(v float64) float64 {
+	if id == nil || id.rate == 0 || math.Abs(v) <= id.rate {
+		return v
+	}
+	rateLimitedTotal.WithLabelValues(id.name).Inc()
+	return math.Copysign(id.rate, v)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// readinessInterval is how often devices that are not ready are checked.
+const readinessInterval = time.Second
+
+// errNotReady is returned for commands to a servo whose device
+// did not pass its initial health check yet.
+var errNotReady = errors.New("the device of the servo is not ready yet")
+
+// check checks that one of the drivers of the device, i.e. its driver
+// or a fallback, can be reached and drive the pins of all of its servos.
+func (d *device) check() error {
+	var err error
+	for _, c := range d.drivers {
+		if err = c.available(); err != nil {
+			continue
+		}
+		for _, s := range d.servos {
+			if err = c.availablePin(s.pin); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// isReady returns whether the device passed its initial health check.
+func (d *device) isReady() bool {
+	return atomic.LoadInt32(&d.ready) == 1
+}
+
+// checkReadiness checks the devices that are not ready yet, marks
+// those that passed, and returns whether all devices are ready.
+func (ss *servos) checkReadiness() bool {
+	ready := true
+	for _, d := range ss.devices {
+		if d.isReady() {
+			continue
+		}
+		if err := d.check(); err != nil {
+			// Only the first failed check is reported, since
+			// the devices are checked every second.
+			if !d.waiting {
+				level.Warn(ss.logger).Log("msg", "device is not ready; rejecting commands until it is", "device", d.config.Device, "err", err)
+				d.waiting = true
+			}
+			ready = false
+			continue
+		}
+		atomic.StoreInt32(&d.ready, 1)
+		level.Info(ss.logger).Log("msg", "device is ready", "device", d.config.Device)
+	}
+	return ready
+}
+
+// ready returns whether the devices of all servos are ready.
+func (ss *servos) ready() bool {
+	for _, s := range ss.list {
+		if !s.device.isReady() {
+			return false
+		}
+	}
+	return true
+}
+
+// watchReadiness checks the devices until all of them are ready
+// and then waits until the context is done.
+func (ss *servos) watchReadiness(ctx context.Context) error {
+	t := time.NewTicker(readinessInterval)
+	defer t.Stop()
+	for !ss.checkReadiness() {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+	}
+	<-ctx.Done()
+	return nil
+}
+
+// actuates returns whether the request moves servos, including the
+// moves of the calibration wizard, which need the config scope.
+// Stopping servos is always allowed.
+func actuates(r *http.Request) bool {
+	if !strings.HasPrefix(r.URL.Path, "/api/") && !strings.HasPrefix(r.URL.Path, "/v1/") || strings.HasSuffix(r.URL.Path, "/stop") {
+		return false
+	}
+	switch requiredScope(r) {
+	case scopeMove, scopeSequences:
+		return true
+	}
+	return r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/calibration/move")
+}
+
+// readiness rejects requests that move servos with 503 Service
+// Unavailable until the devices of all servos are ready, while
+// still serving the UI, the metrics, and the state of the servos.
+func (ss *servos) readiness(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if actuates(r) && !ss.ready() {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, errNotReady.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// readinessUnary rejects the unary gRPC calls that move servos
+// until the devices of all servos are ready.
+func (ss *servos) readinessUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if m := path.Base(info.FullMethod); !readOnlyRPCs[m] && m != "Stop" && !ss.ready() {
+		return nil, status.Error(codes.Unavailable, errNotReady.Error())
+	}
+	return handler(ctx, req)
+}
+
+// readinessStream rejects the streaming gRPC calls that move servos
+// until the devices of all servos are ready.
+func (ss *servos) readinessStream(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if !ss.ready() {
+		return status.Error(codes.Unavailable, errNotReady.Error())
+	}
+	return handler(srv, stream)
+}
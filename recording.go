@@ -0,0 +1,75 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// maxRecordedKeyframes bounds the length of a recording;
+// at 50 frames per second, it holds about five minutes of jogging.
+const maxRecordedKeyframes = 15000
+
+// recording captures the positions that a servo is driven to,
+// e.g. while it is jogged or dragged, as a sequence.
+type recording struct {
+	start     time.Time
+	keyframes sequence
+}
+
+// startRecording starts capturing the moves of the servo,
+// discarding any previous recording.
+// The caller must hold the lock.
+func (s *servor) startRecording() {
+	s.recording = &recording{start: time.Now()}
+	s.record()
+}
+
+// stopRecording stops capturing moves and returns the recording,
+// whose first keyframe is the position of the servo when the
+// recording started. It returns nil if no recording was started.
+// The caller must hold the lock.
+func (s *servor) stopRecording() sequence {
+	if s.recording == nil {
+		return nil
+	}
+	s.record()
+	sq := s.recording.keyframes
+	s.recording = nil
+	return sq
+}
+
+// record adds the position of the servo to the recording, if any.
+// While the servo holds a position, the last keyframe is moved
+// forward in time rather than adding a keyframe for every write.
+// The caller must hold the lock.
+func (s *servor) record() {
+	r := s.recording
+	if r == nil {
+		return
+	}
+	// Times are rounded to milliseconds to keep recordings legible.
+	k := keyframe{Time: math.Round(time.Since(r.start).Seconds()*1000) / 1000, Position: s.position}
+	n := len(r.keyframes)
+	if n >= 1 {
+		last := r.keyframes[n-1]
+		// A servo that was idle held its position until this write,
+		// so it should not drift towards the new position in playback.
+		if hold := k.Time - frameInterval.Seconds(); k.Position != last.Position && hold > last.Time {
+			r.add(keyframe{Time: hold, Position: last.Position})
+			n = len(r.keyframes)
+		}
+	}
+	switch {
+	case n >= 2 && r.keyframes[n-1].Position == k.Position && r.keyframes[n-2].Position == k.Position,
+		n >= 1 && r.keyframes[n-1].Time >= k.Time:
+		r.keyframes[n-1] = k
+	default:
+		r.add(k)
+	}
+}
+
+func (r *recording) add(k keyframe) {
+	if len(r.keyframes) < maxRecordedKeyframes {
+		r.keyframes = append(r.keyframes, k)
+	}
+}
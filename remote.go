@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// remoteTimeout bounds each command sent to a remote node over HTTP.
+const remoteTimeout = time.Second
+
+// remoteNode sends the pulse width of each move to a node on the network,
+// e.g. an ESP32 or ESP8266 that generates the pulses, so that a servo
+// can be far away from servor. Commands are sent either as UDP datagrams
+// of the form "<pin> <microseconds>\n" or as HTTP POST requests with
+// pin and us query parameters.
+type remoteNode struct {
+	url       *url.URL
+	frequency float64
+	client    *http.Client
+	conn      net.Conn
+}
+
+func newRemoteNode(device string, frequency float64) (*remoteNode, error) {
+	u, err := url.Parse(device)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse remote node URL: %v", err)
+	}
+	switch u.Scheme {
+	case "udp", "http", "https":
+	default:
+		return nil, fmt.Errorf("remote node URL must use udp, http, or https; got %q", device)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("remote node URL must include a host; got %q", device)
+	}
+	return &remoteNode{url: u, frequency: frequency, client: &http.Client{Timeout: remoteTimeout}}, nil
+}
+
+// Set implements driver.
+func (r *remoteNode) Set(pin int, value float64) error {
+	us := int(pulseWidth(value, r.frequency) / time.Microsecond)
+	if r.url.Scheme == "udp" {
+		if r.conn == nil {
+			conn, err := net.Dial("udp", r.url.Host)
+			if err != nil {
+				return err
+			}
+			r.conn = conn
+		}
+		if _, err := fmt.Fprintf(r.conn, "%d %d\n", pin, us); err != nil {
+			r.Close()
+			return err
+		}
+		return nil
+	}
+	u := *r.url
+	q := u.Query()
+	q.Set("pin", strconv.Itoa(pin))
+	q.Set("us", strconv.Itoa(us))
+	u.RawQuery = q.Encode()
+	resp, err := r.client.Post(u.String(), "text/plain", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("remote node responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// Close implements driver.
+func (r *remoteNode) Close() error {
+	if r.conn == nil {
+		return nil
+	}
+	err := r.conn.Close()
+	r.conn = nil
+	return err
+}
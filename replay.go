@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"google.golang.org/grpc/codes"
+)
+
+// command is a raw command sent to a device, as recorded in a command log.
+type command struct {
+	Time time.Time `json:"time"`
+	// Device is the device of the driver that the command was sent to.
+	Device string  `json:"device"`
+	Pin    int     `json:"pin"`
+	Value  float64 `json:"value"`
+}
+
+// commandLog records every command sent to the devices, one JSON
+// object per line, so that motion bugs can be reproduced exactly
+// by replaying the log against any driver.
+type commandLog struct {
+	mu     sync.Mutex
+	f      *os.File
+	e      *json.Encoder
+	logger log.Logger
+}
+
+// newCommandLog creates a command log that appends to the given file.
+func newCommandLog(path string, logger log.Logger) (*commandLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open command log: %v", err)
+	}
+	return &commandLog{f: f, e: json.NewEncoder(f), logger: logger}, nil
+}
+
+// record appends a command to the log.
+// It is safe to call on a nil log, which records nothing.
+func (l *commandLog) record(device string, pin int, value float64) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.e.Encode(command{Time: time.Now(), Device: device, Pin: pin, Value: value}); err != nil {
+		level.Warn(l.logger).Log("msg", "failed to write command log", "err", err)
+	}
+}
+
+// Close closes the file of the log.
+func (l *commandLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.f.Close()
+}
+
+// readCommands reads the commands of a command log.
+func readCommands(r io.Reader) ([]command, error) {
+	var commands []command
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+		var c command
+		if err := json.Unmarshal(s.Bytes(), &c); err != nil {
+			return nil, fmt.Errorf("failed to parse command on line %d: %v", line, err)
+		}
+		commands = append(commands, c)
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read command log: %v", err)
+	}
+	return commands, nil
+}
+
+// replay sends the commands with their original timing to set
+// until all were sent, set fails, or the context is done.
+func replay(ctx context.Context, commands []command, set func(command) error) error {
+	if len(commands) == 0 {
+		return nil
+	}
+	start := time.Now()
+	for _, c := range commands {
+		if wait := c.Time.Sub(commands[0].Time) - time.Since(start); wait > 0 {
+			t := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return ctx.Err()
+			case <-t.C:
+			}
+		}
+		if err := set(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replayFile replays the command log at the given path against
+// the given driver, regardless of the devices that the commands
+// were originally sent to.
+func replayFile(ctx context.Context, path string, d driver) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open command log: %v", err)
+	}
+	commands, err := readCommands(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	return replay(ctx, commands, func(c command) error {
+		return d.Set(c.Pin, c.Value)
+	})
+}
+
+// errNoReplayServos is returned for command logs whose
+// commands do not address any of the servos.
+var errNoReplayServos = errors.New("no servo uses the pins of the command log")
+
+// servoFor returns the servo that replays commands for the given pin
+// of the given device: the servo on the same pin of the same device,
+// or else any servo on the same pin, e.g. for a log captured on another
+// unit, or nil if no servo uses the pin.
+func (ss *servos) servoFor(device string, pin int) *servor {
+	var match *servor
+	for _, s := range ss.list {
+		s.mu.Lock()
+		same := s.pin == pin
+		s.mu.Unlock()
+		if !same {
+			continue
+		}
+		if s.device.config.Device == device {
+			return s
+		}
+		if match == nil {
+			match = s
+		}
+	}
+	return match
+}
+
+// replay plays back the commands with their original timing on the
+// servos that they address and returns copies of the jobs that run
+// the replay, one for each servo. Stopping any of the jobs stops the
+// whole replay. Commands for pins that no servo uses are skipped.
+// The replay counts as a move of the client on each servo.
+func (ss *servos) replay(commands []command, p priority, source string, client *identity) ([]job, error) {
+	route := make(map[string]*servor)
+	var involved []*servor
+	for _, c := range commands {
+		key := fmt.Sprintf("%s/%d", c.Device, c.Pin)
+		if _, ok := route[key]; ok {
+			continue
+		}
+		s := ss.servoFor(c.Device, c.Pin)
+		route[key] = s
+		if s == nil {
+			continue
+		}
+		known := false
+		for _, other := range involved {
+			known = known || other == s
+		}
+		if !known {
+			involved = append(involved, s)
+		}
+	}
+	if len(involved) == 0 {
+		return nil, errNoReplayServos
+	}
+	for _, s := range involved {
+		s.mu.Lock()
+		// The commands of the log are replayed as they were,
+		// so they would bypass the pre-move hooks.
+		err := s.checkContinuous()
+		if err == nil {
+			s.client = client
+			err = s.command(p, source)
+			s.client = nil
+		}
+		s.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	jobs := make([]*job, 0, len(involved))
+	copies := make([]job, 0, len(involved))
+	for _, s := range involved {
+		s.mu.Lock()
+		j := s.start("replay", func(jctx context.Context) {
+			select {
+			case <-jctx.Done():
+				cancel()
+			case <-done:
+			}
+		})
+		jobs = append(jobs, j)
+		copies = append(copies, *j)
+		s.mu.Unlock()
+	}
+	go func() {
+		err := replay(ctx, commands, func(c command) error {
+			s := route[fmt.Sprintf("%s/%d", c.Device, c.Pin)]
+			if s == nil {
+				return nil
+			}
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			// The replay may have been stopped while waiting for the lock.
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			s.position = c.Value
+			if err := s.set(); err != nil {
+				level.Error(s.logger).Log("msg", "failed to replay command", "err", err)
+			}
+			return nil
+		})
+		if err != nil {
+			ss.abort(involved, jobs)
+		}
+		close(done)
+		cancel()
+	}()
+	return copies, nil
+}
+
+// abort cancels the jobs of a replay that are still running for the
+// reason for which the first of them was cancelled.
+func (ss *servos) abort(involved []*servor, jobs []*job) {
+	reason := reasonStopped
+	for i, s := range involved {
+		s.mu.Lock()
+		if jobs[i].State == jobCancelled {
+			reason = jobs[i].Reason
+		}
+		s.mu.Unlock()
+	}
+	for i, s := range involved {
+		s.mu.Lock()
+		if s.job == jobs[i] {
+			s.stop(reason)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// replayRequest replays the command log in the body of the request.
+func (ss *servos) replayRequest(w http.ResponseWriter, r *http.Request) {
+	p, err := parsePriority(r, priorityAutomation)
+	if err != nil {
+		priorityError(w, err)
+		return
+	}
+	source, err := parseSource(r, ss.policies)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	commands, err := readCommands(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	jobs, err := ss.replay(commands, p, source, identityFrom(r.Context()))
+	if moveStatus(err) != codes.Unknown {
+		writeMoveError(w, err)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(jobs); err != nil {
+		level.Error(ss.logger).Log("err", err)
+	}
+}
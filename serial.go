@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var baudRates = map[int]uint32{
+	9600:    syscall.B9600,
+	19200:   syscall.B19200,
+	38400:   syscall.B38400,
+	57600:   syscall.B57600,
+	115200:  syscall.B115200,
+	230400:  syscall.B230400,
+	460800:  syscall.B460800,
+	500000:  syscall.B500000,
+	921600:  syscall.B921600,
+	1000000: syscall.B1000000,
+}
+
+// openSerial opens the serial port at the given path in raw 8N1 mode.
+// Reads time out after 100ms so that unresponsive devices are detected.
+func openSerial(path string, baud int) (*os.File, error) {
+	rate, ok := baudRates[baud]
+	if !ok {
+		return nil, fmt.Errorf("unsupported baud rate %d", baud)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+	t := syscall.Termios{
+		Iflag:  syscall.IGNPAR,
+		Cflag:  syscall.CS8 | syscall.CREAD | syscall.CLOCAL | rate,
+		Ispeed: rate,
+		Ospeed: rate,
+	}
+	t.Cc[syscall.VMIN] = 0
+	t.Cc[syscall.VTIME] = 1
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), uintptr(syscall.TCSETS), uintptr(unsafe.Pointer(&t))); errno != 0 {
+		f.Close()
+		return nil, fmt.Errorf("failed to configure serial port: %v", errno)
+	}
+	return f, nil
+}
+
+// flushInput discards data that was received but not read.
+func flushInput(f *os.File) error {
+	// The TCFLSH ioctl and TCIFLUSH argument are not defined
+	// by the syscall package.
+	const tcflsh, tciflush = 0x540b, 0
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), tcflsh, tciflush); errno != 0 {
+		return fmt.Errorf("failed to flush serial port: %v", errno)
+	}
+	return nil
+}
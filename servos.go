@@ -0,0 +1,497 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// device is a driver together with the servos that it drives.
+// Several servos can share a device, e.g. the channels of a
+// Maestro or the servos on a Dynamixel bus. Drivers are not safe
+// for concurrent use, so all access is serialized by the lock.
+type device struct {
+	driver
+	// config is the configuration of the driver with defaults applied.
+	config driverConfig
+	// drivers are the configurations of the driver and its fallbacks.
+	drivers []driverConfig
+	// key identifies the device together with any fallbacks.
+	key    string
+	servos []*servor
+	// ready is set to 1 once the device passed its initial health check,
+	// and waiting is set once a check failed, which is only reported once.
+	ready   int32
+	waiting bool
+	// commands records the commands sent to the device, if set.
+	commands *commandLog
+
+	mu sync.Mutex
+}
+
+// servos is the set of servos controlled by servor.
+type servos struct {
+	// list holds the servos in the configured order. The first servo
+	// is the default for endpoints that do not name a servo.
+	list   []*servor
+	byName map[string]*servor
+	pairs  []pairConfig
+	// controls map keys and gamepad buttons to actions in the UI.
+	controls []controlConfig
+	devices  []*device
+	hooks    *hooks
+	events   *bus
+	policies *policies
+	// callbacks limits the hosts that jobs post their callbacks to.
+	callbacks *callbackPolicy
+	logger    log.Logger
+	// strict rejects malformed input rather than ignoring it.
+	strict bool
+	// exclusive serializes the handlers that lock all servos;
+	// see lockAll.
+	exclusive *sync.Mutex
+	// unsubscribeWebhooks stops posting events to the webhooks.
+	unsubscribeWebhooks func()
+}
+
+// servoSummary describes a servo in the list of servos.
+type servoSummary struct {
+	Name   string `json:"name"`
+	Driver string `json:"driver"`
+	Pin    int    `json:"pin"`
+	// Frequency is the PWM frequency for drivers that take duty cycles,
+	// so that positions can be converted to pulse widths.
+	Frequency float64 `json:"frequency,omitempty"`
+	// Degrees is the angle that the servo turns over its range, if known.
+	Degrees float64 `json:"degrees,omitempty"`
+}
+
+// servoState is the current state of a servo.
+type servoState struct {
+	Servo string `json:"servo"`
+	// Position is the commanded position, which passes through the
+	// intermediate positions of smooth motions.
+	Position float64 `json:"position"`
+	// Target is where the servo is heading, i.e. the end of the
+	// glide in progress, if any, or else its position.
+	Target float64 `json:"target"`
+	// Current is where the servo is, i.e. the measured position,
+	// if known, or else the commanded position.
+	Current float64 `json:"current"`
+	Min     float64 `json:"min"`
+	Max     float64 `json:"max"`
+	// Measured is the position last read back from the servo,
+	// if its driver supports feedback.
+	Measured *float64 `json:"measured,omitempty"`
+}
+
+// state returns the current state of the servo.
+// The caller must hold the lock.
+func (s *servor) state() servoState {
+	st := servoState{Servo: s.name, Position: s.position, Target: s.position, Current: s.position, Min: s.min, Max: s.max}
+	if s.job != nil && s.target != nil {
+		st.Target = *s.target
+	}
+	if s.feedback != nil {
+		measured := s.feedback.Position
+		st.Measured = &measured
+		st.Current = measured
+	}
+	return st
+}
+
+// newServos creates the servos of the given valid configuration
+// and the drivers that they use. Servos whose driver settings
+// refer to the same device share a single driver.
+func newServos(c *config, logger log.Logger) (*servos, error) {
+	ss := &servos{
+		byName:    make(map[string]*servor),
+		pairs:     c.Pairs,
+		controls:  c.Controls,
+		hooks:     newHooks(c.Hooks, logger),
+		events:    newBus(),
+		policies:  newPolicies(c),
+		callbacks: newCallbackPolicy(c.CallbackHosts),
+		logger:    logger,
+		exclusive: &sync.Mutex{},
+	}
+	ss.events.subscribe(ss.hooks)
+	ss.unsubscribeWebhooks = ss.events.subscribeWebhooks(c.Webhooks, logger)
+	if ss.controls == nil {
+		ss.controls = defaultControls
+	}
+	devices := make(map[string]*device)
+	for _, sc := range c.servos() {
+		sc := sc
+		configs := sc.drivers()
+		key := fmt.Sprintf("%+v", configs)
+		d, ok := devices[key]
+		if !ok {
+			var drv driver
+			var err error
+			if len(configs) > 1 {
+				drv, err = newFailover(configs, logger)
+			} else {
+				drv, err = newDriver(configs[0])
+				driverActive.WithLabelValues(configs[0].Type, configs[0].Device).Set(1)
+			}
+			if err != nil {
+				ss.Close()
+				return nil, fmt.Errorf("failed to create driver for servo %q: %v", sc.Name, err)
+			}
+			if m, ok := drv.(*mock); ok && c.DryRun {
+				m.logger = log.With(logger, "device", configs[0].Device)
+			}
+			d = &device{driver: drv, config: configs[0], drivers: configs, key: key}
+			devices[key] = d
+			ss.devices = append(ss.devices, d)
+		}
+		for _, other := range d.servos {
+			if other.pin == sc.Pin {
+				ss.Close()
+				return nil, fmt.Errorf("servos %q and %q use the same pin %d of the same device", other.name, sc.Name, sc.Pin)
+			}
+		}
+		s := newServor(&sc, d, log.With(logger, "servo", sc.Name))
+		s.hooks = ss.hooks
+		s.events = ss.events
+		s.policies = ss.policies
+		s.callbacks = ss.callbacks
+		s.inputs = c.Inputs
+		d.servos = append(d.servos, s)
+		ss.list = append(ss.list, s)
+		ss.byName[sc.Name] = s
+		// Export the congestion gauges as 0 before the first move.
+		deviceWritesPending.WithLabelValues(sc.Name)
+		motionJobsActive.WithLabelValues(sc.Name)
+	}
+	return ss, nil
+}
+
+// get returns the servo with the given name,
+// or the default servo if the name is empty.
+func (ss *servos) get(name string) (*servor, bool) {
+	if name == "" {
+		return ss.list[0], true
+	}
+	s, ok := ss.byName[name]
+	return s, ok
+}
+
+// Close closes the drivers of all servos.
+func (ss *servos) Close() error {
+	var err error
+	for _, d := range ss.devices {
+		d.mu.Lock()
+		if e := d.Close(); e != nil {
+			err = e
+		}
+		d.mu.Unlock()
+	}
+	return err
+}
+
+// lockAll locks all servos and returns a function that unlocks them.
+// Since moves release the lock of their servo while the pre-move hooks
+// run, the callers take turns, so that they do not deadlock over the
+// lock of such a servo.
+func (ss *servos) lockAll() func() {
+	ss.exclusive.Lock()
+	for _, s := range ss.list {
+		s.mu.Lock()
+	}
+	return func() {
+		for _, s := range ss.list {
+			s.mu.Unlock()
+		}
+		ss.exclusive.Unlock()
+	}
+}
+
+// park stops all servos and drives them to their home positions,
+// e.g. before the supply voltage drops too low to move them. Since
+// motion is inhibited, the pre-move hooks are not asked to approve.
+func (ss *servos) park() {
+	for _, s := range ss.list {
+		s.mu.Lock()
+		s.stop(reasonParked)
+		s.source = sourceServor
+		s.position = s.home
+		if err := s.set(); err != nil {
+			level.Error(s.logger).Log("msg", "failed to park servo", "err", err)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// feedback reports whether any of the drivers can read back
+// the state of its servos.
+func (ss *servos) feedback() bool {
+	for _, d := range ss.devices {
+		if _, ok := d.driver.(feedbacker); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// watch periodically checks the devices and re-asserts the current
+// positions when pi-blaster was restarted or the last write failed.
+// Without this, a restart of pi-blaster leaves the servos unset.
+func (ss *servos) watch(ctx context.Context, interval time.Duration) error {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+		for _, d := range ss.devices {
+			d.recover(ss.logger)
+		}
+	}
+}
+
+// poll periodically reads back the state of the servos whose
+// driver implements feedbacker and records it until the context is done.
+func (ss *servos) poll(ctx context.Context, interval time.Duration) error {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+		for _, d := range ss.devices {
+			if _, ok := d.driver.(feedbacker); !ok {
+				continue
+			}
+			for _, s := range d.servos {
+				s.poll()
+			}
+		}
+	}
+}
+
+// recover re-establishes the connection to the device if it was
+// replaced or a write to it failed, and re-asserts the positions
+// of all servos that it drives. A servo that cannot be restored
+// does not keep the others from being restored; its failed write
+// marks the device as failed, so that it is recovered again on the
+// next check, and the recovery only counts once all servos are back.
+func (d *device) recover(logger log.Logger) {
+	var stale bool
+	d.mu.Lock()
+	if st, ok := d.driver.(staler); ok {
+		var err error
+		if stale, err = st.stale(); err != nil {
+			level.Warn(logger).Log("msg", "failed to check device", "device", d.config.Device, "err", err)
+		}
+	}
+	d.mu.Unlock()
+	failed := false
+	for _, s := range d.servos {
+		s.mu.Lock()
+		failed = failed || s.failed
+		s.mu.Unlock()
+	}
+	if !stale && !failed {
+		return
+	}
+	level.Warn(logger).Log("msg", "device was restarted or is unavailable; re-establishing connection", "device", d.config.Device)
+	d.mu.Lock()
+	d.Close()
+	d.mu.Unlock()
+	restored := true
+	for _, s := range d.servos {
+		s.mu.Lock()
+		// Servos that were never moved or were detached are left alone.
+		if !s.sent || s.detached {
+			s.mu.Unlock()
+			continue
+		}
+		// The pulses stopped while the device was unavailable.
+		s.unpowered = true
+		err := s.set()
+		position := s.position
+		s.mu.Unlock()
+		if err != nil {
+			level.Error(s.logger).Log("msg", "failed to re-establish connection to device", "err", err)
+			restored = false
+			continue
+		}
+		level.Info(s.logger).Log("msg", "re-established connection to device", "position", position)
+	}
+	if restored {
+		deviceRecoveriesTotal.Inc()
+	}
+}
+
+func (ss *servos) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodGet && (r.URL.Path == "/" || r.URL.Path == "/index.html"):
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write([]byte(html)); err != nil {
+			level.Error(ss.logger).Log("err", err)
+		}
+		return
+	case r.Method == http.MethodGet && r.URL.Path == "/editor":
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write([]byte(editorHTML)); err != nil {
+			level.Error(ss.logger).Log("err", err)
+		}
+		return
+	case r.Method == http.MethodGet && r.URL.Path == "/api/servos":
+		summaries := make([]servoSummary, 0, len(ss.list))
+		for _, s := range ss.list {
+			s.mu.Lock()
+			summary := servoSummary{Name: s.name, Driver: s.device.config.Type, Pin: s.pin, Degrees: s.degrees}
+			if s.device.config.pulses() {
+				summary.Frequency = s.device.config.Frequency
+			}
+			summaries = append(summaries, summary)
+			s.mu.Unlock()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(summaries); err != nil {
+			level.Error(ss.logger).Log("err", err)
+		}
+		return
+	case r.Method == http.MethodGet && r.URL.Path == "/api/pairs":
+		pairs := ss.pairs
+		if pairs == nil {
+			pairs = []pairConfig{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(pairs); err != nil {
+			level.Error(ss.logger).Log("err", err)
+		}
+		return
+	case r.Method == http.MethodPost && r.URL.Path == "/api/replay":
+		ss.replayRequest(w, r)
+		return
+	case r.Method == http.MethodPost && r.URL.Path == "/api/snapshot":
+		ss.snapshotRequest(w)
+		return
+	case r.Method == http.MethodPost && r.URL.Path == "/api/restore":
+		ss.restoreRequest(w, r)
+		return
+	case r.Method == http.MethodGet && r.URL.Path == "/api/events":
+		ss.streamEvents(w, r)
+		return
+	case r.Method == http.MethodGet && r.URL.Path == "/api/status":
+		ss.writeStatus(w)
+		return
+	case r.Method == http.MethodGet && r.URL.Path == "/api/controls":
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(ss.controls); err != nil {
+			level.Error(ss.logger).Log("err", err)
+		}
+		return
+	case len(ss.list) > 1 && r.Method == http.MethodGet && r.URL.Path == "/api/settings/export":
+		ss.export(w)
+		return
+	case len(ss.list) > 1 && r.Method == http.MethodPost && r.URL.Path == "/api/settings/import":
+		ss.importSettings(w, r)
+		return
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/api/moves/") && r.URL.Path != "/api/moves/current":
+		// Job IDs are unique across servos, so jobs
+		// are cancelled without naming their servo.
+		id, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/api/moves/"), 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		ss.cancelJob(w, id)
+		return
+	}
+	// Endpoints of a specific servo are prefixed with its name,
+	// e.g. /api/pan/left; all others address the default servo.
+	if rest := strings.TrimPrefix(r.URL.Path, "/api/"); rest != r.URL.Path {
+		name := rest
+		if i := strings.Index(rest, "/"); i >= 0 {
+			name = rest[:i]
+		}
+		if s, ok := ss.byName[name]; ok {
+			r = r.Clone(r.Context())
+			r.URL.Path = "/api" + strings.TrimPrefix(rest, name)
+			s.ServeHTTP(w, r)
+			return
+		}
+	}
+	ss.list[0].ServeHTTP(w, r)
+}
+
+// export writes the settings of all servos.
+func (ss *servos) export(w http.ResponseWriter) {
+	c := config{Servos: make([]servoConfig, 0, len(ss.list))}
+	for _, s := range ss.list {
+		s.mu.Lock()
+		c.Servos = append(c.Servos, *s.config())
+		s.mu.Unlock()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="servor.json"`)
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	// Only the list of servos and their pairs is exported, since
+	// the settings of a single servo are ignored alongside it.
+	if err := e.Encode(struct {
+		Servos []servoConfig `json:"servos"`
+		Pairs  []pairConfig  `json:"pairs,omitempty"`
+	}{c.Servos, ss.pairs}); err != nil {
+		level.Error(ss.logger).Log("err", err)
+	}
+}
+
+// importSettings replaces the settings of all servos.
+// The import must include every servo and must not change
+// any drivers, since drivers are only created on startup.
+func (ss *servos) importSettings(w http.ResponseWriter, r *http.Request) {
+	var c config
+	if err := decodeJSON(r.Body, &c, ss.strict); err != nil && err != io.EOF {
+		http.Error(w, fmt.Sprintf("failed to parse request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(c.Servos) != len(ss.list) {
+		http.Error(w, fmt.Sprintf("settings must include all %d servos; got %d", len(ss.list), len(c.Servos)), http.StatusBadRequest)
+		return
+	}
+	for i := range c.Servos {
+		c.Servos[i].defaults()
+	}
+	if err := c.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer ss.lockAll()()
+	for i := range c.Servos {
+		s, ok := ss.byName[c.Servos[i].Name]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown servo %q", c.Servos[i].Name), http.StatusBadRequest)
+			return
+		}
+		if err := s.importable(&c.Servos[i]); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	for i := range c.Servos {
+		s := ss.byName[c.Servos[i].Name]
+		s.stop(reasonReconfigured)
+		s.apply(&c.Servos[i])
+	}
+	level.Info(ss.logger).Log("msg", "imported settings")
+	w.WriteHeader(http.StatusOK)
+}
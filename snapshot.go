@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"google.golang.org/grpc/codes"
+)
+
+// The motions that can be resumed from a snapshot.
+var resumableMotions = []string{"oscillate", "demo", "patrol", "tour", "sequence"}
+
+// motionSpec describes the motion of a job and how far it got,
+// so that a snapshot can pause the job and resume it later.
+type motionSpec struct {
+	Motion      string       `json:"motion"`
+	Oscillation *oscillation `json:"oscillation,omitempty"`
+	Demo        *demo        `json:"demo,omitempty"`
+	Patrol      *patrol      `json:"patrol,omitempty"`
+	Tour        tour         `json:"tour,omitempty"`
+	Sequence    sequence     `json:"sequence,omitempty"`
+	// Step is the index of the leg of a tour or of
+	// the preset of a patrol at which the job got.
+	Step int `json:"step,omitempty"`
+	// Offset is the number of seconds into an oscillation
+	// or a sequence at which the job got.
+	Offset float64 `json:"offset,omitempty"`
+}
+
+// validate checks that the motion can be started on a servo
+// with the given presets and range.
+func (m *motionSpec) validate(presets map[string]float64, min, max float64) error {
+	if m.Step < 0 {
+		return errors.New("step must not be negative")
+	}
+	if m.Offset < 0 {
+		return errors.New("offset must not be negative")
+	}
+	switch m.Motion {
+	case "oscillate":
+		if m.Oscillation == nil || m.Oscillation.Center == nil {
+			return errors.New("oscillate requires an oscillation with a center")
+		}
+		return m.Oscillation.validate()
+	case "demo":
+		if m.Demo == nil || m.Demo.Min == nil || m.Demo.Max == nil {
+			return errors.New("demo requires a demo with min and max")
+		}
+		return m.Demo.validate()
+	case "patrol":
+		if m.Patrol == nil {
+			return errors.New("patrol requires a patrol")
+		}
+		return m.Patrol.validate(presets)
+	case "tour":
+		if m.Step >= len(m.Tour) {
+			return fmt.Errorf("step must be less than the number of legs of the tour; got %d", m.Step)
+		}
+		return m.Tour.validate(presets)
+	case "sequence":
+		return m.Sequence.validate(min, max)
+	}
+	return fmt.Errorf("motion must be one of %s; got %q", strings.Join(resumableMotions, ", "), m.Motion)
+}
+
+// startMotion starts the described motion as a job, from where it got.
+// The motion must be valid.
+// The caller must hold the lock.
+func (s *servor) startMotion(m motionSpec) *job {
+	var fn func(context.Context)
+	switch m.Motion {
+	case "oscillate":
+		o := *m.Oscillation
+		fn = func(ctx context.Context) { s.oscillate(ctx, o, m.Offset) }
+	case "demo":
+		d := *m.Demo
+		fn = func(ctx context.Context) { s.demo(ctx, d) }
+	case "patrol":
+		p := *m.Patrol
+		fn = func(ctx context.Context) { s.runPatrol(ctx, p, m.Step) }
+	case "tour":
+		fn = func(ctx context.Context) { s.runTour(ctx, m.Tour, m.Step) }
+	case "sequence":
+		fn = func(ctx context.Context) { s.runSequence(ctx, m.Sequence, m.Offset) }
+	}
+	j := s.start(m.Motion, fn)
+	j.spec = &m
+	return j
+}
+
+// progress records how far the running job got, if it can be resumed.
+// Motions that run without a job, e.g. the tours of the soak command,
+// record nothing.
+// The caller must hold the lock and check that the motion was not stopped.
+func (s *servor) progress(step int, offset float64) {
+	if s.job == nil || s.job.spec == nil {
+		return
+	}
+	s.job.spec.Step, s.job.spec.Offset = step, offset
+}
+
+// snapshot is the runtime state of all servos, from which
+// servor can resume, e.g. after maintenance or a restart.
+type snapshot struct {
+	Taken  time.Time       `json:"taken"`
+	Servos []servoSnapshot `json:"servos"`
+}
+
+// servoSnapshot is the runtime state of a servo.
+type servoSnapshot struct {
+	Servo    string  `json:"servo"`
+	Position float64 `json:"position"`
+	// Min, Max, and Home are the soft limits of the servo,
+	// which calibration and imported settings may have changed.
+	Min  float64 `json:"min"`
+	Max  float64 `json:"max"`
+	Home float64 `json:"home"`
+	// Job is the job that the snapshot paused, if it can be resumed.
+	Job *pausedJob `json:"job,omitempty"`
+	// Suspended are the sources that policies suspended, if any.
+	Suspended map[string]suspension `json:"suspended,omitempty"`
+}
+
+// pausedJob is a job that a snapshot paused.
+type pausedJob struct {
+	ID uint64 `json:"id"`
+	// Priority and Source are those of the command that
+	// started the job, which are given for reference.
+	Priority string `json:"priority"`
+	Source   string `json:"source,omitempty"`
+	motionSpec
+}
+
+// snapshot returns the runtime state of all servos
+// and pauses their running jobs.
+func (ss *servos) snapshot() snapshot {
+	sn := snapshot{Taken: time.Now(), Servos: make([]servoSnapshot, 0, len(ss.list))}
+	for _, s := range ss.list {
+		s.mu.Lock()
+		st := servoSnapshot{Servo: s.name, Position: s.position, Min: s.min, Max: s.max, Home: s.home, Suspended: s.activeSuspensions()}
+		if j := s.job; j != nil && j.spec != nil {
+			st.Job = &pausedJob{ID: j.ID, Priority: j.Priority, Source: j.Source, motionSpec: *j.spec}
+		}
+		// Jobs that cannot be resumed, e.g. jogs, are stopped all the same.
+		s.stop(reasonPaused)
+		s.mu.Unlock()
+		sn.Servos = append(sn.Servos, st)
+	}
+	return sn
+}
+
+// snapshotRequest pauses all servos and responds with their runtime state.
+func (ss *servos) snapshotRequest(w http.ResponseWriter) {
+	sn := ss.snapshot()
+	level.Info(ss.logger).Log("msg", "took snapshot")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="servor-snapshot.json"`)
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	if err := e.Encode(sn); err != nil {
+		level.Error(ss.logger).Log("err", err)
+	}
+}
+
+// restoreRequest restores the runtime state of the servos in the
+// snapshot in the body of the request: it applies their soft limits
+// and suspensions, moves them to their positions, and resumes their
+// paused jobs. Servos that the snapshot omits are left alone.
+func (ss *servos) restoreRequest(w http.ResponseWriter, r *http.Request) {
+	p, err := parsePriority(r, priorityManual)
+	if err != nil {
+		priorityError(w, err)
+		return
+	}
+	source, err := parseSource(r, ss.policies)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var sn snapshot
+	if err := decodeJSON(r.Body, &sn, ss.strict); err != nil {
+		http.Error(w, fmt.Sprintf("failed to parse request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	defer ss.lockAll()()
+	configs := make([]*servoConfig, len(sn.Servos))
+	for i, st := range sn.Servos {
+		s, ok := ss.byName[st.Servo]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown servo %q", st.Servo), http.StatusBadRequest)
+			return
+		}
+		c := s.config()
+		home := st.Home
+		c.Min, c.Max, c.Home = st.Min, st.Max, &home
+		if err := c.validate(); err != nil {
+			http.Error(w, fmt.Sprintf("invalid snapshot of servo %q: %v", st.Servo, err), http.StatusBadRequest)
+			return
+		}
+		if st.Job != nil {
+			if err := st.Job.validate(c.Presets, c.Min, c.Max); err != nil {
+				http.Error(w, fmt.Sprintf("invalid job of servo %q: %v", st.Servo, err), http.StatusBadRequest)
+				return
+			}
+			if err := s.checkContinuous(); err != nil {
+				http.Error(w, fmt.Sprintf("cannot resume the job of servo %q: %v", st.Servo, err), http.StatusForbidden)
+				return
+			}
+		}
+		configs[i] = c
+	}
+	id := identityFrom(r.Context())
+	for _, st := range sn.Servos {
+		s := ss.byName[st.Servo]
+		s.client = id
+		err := s.command(p, source)
+		s.client = nil
+		if err != nil {
+			writeMoveError(w, err)
+			return
+		}
+	}
+	jobs := make([]job, 0, len(sn.Servos))
+	for i, st := range sn.Servos {
+		s := ss.byName[st.Servo]
+		s.stop(reasonSuperseded)
+		if c := configs[i]; c.Min != s.min || c.Max != s.max || *c.Home != s.home {
+			s.apply(c)
+		}
+		for source, sp := range st.Suspended {
+			if time.Now().Before(sp.Until) {
+				s.suspensions[source] = sp
+			}
+		}
+		if err := s.move(st.Position); err != nil {
+			if moveStatus(err) == codes.Unknown {
+				level.Error(s.logger).Log("err", err)
+			}
+			writeMoveError(w, err)
+			return
+		}
+		if st.Job != nil {
+			jobs = append(jobs, *s.startMotion(st.Job.motionSpec))
+		}
+	}
+	level.Info(ss.logger).Log("msg", "restored snapshot", "taken", sn.Taken.Format(time.RFC3339), "jobs", len(jobs))
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(jobs); err != nil {
+		level.Error(ss.logger).Log("err", err)
+	}
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"math"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// BER tags of the SNMP types that servor uses.
+const (
+	berInteger      = 0x02
+	berOctetString  = 0x04
+	berNull         = 0x05
+	berOID          = 0x06
+	berSequence     = 0x30
+	berCounter32    = 0x41
+	berTimeTicks    = 0x43
+	berNoSuchObject = 0x80
+	berEndOfMIBView = 0x82
+)
+
+// SNMP PDU types.
+const (
+	snmpGetRequest = 0xa0
+	snmpGetNext    = 0xa1
+	snmpResponse   = 0xa2
+	snmpSetRequest = 0xa3
+	snmpGetBulk    = 0xa5
+)
+
+const (
+	snmpVersion1  = 0
+	snmpVersion2c = 1
+	// Error statuses of responses.
+	snmpNoSuchName  = 2
+	snmpNotWritable = 17
+	// snmpMaxResponse keeps responses to GetBulk within a datagram.
+	snmpMaxResponse   = 1400
+	snmpMaxRepetition = 64
+)
+
+// snmpRoot is the root of the MIB of servor. It lies under the
+// netSnmpPlaypen arc, which Net-SNMP reserves for experiments,
+// so that servor does not need an enterprise number of its own.
+// The MIB is in SERVOR-MIB.txt.
+var snmpRoot = []uint32{1, 3, 6, 1, 4, 1, 8072, 9999, 9999, 1}
+
+var errMalformedSNMP = errors.New("malformed SNMP message")
+
+// snmpObject is an object of the MIB with its BER-encoded value.
+type snmpObject struct {
+	oid   []uint32
+	value []byte
+}
+
+// snmpAgent is a read-only SNMP v1 and v2c agent that exposes the
+// positions, move counters, and health of the servos and the host,
+// for monitoring with network management systems that cannot
+// scrape Prometheus.
+type snmpAgent struct {
+	community string
+	servos    *servos
+	system    *system
+	started   time.Time
+	logger    log.Logger
+}
+
+// serve answers requests until the connection is closed.
+func (a *snmpAgent) serve(conn net.PacketConn) error {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		rsp, err := a.handle(buf[:n])
+		if err != nil {
+			level.Debug(a.logger).Log("msg", "dropping SNMP request", "client", addr, "err", err)
+			continue
+		}
+		if _, err := conn.WriteTo(rsp, addr); err != nil {
+			level.Warn(a.logger).Log("msg", "failed to send SNMP response", "client", addr, "err", err)
+		}
+	}
+}
+
+// handle answers a request. Requests with another community
+// are dropped, as SNMP agents do.
+func (a *snmpAgent) handle(req []byte) ([]byte, error) {
+	tag, msg, _, err := berRead(req)
+	if err != nil || tag != berSequence {
+		return nil, errMalformedSNMP
+	}
+	var fields [3][]byte
+	for i := range fields {
+		if tag, fields[i], msg, err = berRead(msg); err != nil {
+			return nil, err
+		}
+	}
+	pduTag := tag
+	version := berInt64(fields[0])
+	if version != snmpVersion1 && version != snmpVersion2c {
+		return nil, errors.New("unsupported SNMP version")
+	}
+	if subtle.ConstantTimeCompare(fields[1], []byte(a.community)) != 1 {
+		return nil, errors.New("unknown SNMP community")
+	}
+	pdu := fields[2]
+	var header [3][]byte
+	for i := range header {
+		if _, header[i], pdu, err = berRead(pdu); err != nil {
+			return nil, err
+		}
+	}
+	_, list, _, err := berRead(pdu)
+	if err != nil {
+		return nil, err
+	}
+	var oids [][]uint32
+	for len(list) > 0 {
+		var vb, raw []byte
+		if _, vb, list, err = berRead(list); err != nil {
+			return nil, err
+		}
+		if _, raw, _, err = berRead(vb); err != nil {
+			return nil, err
+		}
+		oids = append(oids, parseOID(raw))
+	}
+
+	objects := a.objects()
+	var status, index int
+	var bindings [][]byte
+	bind := func(oid []uint32, value []byte) {
+		bindings = append(bindings, berTLV(berSequence, berTLV(berOID, encodeOID(oid)), value))
+	}
+	// fail answers with the requested bindings and an error, as SNMPv1 does.
+	fail := func(s, i int) {
+		status, index, bindings = s, i, nil
+		for _, oid := range oids {
+			bind(oid, berTLV(berNull))
+		}
+	}
+	switch pduTag {
+	case snmpGetRequest:
+		for i, oid := range oids {
+			o := findObject(objects, oid)
+			if o == nil && version == snmpVersion1 {
+				fail(snmpNoSuchName, i+1)
+				break
+			}
+			if o == nil {
+				bind(oid, berTLV(berNoSuchObject))
+				continue
+			}
+			bind(oid, o.value)
+		}
+	case snmpGetNext:
+		for i, oid := range oids {
+			o := nextObject(objects, oid)
+			if o == nil && version == snmpVersion1 {
+				fail(snmpNoSuchName, i+1)
+				break
+			}
+			if o == nil {
+				bind(oid, berTLV(berEndOfMIBView))
+				continue
+			}
+			bind(o.oid, o.value)
+		}
+	case snmpGetBulk:
+		if version == snmpVersion1 {
+			return nil, errors.New("GetBulk is not supported by SNMPv1")
+		}
+		nonRepeaters, repetitions := int(berInt64(header[1])), int(berInt64(header[2]))
+		if nonRepeaters < 0 {
+			nonRepeaters = 0
+		}
+		if nonRepeaters > len(oids) {
+			nonRepeaters = len(oids)
+		}
+		if repetitions > snmpMaxRepetition {
+			repetitions = snmpMaxRepetition
+		}
+		for _, oid := range oids[:nonRepeaters] {
+			if o := nextObject(objects, oid); o != nil {
+				bind(o.oid, o.value)
+			} else {
+				bind(oid, berTLV(berEndOfMIBView))
+			}
+		}
+		last := oids[nonRepeaters:]
+		for r := 0; r < repetitions && len(last) > 0; r++ {
+			size, done := 0, true
+			for i, oid := range last {
+				o := nextObject(objects, oid)
+				if o == nil {
+					bind(oid, berTLV(berEndOfMIBView))
+					continue
+				}
+				bind(o.oid, o.value)
+				last[i], done = o.oid, false
+			}
+			for _, b := range bindings {
+				size += len(b)
+			}
+			// Stop before the response is too large for a datagram.
+			if done || size > snmpMaxResponse {
+				break
+			}
+		}
+	case snmpSetRequest:
+		// All objects are read-only.
+		if version == snmpVersion1 {
+			fail(snmpNoSuchName, 1)
+		} else {
+			fail(snmpNotWritable, 1)
+		}
+	default:
+		return nil, errors.New("unsupported SNMP PDU")
+	}
+
+	return berTLV(berSequence,
+		berTLV(berInteger, fields[0]),
+		berTLV(berOctetString, fields[1]),
+		berTLV(snmpResponse,
+			berTLV(berInteger, header[0]),
+			berInt(berInteger, int64(status)),
+			berInt(berInteger, int64(index)),
+			berTLV(berSequence, bindings...),
+		),
+	), nil
+}
+
+// objects returns the objects of the MIB in order. The scalars
+// describe servor and the host, followed by the table of servos;
+// SERVOR-MIB.txt describes each object.
+func (a *snmpAgent) objects() []snmpObject {
+	var objects []snmpObject
+	scalar := func(n uint32, value []byte) {
+		objects = append(objects, snmpObject{oid: snmpOID(1, n, 0), value: value})
+	}
+	scalar(1, berInt(berInteger, int64(len(a.servos.list))))
+	scalar(2, berInt(berTimeTicks, int64(uint32(time.Since(a.started)/(10*time.Millisecond)))))
+	var state systemState
+	if a.system != nil {
+		a.system.mu.Lock()
+		state = a.system.state
+		a.system.mu.Unlock()
+	}
+	if state.SoCTemperature != nil {
+		scalar(3, berInt(berInteger, int64(math.Round(*state.SoCTemperature*10))))
+	}
+	scalar(4, truthValue(state.ThermalThrottled))
+	scalar(5, truthValue(state.MotionInhibited))
+	if state.SupplyVoltage != nil {
+		scalar(6, berInt(berInteger, int64(math.Round(*state.SupplyVoltage*1000))))
+	}
+	for i, s := range a.servos.list {
+		s.mu.Lock()
+		objects = append(objects, s.servoObjects(uint32(i+1))...)
+		s.mu.Unlock()
+	}
+	sort.Slice(objects, func(i, j int) bool { return oidLess(objects[i].oid, objects[j].oid) })
+	return objects
+}
+
+// servoObjects returns the row of the servo in the table of servos,
+// whose columns are the name, driver, position, target, motion,
+// move counter, error counter, health, and last error of the servo.
+// The caller must hold the lock.
+func (s *servor) servoObjects(index uint32) []snmpObject {
+	scale := func(p float64) []byte {
+		return berInt(berInteger, int64(math.Round((p-s.min)/(s.max-s.min)*10000)))
+	}
+	health := int64(1)
+	if s.failed {
+		health = 2
+	}
+	var lastError string
+	if s.lastError != nil {
+		lastError = s.lastError.Message
+	}
+	values := [][]byte{
+		berTLV(berOctetString, []byte(s.name)),
+		berTLV(berOctetString, []byte(s.driver.Type)),
+		scale(s.position),
+		scale(s.state().Target),
+		truthValue(s.job != nil),
+		berInt(berCounter32, int64(uint32(s.moves))),
+		berInt(berCounter32, int64(uint32(s.deviceErrors))),
+		berInt(berInteger, health),
+		berTLV(berOctetString, []byte(lastError)),
+	}
+	objects := make([]snmpObject, len(values))
+	for i, v := range values {
+		objects[i] = snmpObject{oid: snmpOID(2, 1, uint32(i+2), index), value: v}
+	}
+	return objects
+}
+
+// snmpOID returns the OID of the given suffix under the root.
+func snmpOID(suffix ...uint32) []uint32 {
+	return append(append([]uint32{}, snmpRoot...), suffix...)
+}
+
+// truthValue encodes a boolean as a TruthValue, i.e. 1 for true and 2 for false.
+func truthValue(b bool) []byte {
+	if b {
+		return berInt(berInteger, 1)
+	}
+	return berInt(berInteger, 2)
+}
+
+// findObject returns the object with the given OID, if any.
+func findObject(objects []snmpObject, oid []uint32) *snmpObject {
+	i := sort.Search(len(objects), func(i int) bool { return !oidLess(objects[i].oid, oid) })
+	if i < len(objects) && !oidLess(oid, objects[i].oid) {
+		return &objects[i]
+	}
+	return nil
+}
+
+// nextObject returns the first object after the given OID, if any.
+func nextObject(objects []snmpObject, oid []uint32) *snmpObject {
+	i := sort.Search(len(objects), func(i int) bool { return oidLess(oid, objects[i].oid) })
+	if i < len(objects) {
+		return &objects[i]
+	}
+	return nil
+}
+
+func oidLess(a, b []uint32) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// berRead reads the value of the first element and returns the rest.
+func berRead(b []byte) (byte, []byte, []byte, error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errMalformedSNMP
+	}
+	tag, n, i := b[0], int(b[1]), 2
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 3 || len(b) < 2+size {
+			return 0, nil, nil, errMalformedSNMP
+		}
+		n = 0
+		for ; i < 2+size; i++ {
+			n = n<<8 | int(b[i])
+		}
+	}
+	if len(b)-i < n {
+		return 0, nil, nil, errMalformedSNMP
+	}
+	return tag, b[i : i+n], b[i+n:], nil
+}
+
+// berTLV encodes an element with the given tag from its contents.
+func berTLV(tag byte, contents ...[]byte) []byte {
+	var value []byte
+	for _, c := range contents {
+		value = append(value, c...)
+	}
+	n := len(value)
+	b := []byte{tag}
+	if n < 0x80 {
+		b = append(b, byte(n))
+	} else {
+		var length []byte
+		for ; n > 0; n >>= 8 {
+			length = append([]byte{byte(n)}, length...)
+		}
+		b = append(append(b, 0x80|byte(len(length))), length...)
+	}
+	return append(b, value...)
+}
+
+// berInt encodes an integer of the given type in the fewest bytes.
+func berInt(tag byte, v int64) []byte {
+	var b []byte
+	for {
+		b = append([]byte{byte(v)}, b...)
+		if v >= -128 && v < 128 {
+			return berTLV(tag, b)
+		}
+		v >>= 8
+	}
+}
+
+func berInt64(b []byte) int64 {
+	var v int64
+	for i, c := range b {
+		if i == 0 && c&0x80 != 0 {
+			v = -1
+		}
+		v = v<<8 | int64(c)
+	}
+	return v
+}
+
+func encodeOID(oid []uint32) []byte {
+	if len(oid) < 2 {
+		return []byte{0}
+	}
+	b := []byte{byte(oid[0]*40 + oid[1])}
+	for _, id := range oid[2:] {
+		var sub []byte
+		for sub = []byte{byte(id & 0x7f)}; id >= 0x80; {
+			id >>= 7
+			sub = append([]byte{byte(id&0x7f) | 0x80}, sub...)
+		}
+		b = append(b, sub...)
+	}
+	return b
+}
+
+func parseOID(b []byte) []uint32 {
+	var ids []uint32
+	var id uint32
+	for _, c := range b {
+		id = id<<7 | uint32(c&0x7f)
+		if c&0x80 == 0 {
+			ids = append(ids, id)
+			id = 0
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	first := ids[0] / 40
+	if first > 2 {
+		first = 2
+	}
+	return append([]uint32{first, ids[0] - first*40}, ids[1:]...)
+}
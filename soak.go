@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// soakProgressInterval is how often the soak test logs its progress.
+const soakProgressInterval = time.Minute
+
+// The patterns of the soak test besides the tours and sequences of a servo.
+const (
+	// soakSweep moves from --min to --max and back.
+	soakSweep = "sweep"
+	// soakPresets visits every preset in the order of their names.
+	soakPresets = "presets"
+)
+
+// soakOptions configure a soak test.
+type soakOptions struct {
+	// Duration is how long to cycle the servos. Cycles that
+	// are running when it passes are completed.
+	Duration time.Duration
+	// Pattern is sweep, presets, or the name of a tour
+	// or sequence of every servo.
+	Pattern string
+	// Speed is the speed of the moves of the sweep and presets
+	// patterns in units per second; 0 moves immediately.
+	Speed float64
+	// Dwell is how long the sweep and presets patterns
+	// stay at each position, so that the servo can get there.
+	Dwell time.Duration
+}
+
+// soakResult summarizes the soak test of a servo.
+type soakResult struct {
+	servo  string
+	cycles int
+	writes uint64
+	errors uint64
+	// first, last, min, and max are durations of the cycles,
+	// and total is the sum of all of them.
+	first, last, min, max, total time.Duration
+	// positionError is the largest difference between the commanded
+	// and the measured position at the end of a cycle, if the driver
+	// reads back the position.
+	positionError *float64
+}
+
+// drift is how much longer the last cycle took than the first.
+func (r *soakResult) drift() time.Duration {
+	return r.last - r.first
+}
+
+// soak cycles every servo through the pattern until the duration
+// passes or the context is done, e.g. to validate a new mechanism
+// before deploying it, and returns the results.
+func soak(ctx context.Context, ss *servos, o soakOptions, logger log.Logger) ([]*soakResult, error) {
+	if o.Duration <= 0 {
+		return nil, fmt.Errorf("soak duration must be positive; got %s", o.Duration)
+	}
+	if o.Speed < 0 || o.Dwell < 0 {
+		return nil, errors.New("soak speed and dwell must not be negative")
+	}
+	for _, s := range ss.list {
+		if err := s.soakable(o.Pattern); err != nil {
+			return nil, fmt.Errorf("servo %q: %v", s.name, err)
+		}
+	}
+	level.Info(logger).Log("msg", "starting soak test", "pattern", o.Pattern, "duration", o.Duration)
+	deadline := time.Now().Add(o.Duration)
+	results := make([]*soakResult, len(ss.list))
+	var wg sync.WaitGroup
+	for i, s := range ss.list {
+		results[i] = &soakResult{servo: s.name}
+		wg.Add(1)
+		go func(s *servor, r *soakResult) {
+			defer wg.Done()
+			s.soak(ctx, o, deadline, r)
+		}(s, results[i])
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	t := time.NewTicker(soakProgressInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return results, nil
+		case <-t.C:
+			for i, s := range ss.list {
+				s.mu.Lock()
+				level.Info(logger).Log("msg", "soak test progress", "servo", s.name, "cycles", results[i].cycles, "remaining", time.Until(deadline).Round(time.Second))
+				s.mu.Unlock()
+			}
+		}
+	}
+}
+
+// soakable checks that the servo can run the pattern.
+func (s *servor) soakable(pattern string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch pattern {
+	case soakSweep:
+		return nil
+	case soakPresets:
+		if len(s.presets) == 0 {
+			return errors.New("the presets pattern requires presets")
+		}
+		return nil
+	}
+	if _, ok := s.tours[pattern]; ok {
+		return nil
+	}
+	if _, ok := s.sequences[pattern]; ok {
+		return nil
+	}
+	return fmt.Errorf("soak pattern must be %s, %s, or the name of a tour or sequence; got %q", soakSweep, soakPresets, pattern)
+}
+
+// soak runs cycles of the pattern until the deadline passes
+// or the context is done and records them in the result.
+func (s *servor) soak(ctx context.Context, o soakOptions, deadline time.Time, r *soakResult) {
+	s.mu.Lock()
+	moves, failed := s.moves, s.deviceErrors
+	s.source = sourceSoak
+	s.mu.Unlock()
+	_, feedback := s.device.driver.(feedbacker)
+	for time.Now().Before(deadline) {
+		start := time.Now()
+		s.soakCycle(ctx, o)
+		if ctx.Err() != nil {
+			// Interrupted cycles are not counted.
+			break
+		}
+		d := time.Since(start)
+		if feedback {
+			s.poll()
+		}
+		s.mu.Lock()
+		if r.cycles == 0 {
+			r.first, r.min = d, d
+		}
+		r.cycles++
+		r.last, r.total = d, r.total+d
+		if d < r.min {
+			r.min = d
+		}
+		if d > r.max {
+			r.max = d
+		}
+		if feedback && s.feedback != nil {
+			e := math.Abs(s.feedback.Position - s.position)
+			if r.positionError == nil || e > *r.positionError {
+				r.positionError = &e
+			}
+		}
+		s.mu.Unlock()
+	}
+	s.mu.Lock()
+	r.writes = s.moves + s.deviceErrors - moves - failed
+	r.errors = s.deviceErrors - failed
+	s.mu.Unlock()
+}
+
+// soakCycle runs one cycle of the pattern.
+func (s *servor) soakCycle(ctx context.Context, o soakOptions) {
+	s.mu.Lock()
+	var targets []float64
+	t, isTour := s.tours[o.Pattern]
+	sq, isSequence := s.sequences[o.Pattern]
+	switch o.Pattern {
+	case soakSweep:
+		targets = []float64{s.max, s.min}
+	case soakPresets:
+		names := make([]string, 0, len(s.presets))
+		for name := range s.presets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			targets = append(targets, s.presets[name])
+		}
+	}
+	s.mu.Unlock()
+	switch {
+	case isTour:
+		s.runTour(ctx, t, 0)
+	case isSequence:
+		s.runSequence(ctx, sq, 0)
+	}
+	for _, target := range targets {
+		s.glide(ctx, target, o.Speed, "")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(o.Dwell):
+		}
+	}
+}
+
+// writeSoakReport writes a table of the results.
+func writeSoakReport(w io.Writer, results []*soakResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVO\tCYCLES\tWRITES\tERRORS\tERROR RATE\tCYCLE MIN/MEAN/MAX\tDRIFT\tPOSITION ERROR")
+	for _, r := range results {
+		rate := "-"
+		if r.writes != 0 {
+			rate = fmt.Sprintf("%.3g%%", float64(r.errors)/float64(r.writes)*100)
+		}
+		timing, drift := "-", "-"
+		if r.cycles != 0 {
+			mean := r.total / time.Duration(r.cycles)
+			timing = strings.Join([]string{soakRound(r.min), soakRound(mean), soakRound(r.max)}, "/")
+			drift = soakRound(r.drift())
+			if r.drift() > 0 {
+				drift = "+" + drift
+			}
+		}
+		positionError := "-"
+		if r.positionError != nil {
+			positionError = fmt.Sprintf("%.4g", *r.positionError)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\t%s\t%s\t%s\n", r.servo, r.cycles, r.writes, r.errors, rate, timing, drift, positionError)
+	}
+	return tw.Flush()
+}
+
+// soakRound formats a duration to the millisecond.
+func soakRound(d time.Duration) string {
+	return d.Round(time.Millisecond).String()
+}
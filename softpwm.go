@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// softPWM generates PWM signals in software on arbitrary GPIO pins
+// using the kernel's GPIO interface. It is a last resort for boards
+// without hardware PWM or a PWM daemon: the timing of the pulses
+// depends on the Go scheduler and the load of the system, so expect
+// jitter, and each pin keeps a CPU core partially busy.
+type softPWM struct {
+	root      string
+	frequency float64
+
+	mu   sync.Mutex
+	pins map[int]*softPin
+}
+
+// softPin is a pin driven by a goroutine.
+type softPin struct {
+	// duty holds the bits of the float64 duty cycle.
+	duty uint64
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newSoftPWM(root string, frequency float64) *softPWM {
+	return &softPWM{root: root, frequency: frequency, pins: make(map[int]*softPin)}
+}
+
+// Set implements driver.
+func (s *softPWM) Set(pin int, value float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p, ok := s.pins[pin]; ok {
+		atomic.StoreUint64(&p.duty, math.Float64bits(value))
+		return nil
+	}
+	f, err := s.open(pin)
+	if err != nil {
+		return err
+	}
+	p := &softPin{duty: math.Float64bits(value), done: make(chan struct{})}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer f.Close()
+		s.generate(f, p)
+	}()
+	s.pins[pin] = p
+	return nil
+}
+
+// open exports the pin, configures it as an output, and opens its value.
+func (s *softPWM) open(pin int) (*os.File, error) {
+	dir := filepath.Join(s.root, fmt.Sprintf("gpio%d", pin))
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err := ioutil.WriteFile(filepath.Join(s.root, "export"), []byte(strconv.Itoa(pin)), 0200); err != nil {
+			return nil, fmt.Errorf("failed to export GPIO %d: %v", pin, err)
+		}
+	}
+	// udev may take a moment to make the new files writable.
+	var err error
+	for i := 0; i < 10; i++ {
+		if err = ioutil.WriteFile(filepath.Join(dir, "direction"), []byte("out"), 0644); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure GPIO %d as an output: %v", pin, err)
+	}
+	f, err := os.OpenFile(filepath.Join(dir, "value"), os.O_WRONLY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GPIO %d: %v", pin, err)
+	}
+	return f, nil
+}
+
+// generate drives the pin until it is stopped.
+func (s *softPWM) generate(f *os.File, p *softPin) {
+	// Keeping the goroutine on one thread reduces jitter.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	high, low := []byte("1"), []byte("0")
+	period := time.Duration(float64(time.Second) / s.frequency)
+	next := time.Now()
+	for {
+		select {
+		case <-p.done:
+			f.WriteAt(low, 0)
+			return
+		default:
+		}
+		duty := math.Float64frombits(atomic.LoadUint64(&p.duty))
+		width := time.Duration(duty * float64(period))
+		if width > 0 {
+			f.WriteAt(high, 0)
+			sleepUntil(next.Add(width))
+		}
+		f.WriteAt(low, 0)
+		next = next.Add(period)
+		sleepUntil(next)
+	}
+}
+
+// sleepUntil sleeps until shortly before the deadline and then spins,
+// since sleeping alone typically overshoots by tens of microseconds.
+func sleepUntil(deadline time.Time) {
+	const spin = 200 * time.Microsecond
+	if d := time.Until(deadline) - spin; d > 0 {
+		time.Sleep(d)
+	}
+	for time.Now().Before(deadline) {
+	}
+}
+
+// Close implements driver.
+// It stops all pulses, leaving the pins low.
+func (s *softPWM) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for pin, p := range s.pins {
+		close(p.done)
+		p.wg.Wait()
+		delete(s.pins, pin)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// The sources of commands, which tag the jobs and events that
+// the commands cause, so that conflicts between automations,
+// e.g. a scheduled tour and an MQTT rule, can be told apart.
+const (
+	// sourceAPI is the source of commands of API clients
+	// that neither authenticate nor declare a source.
+	sourceAPI = "api"
+	// sourceUI is the source of commands of the UI.
+	sourceUI = "ui"
+	// sourceScript, sourceScheduler, and sourceGPIOButton are sources
+	// that API clients declare, e.g. a cron job or a daemon that
+	// moves a servo when a button wired to a GPIO pin is pressed.
+	sourceScript     = "script"
+	sourceScheduler  = "scheduler"
+	sourceGPIOButton = "gpio-button"
+	sourceMQTT       = "mqtt"
+	sourceBLE        = "ble"
+	sourceConsole    = "console"
+	sourceDBus       = "dbus"
+	sourceModbus     = "modbus"
+	// sourceReplay is the source of command logs replayed by the replay command.
+	sourceReplay = "replay"
+	// sourceSoak is the source of the moves of the soak command.
+	sourceSoak = "soak"
+	// sourceServor is the source of moves that servor makes on
+	// its own, e.g. to drive servos home on startup or to park them.
+	sourceServor = "servor"
+)
+
+// declaredSources are the sources that clients of the HTTP and gRPC APIs
+// can declare, in addition to the sources given in the configuration.
+var declaredSources = []string{sourceUI, sourceScript, sourceScheduler, sourceGPIOButton}
+
+// builtinSources are all sources of servor, except for tokens.
+var builtinSources = []string{sourceAPI, sourceUI, sourceScript, sourceScheduler, sourceGPIOButton, sourceMQTT, sourceBLE, sourceConsole, sourceDBus, sourceModbus, sourceReplay, sourceSoak, sourceServor}
+
+// tokenSourcePrefix prefixes the name of the token of API clients
+// whose token has no configured source.
+const tokenSourcePrefix = "api-token:"
+
+// apiSource returns the source of a command of an API client: the
+// source of its token, if any, or else the declared source, if any.
+// Clients with a token may only declare the source of their token,
+// since they could otherwise declare whichever source a policy favors.
+func apiSource(declared string, id *identity, ps *policies) (string, error) {
+	if id != nil {
+		if declared != "" && declared != id.source {
+			return "", fmt.Errorf("token %q may only declare its source %q; got %q", id.name, id.source, declared)
+		}
+		return id.source, nil
+	}
+	if declared != "" {
+		sources := ps.declarable()
+		for _, s := range sources {
+			if s == declared {
+				return declared, nil
+			}
+		}
+		return "", fmt.Errorf("source must be one of %s; got %q", strings.Join(sources, ", "), declared)
+	}
+	return sourceAPI, nil
+}
+
+// parseSource returns the source of the command of the request,
+// which clients declare with the source query parameter.
+func parseSource(r *http.Request, ps *policies) (string, error) {
+	return apiSource(r.URL.Query().Get("source"), identityFrom(r.Context()), ps)
+}
+
+// grpcSource returns the source of the command of the gRPC call with
+// the given context, which clients declare with the source metadata,
+// i.e. the Grpc-Metadata-Source header for the REST gateway.
+func grpcSource(ctx context.Context, ps *policies) (string, error) {
+	var declared string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("source"); len(v) > 0 {
+			declared = v[0]
+		}
+	}
+	return apiSource(declared, identityFrom(ctx), ps)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func TestAPISource(t *testing.T) {
+	ps := newPolicies(&config{Sources: []string{"tracker"}})
+	tablet := &identity{name: "tablet", source: sourceUI}
+	daemon := &identity{name: "daemon", source: tokenSourcePrefix + "daemon"}
+	for _, tc := range []struct {
+		name     string
+		declared string
+		id       *identity
+		ps       *policies
+		expected string
+		err      bool
+	}{
+		{name: "undeclared", ps: ps, expected: sourceAPI},
+		{name: "built-in", declared: sourceScheduler, ps: ps, expected: sourceScheduler},
+		{name: "configured", declared: "tracker", ps: ps, expected: "tracker"},
+		{name: "configured without policies", declared: "tracker", err: true},
+		{name: "built-in without policies", declared: sourceUI, expected: sourceUI},
+		{name: "unknown", declared: "cron", ps: ps, err: true},
+		{name: "internal", declared: sourceServor, ps: ps, err: true},
+		{name: "token", id: daemon, ps: ps, expected: tokenSourcePrefix + "daemon"},
+		{name: "token with source", id: tablet, ps: ps, expected: sourceUI},
+		{name: "token declaring its source", declared: sourceUI, id: tablet, ps: ps, expected: sourceUI},
+		{name: "token declaring its default source", declared: tokenSourcePrefix + "daemon", id: daemon, ps: ps, expected: tokenSourcePrefix + "daemon"},
+		{name: "token declaring another source", declared: sourceUI, id: daemon, ps: ps, err: true},
+		{name: "token declaring a configured source", declared: "tracker", id: tablet, ps: ps, err: true},
+	} {
+		got, err := apiSource(tc.declared, tc.id, tc.ps)
+		if (err != nil) != tc.err {
+			t.Errorf("%s: expected an error: %t; got %v", tc.name, tc.err, err)
+			continue
+		}
+		if got != tc.expected {
+			t.Errorf("%s: expected source %q; got %q", tc.name, tc.expected, got)
+		}
+	}
+}
+
+func TestParseSource(t *testing.T) {
+	ps := newPolicies(&config{})
+	daemon := &identity{name: "daemon", source: tokenSourcePrefix + "daemon"}
+
+	r := httptest.NewRequest(http.MethodPost, "/api/left?source=script", nil)
+	if got, err := parseSource(r, ps); err != nil || got != sourceScript {
+		t.Errorf("query: expected source %q; got %q, %v", sourceScript, got, err)
+	}
+	r = r.WithContext(context.WithValue(r.Context(), identityKey{}, daemon))
+	if _, err := parseSource(r, ps); err == nil {
+		t.Errorf("query with token: expected an error")
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("source", sourceGPIOButton))
+	if got, err := grpcSource(ctx, ps); err != nil || got != sourceGPIOButton {
+		t.Errorf("metadata: expected source %q; got %q, %v", sourceGPIOButton, got, err)
+	}
+	if _, err := grpcSource(context.WithValue(ctx, identityKey{}, daemon), ps); err == nil {
+		t.Errorf("metadata with token: expected an error")
+	}
+	if got, err := grpcSource(context.WithValue(context.Background(), identityKey{}, daemon), ps); err != nil || got != daemon.source {
+		t.Errorf("token: expected source %q; got %q, %v", daemon.source, got, err)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/xml"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+const (
+	ssdpAddress = "239.255.255.250:1900"
+	// ssdpDeviceType is the generic device type, which Windows
+	// lists under other devices and opens in a browser.
+	ssdpDeviceType = "urn:schemas-upnp-org:device:Basic:1"
+	// ssdpMaxAge is how long in seconds clients may cache an advertisement.
+	ssdpMaxAge = 1800
+	// descriptionPath is where the UPnP device description is served.
+	descriptionPath  = "/upnp/description.xml"
+	ssdpServerHeader = "Linux/1.0 UPnP/1.0 servor/1.0"
+)
+
+// ssdpServer advertises servor on the local network with SSDP, so that
+// UPnP clients, e.g. the network browser of Windows, can find the UI.
+type ssdpServer struct {
+	uuid   string
+	name   string
+	scheme string
+	host   string
+	port   string
+	group  *net.UDPAddr
+	conn   *net.UDPConn
+	logger log.Logger
+}
+
+// newSSDPServer joins the SSDP multicast group for a server
+// listening on the given address.
+func newSSDPServer(listen string, secure bool, logger log.Logger) (*ssdpServer, error) {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse listen address: %v", err)
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		host = ""
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get hostname: %v", err)
+	}
+	group, err := net.ResolveUDPAddr("udp4", ssdpAddress)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return nil, fmt.Errorf("failed to join SSDP multicast group: %v", err)
+	}
+	// The UUID must not change across restarts,
+	// so it is derived from the host and address.
+	sum := md5.Sum([]byte(hostname + listen))
+	sum[6] = sum[6]&0x0f | 0x30
+	sum[8] = sum[8]&0x3f | 0x80
+	scheme := "http"
+	if secure {
+		scheme = "https"
+	}
+	return &ssdpServer{
+		uuid:   fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:]),
+		name:   "servor on " + hostname,
+		scheme: scheme,
+		host:   host,
+		port:   port,
+		group:  group,
+		conn:   conn,
+		logger: logger,
+	}, nil
+}
+
+// location returns the URL of the device description as reachable
+// from the given address, i.e. on the interface that routes to it.
+func (s *ssdpServer) location(to *net.UDPAddr) (string, error) {
+	host := s.host
+	if host == "" {
+		// Dialing UDP sends nothing; it only picks the local address.
+		c, err := net.DialUDP("udp4", nil, to)
+		if err != nil {
+			return "", fmt.Errorf("failed to find local address for %s: %v", to, err)
+		}
+		host = c.LocalAddr().(*net.UDPAddr).IP.String()
+		c.Close()
+	}
+	return fmt.Sprintf("%s://%s%s", s.scheme, net.JoinHostPort(host, s.port), descriptionPath), nil
+}
+
+// usn returns the unique service name of the given notification or search target.
+func (s *ssdpServer) usn(target string) string {
+	if strings.HasPrefix(target, "uuid:") {
+		return target
+	}
+	return "uuid:" + s.uuid + "::" + target
+}
+
+// targets returns the notification types that servor advertises.
+func (s *ssdpServer) targets() []string {
+	return []string{"upnp:rootdevice", "uuid:" + s.uuid, ssdpDeviceType}
+}
+
+// run answers searches and advertises servor until the context is done,
+// when it announces that servor is leaving the network.
+func (s *ssdpServer) run(ctx context.Context) error {
+	go func() {
+		t := time.NewTicker(ssdpMaxAge / 2 * time.Second)
+		defer t.Stop()
+		for {
+			s.notify("ssdp:alive")
+			select {
+			case <-ctx.Done():
+				s.notify("ssdp:byebye")
+				s.conn.Close()
+				return
+			case <-t.C:
+			}
+		}
+	}()
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to read SSDP message: %v", err)
+		}
+		r, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf[:n])))
+		if err != nil || r.Method != "M-SEARCH" || r.Header.Get("MAN") != `"ssdp:discover"` {
+			continue
+		}
+		st := r.Header.Get("ST")
+		for _, t := range s.targets() {
+			if st != "ssdp:all" && st != t {
+				continue
+			}
+			// Clients ask for responses to be spread over MX seconds
+			// so that they are not flooded by all devices at once.
+			mx, _ := strconv.Atoi(r.Header.Get("MX"))
+			if mx < 1 || mx > 5 {
+				mx = 1
+			}
+			delay := time.Duration(rand.Int63n(int64(mx) * int64(time.Second)))
+			t := t
+			time.AfterFunc(delay, func() {
+				s.respond(from, t)
+			})
+		}
+	}
+}
+
+// respond answers a search for the given target.
+func (s *ssdpServer) respond(to *net.UDPAddr, target string) {
+	location, err := s.location(to)
+	if err != nil {
+		level.Warn(s.logger).Log("err", err)
+		return
+	}
+	msg := fmt.Sprintf("HTTP/1.1 200 OK\r\nCACHE-CONTROL: max-age=%d\r\nEXT:\r\nLOCATION: %s\r\nSERVER: %s\r\nST: %s\r\nUSN: %s\r\n\r\n",
+		ssdpMaxAge, location, ssdpServerHeader, target, s.usn(target))
+	if _, err := s.conn.WriteToUDP([]byte(msg), to); err != nil {
+		level.Warn(s.logger).Log("msg", "failed to answer SSDP search", "err", err)
+	}
+}
+
+// notify multicasts a notification of the given subtype for every target.
+func (s *ssdpServer) notify(nts string) {
+	location, err := s.location(s.group)
+	if err != nil {
+		level.Warn(s.logger).Log("err", err)
+		return
+	}
+	for _, t := range s.targets() {
+		msg := fmt.Sprintf("NOTIFY * HTTP/1.1\r\nHOST: %s\r\nCACHE-CONTROL: max-age=%d\r\nLOCATION: %s\r\nNT: %s\r\nNTS: %s\r\nSERVER: %s\r\nUSN: %s\r\n\r\n",
+			ssdpAddress, ssdpMaxAge, location, t, nts, ssdpServerHeader, s.usn(t))
+		if _, err := s.conn.WriteToUDP([]byte(msg), s.group); err != nil {
+			level.Warn(s.logger).Log("msg", "failed to send SSDP notification", "err", err)
+		}
+	}
+}
+
+type deviceDescription struct {
+	XMLName     xml.Name `xml:"urn:schemas-upnp-org:device-1-0 root"`
+	SpecVersion struct {
+		Major int `xml:"major"`
+		Minor int `xml:"minor"`
+	} `xml:"specVersion"`
+	Device struct {
+		DeviceType      string `xml:"deviceType"`
+		FriendlyName    string `xml:"friendlyName"`
+		Manufacturer    string `xml:"manufacturer"`
+		ManufacturerURL string `xml:"manufacturerURL"`
+		ModelName       string `xml:"modelName"`
+		UDN             string `xml:"UDN"`
+		// PresentationURL is resolved against the URL of the
+		// description, so it points at the UI on the same address.
+		PresentationURL string `xml:"presentationURL"`
+	} `xml:"device"`
+}
+
+// ServeHTTP serves the UPnP device description.
+func (s *ssdpServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	var d deviceDescription
+	d.SpecVersion.Major = 1
+	d.Device.DeviceType = ssdpDeviceType
+	d.Device.FriendlyName = s.name
+	d.Device.Manufacturer = "servor"
+	d.Device.ManufacturerURL = "https://github.com/squat/servor"
+	d.Device.ModelName = "servor"
+	d.Device.UDN = "uuid:" + s.uuid
+	d.Device.PresentationURL = "/"
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(d); err != nil {
+		level.Error(s.logger).Log("err", err)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// defaultStallDuration is how long the current must stay above
+// the limit before a servo is considered stalled.
+const defaultStallDuration = 0.5
+
+// stallConfig detects a servo that is stalled, e.g. jammed against an
+// obstruction, from the current that it draws, so that it can back off
+// before it burns out.
+type stallConfig struct {
+	// Current is the file from which to read the current drawn by the
+	// servo in milliamps, e.g. /sys/class/hwmon/hwmon2/curr1_input of an
+	// INA219 bound to the ina2xx driver. If Shunt is set, it is instead
+	// the IIO channel of an ADC that measures the voltage across a shunt
+	// resistor, e.g. /sys/bus/iio/devices/iio:device0/in_voltage1.
+	Current string `json:"current"`
+	// Shunt is the resistance of the shunt in ohms.
+	Shunt float64 `json:"shunt,omitempty"`
+	// Limit is the current in milliamps above which the servo may be stalled.
+	Limit float64 `json:"limit"`
+	// Duration is how long in seconds the current must stay above the
+	// limit without the servo making progress; defaults to 0.5.
+	Duration float64 `json:"duration,omitempty"`
+	// BackOff is how far to move the servo back from the obstruction;
+	// defaults to one step.
+	BackOff float64 `json:"backOff,omitempty"`
+}
+
+func (c *stallConfig) validate() error {
+	if c.Current == "" {
+		return errors.New("current must be set")
+	}
+	if c.Shunt < 0 {
+		return fmt.Errorf("shunt must not be negative; got %f", c.Shunt)
+	}
+	if c.Limit <= 0 {
+		return fmt.Errorf("limit must be greater than 0; got %f", c.Limit)
+	}
+	if c.Duration < 0 {
+		return fmt.Errorf("duration must not be negative; got %f", c.Duration)
+	}
+	if c.BackOff < 0 {
+		return fmt.Errorf("back-off must not be negative; got %f", c.BackOff)
+	}
+	return nil
+}
+
+// current reads the current drawn by the servo in milliamps.
+func (c *stallConfig) current() (float64, error) {
+	if c.Shunt == 0 {
+		i, err := readFloat(c.Current)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read current: %v", err)
+		}
+		return i, nil
+	}
+	mv, err := readChannel(c.Current)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read current: %v", err)
+	}
+	return mv / c.Shunt, nil
+}
+
+// overload tracks how long a servo has drawn more than its current limit.
+type overload struct {
+	// since is when the current rose above the limit or the servo last
+	// made progress while above it; it is zero while below the limit.
+	since time.Time
+	// position is where the servo was at that time.
+	position float64
+}
+
+// checkStall reads the current drawn by the servo and, if it has been
+// above the limit for too long without the servo making progress,
+// stops any motion and backs the servo off from the obstruction.
+// The servo must have a stall configuration.
+func (s *servor) checkStall() {
+	s.mu.Lock()
+	c := *s.stall
+	s.mu.Unlock()
+	i, err := c.current()
+	if err != nil {
+		level.Warn(s.logger).Log("err", err)
+		s.mu.Lock()
+		s.meter.current = nil
+		s.mu.Unlock()
+		return
+	}
+	currentMilliamps.WithLabelValues(s.name).Set(i)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// The energy meter uses the measured current.
+	s.meter.current = &i
+	position := s.position
+	if s.feedback != nil {
+		position = s.feedback.Position
+	}
+	duration := c.Duration
+	if duration == 0 {
+		duration = defaultStallDuration
+	}
+	switch {
+	case i <= c.Limit:
+		s.overload = overload{}
+		return
+	// Moving more than half a step counts as progress, since moving
+	// servos draw more current than idle ones.
+	case s.overload.since.IsZero() || math.Abs(position-s.overload.position) > s.step/2:
+		s.overload = overload{since: time.Now(), position: position}
+		return
+	case time.Since(s.overload.since).Seconds() < duration:
+		return
+	}
+	s.overload = overload{}
+	stallsTotal.WithLabelValues(s.name).Inc()
+	level.Warn(s.logger).Log("msg", "servo stalled; backing off", "current", i, "limit", c.Limit, "position", position)
+	s.stop(reasonStalled)
+	backOff := c.BackOff
+	if backOff == 0 {
+		backOff = s.step
+	}
+	// The servo backs away from the direction of its last move; one
+	// that never moved is assumed to have jammed on its way from home.
+	direction := s.direction
+	if direction == 0 && s.position != s.home {
+		direction = math.Copysign(1, s.position-s.home)
+	}
+	// Backing off is a safety measure, so it bypasses the pre-move hooks.
+	s.position -= direction * backOff
+	if err := s.set(); err != nil {
+		level.Error(s.logger).Log("msg", "failed to back off", "err", err)
+	}
+	// If the servo stalls again, it keeps backing away from the obstruction.
+	s.direction = direction
+	s.events.publish(s.event(eventStall))
+}
+
+// watchStalls checks the servos that have a stall configuration
+// at the given interval until the context is done.
+func (ss *servos) watchStalls(ctx context.Context, interval time.Duration) error {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+		for _, s := range ss.list {
+			s.mu.Lock()
+			configured := s.stall != nil
+			s.mu.Unlock()
+			if configured {
+				s.checkStall()
+			}
+		}
+	}
+}
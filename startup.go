@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// defaultStartupTimeout bounds the steps of the startup sequence
+// that wait for something outside of servor.
+const defaultStartupTimeout = 60
+
+// startupRetry is how often waiting steps check again.
+const startupRetry = time.Second
+
+// The steps of the startup sequence.
+const (
+	// stepWaitForDevices waits until the devices of all drivers,
+	// including fallbacks, are available.
+	stepWaitForDevices = "wait-for-devices"
+	// stepSelfTest writes the position of every servo and reads
+	// it back from the drivers that support feedback.
+	stepSelfTest = "self-test"
+	// stepInitial writes the initial position of every servo.
+	stepInitial = "initial"
+	// stepHome drives every servo to its home position.
+	stepHome = "home"
+	// stepMQTT connects the MQTT bridge and waits until it
+	// published the availability of every servo.
+	stepMQTT = "mqtt"
+)
+
+var startupSteps = []string{stepWaitForDevices, stepSelfTest, stepInitial, stepHome, stepMQTT}
+
+// startupStep is a step of the startup sequence, which runs before
+// servor opens its listeners, so that complex installations boot
+// predictably rather than racing clients against the hardware.
+type startupStep struct {
+	Step string `json:"step"`
+	// Timeout is how many seconds the wait-for-devices and mqtt steps
+	// wait before startup fails; defaults to 60.
+	Timeout float64 `json:"timeout,omitempty"`
+}
+
+func (s *startupStep) validate() error {
+	if s.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative; got %f", s.Timeout)
+	}
+	for _, step := range startupSteps {
+		if s.Step == step {
+			return nil
+		}
+	}
+	return fmt.Errorf("step must be one of %s; got %q", strings.Join(startupSteps, ", "), s.Step)
+}
+
+func (s *startupStep) timeout() time.Duration {
+	if s.Timeout == 0 {
+		return defaultStartupTimeout * time.Second
+	}
+	return time.Duration(s.Timeout * float64(time.Second))
+}
+
+// startup runs the startup sequence. The mqtt step starts the bridge
+// with start, which returns a channel that is closed once the bridge
+// is online, or nil if there is no bridge.
+func startup(c *config, ss *servos, start func() <-chan struct{}, logger log.Logger) error {
+	for i, step := range c.Startup {
+		level.Info(logger).Log("msg", "running startup step", "step", step.Step)
+		if err := step.run(c, ss, start, logger); err != nil {
+			return fmt.Errorf("startup step %d (%s) failed: %v", i, step.Step, err)
+		}
+	}
+	return nil
+}
+
+func (s *startupStep) run(c *config, ss *servos, start func() <-chan struct{}, logger log.Logger) error {
+	switch s.Step {
+	case stepWaitForDevices:
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeout())
+		defer cancel()
+		for {
+			errs := c.available()
+			if len(errs) == 0 {
+				return nil
+			}
+			level.Info(logger).Log("msg", "waiting for devices", "err", errs[0])
+			select {
+			case <-ctx.Done():
+				return errs[0]
+			case <-time.After(startupRetry):
+			}
+		}
+	case stepSelfTest:
+		for _, sv := range ss.list {
+			if err := sv.writeInitial(); err != nil {
+				return fmt.Errorf("failed to write servo %q: %v", sv.name, err)
+			}
+			f, ok := sv.device.driver.(feedbacker)
+			if !ok {
+				continue
+			}
+			sv.device.mu.Lock()
+			_, err := f.feedback(sv.pin)
+			sv.device.mu.Unlock()
+			if err != nil {
+				return fmt.Errorf("failed to read feedback of servo %q: %v", sv.name, err)
+			}
+		}
+	case stepInitial:
+		for _, sv := range ss.list {
+			if err := sv.writeInitial(); err != nil {
+				return fmt.Errorf("failed to write initial position of servo %q: %v", sv.name, err)
+			}
+		}
+	case stepHome:
+		for _, sv := range ss.list {
+			if err := sv.goHome(); err != nil {
+				return fmt.Errorf("failed to move servo %q home: %v", sv.name, err)
+			}
+		}
+	case stepMQTT:
+		online := start()
+		if online == nil {
+			return errors.New("the mqtt step requires --mqtt")
+		}
+		select {
+		case <-online:
+		case <-time.After(s.timeout()):
+			return errors.New("timed out waiting for the MQTT broker")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// servoError is an error of a servo's device and when it happened.
+type servoError struct {
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// backendStatus describes the driver that drives a servo.
+type backendStatus struct {
+	// Driver and Device are the active driver, which
+	// may be a fallback, and the device that it uses.
+	Driver string `json:"driver"`
+	Device string `json:"device"`
+	// Healthy is false while the last write to the device failed.
+	Healthy bool `json:"healthy"`
+	// Ready is false until the device passed its initial health check.
+	Ready bool `json:"ready"`
+}
+
+// servoStatus is the complete status of a servo,
+// so that dashboards need a single request per refresh.
+type servoStatus struct {
+	servoState
+	Backend backendStatus `json:"backend"`
+	// Job is the running motion, if any.
+	Job       *job        `json:"job,omitempty"`
+	LastError *servoError `json:"lastError,omitempty"`
+	// MaintenanceDue is set once the servo exceeded a maintenance
+	// threshold since it was last serviced.
+	MaintenanceDue bool `json:"maintenanceDue,omitempty"`
+	// Suspended are the sources that policies suspended, if any.
+	Suspended map[string]suspension `json:"suspended,omitempty"`
+	// Detached is set while the pulses of the servo are stopped
+	// because it was idle.
+	Detached bool `json:"detached,omitempty"`
+}
+
+// active returns the configuration of the driver
+// that currently drives the servos of the device.
+// The caller must hold the lock of the device.
+func (d *device) active() driverConfig {
+	if f, ok := d.driver.(*failover); ok {
+		return f.configs[f.active]
+	}
+	return d.config
+}
+
+// status returns the complete status of the servo.
+// The caller must hold the lock.
+func (s *servor) status() servoStatus {
+	st := servoStatus{servoState: s.state(), LastError: s.lastError, MaintenanceDue: s.maintenanceDue(), Suspended: s.activeSuspensions(), Detached: s.detached}
+	if s.job != nil {
+		j := *s.job
+		st.Job = &j
+	}
+	s.device.mu.Lock()
+	c := s.device.active()
+	s.device.mu.Unlock()
+	st.Backend = backendStatus{Driver: c.Type, Device: c.Device, Healthy: !s.failed, Ready: s.device.isReady()}
+	return st
+}
+
+// writeStatus responds with the status of all servos.
+func (ss *servos) writeStatus(w http.ResponseWriter) {
+	var st struct {
+		Servos []servoStatus `json:"servos"`
+	}
+	st.Servos = make([]servoStatus, 0, len(ss.list))
+	for _, s := range ss.list {
+		s.mu.Lock()
+		st.Servos = append(st.Servos, s.status())
+		s.mu.Unlock()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(st); err != nil {
+		level.Error(ss.logger).Log("err", err)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// strictError is returned in strict mode for values that
+// would otherwise be clamped or ignored.
+type strictError struct {
+	msg string
+}
+
+func (e *strictError) Error() string {
+	return e.msg
+}
+
+// decodeJSON decodes the JSON body of a request into v.
+// In strict mode, it also rejects unknown fields and any data
+// after the JSON value, so that client bugs surface early.
+// An empty body yields io.EOF, as with json.Decoder.
+func decodeJSON(body io.Reader, v interface{}, strict bool) error {
+	d := json.NewDecoder(body)
+	if strict {
+		d.DisallowUnknownFields()
+	}
+	if err := d.Decode(v); err != nil {
+		return err
+	}
+	if strict {
+		if _, err := d.Token(); err != io.EOF {
+			return errors.New("unexpected data after the JSON value")
+		}
+	}
+	return nil
+}
+
+// checkTarget rejects targets outside of the limits of the servo,
+// which are otherwise clamped, and non-finite targets in strict mode.
+// The caller must hold the lock.
+func (s *servor) checkTarget(target float64) error {
+	if !s.strict || (target >= s.min && target <= s.max) {
+		return nil
+	}
+	return &strictError{fmt.Sprintf("target %v is outside of the limits of servo %q, %v to %v", target, s.name, s.min, s.max)}
+}
+
+// checkVelocity rejects non-finite jog velocities in strict mode.
+// The caller must hold the lock.
+func (s *servor) checkVelocity(v float64) error {
+	if !s.strict || !(math.IsNaN(v) || math.IsInf(v, 0)) {
+		return nil
+	}
+	return &strictError{fmt.Sprintf("velocity must be finite; got %v", v)}
+}
+
+// checkAxis rejects deflections of analog inputs beyond -1 and 1,
+// which are otherwise clamped, in strict mode.
+// The caller must hold the lock.
+func (s *servor) checkAxis(a float64) error {
+	if !s.strict || (a >= -1 && a <= 1) {
+		return nil
+	}
+	return &strictError{fmt.Sprintf("axis must be between -1 and 1; got %v", a)}
+}
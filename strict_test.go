@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		body   string
+		strict bool
+		err    bool
+		eof    bool
+	}{
+		{name: "valid", body: `{"position": 0.5}`},
+		{name: "valid strict", body: `{"position": 0.5}`, strict: true},
+		{name: "trailing newline strict", body: "{\"position\": 0.5}\n", strict: true},
+		{name: "unknown field", body: `{"position": 0.5, "speed": 1}`},
+		{name: "unknown field strict", body: `{"position": 0.5, "speed": 1}`, strict: true, err: true},
+		{name: "trailing data", body: `{"position": 0.5} {"position": 0.7}`},
+		{name: "trailing data strict", body: `{"position": 0.5} {"position": 0.7}`, strict: true, err: true},
+		{name: "trailing garbage strict", body: `{"position": 0.5}]`, strict: true, err: true},
+		{name: "malformed", body: `{"position": }`, err: true},
+		{name: "malformed strict", body: `{"position": }`, strict: true, err: true},
+		{name: "wrong type strict", body: `{"position": "0.5"}`, strict: true, err: true},
+		{name: "empty", body: ``, err: true, eof: true},
+		{name: "empty strict", body: ``, strict: true, err: true, eof: true},
+	} {
+		var m calibrationMove
+		err := decodeJSON(strings.NewReader(tc.body), &m, tc.strict)
+		if (err != nil) != tc.err {
+			t.Errorf("%s: expected an error: %t; got %v", tc.name, tc.err, err)
+			continue
+		}
+		if (err == io.EOF) != tc.eof {
+			t.Errorf("%s: expected io.EOF: %t; got %v", tc.name, tc.eof, err)
+		}
+		if err == nil && m.Position != 0.5 {
+			t.Errorf("%s: expected position 0.5; got %v", tc.name, m.Position)
+		}
+	}
+}
+
+func TestStrictChecks(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		strict bool
+		check  func(s *servor) error
+		err    bool
+	}{
+		{name: "target within limits", strict: true, check: func(s *servor) error { return s.checkTarget(0.8) }},
+		{name: "target at limit", strict: true, check: func(s *servor) error { return s.checkTarget(0.9) }},
+		{name: "target beyond limits", strict: true, check: func(s *servor) error { return s.checkTarget(0.95) }, err: true},
+		{name: "target beyond limits lax", check: func(s *servor) error { return s.checkTarget(0.95) }},
+		{name: "target NaN", strict: true, check: func(s *servor) error { return s.checkTarget(math.NaN()) }, err: true},
+		{name: "velocity", strict: true, check: func(s *servor) error { return s.checkVelocity(-3) }},
+		{name: "velocity infinite", strict: true, check: func(s *servor) error { return s.checkVelocity(math.Inf(1)) }, err: true},
+		{name: "velocity infinite lax", check: func(s *servor) error { return s.checkVelocity(math.Inf(1)) }},
+		{name: "axis", strict: true, check: func(s *servor) error { return s.checkAxis(-1) }},
+		{name: "axis beyond 1", strict: true, check: func(s *servor) error { return s.checkAxis(1.5) }, err: true},
+		{name: "axis beyond 1 lax", check: func(s *servor) error { return s.checkAxis(1.5) }},
+	} {
+		s := &servor{name: "pan", min: 0.1, max: 0.9, strict: tc.strict}
+		err := tc.check(s)
+		if (err != nil) != tc.err {
+			t.Errorf("%s: expected an error: %t; got %v", tc.name, tc.err, err)
+			continue
+		}
+		if _, ok := err.(*strictError); err != nil && !ok {
+			t.Errorf("%s: expected a strict error; got %T", tc.name, err)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// supplyHysteresis is the fraction by which the supply voltage must
+// rise above the cutoff before motion is allowed again, so that the
+// voltage recovering under no load does not immediately re-enable it.
+const supplyHysteresis = 0.05
+
+// errInhibited is returned for moves while the supply voltage is low.
+var errInhibited = errors.New("motion is inhibited because the supply voltage is below the cutoff")
+
+// supply reads the supply voltage, e.g. of a battery, from an ADC
+// channel exposed by the Linux IIO subsystem, such as an ADS1115
+// enabled with the ads1115 device tree overlay.
+type supply struct {
+	// channel is the path of the channel without its suffix, e.g.
+	// /sys/bus/iio/devices/iio:device0/in_voltage0, whose raw reading
+	// and scale in millivolts are read from the _raw and _scale files.
+	channel string
+	// divider is the ratio of the supply voltage to the voltage at
+	// the ADC, e.g. 4 for a divider of 30kΩ and 10kΩ.
+	divider float64
+	// cutoff is the voltage below which servos are parked and motion
+	// is inhibited; 0 disables the cutoff.
+	cutoff float64
+}
+
+// voltage reads the supply voltage in volts.
+func (s *supply) voltage() (float64, error) {
+	mv, err := readChannel(s.channel)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read supply voltage: %v", err)
+	}
+	return mv / 1000 * s.divider, nil
+}
+
+// readChannel reads the voltage of an IIO channel in millivolts
+// from its raw reading and its scale.
+func readChannel(channel string) (float64, error) {
+	raw, err := readFloat(channel + "_raw")
+	if err != nil {
+		return 0, err
+	}
+	scale, err := readFloat(channel + "_scale")
+	if err != nil {
+		return 0, err
+	}
+	return raw * scale, nil
+}
+
+// readFloat reads a file that holds a single number, as sysfs files do.
+func readFloat(path string) (float64, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(strings.TrimSpace(string(buf)), 64)
+}
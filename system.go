@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+const (
+	// defaultTemperatureFile is the SoC temperature on the Raspberry Pi
+	// and most other Linux boards, in millidegrees Celsius.
+	defaultTemperatureFile = "/sys/class/thermal/thermal_zone0/temp"
+	// thermalHysteresis is how far the temperature must fall below the
+	// limit before paused motions resume, so that they do not toggle
+	// with every reading near the limit.
+	thermalHysteresis = 5
+	// defaultThrottledFile holds the power and throttling flags of the
+	// Raspberry Pi firmware as a hexadecimal bit field; on older kernels,
+	// the flags are read with vcgencmd instead.
+	defaultThrottledFile = "/sys/devices/platform/soc/soc:firmware/get_throttled"
+)
+
+// throttledFlags are the flags reported by the Raspberry Pi firmware.
+// Each flag is reported both for now and for any time since boot.
+type throttledFlags struct {
+	UnderVoltage                 bool `json:"underVoltage"`
+	FrequencyCapped              bool `json:"frequencyCapped"`
+	Throttled                    bool `json:"throttled"`
+	SoftTemperatureLimit         bool `json:"softTemperatureLimit"`
+	UnderVoltageOccurred         bool `json:"underVoltageOccurred"`
+	FrequencyCappedOccurred      bool `json:"frequencyCappedOccurred"`
+	ThrottledOccurred            bool `json:"throttledOccurred"`
+	SoftTemperatureLimitOccurred bool `json:"softTemperatureLimitOccurred"`
+}
+
+// parseThrottled parses the bit field of the firmware, given with or
+// without a 0x prefix or the throttled= prefix of vcgencmd.
+func parseThrottled(s string) (*throttledFlags, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "throttled=")
+	bits, err := strconv.ParseUint(strings.TrimPrefix(s, "0x"), 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse throttled flags: %v", err)
+	}
+	return &throttledFlags{
+		UnderVoltage:                 bits&(1<<0) != 0,
+		FrequencyCapped:              bits&(1<<1) != 0,
+		Throttled:                    bits&(1<<2) != 0,
+		SoftTemperatureLimit:         bits&(1<<3) != 0,
+		UnderVoltageOccurred:         bits&(1<<16) != 0,
+		FrequencyCappedOccurred:      bits&(1<<17) != 0,
+		ThrottledOccurred:            bits&(1<<18) != 0,
+		SoftTemperatureLimitOccurred: bits&(1<<19) != 0,
+	}, nil
+}
+
+// set exports the flags as metrics.
+func (f *throttledFlags) set() {
+	for flag, v := range map[string][2]bool{
+		"under-voltage":          {f.UnderVoltage, f.UnderVoltageOccurred},
+		"frequency-capped":       {f.FrequencyCapped, f.FrequencyCappedOccurred},
+		"throttled":              {f.Throttled, f.ThrottledOccurred},
+		"soft-temperature-limit": {f.SoftTemperatureLimit, f.SoftTemperatureLimitOccurred},
+	} {
+		firmwareThrottled.WithLabelValues(flag).Set(gauge(v[0]))
+		firmwareThrottledOccurred.WithLabelValues(flag).Set(gauge(v[1]))
+	}
+}
+
+func gauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// systemState describes the host that servor runs on.
+type systemState struct {
+	// SoCTemperature is in degrees Celsius; it is unset
+	// if the temperature cannot be read.
+	SoCTemperature *float64 `json:"socTemperature,omitempty"`
+	// ThermalThrottled is true while continuous motions are paused
+	// because the SoC is hotter than the thermal limit.
+	ThermalThrottled bool `json:"thermalThrottled"`
+	// Power holds the flags of the Raspberry Pi firmware, e.g.
+	// whether the supply voltage is too low; it is unset on other boards.
+	Power *throttledFlags `json:"power,omitempty"`
+	// SupplyVoltage is in volts; it is unset unless an ADC is configured.
+	SupplyVoltage *float64 `json:"supplyVoltage,omitempty"`
+	// MotionInhibited is true while servos are parked because
+	// the supply voltage is below the cutoff.
+	MotionInhibited bool `json:"motionInhibited"`
+}
+
+// system monitors the host that servor runs on.
+type system struct {
+	temperatureFile string
+	throttledFile   string
+	// limit is the SoC temperature above which continuous motions
+	// are paused; 0 disables throttling.
+	limit  float64
+	supply *supply
+	// park drives all servos to a safe position and is called
+	// when motion is inhibited.
+	park func()
+
+	mu     sync.Mutex
+	state  systemState
+	logger log.Logger
+}
+
+func newSystem(temperatureFile, throttledFile string, limit float64, supply *supply, park func(), logger log.Logger) *system {
+	sys := &system{temperatureFile: temperatureFile, throttledFile: throttledFile, limit: limit, supply: supply, park: park, logger: logger}
+	sys.check()
+	return sys
+}
+
+// temperature reads the SoC temperature in degrees Celsius.
+func (sys *system) temperature() (float64, error) {
+	buf, err := ioutil.ReadFile(sys.temperatureFile)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read SoC temperature: %v", err)
+	}
+	t, err := strconv.ParseFloat(strings.TrimSpace(string(buf)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse SoC temperature: %v", err)
+	}
+	return t / 1000, nil
+}
+
+// power reads the power and throttling flags of the firmware,
+// falling back to vcgencmd if the kernel does not expose them.
+func (sys *system) power() (*throttledFlags, error) {
+	buf, err := ioutil.ReadFile(sys.throttledFile)
+	if os.IsNotExist(err) {
+		buf, err = exec.Command("vcgencmd", "get_throttled").Output()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read throttled flags: %v", err)
+	}
+	return parseThrottled(string(buf))
+}
+
+// check reads the state of the host, throttles or resumes continuous
+// motions as needed, and parks the servos when motion is inhibited.
+func (sys *system) check() {
+	// The servos are parked without holding the lock, since
+	// their motions check the system while holding their own.
+	if sys.update() {
+		sys.park()
+	}
+}
+
+// update reads the state of the host and returns
+// whether motion was just inhibited.
+func (sys *system) update() bool {
+	t, err := sys.temperature()
+	p, perr := sys.power()
+	sys.mu.Lock()
+	defer sys.mu.Unlock()
+	inhibit := sys.updateSupply()
+	// Boards other than the Raspberry Pi do not report power flags.
+	if perr == nil {
+		if p.UnderVoltage && (sys.state.Power == nil || !sys.state.Power.UnderVoltage) {
+			level.Warn(sys.logger).Log("msg", "the supply voltage is too low; servos that stall may cause brownouts")
+		}
+		p.set()
+	}
+	sys.state.Power = p
+	if err != nil {
+		// Boards without a thermal zone are not throttled.
+		sys.state.SoCTemperature = nil
+		return inhibit
+	}
+	sys.state.SoCTemperature = &t
+	socTemperature.Set(t)
+	if sys.limit == 0 {
+		return inhibit
+	}
+	switch {
+	case !sys.state.ThermalThrottled && t > sys.limit:
+		sys.state.ThermalThrottled = true
+		thermalThrottled.Set(1)
+		level.Warn(sys.logger).Log("msg", "SoC is too hot; pausing continuous motions", "temperature", t, "limit", sys.limit)
+	case sys.state.ThermalThrottled && t < sys.limit-thermalHysteresis:
+		sys.state.ThermalThrottled = false
+		thermalThrottled.Set(0)
+		level.Info(sys.logger).Log("msg", "SoC cooled down; resuming continuous motions", "temperature", t)
+	}
+	return inhibit
+}
+
+// updateSupply reads the supply voltage, if configured, and returns
+// whether motion was just inhibited. If the voltage cannot be read,
+// the previous state is kept.
+// The caller must hold the lock.
+func (sys *system) updateSupply() bool {
+	if sys.supply == nil {
+		return false
+	}
+	v, err := sys.supply.voltage()
+	if err != nil {
+		level.Warn(sys.logger).Log("err", err)
+		return false
+	}
+	sys.state.SupplyVoltage = &v
+	supplyVoltage.Set(v)
+	if sys.supply.cutoff == 0 {
+		return false
+	}
+	switch {
+	case !sys.state.MotionInhibited && v < sys.supply.cutoff:
+		sys.state.MotionInhibited = true
+		motionInhibited.Set(1)
+		level.Warn(sys.logger).Log("msg", "supply voltage is below the cutoff; parking servos and inhibiting motion", "voltage", v, "cutoff", sys.supply.cutoff)
+		return true
+	case sys.state.MotionInhibited && v > sys.supply.cutoff*(1+supplyHysteresis):
+		sys.state.MotionInhibited = false
+		motionInhibited.Set(0)
+		level.Info(sys.logger).Log("msg", "supply voltage recovered; allowing motion", "voltage", v)
+	}
+	return false
+}
+
+// run checks the host at the given interval until the context is done.
+func (sys *system) run(ctx context.Context, interval time.Duration) error {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+		sys.check()
+	}
+}
+
+// paused returns whether continuous motions should pause, i.e.
+// while the SoC is too hot or motion is inhibited.
+// It is safe to call on a nil system.
+func (sys *system) paused() bool {
+	if sys == nil {
+		return false
+	}
+	sys.mu.Lock()
+	defer sys.mu.Unlock()
+	return sys.state.ThermalThrottled || sys.state.MotionInhibited
+}
+
+// inhibited returns whether motion is inhibited.
+// It is safe to call on a nil system.
+func (sys *system) inhibited() bool {
+	if sys == nil {
+		return false
+	}
+	sys.mu.Lock()
+	defer sys.mu.Unlock()
+	return sys.state.MotionInhibited
+}
+
+// ServeHTTP serves the state of the host.
+func (sys *system) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	sys.mu.Lock()
+	state := sys.state
+	sys.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(state); err != nil {
+		level.Error(sys.logger).Log("err", err)
+	}
+}
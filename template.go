@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	templateMicroseconds = "us"
+	templateValue        = "value"
+)
+
+// serialTemplate drives servo controllers that accept one textual or
+// binary command per move over a serial port, e.g. an SSC-32 with the
+// template "#%dP%d\r". The template is a printf-style format that is
+// given the pin and the target, either as an integer pulse width in
+// microseconds or as the raw value.
+type serialTemplate struct {
+	device    string
+	baud      int
+	template  string
+	unit      string
+	frequency float64
+
+	f *os.File
+}
+
+// newSerialTemplate creates a templated serial driver.
+// Escape sequences like \r and \x80 in the template are interpreted.
+func newSerialTemplate(device string, baud int, template, unit string, frequency float64) (*serialTemplate, error) {
+	if template == "" {
+		return nil, fmt.Errorf("a template is required")
+	}
+	t, err := strconv.Unquote(`"` + template + `"`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template: %v", err)
+	}
+	switch unit {
+	case "":
+		unit = templateMicroseconds
+	case templateMicroseconds, templateValue:
+	default:
+		return nil, fmt.Errorf("unknown template unit %q", unit)
+	}
+	return &serialTemplate{device: device, baud: baud, template: t, unit: unit, frequency: frequency}, nil
+}
+
+// Set implements driver.
+func (s *serialTemplate) Set(pin int, value float64) error {
+	if s.f == nil {
+		f, err := openSerial(s.device, s.baud)
+		if err != nil {
+			return err
+		}
+		s.f = f
+	}
+	var target interface{} = value
+	if s.unit == templateMicroseconds {
+		target = int(pulseWidth(value, s.frequency) / time.Microsecond)
+	}
+	if _, err := fmt.Fprintf(s.f, s.template, pin, target); err != nil {
+		s.Close()
+		return err
+	}
+	return nil
+}
+
+// Close implements driver.
+func (s *serialTemplate) Close() error {
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
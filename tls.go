@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// ensureCertificate generates and persists a self-signed certificate
+// and key at the given paths if neither exists yet.
+// It returns true if a certificate was generated.
+func ensureCertificate(certPath, keyPath string) (bool, error) {
+	_, certErr := os.Stat(certPath)
+	_, keyErr := os.Stat(keyPath)
+	if certErr == nil && keyErr == nil {
+		return false, nil
+	}
+	if !os.IsNotExist(certErr) || !os.IsNotExist(keyErr) {
+		return false, fmt.Errorf("only one of the TLS certificate and key exists")
+	}
+	cert, key, err := selfSignedCertificate()
+	if err != nil {
+		return false, err
+	}
+	if err := ioutil.WriteFile(keyPath, key, 0600); err != nil {
+		return false, fmt.Errorf("failed to write TLS key: %v", err)
+	}
+	if err := ioutil.WriteFile(certPath, cert, 0644); err != nil {
+		return false, fmt.Errorf("failed to write TLS certificate: %v", err)
+	}
+	return true, nil
+}
+
+// selfSignedCertificate returns a PEM-encoded self-signed certificate
+// and key valid for the names and addresses of the host.
+func selfSignedCertificate() ([]byte, []byte, error) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate key: %v", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate serial number: %v", err)
+	}
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"servor"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		template.Subject.CommonName = hostname
+		template.DNSNames = append(template.DNSNames, hostname, hostname+".local")
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list interface addresses: %v", err)
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok {
+			template.IPAddresses = append(template.IPAddresses, ipnet.IP)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal key: %v", err)
+	}
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	key := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return cert, key, nil
+}
+
+// certReloader serves a TLS certificate and key from disk,
+// reloading them whenever either file changes so that renewed
+// certificates are picked up without restarting the server.
+type certReloader struct {
+	certPath string
+	keyPath  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	logger  log.Logger
+}
+
+func newCertReloader(certPath, keyPath string, logger log.Logger) (*certReloader, error) {
+	c := &certReloader{certPath: certPath, keyPath: keyPath, logger: logger}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// reload loads the certificate and key if either changed since
+// they were last loaded.
+func (c *certReloader) reload() error {
+	modTime, err := latestModTime(c.certPath, c.keyPath)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cert != nil && !modTime.After(c.modTime) {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.certPath, c.keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+	if c.cert != nil {
+		level.Info(c.logger).Log("msg", "reloaded TLS certificate", "cert", c.certPath)
+	}
+	c.cert = &cert
+	c.modTime = modTime
+	return nil
+}
+
+// watch periodically reloads the certificate until the context is done.
+// A failure to reload, e.g. because only one of the files was
+// replaced so far, keeps the previous certificate.
+func (c *certReloader) watch(ctx context.Context, interval time.Duration) error {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+		if err := c.reload(); err != nil {
+			level.Warn(c.logger).Log("msg", "failed to reload TLS certificate", "err", err)
+		}
+	}
+}
+
+// getCertificate implements tls.Config.GetCertificate.
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cert, nil
+}
+
+func latestModTime(paths ...string) (time.Time, error) {
+	var latest time.Time
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return latest, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// redirectHandler permanently redirects requests to the same
+// host and path over HTTPS on the given port.
+func redirectHandler(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		u := *r.URL
+		u.Scheme = "https"
+		u.Host = host
+		http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+	})
+}
@@ -2,4 +2,8 @@
 
 package main
 
-import _ "golang.org/x/lint/golint"
+import (
+	_ "github.com/golang/protobuf/protoc-gen-go"
+	_ "github.com/grpc-ecosystem/grpc-gateway/protoc-gen-grpc-gateway"
+	_ "golang.org/x/lint/golint"
+)
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// traceIDKey is the context key of the trace ID of a request.
+type traceIDKey struct{}
+
+// traceID returns the trace ID stored in the context, if any.
+func traceID(ctx context.Context) string {
+	id, _ := ctx.Value(traceIDKey{}).(string)
+	return id
+}
+
+// parseTraceParent returns the trace ID of a W3C traceparent header,
+// i.e. version-traceid-parentid-flags, or the empty string if the
+// header is missing or malformed.
+func parseTraceParent(header string) string {
+	parts := strings.Split(header, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || parts[1] == strings.Repeat("0", 32) {
+		return ""
+	}
+	if _, err := hex.DecodeString(parts[1]); err != nil {
+		return ""
+	}
+	return parts[1]
+}
+
+// observe records the value with the given trace ID as an exemplar,
+// or without an exemplar if the trace ID is empty.
+func observe(o prometheus.Observer, v float64, traceID string) {
+	if eo, ok := o.(prometheus.ExemplarObserver); ok && traceID != "" {
+		eo.ObserveWithExemplar(v, prometheus.Labels{"trace_id": traceID})
+		return
+	}
+	o.Observe(v)
+}
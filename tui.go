@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// tuiRefresh is how often the terminal UI refreshes the status.
+const tuiRefresh = 250 * time.Millisecond
+
+// tuiDialWidth is the number of cells of the dial.
+const tuiDialWidth = 50
+
+// tuiHistory is the number of recent jobs shown.
+const tuiHistory = 5
+
+const tuiHelp = "←/→ step  ↑/↓ servo  1-9 preset  h home  c center  space stop  q quit"
+
+// Escape sequences of the terminal.
+const (
+	ansiAltScreen  = "\x1b[?1049h\x1b[?25l"
+	ansiMainScreen = "\x1b[?25h\x1b[?1049l"
+	ansiClear      = "\x1b[H\x1b[2J"
+	ansiBold       = "\x1b[1m"
+	ansiDim        = "\x1b[2m"
+	ansiReset      = "\x1b[0m"
+)
+
+// tui is a terminal UI client that controls the servos of a remote
+// servor, e.g. for users who only have SSH access to a machine.
+type tui struct {
+	*apiClient
+	// current is the index of the servo under control.
+	current int
+	servos  []servoStatus
+	presets []preset
+	jobs    []job
+	// message is the result of the last command.
+	message string
+}
+
+// runTUI runs the terminal UI against the servor at the given
+// URL until the user quits.
+func runTUI(server, token string) error {
+	c, err := newAPIClient(server, token)
+	if err != nil {
+		return err
+	}
+	t := &tui{apiClient: c}
+	if err := t.refresh(); err != nil {
+		return err
+	}
+	fd := int(os.Stdin.Fd())
+	termios, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return errors.New("the terminal UI must run in a terminal")
+	}
+	raw := *termios
+	raw.Lflag &^= unix.ICANON | unix.ECHO | unix.ISIG
+	raw.Cc[unix.VMIN], raw.Cc[unix.VTIME] = 1, 0
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, &raw); err != nil {
+		return fmt.Errorf("failed to put the terminal into raw mode: %v", err)
+	}
+	defer unix.IoctlSetTermios(fd, unix.TCSETS, termios)
+	fmt.Print(ansiAltScreen)
+	defer fmt.Print(ansiMainScreen)
+
+	keys := make(chan string)
+	go func() {
+		buf := make([]byte, 16)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				close(keys)
+				return
+			}
+			keys <- string(buf[:n])
+		}
+	}()
+	tick := time.NewTicker(tuiRefresh)
+	defer tick.Stop()
+	for {
+		t.render()
+		select {
+		case key, ok := <-keys:
+			if !ok || !t.key(key) {
+				return nil
+			}
+		case <-tick.C:
+		}
+		if err := t.refresh(); err != nil {
+			t.message = err.Error()
+		}
+	}
+}
+
+// key handles a key press and returns false if the user quits.
+func (t *tui) key(key string) bool {
+	switch key {
+	case "q", "Q", "\x03", "\x04":
+		return false
+	case "\x1b[A", "k":
+		t.current = (t.current + len(t.servos) - 1) % len(t.servos)
+		t.presets, t.jobs = nil, nil
+	case "\x1b[B", "j":
+		t.current = (t.current + 1) % len(t.servos)
+		t.presets, t.jobs = nil, nil
+	case "\x1b[D":
+		t.post("left")
+	case "\x1b[C":
+		t.post("right")
+	case "h", "H":
+		t.post("home")
+	case "c", "C":
+		t.post("center")
+	case " ", "s":
+		t.post("stop")
+	default:
+		if len(key) == 1 && key[0] >= '1' && key[0] <= '9' {
+			if i := int(key[0] - '1'); i < len(t.presets) {
+				t.post("presets/" + url.PathEscape(t.presets[i].Name))
+			}
+		}
+	}
+	return true
+}
+
+// endpoint returns the path of the endpoint of the servo under control.
+func (t *tui) endpoint(path string) string {
+	if len(t.servos) == 0 {
+		return "/api/" + path
+	}
+	return "/api/" + url.PathEscape(t.servos[t.current].Servo) + "/" + path
+}
+
+// post sends a command to the servo under control.
+func (t *tui) post(path string) {
+	t.message = path
+	if err := t.do(http.MethodPost, t.endpoint(path), nil, nil); err != nil {
+		t.message = fmt.Sprintf("%s: %v", path, err)
+	}
+}
+
+// refresh fetches the status of all servos and the presets
+// and recent jobs of the servo under control.
+func (t *tui) refresh() error {
+	var st struct {
+		Servos []servoStatus `json:"servos"`
+	}
+	if err := t.do(http.MethodGet, "/api/status", nil, &st); err != nil {
+		return fmt.Errorf("failed to get status: %v", err)
+	}
+	if len(st.Servos) == 0 {
+		return errors.New("the server has no servos")
+	}
+	t.servos = st.Servos
+	if t.current >= len(t.servos) {
+		t.current = 0
+	}
+	if err := t.do(http.MethodGet, t.endpoint("presets"), nil, &t.presets); err != nil {
+		return fmt.Errorf("failed to get presets: %v", err)
+	}
+	if err := t.do(http.MethodGet, t.endpoint("jobs"), nil, &t.jobs); err != nil {
+		return fmt.Errorf("failed to get jobs: %v", err)
+	}
+	return nil
+}
+
+// render draws the screen.
+func (t *tui) render() {
+	var b strings.Builder
+	b.WriteString(ansiClear)
+	line := func(format string, args ...interface{}) {
+		fmt.Fprintf(&b, format+"\r\n", args...)
+	}
+	line("%sservor%s %s", ansiBold, ansiReset, t.server)
+	line("")
+	for i, s := range t.servos {
+		mark := "  "
+		if i == t.current {
+			mark = ansiBold + "> "
+		}
+		state := "idle"
+		if s.Job != nil {
+			state = s.Job.Motion
+		}
+		if !s.Backend.Healthy {
+			state += ", device failed"
+		}
+		line("%s%-16s %s%s", mark, s.Servo, ansiReset, tuiDial(s))
+		line("  %s%-16s %.4g → %.4g (%s)%s", ansiDim, "", s.Position, s.Target, state, ansiReset)
+	}
+	line("")
+	line("%sPresets%s", ansiBold, ansiReset)
+	for i, p := range t.presets {
+		if i == 9 {
+			break
+		}
+		line("  %d %-16s %.4g", i+1, p.Name, p.Position)
+	}
+	if len(t.presets) == 0 {
+		line("  none")
+	}
+	line("")
+	line("%sHistory%s", ansiBold, ansiReset)
+	for i := len(t.jobs) - 1; i >= 0 && i >= len(t.jobs)-tuiHistory; i-- {
+		j := t.jobs[i]
+		line("  #%-5d %-10s %-10s %s %s", j.ID, j.Motion, j.State, j.Started.Local().Format("15:04:05"), j.Source)
+	}
+	if len(t.jobs) == 0 {
+		line("  none")
+	}
+	line("")
+	if s := t.servos[t.current]; s.LastError != nil {
+		line("last error: %s", s.LastError.Message)
+	}
+	line("%s", t.message)
+	line("%s%s%s", ansiDim, tuiHelp, ansiReset)
+	fmt.Print(b.String())
+}
+
+// tuiDial draws the range of the servo with its position and target.
+func tuiDial(s servoStatus) string {
+	cell := func(p float64) int {
+		if s.Max == s.Min {
+			return 0
+		}
+		c := int((p - s.Min) / (s.Max - s.Min) * (tuiDialWidth - 1))
+		if c < 0 {
+			return 0
+		}
+		if c >= tuiDialWidth {
+			return tuiDialWidth - 1
+		}
+		return c
+	}
+	cells := []rune(strings.Repeat("─", tuiDialWidth))
+	cells[cell(s.Target)] = '◇'
+	cells[cell(s.Position)] = '●'
+	return fmt.Sprintf("%.4g ├%s┤ %.4g", s.Min, string(cells), s.Max)
+}
@@ -0,0 +1,1462 @@
+package main
+
+// html is the web UI. It renders a panel for each servo, and one for
+// each pair of servos, from the configuration served by the API.
+const html = `<!doctype html>
+<html style="
+    align-items: center;
+    display: flex;
+    height: 100%;
+    justify-content: center;
+    width: 100%;
+">
+<head>
+  <meta charset="utf-8">
+  <title>Servor</title>
+  <meta name="description" content="">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+</head>
+<body>
+    <div style="
+	border: solid 5px;
+        display: inline-block;
+        font-family: sans-serif;
+        font-size: 4em;
+        font-weight: 500;
+        line-height: 1;
+        padding: .5em;
+    ">
+	<a href="https://github.com/squat/servor" style="
+	    text-decoration: none;
+	    color: #000;
+	">servor</a>
+	<div id="warning" hidden style="
+	    color: #c00;
+	    font-size: .25em;
+	    margin-top: .5em;
+	    max-width: 16em;
+	"></div>
+	<div id="tabs" hidden>
+	    <div id="tab-list" style="
+    	        display: flex;
+    	        flex-wrap: wrap;
+    	        font-size: .25em;
+    	        justify-content: space-around;
+    	        margin-top: .5em;
+    	    "></div>
+	</div>
+	<div id="panels"></div>
+	<div data-scope="config" data-profile="advanced" hidden style="
+    	    font-size: .25em;
+    	    margin-top: .5em;
+    	    text-align: center;
+    	">
+	    <a href="/api/settings/export" style="
+	        color: #000;
+	    ">export settings</a>
+	    <label style="
+	        cursor: pointer;
+	        margin-left: 1em;
+	        text-decoration: underline;
+	    ">import settings<input id="import" type="file" accept=".json" hidden></label>
+	    <a href="/editor" style="
+	        color: #000;
+	        margin-left: 1em;
+	    ">edit sequences</a>
+	</div>
+	<div style="
+    	    font-size: .25em;
+    	    margin-top: .5em;
+    	    text-align: center;
+    	">
+	    <span id="profile" style="
+	        cursor: pointer;
+	        text-decoration: underline;
+	    "></span>
+	</div>
+    </div>
+    <template id="servo-panel">
+	<div data-scope="read" hidden>
+	    <svg viewBox="-50 -50 100 58" style="
+	        display: block;
+	        margin: .25em auto 0;
+	        width: 2em;
+	    ">
+	        <path d="M -45 0 A 45 45 0 0 1 45 0" fill="none" stroke="#000" stroke-width="5"/>
+	        <line class="measured" x1="0" y1="0" x2="0" y2="-38" stroke="#999" stroke-width="6" stroke-dasharray="6 6" style="display: none;"/>
+	        <line class="target" x1="0" y1="-50" x2="0" y2="-40" stroke="#999" stroke-width="3" style="display: none;"/>
+	        <line class="needle" x1="0" y1="0" x2="0" y2="-38" stroke="#000" stroke-width="6" stroke-linecap="round"/>
+	        <circle r="7"/>
+	    </svg>
+	</div>
+	<div class="presets" data-scope="move" data-profile="simple" hidden style="
+	    display: flex;
+	    flex-wrap: wrap;
+	    font-size: .5em;
+	    justify-content: center;
+	    margin-top: .25em;
+	    max-width: 8em;
+	"></div>
+	<div data-scope="move" data-profile="advanced" hidden>
+	    <div style="
+    	        display: flex;
+    	        justify-content: space-around;
+    	    ">
+	        <div class="left" style="
+	            cursor: pointer;
+	        ">←</div>
+	        <div class="right" style="
+	            cursor: pointer;
+	        ">→</div>
+	    </div>
+	    <input class="slider" type="range" step="any" style="
+	        direction: rtl;
+	        display: block;
+	        margin: .25em auto 0;
+	        width: 100%;
+	    ">
+	    <form class="position-form" style="
+	        display: flex;
+	        font-size: .25em;
+	        justify-content: center;
+	        margin-top: .5em;
+	    ">
+	        <input class="position" type="number" step="any" required style="
+	            width: 6em;
+	        ">
+	        <select class="unit"></select>
+	    </form>
+	</div>
+	<div data-profile="advanced" hidden style="
+    	    display: flex;
+    	    font-size: .25em;
+    	    justify-content: space-around;
+    	    margin-top: .5em;
+    	">
+	    <div class="home" data-scope="move" hidden style="
+	        cursor: pointer;
+	    ">home</div>
+	    <div class="center" data-scope="move" hidden style="
+	        cursor: pointer;
+	    ">center</div>
+	    <div class="patrol" data-scope="sequences" hidden style="
+	        cursor: pointer;
+	    ">patrol</div>
+	    <div class="calibrate" data-scope="config" hidden style="
+	        cursor: pointer;
+	    ">calibrate</div>
+	    <div class="record" data-scope="config" hidden style="
+	        cursor: pointer;
+	    ">record</div>
+	</div>
+	<div class="recorder" hidden style="
+	    font-size: .25em;
+	    margin-top: .5em;
+	    text-align: center;
+	">
+	    <svg viewBox="0 0 200 50" preserveAspectRatio="none" style="
+	        border: solid 2px;
+	        box-sizing: border-box;
+	        display: block;
+	        height: 3em;
+	        width: 100%;
+	    ">
+	        <path class="recording-curve" fill="none" stroke="#000" stroke-width="2" vector-effect="non-scaling-stroke"/>
+	    </svg>
+	    <div style="
+	        display: flex;
+	        justify-content: space-around;
+	    ">
+	        <label>start <input class="trim-start" type="range" min="0" step=".001"></label>
+	        <label>end <input class="trim-end" type="range" min="0" step=".001"></label>
+	    </div>
+	    <div class="trim-length"></div>
+	    <form class="recording-form">
+	        <input class="recording-name" placeholder="name" pattern="[^/]+" required style="
+	            width: 8em;
+	        ">
+	        <button>save</button>
+	        <span class="recording-discard" style="
+	            cursor: pointer;
+	            margin-left: 1em;
+	            text-decoration: underline;
+	        ">discard</span>
+	    </form>
+	</div>
+	<div class="wizard" hidden style="
+	    font-size: .25em;
+	    margin-top: .5em;
+	    text-align: center;
+	">
+	    <div class="wizard-step"></div>
+	    <div style="
+	        display: flex;
+	        font-size: 4em;
+	        justify-content: space-around;
+	    ">
+	        <div data-nudge=".01" style="
+	            cursor: pointer;
+	        ">«</div>
+	        <div data-nudge=".001" style="
+	            cursor: pointer;
+	        ">‹</div>
+	        <div class="wizard-position" style="
+	            align-self: center;
+	            font-size: .25em;
+	        "></div>
+	        <div data-nudge="-.001" style="
+	            cursor: pointer;
+	        ">›</div>
+	        <div data-nudge="-.01" style="
+	            cursor: pointer;
+	        ">»</div>
+	    </div>
+	    <span class="wizard-next" style="
+	        cursor: pointer;
+	        text-decoration: underline;
+	    "></span>
+	    <span class="wizard-cancel" style="
+	        cursor: pointer;
+	        margin-left: 1em;
+	        text-decoration: underline;
+	    ">cancel</span>
+	</div>
+    </template>
+    <template id="pair-panel">
+	<div class="pad" data-profile="advanced" hidden style="
+	    border: solid 5px;
+	    box-sizing: border-box;
+	    cursor: crosshair;
+	    height: 2em;
+	    margin: .25em auto 0;
+	    position: relative;
+	    touch-action: none;
+	    width: 2em;
+	">
+	    <div class="target" style="
+	        border: solid 2px #999;
+	        border-radius: 50%;
+	        display: none;
+	        height: .2em;
+	        margin: -.1em 0 0 -.1em;
+	        position: absolute;
+	        width: .2em;
+	    "></div>
+	    <div class="measured" style="
+	        border: dashed 2px #999;
+	        border-radius: 50%;
+	        display: none;
+	        height: .2em;
+	        margin: -.1em 0 0 -.1em;
+	        position: absolute;
+	        width: .2em;
+	    "></div>
+	    <div class="dot" style="
+	        background: #000;
+	        border-radius: 50%;
+	        height: .2em;
+	        margin: -.1em 0 0 -.1em;
+	        position: absolute;
+	        width: .2em;
+	    "></div>
+	</div>
+	<div class="presets" data-scope="move" data-profile="simple" hidden style="
+	    display: flex;
+	    flex-wrap: wrap;
+	    font-size: .5em;
+	    justify-content: center;
+	    margin-top: .25em;
+	    max-width: 8em;
+	"></div>
+	<div data-profile="advanced" hidden style="
+    	    display: flex;
+    	    font-size: .25em;
+    	    justify-content: space-around;
+    	    margin-top: .5em;
+    	">
+	    <div class="home" data-scope="move" hidden style="
+	        cursor: pointer;
+	    ">home</div>
+	    <div class="center" data-scope="move" hidden style="
+	        cursor: pointer;
+	    ">center</div>
+	</div>
+    </template>
+    <script>
+	scopes = [];
+	// source is the source that the UI declares for its commands:
+	// ui, or the source of the token, which is the only one that
+	// clients with a token may declare.
+	source = 'ui';
+	allowed = function(scope) {
+	    return scopes.indexOf(scope) >= 0;
+	};
+	// profile is the UI profile of the session: simple shows
+	// only large preset buttons, advanced shows all controls.
+	profile = 'advanced';
+	// Only the controls that the caller's token permits
+	// and that belong to the profile are shown.
+	applyScopes = function(root) {
+	    root.querySelectorAll('[data-scope], [data-profile]').forEach(function(e) {
+	        e.hidden = (e.dataset.scope && !allowed(e.dataset.scope)) || (e.dataset.profile && e.dataset.profile !== profile);
+	    });
+	};
+	// presetButtons renders a large button for each of the
+	// named presets that plays it with the given panel.
+	presetButtons = function(root, names, p) {
+	    names.forEach(function(n) {
+	        var b = document.createElement('div');
+	        b.textContent = n;
+	        b.style.border = 'solid 3px';
+	        b.style.cursor = 'pointer';
+	        b.style.margin = '.1em';
+	        b.style.padding = '.2em .4em';
+	        b.onclick = function(e){
+	            p.act({action: 'preset', name: n});
+	            e.preventDefault();
+	        };
+	        root.appendChild(b);
+	    });
+	};
+	presetNames = function(name) {
+	    return fetch('/api/'+encodeURIComponent(name)+'/presets').then(function(r) {
+	        return r.ok ? r.json() : [];
+	    }).then(function(l) {
+	        return l.map(function(preset) {
+	            return preset.name;
+	        });
+	    });
+	};
+	// The profile can be switched for the session, e.g. to calibrate
+	// on a wall tablet, or chosen with the profile query parameter.
+	showProfile = function() {
+	    var other = profile === 'simple' ? 'advanced' : 'simple';
+	    document.getElementById('profile').textContent = other + ' mode';
+	    applyScopes(document);
+	};
+	document.getElementById('profile').onclick = function(e){
+	    profile = profile === 'simple' ? 'advanced' : 'simple';
+	    sessionStorage.setItem('profile', profile);
+	    showProfile();
+	    e.preventDefault();
+	};
+	// states holds the latest state of each servo by name.
+	states = {};
+	servoNames = [];
+	panels = [];
+	active = null;
+	// post tags its commands with the source of the UI; the REST
+	// gateway under /v1 takes the source as gRPC metadata instead.
+	post = function(path, body) {
+	    return fetch(path + (path.indexOf('?') < 0 ? '?' : '&') + 'source=' + encodeURIComponent(source), {
+	        method: 'POST',
+	        headers: {'Grpc-Metadata-Source': source},
+	        body: body === undefined ? undefined : JSON.stringify(body)
+	    });
+	};
+	move = function(name, target) {
+	    return post('/api/'+encodeURIComponent(name)+'/position', {position: target});
+	};
+	// glide moves the displayed angle or offset towards its target.
+	glide = function(current, target) {
+	    return current === undefined ? target : current + (target - current) * .2;
+	};
+
+	// servoPanel renders the controls of a single servo.
+	servoPanel = function(summary) {
+	    var root = document.createElement('div');
+	    root.appendChild(document.getElementById('servo-panel').content.cloneNode(true));
+	    var $ = function(c) {
+	        return root.querySelector('.' + c);
+	    };
+	    var name = summary.name;
+	    var api = function(action, body) {
+	        return post('/api/'+encodeURIComponent(name)+'/'+action, body);
+	    };
+	    var p = {name: name, root: root, angles: {}, targets: {}, patrolling: false};
+	    var state = function() {
+	        return states[name];
+	    };
+	    p.servor = function(action) {
+	        p.patrolling = false;
+	        $('patrol').style.textDecoration = '';
+	        return api(action);
+	    };
+	    $('left').onclick = function(e){
+	        p.servor('left');
+	        e.preventDefault();
+	    };
+	    $('right').onclick = function(e){
+	        p.servor('right');
+	        e.preventDefault();
+	    };
+	    $('home').onclick = function(e){
+	        p.servor('home');
+	        e.preventDefault();
+	    };
+	    $('center').onclick = function(e){
+	        p.servor('center');
+	        e.preventDefault();
+	    };
+	    $('patrol').onclick = function(e){
+	        if (p.patrolling) {
+	            p.servor('stop');
+	        } else {
+	            p.servor('patrol').then(function(r) {
+	                if (r.ok) {
+	                    p.patrolling = true;
+	                    $('patrol').style.textDecoration = 'underline';
+	                }
+	            });
+	        }
+	        e.preventDefault();
+	    };
+	    var slider = $('slider');
+	    slider.onchange = function(){
+	        move(name, parseFloat(slider.value));
+	    };
+
+	    // Positions can be entered in any unit that the servo supports:
+	    // percent of the range, raw values, and, if known, degrees of
+	    // rotation and pulse widths in microseconds.
+	    var units = {
+	        '%': {
+	            from: function(v) { return state().min + v / 100 * (state().max - state().min); },
+	            to: function(x) { return 100 * (x - state().min) / (state().max - state().min); }
+	        },
+	        'value': {
+	            from: function(v) { return v; },
+	            to: function(x) { return x; }
+	        }
+	    };
+	    if (summary.degrees) {
+	        units['°'] = {
+	            from: function(v) { return state().min + v / summary.degrees * (state().max - state().min); },
+	            to: function(x) { return summary.degrees * (x - state().min) / (state().max - state().min); }
+	        };
+	    }
+	    if (summary.frequency) {
+	        units['µs'] = {
+	            from: function(v) { return v * summary.frequency / 1e6; },
+	            to: function(x) { return x * 1e6 / summary.frequency; }
+	        };
+	    }
+	    var position = $('position');
+	    var unit = $('unit');
+	    for (var u in units) {
+	        unit.add(new Option(u, u));
+	    }
+	    if (localStorage.getItem('unit') in units) {
+	        unit.value = localStorage.getItem('unit');
+	    }
+	    unit.onchange = function(){
+	        localStorage.setItem('unit', unit.value);
+	        position.value = '';
+	    };
+	    position.oninput = function(){
+	        position.setCustomValidity('');
+	    };
+	    $('position-form').onsubmit = function(e){
+	        e.preventDefault();
+	        var s = state();
+	        if (!s) {
+	            return;
+	        }
+	        var u = units[unit.value];
+	        var target = u.from(parseFloat(position.value));
+	        // Allow for rounding when converting between units.
+	        var epsilon = (s.max - s.min) * 1e-9;
+	        if (isNaN(target) || target < s.min - epsilon || target > s.max + epsilon) {
+	            var a = u.to(s.min), b = u.to(s.max);
+	            position.setCustomValidity('The position must be between ' + +Math.min(a, b).toPrecision(4) + ' and ' + +Math.max(a, b).toPrecision(4) + ' ' + unit.value + '.');
+	            position.reportValidity();
+	            return;
+	        }
+	        move(name, Math.min(s.max, Math.max(s.min, target)));
+	    };
+
+	    // The calibration wizard finds both ends of the mechanism's travel
+	    // and its home position by nudging the servo, ignoring its range.
+	    var wizard = {steps: [
+	        'Nudge the servo to one end of its travel.',
+	        'Nudge the servo to the other end of its travel.',
+	        'Nudge the servo to its home position.'
+	    ]};
+	    var calibrate = function(x) {
+	        wizard.position = Math.min(1, Math.max(0, Math.round(x * 1000) / 1000));
+	        $('wizard-position').textContent = wizard.position.toFixed(3);
+	        return api('calibration/move', {position: wizard.position});
+	    };
+	    var step = function(i) {
+	        wizard.step = i;
+	        $('wizard-step').textContent = wizard.steps[i];
+	        $('wizard-next').textContent = i === wizard.steps.length - 1 ? 'save' : 'next';
+	    };
+	    $('calibrate').onclick = function(e){
+	        wizard.ends = [];
+	        $('wizard').hidden = false;
+	        step(0);
+	        calibrate(state() ? state().min : .5);
+	        e.preventDefault();
+	    };
+	    root.querySelectorAll('[data-nudge]').forEach(function(n) {
+	        n.onclick = function(e){
+	            calibrate(wizard.position + parseFloat(n.dataset.nudge));
+	            e.preventDefault();
+	        };
+	    });
+	    $('wizard-next').onclick = function(e){
+	        if (wizard.step < wizard.steps.length - 1) {
+	            wizard.ends.push(wizard.position);
+	            step(wizard.step + 1);
+	            calibrate(wizard.ends.length === 1 ? (state() ? state().max : .5) : (wizard.ends[0] + wizard.ends[1]) / 2);
+	        } else {
+	            var c = {min: Math.min(wizard.ends[0], wizard.ends[1]), max: Math.max(wizard.ends[0], wizard.ends[1]), home: wizard.position};
+	            api('calibration', c).then(function(r) {
+	                return r.ok ? 'calibration saved; export the settings to keep it across restarts' : r.text();
+	            }).then(alert);
+	            $('wizard').hidden = true;
+	        }
+	        e.preventDefault();
+	    };
+	    $('wizard-cancel').onclick = function(e){
+	        $('wizard').hidden = true;
+	        p.servor('home');
+	        e.preventDefault();
+	    };
+
+	    // Recording captures the moves made with any control, e.g. by
+	    // jogging with the arrow keys or dragging the slider, so that a
+	    // gesture can be performed once, trimmed, and saved as a sequence.
+	    var recorded = [];
+	    var recording = false;
+	    var trimStart = $('trim-start'), trimEnd = $('trim-end');
+	    var recordButton = function(on) {
+	        recording = on;
+	        $('record').textContent = on ? 'stop recording' : 'record';
+	        $('record').style.textDecoration = on ? 'underline' : '';
+	    };
+	    $('record').onclick = function(e){
+	        if (!recording) {
+	            api('recording/start').then(function(r) {
+	                if (r.ok) {
+	                    recordButton(true);
+	                    $('recorder').hidden = true;
+	                }
+	            });
+	        } else {
+	            api('recording/stop').then(function(r) {
+	                return r.ok ? r.json() : [];
+	            }).then(function(keyframes) {
+	                recordButton(false);
+	                if (!keyframes.length) {
+	                    return;
+	                }
+	                recorded = keyframes;
+	                trimStart.max = trimEnd.max = keyframes[keyframes.length - 1].time;
+	                trimStart.value = 0;
+	                trimEnd.value = trimEnd.max;
+	                $('recorder').hidden = false;
+	                drawRecording();
+	            });
+	        }
+	        e.preventDefault();
+	    };
+	    // at returns the recorded position at time t.
+	    var at = function(t) {
+	        for (var i = 1; i < recorded.length; i++) {
+	            var a = recorded[i - 1], b = recorded[i];
+	            if (b.time >= t) {
+	                return a.position + (b.position - a.position) * (t - a.time) / (b.time - a.time);
+	            }
+	        }
+	        return recorded[recorded.length - 1].position;
+	    };
+	    // trimmed returns the part of the recording between the trim
+	    // handles, shifted so that it starts at time 0.
+	    var trimmed = function() {
+	        var a = parseFloat(trimStart.value), b = parseFloat(trimEnd.value);
+	        var round = function(t) {
+	            return Math.round(t * 1000) / 1000;
+	        };
+	        var keyframes = [{time: 0, position: at(a)}];
+	        recorded.forEach(function(k) {
+	            if (k.time > a && k.time < b && round(k.time - a) > keyframes[keyframes.length - 1].time) {
+	                keyframes.push({time: round(k.time - a), position: k.position});
+	            }
+	        });
+	        if (round(b - a) > keyframes[keyframes.length - 1].time) {
+	            keyframes.push({time: round(b - a), position: at(b)});
+	        }
+	        return keyframes;
+	    };
+	    var drawRecording = function() {
+	        var s = state();
+	        var keyframes = trimmed();
+	        var end = keyframes[keyframes.length - 1].time || 1;
+	        $('recording-curve').setAttribute('d', keyframes.map(function(k, i) {
+	            return (i ? 'L ' : 'M ') + 200 * k.time / end + ' ' + 50 * (s.max - k.position) / (s.max - s.min);
+	        }).join(' '));
+	        $('trim-length').textContent = keyframes[keyframes.length - 1].time.toFixed(2) + 's';
+	    };
+	    trimStart.oninput = function(){
+	        trimEnd.value = Math.max(trimEnd.value, trimStart.value);
+	        drawRecording();
+	    };
+	    trimEnd.oninput = function(){
+	        trimStart.value = Math.min(trimStart.value, trimEnd.value);
+	        drawRecording();
+	    };
+	    $('recording-form').onsubmit = function(e){
+	        e.preventDefault();
+	        var sequence = $('recording-name').value;
+	        fetch('/api/'+encodeURIComponent(name)+'/sequences/'+encodeURIComponent(sequence), {method: 'PUT', body: JSON.stringify(trimmed())}).then(function(r) {
+	            if (r.ok) {
+	                $('recorder').hidden = true;
+	                return 'sequence saved; export the settings to keep it across restarts';
+	            }
+	            return r.text();
+	        }).then(alert);
+	    };
+	    $('recording-discard').onclick = function(e){
+	        $('recorder').hidden = true;
+	        e.preventDefault();
+	    };
+
+	    // Holding an arrow key jogs the servo with samples sent at a steady
+	    // rate, rather than relying on the operating system's key repeat.
+	    // The velocity starts at a quarter of the range per second and
+	    // accelerates to the full range per second over two seconds.
+	    // The servo stops on keyup; if the page loses the connection, it
+	    // stops on its own once no samples arrive.
+	    p.jog = function(direction) {
+	        if (p.jogging) {
+	            return;
+	        }
+	        var start = Date.now();
+	        var sample = function() {
+	            var range = state() ? state().max - state().min : 1;
+	            var t = (Date.now() - start) / 1000;
+	            api('jog', {velocity: direction * range * Math.min(1, .25 + .375 * t)});
+	        };
+	        p.patrolling = false;
+	        $('patrol').style.textDecoration = '';
+	        sample();
+	        p.jogging = setInterval(sample, 100);
+	    };
+	    p.stopJogging = function() {
+	        if (p.jogging) {
+	            clearInterval(p.jogging);
+	            p.jogging = null;
+	            api('jog', {velocity: 0});
+	        }
+	    };
+	    // act performs the action of a control. Controls of the
+	    // vertical axis only step and jog the tilt servo of a pair.
+	    p.act = function(c) {
+	        switch (c.action) {
+	        case 'step':
+	        case 'jog':
+	            if (c.axis === 'vertical') {
+	                return;
+	            }
+	            if (c.action === 'jog') {
+	                p.jog(c.direction);
+	            } else {
+	                // Higher positions are to the left.
+	                p.servor(c.direction > 0 ? 'left' : 'right');
+	            }
+	            return;
+	        case 'preset':
+	        case 'tour':
+	        case 'sequence':
+	            p.servor(c.action + 's/' + encodeURIComponent(c.name));
+	            return;
+	        }
+	        p.servor(c.action);
+	    };
+	    // A gamepad stick jogs the servo with its deflection, which the
+	    // server shapes with the deadzone and curve of the gamepad input.
+	    // Once the stick rests, a single sample stops the servo.
+	    p.stick = function(x) {
+	        var deflected = Math.abs(x) >= .01;
+	        if (!deflected && !p.deflected) {
+	            return;
+	        }
+	        p.deflected = deflected;
+	        // Higher positions are to the left.
+	        api('jog', {axis: deflected ? -x : 0, source: 'gamepad'});
+	    };
+	    p.release = function() {
+	        p.stopJogging();
+	    };
+	    if (allowed('move')) {
+	        presetNames(name).then(function(names) {
+	            presetButtons($('presets'), names, p);
+	        });
+	    }
+
+	    // The dial shows the commanded position as a solid arm and,
+	    // for drivers with feedback, the measured position as a dashed
+	    // arm; both glide towards the latest values. While the servo
+	    // glides, a tick on the rim marks where it is heading.
+	    var angle = function(x) {
+	        // Higher positions are to the left.
+	        return 90 - 180 * (x - state().min) / (state().max - state().min);
+	    };
+	    p.update = function() {
+	        var s = state();
+	        if (!s) {
+	            return;
+	        }
+	        p.targets.needle = angle(s.position);
+	        if (s.measured !== undefined) {
+	            p.targets.measured = angle(s.measured);
+	            $('measured').style.display = '';
+	        }
+	        p.targets.target = angle(s.target);
+	        $('target').style.display = s.target === s.position ? 'none' : '';
+	        slider.min = s.min;
+	        slider.max = s.max;
+	        if (document.activeElement !== slider) {
+	            slider.value = s.position;
+	        }
+	        position.placeholder = +units[unit.value].to(s.position).toPrecision(4);
+	    };
+	    p.animate = function() {
+	        for (var c in p.targets) {
+	            p.angles[c] = glide(p.angles[c], p.targets[c]);
+	            $(c).setAttribute('transform', 'rotate(' + p.angles[c] + ')');
+	        }
+	    };
+	    return p;
+	};
+
+	// pairPanel renders a pad that moves two servos at once, e.g. a
+	// pan/tilt head: the horizontal axis controls the pan servo and the
+	// vertical axis the tilt servo, with higher positions to the left
+	// and to the top, respectively.
+	pairPanel = function(pair) {
+	    var root = document.createElement('div');
+	    root.appendChild(document.getElementById('pair-panel').content.cloneNode(true));
+	    var $ = function(c) {
+	        return root.querySelector('.' + c);
+	    };
+	    var p = {name: pair.name, root: root, offsets: {}, targets: {}};
+	    var pad = $('pad');
+	    var last = 0;
+	    var point = function(e) {
+	        var pan = states[pair.pan], tilt = states[pair.tilt];
+	        var r = pad.getBoundingClientRect();
+	        // Moves are sent at most every 50ms while dragging.
+	        if (!pan || !tilt || !allowed('move') || Date.now() - last < 50) {
+	            return;
+	        }
+	        last = Date.now();
+	        var x = Math.min(1, Math.max(0, (e.clientX - r.left) / r.width));
+	        var y = Math.min(1, Math.max(0, (e.clientY - r.top) / r.height));
+	        move(pair.pan, pan.max - x * (pan.max - pan.min));
+	        move(pair.tilt, tilt.max - y * (tilt.max - tilt.min));
+	    };
+	    pad.onpointerdown = function(e){
+	        pad.setPointerCapture(e.pointerId);
+	        last = 0;
+	        point(e);
+	        e.preventDefault();
+	    };
+	    pad.onpointermove = function(e){
+	        if (pad.hasPointerCapture(e.pointerId)) {
+	            point(e);
+	        }
+	    };
+	    var both = function(action) {
+	        post('/api/'+encodeURIComponent(pair.pan)+'/'+action);
+	        post('/api/'+encodeURIComponent(pair.tilt)+'/'+action);
+	    };
+	    $('home').onclick = function(e){
+	        both('home');
+	        e.preventDefault();
+	    };
+	    $('center').onclick = function(e){
+	        both('center');
+	        e.preventDefault();
+	    };
+	    // Steps and jogs address the pan servo for the horizontal
+	    // axis and the tilt servo for the vertical axis; all other
+	    // actions address both servos.
+	    p.act = function(c) {
+	        if (c.action === 'step' || c.action === 'jog') {
+	            findServo(c.axis === 'vertical' ? pair.tilt : pair.pan).act({action: c.action, direction: c.direction});
+	            return;
+	        }
+	        findServo(pair.pan).act(c);
+	        findServo(pair.tilt).act(c);
+	    };
+	    p.release = function() {
+	        findServo(pair.pan).stopJogging();
+	        findServo(pair.tilt).stopJogging();
+	    };
+	    // The presets of a pair are those that both of its servos define.
+	    if (allowed('move')) {
+	        Promise.all([presetNames(pair.pan), presetNames(pair.tilt)]).then(function(l) {
+	            presetButtons($('presets'), l[0].filter(function(n) {
+	                return l[1].indexOf(n) >= 0;
+	            }), p);
+	        });
+	    }
+	    p.stick = function(x, y) {
+	        findServo(pair.pan).stick(x);
+	        findServo(pair.tilt).stick(y);
+	    };
+	    var offset = function(s, x) {
+	        return 100 * (s.max - x) / (s.max - s.min);
+	    };
+	    p.update = function() {
+	        var pan = states[pair.pan], tilt = states[pair.tilt];
+	        if (!pan || !tilt) {
+	            return;
+	        }
+	        p.targets.dot = [offset(pan, pan.position), offset(tilt, tilt.position)];
+	        if (pan.measured !== undefined && tilt.measured !== undefined) {
+	            p.targets.measured = [offset(pan, pan.measured), offset(tilt, tilt.measured)];
+	            $('measured').style.display = '';
+	        }
+	        p.targets.target = [offset(pan, pan.target), offset(tilt, tilt.target)];
+	        $('target').style.display = pan.target === pan.position && tilt.target === tilt.position ? 'none' : '';
+	    };
+	    p.animate = function() {
+	        for (var c in p.targets) {
+	            var o = p.offsets[c] || [];
+	            p.offsets[c] = [glide(o[0], p.targets[c][0]), glide(o[1], p.targets[c][1])];
+	            $(c).style.left = p.offsets[c][0] + '%';
+	            $(c).style.top = p.offsets[c][1] + '%';
+	        }
+	    };
+	    return p;
+	};
+
+	// With more than one panel, each gets a tab and
+	// the keyboard controls the panel that is shown.
+	show = function(p) {
+	    active = p;
+	    panels.forEach(function(q) {
+	        q.root.hidden = q !== p;
+	        q.tab.style.textDecoration = q === p ? 'underline' : '';
+	    });
+	};
+	addPanel = function(p) {
+	    applyScopes(p.root);
+	    document.getElementById('panels').appendChild(p.root);
+	    p.tab = document.createElement('div');
+	    p.tab.textContent = p.name;
+	    p.tab.style.cursor = 'pointer';
+	    p.tab.onclick = function(e){
+	        show(p);
+	        e.preventDefault();
+	    };
+	    document.getElementById('tab-list').appendChild(p.tab);
+	    panels.push(p);
+	};
+	// update polls the live position of each servo.
+	update = function() {
+	    Promise.all(servoNames.map(function(name) {
+	        return fetch('/api/'+encodeURIComponent(name)+'/position').then(function(r) {
+	            return r.json();
+	        });
+	    })).then(function(l) {
+	        l.forEach(function(s) {
+	            states[s.servo] = s;
+	        });
+	        panels.forEach(function(p) {
+	            p.update();
+	        });
+	    }).finally(function() {
+	        setTimeout(update, 200);
+	    });
+	};
+	// The host is checked for conditions that make servos misbehave,
+	// e.g. brownouts caused by the stall current of a servo, and
+	// the servos are checked for maintenance that is due.
+	checkSystem = function() {
+	    Promise.all([fetch('/api/system'), fetch('/api/status')]).then(function(rs) {
+	        return Promise.all(rs.map(function(r) {
+	            return r.json();
+	        }));
+	    }).then(function(rs) {
+	        var sys = rs[0];
+	        var warnings = [];
+	        rs[1].servos.forEach(function(s) {
+	            if (s.maintenanceDue) {
+	                warnings.push('Servo ' + s.servo + ' is due for maintenance.');
+	            }
+	        });
+	        if (sys.power && sys.power.underVoltage) {
+	            warnings.push('The supply voltage is too low; check the power supply of the servos and the board.');
+	        } else if (sys.power && sys.power.underVoltageOccurred) {
+	            warnings.push('The supply voltage dropped too low since boot; servos that stall may cause brownouts.');
+	        }
+	        if (sys.motionInhibited) {
+	            warnings.push('The supply voltage is below the cutoff; the servos are parked until it recovers.');
+	        }
+	        if (sys.thermalThrottled) {
+	            warnings.push('The board is too hot; continuous motions are paused until it cools down.');
+	        }
+	        document.getElementById('warning').textContent = warnings.join(' ');
+	        document.getElementById('warning').hidden = !warnings.length;
+	    }).finally(function() {
+	        setTimeout(checkSystem, 5000);
+	    });
+	};
+	// findServo returns the panel of the servo with the given name.
+	findServo = function(n) {
+	    for (var i = 0; i < panels.length; i++) {
+	        if (panels[i].name === n && panels[i].jog) {
+	            return panels[i];
+	        }
+	    }
+	};
+	// controls map keys and gamepad buttons to actions; they
+	// are configured on the server and loaded at startup.
+	controls = [];
+	control = function(key, button) {
+	    for (var i = 0; i < controls.length; i++) {
+	        var c = controls[i];
+	        if (key !== undefined ? c.key === key : c.button === button) {
+	            return c;
+	        }
+	    }
+	};
+	// perform runs the action of a control on the servo that it names
+	// or else on the active panel, if the scopes of the token allow it.
+	perform = function(c) {
+	    var target = c.servo ? findServo(c.servo) : active;
+	    if (!target || !allowed(c.action === 'tour' || c.action === 'sequence' ? 'sequences' : 'move')) {
+	        return false;
+	    }
+	    target.act(c);
+	    return true;
+	};
+	// release stops the jog of a control once its key or button is let go.
+	release = function(c) {
+	    var target = c.servo ? findServo(c.servo) : active;
+	    if (target && c.action === 'jog') {
+	        target.release();
+	    }
+	};
+	// The left stick of the first connected gamepad jogs the
+	// active panel, sampled every 100ms like the arrow keys;
+	// its buttons perform their controls.
+	lastStick = 0;
+	buttons = [];
+	pollGamepad = function() {
+	    var pads = navigator.getGamepads ? navigator.getGamepads() : [];
+	    for (var i = 0; i < pads.length; i++) {
+	        if (pads[i] && pads[i].axes.length >= 2) {
+	            for (var j = 0; j < pads[i].buttons.length; j++) {
+	                var pressed = pads[i].buttons[j].pressed;
+	                var c = control(undefined, j);
+	                if (pressed !== !!buttons[j] && c) {
+	                    if (pressed) {
+	                        perform(c);
+	                    } else {
+	                        release(c);
+	                    }
+	                }
+	                buttons[j] = pressed;
+	            }
+	            if (active && active.stick && allowed('move') && Date.now() - lastStick >= 100) {
+	                lastStick = Date.now();
+	                active.stick(pads[i].axes[0], pads[i].axes[1]);
+	            }
+	            return;
+	        }
+	    }
+	};
+	animate = function() {
+	    panels.forEach(function(p) {
+	        p.animate();
+	    });
+	    pollGamepad();
+	    requestAnimationFrame(animate);
+	};
+	document.getElementById('import').onchange = function(e){
+	    if (e.target.files.length) {
+	        fetch('/api/settings/import', {method: 'POST', body: e.target.files[0]}).then(function(r) {
+	            return r.ok ? 'settings imported' : r.text();
+	        }).then(alert);
+	        e.target.value = '';
+	    }
+	};
+	fetch('/api/me').then(function(r) {
+	    return r.json();
+	}).then(function(me) {
+	    scopes = me.scopes;
+	    source = me.source || 'ui';
+	    var chosen = new URLSearchParams(location.search).get('profile') || sessionStorage.getItem('profile');
+	    profile = chosen === 'simple' || chosen === 'advanced' ? chosen : me.profile;
+	    showProfile();
+	    if (!allowed('read')) {
+	        return;
+	    }
+	    Promise.all([fetch('/api/servos'), fetch('/api/pairs'), fetch('/api/controls')].map(function(f) {
+	        return f.then(function(r) {
+	            return r.json();
+	        });
+	    })).then(function(res) {
+	        res[0].forEach(function(s) {
+	            servoNames.push(s.name);
+	            addPanel(servoPanel(s));
+	        });
+	        res[1].forEach(function(pair) {
+	            addPanel(pairPanel(pair));
+	        });
+	        controls = res[2];
+	        document.getElementById('tabs').hidden = panels.length < 2;
+	        show(panels[0]);
+	        update();
+	        checkSystem();
+	        requestAnimationFrame(animate);
+	    });
+	});
+	keys = {
+	    Left: 'ArrowLeft',
+	    Right: 'ArrowRight',
+	    Up: 'ArrowUp',
+	    Down: 'ArrowDown'
+	};
+        window.addEventListener('keydown', function (e) {
+            var key = keys[e.key] || e.key;
+            var c = control(key);
+            // Form controls keep their own keyboard handling.
+            if (!c || e.target.tagName === 'INPUT' || e.target.tagName === 'SELECT' || !perform(c)) {
+                return;
+            }
+            e.preventDefault();
+        });
+        window.addEventListener('keyup', function (e) {
+            var key = keys[e.key] || e.key;
+            var c = control(key);
+            if (!c || c.action !== 'jog') {
+                return;
+            }
+            release(c);
+            e.preventDefault();
+        });
+        window.addEventListener('blur', function () {
+            if (active) {
+                active.release();
+            }
+        });
+    </script>
+</body>
+</html>`
+
+// editorHTML is the sequence editor, which edits the keyframes of a
+// sequence on a timeline and previews it on a virtual servo.
+const editorHTML = `<!doctype html>
+<html style="
+    align-items: center;
+    display: flex;
+    height: 100%;
+    justify-content: center;
+    width: 100%;
+">
+<head>
+  <meta charset="utf-8">
+  <title>Servor sequences</title>
+  <meta name="description" content="">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+</head>
+<body style="
+    width: 100%;
+">
+    <div style="
+	border: solid 5px;
+        font-family: sans-serif;
+        margin: auto;
+        max-width: 60em;
+        padding: 2em;
+    ">
+	<a href="/" style="
+	    color: #000;
+	    font-size: 4em;
+	    font-weight: 500;
+	    line-height: 1;
+	    text-decoration: none;
+	">servor</a>
+	<div style="
+    	    display: flex;
+    	    flex-wrap: wrap;
+    	    gap: 1em;
+    	    margin-top: 1em;
+    	">
+	    <label>servo <select id="servo"></select></label>
+	    <label>sequence <select id="sequence"></select></label>
+	    <label>name <input id="name" required pattern="[^/]+" style="
+	        width: 8em;
+	    "></label>
+	</div>
+	<svg id="timeline" viewBox="0 0 1000 300" style="
+	    border: solid 2px;
+	    box-sizing: border-box;
+	    display: block;
+	    margin-top: 1em;
+	    touch-action: none;
+	    width: 100%;
+	">
+	    <g id="grid" stroke="#ccc" font-size="14" fill="#999"></g>
+	    <path id="curve" fill="none" stroke="#000" stroke-width="3"/>
+	    <line id="playhead" y1="0" y2="300" stroke="#999" stroke-width="2" style="display: none;"/>
+	    <g id="keyframes"></g>
+	</svg>
+	<div style="
+    	    font-size: .8em;
+    	    margin-top: .25em;
+    	">Click the timeline to add a keyframe and drag keyframes to change their time and position.</div>
+	<div style="
+    	    align-items: center;
+    	    display: flex;
+    	    flex-wrap: wrap;
+    	    gap: 1em;
+    	    margin-top: 1em;
+    	">
+	    <svg viewBox="-50 -50 100 58" style="
+	        width: 6em;
+	    ">
+	        <path d="M -45 0 A 45 45 0 0 1 45 0" fill="none" stroke="#000" stroke-width="5"/>
+	        <line id="needle" x1="0" y1="0" x2="0" y2="-38" stroke="#000" stroke-width="6" stroke-linecap="round"/>
+	        <circle r="7"/>
+	    </svg>
+	    <fieldset id="keyframe" disabled style="
+	        display: flex;
+	        gap: 1em;
+	    ">
+	        <legend>keyframe</legend>
+	        <label>time (s) <input id="time" type="number" step="any" min="0" style="
+	            width: 5em;
+	        "></label>
+	        <label>position <input id="position" type="number" step="any" style="
+	            width: 5em;
+	        "></label>
+	        <label>easing <select id="easing"></select></label>
+	        <button id="remove">remove</button>
+	    </fieldset>
+	</div>
+	<div style="
+    	    display: flex;
+    	    gap: 1em;
+    	    margin-top: 1em;
+    	">
+	    <button id="preview">preview</button>
+	    <button id="play" data-scope="sequences" hidden>play on servo</button>
+	    <button id="save" data-scope="config" hidden>save</button>
+	    <button id="delete" data-scope="config" hidden>delete</button>
+	</div>
+    </div>
+    <script>
+	// The easings mirror those of the server; a keyframe's easing
+	// shapes the move from the previous keyframe.
+	easings = {
+	    'linear': function(t) { return t; },
+	    'ease-in': function(t) { return t * t; },
+	    'ease-out': function(t) { return t * (2 - t); },
+	    'ease-in-out': function(t) { return t < .5 ? 2 * t * t : -1 + (4 - 2 * t) * t; }
+	};
+	W = 1000;
+	H = 300;
+	PAD = 20;
+	scopes = [];
+	source = 'ui';
+	servo = null;
+	keyframes = [];
+	selected = null;
+	sequences = {};
+	previewing = null;
+	$ = function(id) {
+	    return document.getElementById(id);
+	};
+	for (var e in easings) {
+	    $('easing').add(new Option(e, e));
+	}
+	api = function(path, method, body) {
+	    return fetch('/api/'+encodeURIComponent(servo.servo)+path+'?source='+encodeURIComponent(source), {method: method || 'GET', body: body === undefined ? undefined : JSON.stringify(body)});
+	};
+	duration = function() {
+	    var last = keyframes.length ? keyframes[keyframes.length - 1].time : 0;
+	    return Math.max(5, Math.ceil(last + 1));
+	};
+	x = function(t) {
+	    return PAD + t / duration() * (W - 2 * PAD);
+	};
+	// Higher positions are at the top, as on the dial, where
+	// they are to the left.
+	y = function(p) {
+	    return H - PAD - (p - servo.min) / (servo.max - servo.min) * (H - 2 * PAD);
+	};
+	// positionAt returns the position of the servo at time t when the
+	// sequence is played from the given start position.
+	positionAt = function(t, start) {
+	    var from = {time: 0, position: start};
+	    for (var i = 0; i < keyframes.length; i++) {
+	        var k = keyframes[i];
+	        if (k.time > t) {
+	            var ease = easings[k.easing || 'linear'];
+	            return from.position + (k.position - from.position) * ease((t - from.time) / (k.time - from.time));
+	        }
+	        from = k;
+	    }
+	    return from.position;
+	};
+	svgElement = function(tag, attrs) {
+	    var e = document.createElementNS('http://www.w3.org/2000/svg', tag);
+	    for (var a in attrs) {
+	        e.setAttribute(a, attrs[a]);
+	    }
+	    return e;
+	};
+	render = function() {
+	    var grid = $('grid');
+	    while (grid.firstChild) {
+	        grid.removeChild(grid.firstChild);
+	    }
+	    for (var s = 0; s <= duration(); s++) {
+	        grid.appendChild(svgElement('line', {x1: x(s), x2: x(s), y1: PAD, y2: H - PAD}));
+	        grid.appendChild(svgElement('text', {x: x(s) + 3, y: H - 5, stroke: 'none'})).textContent = s + 's';
+	    }
+	    var start = servo.position;
+	    var d = 'M ' + x(0) + ' ' + y(start);
+	    for (var px = 0; px <= W - 2 * PAD; px += 4) {
+	        var t = px / (W - 2 * PAD) * duration();
+	        d += ' L ' + x(t) + ' ' + y(positionAt(t, start));
+	    }
+	    $('curve').setAttribute('d', d);
+	    var g = $('keyframes');
+	    while (g.firstChild) {
+	        g.removeChild(g.firstChild);
+	    }
+	    keyframes.forEach(function(k) {
+	        var c = svgElement('circle', {cx: x(k.time), cy: y(k.position), r: 10, fill: k === selected ? '#000' : '#fff', stroke: '#000', 'stroke-width': 3});
+	        c.style.cursor = 'move';
+	        c.onpointerdown = function(e){
+	            select(k);
+	            c.setPointerCapture(e.pointerId);
+	            c.onpointermove = function(e){
+	                drag(k, e);
+	            };
+	            e.stopPropagation();
+	            e.preventDefault();
+	        };
+	        c.onpointerup = function(){
+	            c.onpointermove = null;
+	        };
+	        g.appendChild(c);
+	    });
+	    $('keyframe').disabled = !selected;
+	    if (selected) {
+	        $('time').value = selected.time;
+	        $('position').value = selected.position;
+	        $('easing').value = selected.easing || 'linear';
+	    }
+	};
+	select = function(k) {
+	    selected = k;
+	    render();
+	};
+	// point converts the pointer position to a time and position.
+	point = function(e) {
+	    var p = $('timeline').createSVGPoint();
+	    p.x = e.clientX;
+	    p.y = e.clientY;
+	    p = p.matrixTransform($('timeline').getScreenCTM().inverse());
+	    var t = Math.max(0, (p.x - PAD) / (W - 2 * PAD) * duration());
+	    var pos = servo.min + (H - PAD - p.y) / (H - 2 * PAD) * (servo.max - servo.min);
+	    return {
+	        time: Math.round(t * 100) / 100,
+	        position: +Math.min(servo.max, Math.max(servo.min, pos)).toPrecision(4)
+	    };
+	};
+	// Keyframes stay in order: a keyframe cannot be dragged
+	// past its neighbours.
+	drag = function(k, e) {
+	    var i = keyframes.indexOf(k);
+	    var p = point(e);
+	    var lo = i > 0 ? keyframes[i - 1].time + .01 : 0;
+	    var hi = i < keyframes.length - 1 ? keyframes[i + 1].time - .01 : Infinity;
+	    k.time = Math.round(Math.min(hi, Math.max(lo, p.time)) * 100) / 100;
+	    k.position = p.position;
+	    render();
+	};
+	$('timeline').onpointerdown = function(e){
+	    if (!servo) {
+	        return;
+	    }
+	    var k = point(e);
+	    for (var i = 0; i < keyframes.length; i++) {
+	        if (keyframes[i].time === k.time) {
+	            return;
+	        }
+	    }
+	    k.easing = 'linear';
+	    keyframes.push(k);
+	    keyframes.sort(function(a, b) { return a.time - b.time; });
+	    select(k);
+	};
+	$('time').onchange = function(){
+	    var t = parseFloat($('time').value);
+	    var i = keyframes.indexOf(selected);
+	    var clash = keyframes.some(function(k) { return k !== selected && k.time === t; });
+	    if (isNaN(t) || t < 0 || clash) {
+	        render();
+	        return;
+	    }
+	    selected.time = t;
+	    keyframes.sort(function(a, b) { return a.time - b.time; });
+	    render();
+	};
+	$('position').onchange = function(){
+	    var p = parseFloat($('position').value);
+	    if (!isNaN(p)) {
+	        selected.position = Math.min(servo.max, Math.max(servo.min, p));
+	    }
+	    render();
+	};
+	$('easing').onchange = function(){
+	    selected.easing = $('easing').value;
+	    render();
+	};
+	$('remove').onclick = function(e){
+	    keyframes.splice(keyframes.indexOf(selected), 1);
+	    select(null);
+	    e.preventDefault();
+	};
+	// The preview plays the sequence on the virtual servo only,
+	// starting from the servo's current position.
+	needle = function(p) {
+	    $('needle').setAttribute('transform', 'rotate(' + (90 - 180 * (p - servo.min) / (servo.max - servo.min)) + ')');
+	};
+	$('preview').onclick = function(){
+	    if (previewing) {
+	        cancelAnimationFrame(previewing);
+	    }
+	    var start = performance.now();
+	    var end = keyframes.length ? keyframes[keyframes.length - 1].time : 0;
+	    var frame = function(now) {
+	        var t = (now - start) / 1000;
+	        $('playhead').style.display = '';
+	        $('playhead').setAttribute('x1', x(t));
+	        $('playhead').setAttribute('x2', x(t));
+	        needle(positionAt(t, servo.position));
+	        if (t < end) {
+	            previewing = requestAnimationFrame(frame);
+	        } else {
+	            previewing = null;
+	            $('playhead').style.display = 'none';
+	        }
+	    };
+	    previewing = requestAnimationFrame(frame);
+	};
+	// Keyframes with the default easing are saved without one.
+	body = function() {
+	    return keyframes.map(function(k) {
+	        var kf = {time: k.time, position: k.position};
+	        if (k.easing && k.easing !== 'linear') {
+	            kf.easing = k.easing;
+	        }
+	        return kf;
+	    });
+	};
+	report = function(ok) {
+	    return function(r) {
+	        return r.ok ? ok : r.text();
+	    };
+	};
+	$('save').onclick = function(){
+	    var name = $('name').value;
+	    if (!$('name').reportValidity()) {
+	        return;
+	    }
+	    api('/sequences/'+encodeURIComponent(name), 'PUT', body()).then(report('sequence saved; export the settings to keep it across restarts')).then(function(msg) {
+	        alert(msg);
+	        load(name);
+	    });
+	};
+	$('delete').onclick = function(){
+	    var name = $('sequence').value;
+	    if (!name || !confirm('Delete the sequence ' + name + '?')) {
+	        return;
+	    }
+	    api('/sequences/'+encodeURIComponent(name), 'DELETE').then(report('sequence deleted')).then(function(msg) {
+	        alert(msg);
+	        load('');
+	    });
+	};
+	$('play').onclick = function(){
+	    var name = $('sequence').value;
+	    if (!name) {
+	        alert('save the sequence before playing it on the servo');
+	        return;
+	    }
+	    api('/sequences/'+encodeURIComponent(name), 'POST').then(report('playing ' + name)).then(function(msg) {
+	        if (msg !== 'playing ' + name) {
+	            alert(msg);
+	        }
+	    });
+	};
+	edit = function(name) {
+	    $('sequence').value = name;
+	    $('name').value = name;
+	    keyframes = (sequences[name] || []).map(function(k) {
+	        return {time: k.time, position: k.position, easing: k.easing || 'linear'};
+	    });
+	    select(null);
+	};
+	$('sequence').onchange = function(){
+	    edit($('sequence').value);
+	};
+	// load lists the sequences of the servo and edits the given one.
+	load = function(name) {
+	    api('/sequences').then(function(r) {
+	        return r.json();
+	    }).then(function(s) {
+	        sequences = s;
+	        var list = $('sequence');
+	        while (list.options.length) {
+	            list.remove(0);
+	        }
+	        list.add(new Option('new sequence', ''));
+	        Object.keys(s).sort().forEach(function(n) {
+	            list.add(new Option(n, n));
+	        });
+	        edit(name in s ? name : '');
+	    });
+	};
+	fetch('/api/me').then(function(r) {
+	    return r.json();
+	}).then(function(me) {
+	    scopes = me.scopes;
+	    source = me.source || 'ui';
+	    document.querySelectorAll('[data-scope]').forEach(function(e) {
+	        e.hidden = scopes.indexOf(e.dataset.scope) < 0;
+	    });
+	    return fetch('/v1/servos');
+	}).then(function(r) {
+	    return r.json();
+	}).then(function(l) {
+	    var states = {};
+	    l.servos.forEach(function(s) {
+	        states[s.servo] = s;
+	        $('servo').add(new Option(s.servo, s.servo));
+	    });
+	    $('servo').onchange = function(){
+	        servo = states[$('servo').value];
+	        needle(servo.position);
+	        load('');
+	    };
+	    if (location.hash.slice(1) in states) {
+	        $('servo').value = location.hash.slice(1);
+	    }
+	    $('servo').onchange();
+	});
+    </script>
+</body>
+</html>`
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"periph.io/x/conn/v3/driver/driverreg"
+)
+
+// availableTimeout bounds the check of a driver that connects to a daemon.
+const availableTimeout = 2 * time.Second
+
+// available checks that the device of the driver can be reached
+// without moving any servo.
+func (c driverConfig) available() error {
+	switch c.Type {
+	case "pigpiod":
+		conn, err := net.DialTimeout("tcp", c.Device, availableTimeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case "pi-blaster":
+		// Opening the FIFO without blocking fails
+		// unless pi-blaster is reading from it.
+		f, err := os.OpenFile(c.Device, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+		if err != nil {
+			return err
+		}
+		return f.Close()
+	case "periph":
+		// periph.io accesses the peripherals directly and has no device.
+		_, err := driverreg.Init()
+		return err
+	case "mock":
+		// The mock driver needs no device, only valid physics.
+		p := mockPhysics{Speed: c.MockSpeed, Acceleration: c.MockAcceleration, Overshoot: c.MockOvershoot}
+		return p.validate()
+	case "remote":
+		r, err := newRemoteNode(c.Device, c.Frequency)
+		if err != nil {
+			return err
+		}
+		// UDP cannot tell whether the node is listening,
+		// so only check that its address resolves.
+		if r.url.Scheme == "udp" {
+			_, err := net.ResolveUDPAddr("udp", r.url.Host)
+			return err
+		}
+		host := r.url.Host
+		if r.url.Port() == "" {
+			host = net.JoinHostPort(r.url.Hostname(), r.url.Scheme)
+		}
+		conn, err := net.DialTimeout("tcp", host, availableTimeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	_, err := os.Stat(c.Device)
+	return err
+}
+
+// availablePin checks that the device of the driver has a channel
+// for the pin. Only the pwm and beaglebone drivers, whose chips have
+// a fixed number of channels, and the periph driver, which knows the
+// GPIOs of the board, are checked.
+func (c driverConfig) availablePin(pin int) error {
+	switch c.Type {
+	case "pwm":
+	case "periph":
+		if _, err := driverreg.Init(); err != nil {
+			// A failed initialization is reported by available.
+			return nil
+		}
+		_, err := periphPin(pin)
+		return err
+	case "beaglebone":
+		out, ok := bonePins[pin]
+		if !ok {
+			return fmt.Errorf("pin %d is not a PWM pin of the BeagleBone; use one of %s", pin, bonePinList())
+		}
+		_, err := newBeagleBone(c.Device, c.Frequency).chip(out.module)
+		return err
+	default:
+		return nil
+	}
+	channel, err := newPWM(c.Device, c.Frequency, boardPWMChannels(c.Board)).channel(pin)
+	if err != nil {
+		return err
+	}
+	n, err := pwmChannels(c.Device)
+	if err != nil {
+		// A missing chip is reported by available.
+		return nil
+	}
+	if channel >= n {
+		return fmt.Errorf("channel %d exceeds the %d channels of %s", channel, n, c.Device)
+	}
+	return nil
+}
+
+// available checks the drivers, including fallbacks, of all servos
+// of the valid configuration and returns every problem found, so
+// that a configuration can be checked before servor is restarted.
+func (c *config) available() []error {
+	var errs []error
+	checked := make(map[string]bool)
+	for _, s := range c.servos() {
+		for _, d := range s.drivers() {
+			key := fmt.Sprintf("%+v", d)
+			if checked[key] {
+				continue
+			}
+			checked[key] = true
+			if err := d.available(); err != nil {
+				errs = append(errs, fmt.Errorf("%s driver of servo %q is unavailable: %v", d.Type, s.Name, err))
+			}
+		}
+		for _, d := range s.drivers() {
+			if err := d.availablePin(s.Pin); err != nil {
+				errs = append(errs, fmt.Errorf("%s driver of servo %q cannot drive pin %d: %v", d.Type, s.Name, s.Pin, err))
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// watchdogInterval is how often the watchdogs of the servos are checked.
+const watchdogInterval = 100 * time.Millisecond
+
+// watchdogConfig drives a servo to its failsafe position when it
+// received no command for a while, e.g. because the client that
+// controls it crashed or lost its connection. Each servo has its own,
+// since different axes have different safe states, e.g. a tilt servo
+// parks level and a gripper opens.
+type watchdogConfig struct {
+	// Timeout is the number of seconds without a command
+	// after which the watchdog trips.
+	Timeout float64 `json:"timeout"`
+	// Position is the failsafe position.
+	Position *float64 `json:"position,omitempty"`
+	// Preset is the name of the preset at the failsafe position.
+	// If neither it nor Position is set, the failsafe is home.
+	Preset string `json:"preset,omitempty"`
+}
+
+// validate checks the watchdog of a servo with the given range and presets.
+func (c *watchdogConfig) validate(min, max float64, presets map[string]float64) error {
+	if c.Timeout <= 0 {
+		return fmt.Errorf("timeout must be greater than 0; got %f", c.Timeout)
+	}
+	if c.Position != nil && c.Preset != "" {
+		return errors.New("position and preset must not be set together")
+	}
+	if c.Position != nil && (*c.Position < min || *c.Position > max) {
+		return fmt.Errorf("position must be between min and max; got %f", *c.Position)
+	}
+	if _, ok := presets[c.Preset]; c.Preset != "" && !ok {
+		return fmt.Errorf("unknown preset %q", c.Preset)
+	}
+	return nil
+}
+
+// failsafe returns the failsafe position of the servo.
+// The servo must have a watchdog.
+// The caller must hold the lock.
+func (s *servor) failsafe() float64 {
+	switch {
+	case s.watchdog.Position != nil:
+		return *s.watchdog.Position
+	case s.watchdog.Preset != "":
+		if p, ok := s.presets[s.watchdog.Preset]; ok {
+			return p
+		}
+		// The preset may have been deleted since the watchdog was configured.
+		level.Warn(s.logger).Log("msg", "failsafe preset does not exist; using home", "preset", s.watchdog.Preset)
+	}
+	return s.home
+}
+
+// checkWatchdog drives the servo to its failsafe position if it
+// received no command for the timeout of its watchdog. Running jobs
+// count as activity, and the watchdog trips only once until the
+// next command.
+// The servo must have a watchdog.
+func (s *servor) checkWatchdog() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lastCommand.IsZero() {
+		// The timeout of a servo that was never commanded runs from startup.
+		s.lastCommand = time.Now()
+	}
+	if s.tripped || s.job != nil || time.Since(s.lastCommand).Seconds() < s.watchdog.Timeout {
+		return
+	}
+	s.tripped = true
+	watchdogTripsTotal.WithLabelValues(s.name).Inc()
+	failsafe := s.failsafe()
+	level.Warn(s.logger).Log("msg", "watchdog tripped; moving to failsafe position", "timeout", s.watchdog.Timeout, "position", failsafe)
+	// Like parking, the failsafe is a safety measure,
+	// so it bypasses the pre-move hooks.
+	s.source = sourceServor
+	s.position = failsafe
+	if err := s.set(); err != nil {
+		level.Error(s.logger).Log("msg", "failed to move to failsafe position", "err", err)
+	}
+	s.events.publish(s.event(eventWatchdog))
+}
+
+// watchWatchdogs checks the servos that have a watchdog
+// until the context is done.
+func (ss *servos) watchWatchdogs(ctx context.Context) error {
+	t := time.NewTicker(watchdogInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+		for _, s := range ss.list {
+			s.mu.Lock()
+			configured := s.watchdog != nil
+			s.mu.Unlock()
+			if configured {
+				s.checkWatchdog()
+			}
+		}
+	}
+}
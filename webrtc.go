@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pion/webrtc/v3"
+)
+
+// telemetryInterval is how often the states of all servos
+// are sent on open WebRTC data channels.
+const telemetryInterval = 200 * time.Millisecond
+
+// webrtcCommand is a message sent by clients on a WebRTC data channel.
+// Like the body of the jog endpoint, it sets exactly one of target,
+// velocity, and axis.
+type webrtcCommand struct {
+	Servo string `json:"servo"`
+	jogRequest
+}
+
+// webrtcMessage is a message sent by servor on a WebRTC data channel:
+// either the state of a servo in response to a command, the states
+// of all servos as telemetry, or an error.
+type webrtcMessage struct {
+	State  *servoState  `json:"state,omitempty"`
+	Servos []servoState `json:"servos,omitempty"`
+	Error  string       `json:"error,omitempty"`
+}
+
+// webrtcServer answers WebRTC offers from clients and serves
+// the data channels that they open.
+type webrtcServer struct {
+	servos *servos
+	config webrtc.Configuration
+	// limiter caps the commands that are applied at once along
+	// with the other actuation requests, if set.
+	limiter *limiter
+	logger  log.Logger
+}
+
+func (ws *webrtcServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	var offer webrtc.SessionDescription
+	if err := decodeJSON(r.Body, &offer, ws.servos.strict); err != nil {
+		http.Error(w, fmt.Sprintf("failed to parse request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	pc, err := webrtc.NewPeerConnection(ws.config)
+	if err != nil {
+		level.Error(ws.logger).Log("msg", "failed to create peer connection", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	pc.OnConnectionStateChange(func(s webrtc.PeerConnectionState) {
+		if s == webrtc.PeerConnectionStateFailed || s == webrtc.PeerConnectionStateDisconnected {
+			pc.Close()
+		}
+	})
+	id := identityFrom(r.Context())
+	// Commands over WebRTC come from the UI and other operator input,
+	// unless they are made with a token, which has a source of its own.
+	source := sourceUI
+	if id != nil {
+		source = id.source
+	}
+	pc.OnDataChannel(func(dc *webrtc.DataChannel) {
+		ws.serve(dc, id, source)
+	})
+	if err := pc.SetRemoteDescription(offer); err != nil {
+		pc.Close()
+		http.Error(w, fmt.Sprintf("invalid offer: %v", err), http.StatusBadRequest)
+		return
+	}
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		pc.Close()
+		http.Error(w, fmt.Sprintf("failed to answer offer: %v", err), http.StatusBadRequest)
+		return
+	}
+	// The answer is only sent once all candidates are gathered,
+	// so that clients do not need a second signaling round trip.
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		pc.Close()
+		level.Error(ws.logger).Log("msg", "failed to set local description", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	select {
+	case <-gathered:
+	case <-r.Context().Done():
+		pc.Close()
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(pc.LocalDescription()); err != nil {
+		level.Error(ws.logger).Log("err", err)
+	}
+}
+
+// serve handles the commands that the given identity, if any, sends from
+// the source on a data channel and sends telemetry while it is open.
+func (ws *webrtcServer) serve(dc *webrtc.DataChannel, id *identity, source string) {
+	send := func(m webrtcMessage) {
+		buf, err := json.Marshal(m)
+		if err != nil {
+			level.Error(ws.logger).Log("err", err)
+			return
+		}
+		if err := dc.SendText(string(buf)); err != nil {
+			level.Warn(ws.logger).Log("msg", "failed to send on data channel", "err", err)
+		}
+	}
+	done := make(chan struct{})
+	dc.OnOpen(func() {
+		go func() {
+			t := time.NewTicker(telemetryInterval)
+			defer t.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-t.C:
+				}
+				states := make([]servoState, 0, len(ws.servos.list))
+				for _, s := range ws.servos.list {
+					s.mu.Lock()
+					states = append(states, s.state())
+					s.mu.Unlock()
+				}
+				send(webrtcMessage{Servos: states})
+			}
+		}()
+	})
+	dc.OnClose(func() {
+		close(done)
+	})
+	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		var c webrtcCommand
+		if err := decodeJSON(bytes.NewReader(msg.Data), &c, ws.servos.strict); err != nil {
+			send(webrtcMessage{Error: fmt.Sprintf("failed to parse command: %v", err)})
+			return
+		}
+		s, ok := ws.servos.get(c.Servo)
+		if !ok {
+			send(webrtcMessage{Error: fmt.Sprintf("unknown servo %q", c.Servo)})
+			return
+		}
+		release, err := ws.limiter.acquire(done)
+		if err != nil {
+			send(webrtcMessage{Error: err.Error()})
+			return
+		}
+		defer release()
+		s.mu.Lock()
+		s.client = id
+		err = s.command(priorityManual, source)
+		if err == nil {
+			err = s.jogRequest(c.jogRequest)
+		}
+		s.client = nil
+		st := s.state()
+		s.mu.Unlock()
+		if err != nil {
+			send(webrtcMessage{Error: err.Error()})
+			return
+		}
+		send(webrtcMessage{State: &st})
+	})
+}
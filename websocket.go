@@ -0,0 +1,480 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// websocketSessionTTL is how long servor remembers the last frame
+	// of a session after its connection closed, so that a client
+	// that reconnects within it can resend unacknowledged frames.
+	websocketSessionTTL = 10 * time.Minute
+	// websocketPingInterval is how often servor pings WebSocket
+	// clients, which are closed if they do not answer in time,
+	// so that dead mobile connections are noticed.
+	websocketPingInterval = 20 * time.Second
+	websocketPongTimeout  = 2 * websocketPingInterval
+	// websocketSessionIDBytes is the number of random bytes of a
+	// session ID, which is unguessable so that no other client can
+	// take over the session.
+	websocketSessionIDBytes = 16
+)
+
+// The results of frames sent over WebSocket.
+const (
+	frameApplied    = "applied"
+	frameCoalesced  = "coalesced"
+	frameDuplicate  = "duplicate"
+	frameOutOfOrder = "out-of-order"
+	frameInvalid    = "invalid"
+	frameRejected   = "rejected"
+)
+
+// websocketCommand is a command in a frame. Like the body of the jog
+// endpoint, it sets exactly one of target, velocity, and axis, or
+// else delta, which moves the servo by a distance from its position.
+type websocketCommand struct {
+	Servo string `json:"servo"`
+	jogRequest
+	Delta *float64 `json:"delta"`
+}
+
+// validate checks that the command sets exactly one kind of move.
+func (c *websocketCommand) validate() error {
+	set := 0
+	for _, v := range []*float64{c.Target, c.Velocity, c.Axis, c.Delta} {
+		if v != nil {
+			set++
+		}
+	}
+	if set != 1 {
+		return errors.New("exactly one of target, velocity, axis, and delta must be set")
+	}
+	return nil
+}
+
+// websocketFrame is a message sent by clients over WebSocket:
+// a batch of commands that are applied in order.
+type websocketFrame struct {
+	// Seq numbers the frames of a session consecutively.
+	Seq      uint64             `json:"seq"`
+	Commands []websocketCommand `json:"commands"`
+}
+
+// websocketAck is a message sent by servor over WebSocket.
+type websocketAck struct {
+	// Session is the ID of the session, which is sent in the first
+	// message of every connection, so that the client can resume
+	// the session after a reconnect.
+	Session string `json:"session,omitempty"`
+	// Ack is the sequence number up to which all frames were received.
+	Ack uint64 `json:"ack"`
+	// Frames is the number of frames that were coalesced and applied
+	// at once, which is more than one while the servos are busy.
+	Frames int `json:"frames,omitempty"`
+	// Duplicate is set for a frame that was applied before,
+	// e.g. when it was resent after a reconnect.
+	Duplicate bool `json:"duplicate,omitempty"`
+	// Expected is the sequence number of the frame that servor expects
+	// next, which is set when a frame arrives out of order.
+	Expected uint64       `json:"expected,omitempty"`
+	Error    string       `json:"error,omitempty"`
+	Servos   []servoState `json:"servos,omitempty"`
+}
+
+// websocketEvent is a message sent by servor over WebSocket
+// for each event that the client subscribed to.
+type websocketEvent struct {
+	Event eventMessage `json:"event"`
+}
+
+// websocketSession tracks the frames of a client across reconnects.
+type websocketSession struct {
+	mu sync.Mutex
+	// last is the sequence number of the last frame that was received.
+	last uint64
+	seen time.Time
+}
+
+// websocketServer serves the framed command protocol over WebSocket.
+type websocketServer struct {
+	servos   *servos
+	upgrader websocket.Upgrader
+	// limiter caps the frames that are applied at once along with
+	// the other actuation requests, if set.
+	limiter *limiter
+	logger  log.Logger
+
+	mu       sync.Mutex
+	sessions map[string]*websocketSession
+}
+
+func newWebsocketServer(ss *servos, l *limiter, logger log.Logger) *websocketServer {
+	return &websocketServer{servos: ss, limiter: l, logger: logger, sessions: make(map[string]*websocketSession)}
+}
+
+// session returns the ID and the session with the given ID of the
+// given identity, or else a new session with a random ID, so that
+// clients can only resume the sessions that servor gave them.
+// Sessions that were not seen for websocketSessionTTL are forgotten.
+func (ws *websocketServer) session(id string, client *identity) (string, *websocketSession, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	for k, s := range ws.sessions {
+		s.mu.Lock()
+		if time.Since(s.seen) > websocketSessionTTL {
+			delete(ws.sessions, k)
+		}
+		s.mu.Unlock()
+	}
+	s, ok := ws.sessions[sessionKey(id, client)]
+	if id == "" || !ok {
+		buf := make([]byte, websocketSessionIDBytes)
+		if _, err := rand.Read(buf); err != nil {
+			return "", nil, fmt.Errorf("failed to generate a session ID: %v", err)
+		}
+		id = hex.EncodeToString(buf)
+		s = &websocketSession{}
+		ws.sessions[sessionKey(id, client)] = s
+	}
+	s.mu.Lock()
+	s.seen = time.Now()
+	s.mu.Unlock()
+	return id, s, nil
+}
+
+// sessionKey scopes the session ID to the token of the client,
+// if authentication is enabled.
+func sessionKey(id string, client *identity) string {
+	if client != nil {
+		return client.name + "/" + id
+	}
+	return id
+}
+
+func (ws *websocketServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	p, err := parsePriority(r, priorityManual)
+	if err != nil {
+		priorityError(w, err)
+		return
+	}
+	source, err := parseSource(r, ws.servos.policies)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Clients that give events, even none, i.e. all of them,
+	// subscribe to events on the same connection.
+	var sub *subscription
+	if _, ok := r.URL.Query()["events"]; ok {
+		f, err := parseEventFilter(r, ws.servos.byName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var unsubscribe func()
+		sub, unsubscribe = ws.servos.events.listen(sinkWebSocket, f)
+		defer unsubscribe()
+	}
+	id := identityFrom(r.Context())
+	sessionID, session, err := ws.session(r.URL.Query().Get("session"), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// The upgrader responds with an error itself.
+	conn, err := ws.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	c := &websocketConn{
+		conn:     conn,
+		servos:   ws.servos,
+		priority: p,
+		source:   source,
+		client:   id,
+		limiter:  ws.limiter,
+		events:   sub,
+		wake:     make(chan struct{}, 1),
+		logger:   ws.logger,
+	}
+	c.serve(sessionID, session)
+}
+
+// websocketConn is a WebSocket connection of a client.
+type websocketConn struct {
+	conn     *websocket.Conn
+	servos   *servos
+	priority priority
+	source   string
+	client   *identity
+	limiter  *limiter
+	// events are the events that the client subscribed to, if any.
+	events *subscription
+	logger log.Logger
+
+	// wmu serializes writes to the connection.
+	wmu sync.Mutex
+	// pmu guards the frames that were received but not yet applied.
+	pmu     sync.Mutex
+	pending []websocketFrame
+	wake    chan struct{}
+}
+
+// send writes the message to the client.
+func (c *websocketConn) send(m interface{}) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if err := c.conn.WriteJSON(m); err != nil {
+		level.Debug(c.logger).Log("msg", "failed to send on WebSocket", "err", err)
+	}
+}
+
+// serve tells the client the ID of its session and the last frame
+// that was received in it and then reads frames until the connection
+// closes, acknowledging duplicates and frames out of order at once and
+// queueing the others to be applied.
+func (c *websocketConn) serve(id string, session *websocketSession) {
+	defer c.conn.Close()
+	session.mu.Lock()
+	c.send(websocketAck{Session: id, Ack: session.last})
+	session.mu.Unlock()
+	done := make(chan struct{})
+	applied := make(chan struct{})
+	go func() {
+		defer close(applied)
+		c.apply(done)
+	}()
+	go c.ping(done)
+	if c.events != nil {
+		go c.forward(done)
+	}
+	c.conn.SetReadDeadline(time.Now().Add(websocketPongTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(websocketPongTimeout))
+	})
+	for {
+		_, buf, err := c.conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		var f websocketFrame
+		if err := decodeJSON(bytes.NewReader(buf), &f, c.servos.strict); err != nil {
+			websocketFramesTotal.WithLabelValues(frameInvalid).Inc()
+			c.send(websocketAck{Error: fmt.Sprintf("failed to parse frame: %v", err)})
+			continue
+		}
+		session.mu.Lock()
+		session.seen = time.Now()
+		last := session.last
+		switch {
+		case f.Seq == 0:
+			session.mu.Unlock()
+			websocketFramesTotal.WithLabelValues(frameInvalid).Inc()
+			c.send(websocketAck{Ack: last, Error: "seq must be greater than 0"})
+			continue
+		case last != 0 && f.Seq <= last:
+			session.mu.Unlock()
+			websocketFramesTotal.WithLabelValues(frameDuplicate).Inc()
+			c.send(websocketAck{Ack: f.Seq, Duplicate: true})
+			continue
+		case last != 0 && f.Seq != last+1:
+			session.mu.Unlock()
+			websocketFramesTotal.WithLabelValues(frameOutOfOrder).Inc()
+			c.send(websocketAck{Ack: last, Expected: last + 1, Error: fmt.Sprintf("frame %d was expected; got %d", last+1, f.Seq)})
+			continue
+		}
+		// Frames count as received once they are queued, since
+		// they are applied even if the connection closes.
+		session.last = f.Seq
+		session.mu.Unlock()
+		c.pmu.Lock()
+		c.pending = append(c.pending, f)
+		c.pmu.Unlock()
+		select {
+		case c.wake <- struct{}{}:
+		default:
+		}
+	}
+	close(done)
+	<-applied
+}
+
+// ping pings the client until done is closed.
+func (c *websocketConn) ping(done <-chan struct{}) {
+	t := time.NewTicker(websocketPingInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+		c.wmu.Lock()
+		err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(websocketPingInterval))
+		c.wmu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// forward sends the events that the client subscribed to
+// until done is closed.
+func (c *websocketConn) forward(done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case e := <-c.events.events:
+			// Virtual hosts only send the events of their servos.
+			if _, ok := c.servos.byName[e.servo]; !ok {
+				continue
+			}
+			c.send(websocketEvent{Event: e.message()})
+		}
+	}
+}
+
+// apply applies the queued frames until done is closed and no frames
+// are left. Frames that queued up while the servos were busy are
+// coalesced, so that a slow device catches up with the latest
+// commands rather than working through stale ones.
+func (c *websocketConn) apply(done <-chan struct{}) {
+	for {
+		c.pmu.Lock()
+		frames := c.pending
+		c.pending = nil
+		c.pmu.Unlock()
+		if len(frames) == 0 {
+			select {
+			case <-c.wake:
+				continue
+			case <-done:
+				// Frames may have been queued just before the connection closed.
+				select {
+				case <-c.wake:
+					continue
+				default:
+					return
+				}
+			}
+		}
+		c.send(c.applyFrames(frames))
+	}
+}
+
+// applyFrames coalesces the commands of the frames into one move per
+// servo and applies them. Invalid frames are skipped and reported.
+func (c *websocketConn) applyFrames(frames []websocketFrame) websocketAck {
+	ack := websocketAck{Ack: frames[len(frames)-1].Seq, Frames: len(frames)}
+	var order []*servor
+	moves := make(map[*servor]*websocketCommand)
+	var errs []string
+	for _, f := range frames {
+		if err := c.validate(f); err != nil {
+			websocketFramesTotal.WithLabelValues(frameInvalid).Inc()
+			errs = append(errs, fmt.Sprintf("frame %d: %v", f.Seq, err))
+			continue
+		}
+		for i := range f.Commands {
+			cmd := f.Commands[i]
+			s, _ := c.servos.get(cmd.Servo)
+			m, ok := moves[s]
+			if !ok {
+				order = append(order, s)
+				moves[s] = &cmd
+				continue
+			}
+			coalesce(m, cmd)
+		}
+	}
+	n := len(frames) - len(errs)
+	if len(order) != 0 {
+		// Like any other actuation request, the frames wait
+		// for a free slot, even if the connection closes.
+		release, err := c.limiter.acquire(nil)
+		if err != nil {
+			websocketFramesTotal.WithLabelValues(frameRejected).Add(float64(n))
+			errs = append(errs, err.Error())
+			order, n = nil, 0
+		} else {
+			defer release()
+		}
+	}
+	if n > 0 {
+		websocketFramesTotal.WithLabelValues(frameApplied).Inc()
+		websocketFramesTotal.WithLabelValues(frameCoalesced).Add(float64(n - 1))
+	}
+	for _, s := range order {
+		m := moves[s]
+		s.mu.Lock()
+		s.client = c.client
+		err := s.command(c.priority, c.source)
+		if err == nil {
+			if m.Delta != nil {
+				target := s.stepFrom() + *m.Delta
+				m.Target = &target
+			}
+			err = s.jogRequest(m.jogRequest)
+		}
+		s.client = nil
+		ack.Servos = append(ack.Servos, s.state())
+		s.mu.Unlock()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("servo %q: %v", s.name, err))
+		}
+	}
+	if len(errs) != 0 {
+		ack.Error = errs[0]
+		if len(errs) > 1 {
+			ack.Error = fmt.Sprintf("%s (and %d more)", errs[0], len(errs)-1)
+		}
+	}
+	return ack
+}
+
+// validate checks that the frame only addresses known servos with
+// valid commands, so that it is either applied as a whole or not at all.
+func (c *websocketConn) validate(f websocketFrame) error {
+	for i, cmd := range f.Commands {
+		if _, ok := c.servos.get(cmd.Servo); !ok {
+			return fmt.Errorf("command %d: unknown servo %q", i, cmd.Servo)
+		}
+		if err := cmd.validate(); err != nil {
+			return fmt.Errorf("command %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// coalesce folds the next command for the same servo into m: a
+// delta after a target or another delta adds up, and any other
+// command replaces what came before it.
+func coalesce(m *websocketCommand, next websocketCommand) {
+	switch {
+	case next.Delta != nil && m.Target != nil:
+		target := *m.Target + *next.Delta
+		m.Target = &target
+	case next.Delta != nil && m.Delta != nil:
+		delta := *m.Delta + *next.Delta
+		m.Delta = &delta
+	default:
+		*m = next
+	}
+}
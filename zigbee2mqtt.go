@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// Cover states of Zigbee2MQTT.
+const (
+	coverOpen  = "OPEN"
+	coverClose = "CLOSE"
+	coverStop  = "STOP"
+)
+
+// mqttReconnect is how long the bridge waits before reconnecting.
+const mqttReconnect = 5 * time.Second
+
+var errUnknownCoverState = errors.New("state must be one of OPEN, CLOSE, and STOP")
+
+// coverState is the state of a servo as a Zigbee2MQTT cover, whose
+// position is a percentage of the range, where 100 is fully open.
+type coverState struct {
+	Position int    `json:"position"`
+	State    string `json:"state"`
+}
+
+// coverCommand is a command sent to a cover; it sets either the
+// position or the state.
+type coverCommand struct {
+	Position *float64 `json:"position"`
+	State    string   `json:"state"`
+}
+
+// mqttBridge mirrors every servo as a cover device in the MQTT
+// namespace of Zigbee2MQTT, so that home automation that runs on
+// Zigbee2MQTT drives servos like any other cover: the state is
+// published to <base>/<servo>, commands are received on
+// <base>/<servo>/set, and <base>/<servo>/availability tells
+// whether servor is online.
+type mqttBridge struct {
+	url    *url.URL
+	base   string
+	servos *servos
+	logger log.Logger
+
+	// mu guards the servos whose availability was published,
+	// and ready is closed once it was published for all of them.
+	mu     sync.Mutex
+	online map[string]bool
+	ready  chan struct{}
+}
+
+func newMQTTBridge(broker, base string, ss *servos, logger log.Logger) (*mqttBridge, error) {
+	u, err := url.Parse(broker)
+	if err != nil || u.Scheme != "mqtt" || u.Host == "" {
+		return nil, fmt.Errorf("MQTT broker must be a URL like mqtt://broker:1883; got %q", broker)
+	}
+	return &mqttBridge{url: u, base: strings.TrimSuffix(base, "/"), servos: ss, logger: logger, online: make(map[string]bool), ready: make(chan struct{})}, nil
+}
+
+// markOnline records that the availability of the servo was published.
+func (b *mqttBridge) markOnline(s *servor) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.online[s.name] {
+		return
+	}
+	b.online[s.name] = true
+	if len(b.online) == len(b.servos.list) {
+		close(b.ready)
+	}
+}
+
+// run bridges all servos until the context is done. Each servo has
+// its own connection, so that the broker marks each device offline
+// with its will if servor goes away.
+func (b *mqttBridge) run(ctx context.Context) error {
+	done := make(chan struct{})
+	for _, s := range b.servos.list {
+		go func(s *servor) {
+			defer func() { done <- struct{}{} }()
+			for {
+				err := b.session(ctx, s)
+				if ctx.Err() != nil {
+					return
+				}
+				level.Warn(b.logger).Log("msg", "MQTT bridge disconnected; reconnecting", "servo", s.name, "err", err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(mqttReconnect):
+				}
+			}
+		}(s)
+	}
+	for range b.servos.list {
+		<-done
+	}
+	return nil
+}
+
+// session connects the servo to the broker and bridges it
+// until the connection fails or the context is done.
+func (b *mqttBridge) session(ctx context.Context, s *servor) error {
+	topic := b.base + "/" + s.name
+	availability := func(state string) mqttMessage {
+		return mqttMessage{topic: topic + "/availability", payload: []byte(fmt.Sprintf(`{"state":%q}`, state)), retain: true}
+	}
+	hostname, _ := os.Hostname()
+	offline := availability("offline")
+	c, err := dialMQTT(b.url, fmt.Sprintf("servor-%s-%s", hostname, s.name), &offline)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if err := c.publish(availability("online")); err != nil {
+		return err
+	}
+	b.markOnline(s)
+	if err := c.subscribe(topic+"/set", topic+"/set/+", topic+"/get"); err != nil {
+		return err
+	}
+	errs := make(chan error, 1)
+	get := make(chan struct{}, 1)
+	go func() {
+		for {
+			m, err := c.receive()
+			if err != nil {
+				errs <- err
+				return
+			}
+			if m.topic == topic+"/get" {
+				select {
+				case get <- struct{}{}:
+				default:
+				}
+				continue
+			}
+			if err := b.command(s, strings.TrimPrefix(m.topic, topic+"/set"), m.payload); err != nil {
+				level.Warn(b.logger).Log("msg", "failed to apply MQTT command", "servo", s.name, "err", err)
+			}
+		}
+	}()
+	// The state topic already mirrors the position,
+	// so the event topic carries all other events.
+	sub, unsubscribe := b.servos.events.listen(sinkMQTT, eventFilter{Servo: s.name})
+	defer unsubscribe()
+	t := time.NewTicker(telemetryInterval)
+	defer t.Stop()
+	ping := time.NewTicker(mqttKeepAlive / 2)
+	defer ping.Stop()
+	var last []byte
+	for {
+		select {
+		case <-ctx.Done():
+			c.publish(offline)
+			return nil
+		case err := <-errs:
+			return err
+		case <-ping.C:
+			if err := c.ping(); err != nil {
+				return err
+			}
+			continue
+		case e := <-sub.events:
+			if e.name == eventPositionChanged {
+				continue
+			}
+			buf, err := json.Marshal(e.message())
+			if err != nil {
+				return err
+			}
+			if err := c.publish(mqttMessage{topic: topic + "/event", payload: buf}); err != nil {
+				return err
+			}
+			continue
+		case <-get:
+			last = nil
+		case <-t.C:
+		}
+		s.mu.Lock()
+		state, err := json.Marshal(cover(s))
+		s.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(state, last) {
+			continue
+		}
+		if err := c.publish(mqttMessage{topic: topic, payload: state, retain: true}); err != nil {
+			return err
+		}
+		last = state
+	}
+}
+
+// cover returns the state of the servo as a cover.
+// The caller must hold the lock.
+func cover(s *servor) coverState {
+	c := coverState{Position: int(math.Round((s.position - s.min) / (s.max - s.min) * 100)), State: coverOpen}
+	if c.Position == 0 {
+		c.State = coverClose
+	}
+	return c
+}
+
+// command applies a command received on the set topic, either as JSON,
+// e.g. {"position": 50}, or, on the set/position and set/state topics,
+// as the bare value.
+func (b *mqttBridge) command(s *servor, attribute string, payload []byte) error {
+	var c coverCommand
+	switch attribute {
+	case "":
+		if err := decodeJSON(bytes.NewReader(payload), &c, b.servos.strict); err != nil {
+			return fmt.Errorf("failed to parse command: %v", err)
+		}
+	case "/position":
+		var p float64
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return fmt.Errorf("failed to parse position: %v", err)
+		}
+		c.Position = &p
+	case "/state":
+		c.State = string(payload)
+	default:
+		return fmt.Errorf("unknown attribute %q", strings.TrimPrefix(attribute, "/"))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Commands over MQTT come from home automation.
+	if err := s.command(priorityAutomation, sourceMQTT); err != nil {
+		return err
+	}
+	var target float64
+	switch {
+	case c.Position != nil:
+		p := *c.Position
+		if s.strict && !(p >= 0 && p <= 100) {
+			return &strictError{fmt.Sprintf("position must be between 0 and 100; got %f", p)}
+		}
+		target = s.min + math.Max(0, math.Min(100, p))/100*(s.max-s.min)
+	case strings.EqualFold(c.State, coverOpen):
+		target = s.max
+	case strings.EqualFold(c.State, coverClose):
+		target = s.min
+	case strings.EqualFold(c.State, coverStop):
+		s.stop(reasonStopped)
+		return nil
+	default:
+		return errUnknownCoverState
+	}
+	s.stop(reasonSuperseded)
+	return s.move(target)
+}